@@ -0,0 +1,147 @@
+package elasticapm
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/apm-agent-go/internal/sanitize"
+	"github.com/elastic/apm-agent-go/model"
+)
+
+const envPrefix = "ELASTIC_APM_"
+
+// loopConfig holds the options owned by the tracer's loop goroutine,
+// which are reported in the agent configuration.
+type loopConfig struct {
+	flushInterval           time.Duration
+	maxTransactionQueueSize int
+	apiRequestConcurrency   int
+	apiRequestMinInterval   time.Duration
+	metricsInterval         time.Duration
+	circuitBreaker          circuitBreakerConfig
+}
+
+// initialAgentConfig returns the ELASTIC_APM_* environment variables in
+// environ, such as those consumed by the transport, along with options
+// in opts which cannot be changed once the tracer is created. Options
+// are keyed by their environment variable names, lower-cased and without
+// the prefix, e.g. "capture_env", and their values are sanitized.
+func initialAgentConfig(opts options, environ []string) map[string]string {
+	config := make(map[string]string)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		equal := strings.IndexRune(kv, '=')
+		if equal < 0 {
+			continue
+		}
+		name := configName(kv[:equal])
+		config[name] = sanitize.Value(name, kv[equal+1:])
+	}
+	config[configName(envCaptureEnv)] = strings.Join(opts.captureEnv, ",")
+	return config
+}
+
+// agentConfig returns the tracer's effective agent configuration, for
+// reporting in the service metadata. The configuration is built from
+// the tracer's current state on each call, so that options changed
+// after the tracer was created, e.g. with SetMaxSpans, are reported
+// correctly; loop holds the options owned by the tracer loop.
+//
+// The values of options whose names suggest they hold secrets, such
+// as the secret token, are redacted.
+func (t *Tracer) agentConfig(loop loopConfig) map[string]string {
+	sampleRate := 1.0
+	t.samplerMu.RLock()
+	if sampler, ok := t.sampler.(*RatioSampler); ok {
+		sampleRate = sampler.r
+	}
+	t.samplerMu.RUnlock()
+	t.maxSpansMu.RLock()
+	maxSpans := t.maxSpans
+	t.maxSpansMu.RUnlock()
+	t.maxSpanStacktracesMu.RLock()
+	maxSpanStacktraces := t.maxSpanStacktraces
+	t.maxSpanStacktracesMu.RUnlock()
+	t.transactionMaxDurationMu.RLock()
+	transactionMaxDuration := t.transactionMaxDuration
+	t.transactionMaxDurationMu.RUnlock()
+	t.breakdownMetrics.mu.Lock()
+	breakdownMetrics := t.breakdownMetrics.enabled
+	metricsExemplars := t.breakdownMetrics.exemplars
+	t.breakdownMetrics.mu.Unlock()
+	t.errorRateLimiter.mu.Lock()
+	errorRateLimit := t.errorRateLimiter.limit
+	t.errorRateLimiter.mu.Unlock()
+	captureBodyLimits := t.CaptureBodyLimits()
+
+	config := make(map[string]string)
+	for name, value := range t.initialAgentConfig {
+		config[name] = value
+	}
+	for name, value := range map[string]string{
+		envFlushInterval:            loop.flushInterval.String(),
+		envMaxQueueSize:             strconv.Itoa(loop.maxTransactionQueueSize),
+		envMaxSpans:                 strconv.Itoa(maxSpans),
+		envMaxSpanStacktraces:       strconv.Itoa(maxSpanStacktraces),
+		envTransactionSampleRate:    strconv.FormatFloat(sampleRate, 'g', -1, 64),
+		envRecording:                strconv.FormatBool(t.Recording()),
+		envCaptureBody:              captureBodyModeString(t.CaptureBody()),
+		envCaptureBodyMaxFormFields: strconv.Itoa(captureBodyLimits.MaxFormFields),
+		envCaptureBodyMaxSize:       strconv.Itoa(captureBodyLimits.MaxSize),
+		envCaptureQueryParams:       strconv.FormatBool(t.CaptureQueryParams()),
+		envAPIRequestConcurrency:    strconv.Itoa(loop.apiRequestConcurrency),
+		envAPIRequestMinInterval:    loop.apiRequestMinInterval.String(),
+		envMetricsInterval:          loop.metricsInterval.String(),
+		envBreakdownMetrics:         strconv.FormatBool(breakdownMetrics),
+		envMetricsExemplars:         strconv.FormatBool(metricsExemplars),
+		envCircuitBreakerThreshold:  strconv.Itoa(loop.circuitBreaker.threshold),
+		envCircuitBreakerCooldown:   loop.circuitBreaker.cooldown.String(),
+		envTopLevelSpans:            strconv.FormatBool(t.sendTopLevelSpans()),
+		envPerEventService:          strconv.FormatBool(t.sendPerEventService()),
+		envTransactionMaxDuration:   transactionMaxDuration.String(),
+		envNestedTransactionSpans:   strconv.FormatBool(t.NestedTransactionSpans()),
+		envCaptureGoroutines:        strconv.FormatBool(t.CaptureGoroutines()),
+		envErrorRateLimit:           strconv.Itoa(errorRateLimit),
+	} {
+		name = configName(name)
+		config[name] = sanitize.Value(name, value)
+	}
+	return config
+}
+
+// configName returns the name of the option configured
+// by the ELASTIC_APM_* environment variable envName.
+func configName(envName string) string {
+	return strings.ToLower(strings.TrimPrefix(envName, envPrefix))
+}
+
+func captureBodyModeString(mode CaptureBodyMode) string {
+	switch mode {
+	case CaptureBodyErrors:
+		return "errors"
+	case CaptureBodyTransactions:
+		return "transactions"
+	case CaptureBodyAll:
+		return "all"
+	}
+	return "off"
+}
+
+// metadataService returns a copy of t.Service, with the agent
+// configuration included for reporting to the APM server, and the
+// agent name and version overridden if set with SetAgent. It must
+// be called by the tracer loop, which provides loop.
+func (t *Tracer) metadataService(loop loopConfig) *model.Service {
+	service := *t.Service
+	t.agentMu.RLock()
+	if t.agent != nil {
+		service.Agent.Name = t.agent.Name
+		service.Agent.Version = t.agent.Version
+	}
+	t.agentMu.RUnlock()
+	service.Agent.Config = t.agentConfig(loop)
+	return &service
+}
@@ -0,0 +1,187 @@
+package elasticapm
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strings"
+)
+
+const (
+	// maxBaggageMembers is the maximum number of list-members
+	// in a W3C baggage header.
+	maxBaggageMembers = 64
+
+	// maxBaggageLength is the maximum length of a W3C baggage
+	// header that must be propagated.
+	maxBaggageLength = 8192
+)
+
+// Baggage holds application-defined key/value pairs, as described by
+// the W3C baggage header, which are propagated along with the trace
+// context to downstream services. Baggage is not recorded by the agent;
+// it is for the application to use, e.g. for propagating a tenant ID.
+type Baggage []BaggageMember
+
+// BaggageMember holds a single baggage list-member.
+type BaggageMember struct {
+	// Key holds the baggage key.
+	Key string
+
+	// Value holds the baggage value, decoded.
+	Value string
+
+	// Properties holds the member's properties, if any, in their
+	// encoded form, e.g. "propertyKey=propertyValue". Properties are
+	// not interpreted by the agent, but are propagated as-is.
+	Properties string
+}
+
+// BaggageFromContext returns the baggage of the transaction in ctx, if
+// any. The baggage is that propagated by the transaction's parent, via
+// StartTransactionOptions, e.g. by instrumentation modules extracting
+// it from incoming requests.
+func BaggageFromContext(ctx context.Context) Baggage {
+	tx := TransactionFromContext(ctx)
+	if tx == nil {
+		return nil
+	}
+	return tx.traceContext.Baggage
+}
+
+// ParseBaggage parses s as a W3C baggage header value.
+//
+// Malformed members are skipped, and if the header exceeds the limits
+// of 64 members or 8192 characters, then the members beyond the limits
+// are discarded.
+func ParseBaggage(s string) Baggage {
+	var baggage Baggage
+	var length int
+	for _, member := range strings.Split(s, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		encoded := member
+		var properties string
+		if semi := strings.IndexRune(encoded, ';'); semi >= 0 {
+			properties = strings.TrimSpace(encoded[semi+1:])
+			encoded = encoded[:semi]
+		}
+		equal := strings.IndexRune(encoded, '=')
+		if equal < 0 {
+			continue
+		}
+		key := strings.TrimSpace(encoded[:equal])
+		if !validBaggageKey(key) {
+			continue
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(encoded[equal+1:]))
+		if err != nil {
+			continue
+		}
+		if len(baggage) > 0 {
+			length++ // comma
+		}
+		length += len(member)
+		if len(baggage) == maxBaggageMembers || length > maxBaggageLength {
+			break
+		}
+		baggage = append(baggage, BaggageMember{
+			Key:        key,
+			Value:      value,
+			Properties: properties,
+		})
+	}
+	return baggage
+}
+
+// Get returns the value of the first member with the given key,
+// and reports whether there is such a member.
+func (b Baggage) Get(key string) (string, bool) {
+	for _, member := range b {
+		if member.Key == key {
+			return member.Value, true
+		}
+	}
+	return "", false
+}
+
+// String returns b encoded as a W3C baggage header value.
+//
+// Members with invalid keys are omitted. If b exceeds the limits
+// of 64 members or 8192 characters, then the members beyond the
+// limits are omitted.
+func (b Baggage) String() string {
+	var buf bytes.Buffer
+	var n int
+	for _, member := range b {
+		if !validBaggageKey(member.Key) {
+			continue
+		}
+		if n == maxBaggageMembers {
+			break
+		}
+		encoded := member.encode()
+		length := buf.Len() + len(encoded)
+		if n > 0 {
+			length++ // comma
+		}
+		if length > maxBaggageLength {
+			break
+		}
+		if n > 0 {
+			buf.WriteRune(',')
+		}
+		buf.WriteString(encoded)
+		n++
+	}
+	return buf.String()
+}
+
+func (m BaggageMember) encode() string {
+	var buf bytes.Buffer
+	buf.WriteString(m.Key)
+	buf.WriteRune('=')
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(m.Value); i++ {
+		c := m.Value[i]
+		if validBaggageOctet(c) && c != '%' {
+			buf.WriteByte(c)
+			continue
+		}
+		buf.WriteByte('%')
+		buf.WriteByte(hex[c>>4])
+		buf.WriteByte(hex[c&0x0f])
+	}
+	if m.Properties != "" {
+		buf.WriteRune(';')
+		buf.WriteString(m.Properties)
+	}
+	return buf.String()
+}
+
+// validBaggageKey reports whether or not key is a valid baggage
+// key: a non-empty token, as defined by RFC 7230.
+func validBaggageKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validBaggageOctet reports whether or not c may appear unencoded
+// in a baggage value: printable ASCII, other than space, '"', ',',
+// ';', and '\'.
+func validBaggageOctet(c byte) bool {
+	return c > 0x20 && c < 0x7f && c != '"' && c != ',' && c != ';' && c != '\\'
+}
@@ -0,0 +1,92 @@
+package elasticapm_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestParseBaggage(t *testing.T) {
+	baggage := elasticapm.ParseBaggage(" tenant = acme ,, invalid, bad key=x, cohort=beta%20users;ttl=60 , pct=%zz")
+	assert.Equal(t, elasticapm.Baggage{
+		{Key: "tenant", Value: "acme"},
+		{Key: "cohort", Value: "beta users", Properties: "ttl=60"},
+	}, baggage)
+	assert.Equal(t, "tenant=acme,cohort=beta%20users;ttl=60", baggage.String())
+
+	value, ok := baggage.Get("cohort")
+	assert.True(t, ok)
+	assert.Equal(t, "beta users", value)
+	_, ok = baggage.Get("missing")
+	assert.False(t, ok)
+}
+
+func TestBaggageStringEncoding(t *testing.T) {
+	baggage := elasticapm.Baggage{
+		{Key: "k", Value: `a b,c;d"e\f%g=h`},
+		{Key: "invalid key", Value: "omitted"},
+	}
+	assert.Equal(t, `k=a%20b%2Cc%3Bd%22e%5Cf%25g=h`, baggage.String())
+	assert.Equal(t, baggage[:1], elasticapm.ParseBaggage(baggage.String()))
+}
+
+func TestParseBaggageMaxMembers(t *testing.T) {
+	members := make([]string, 65)
+	for i := range members {
+		members[i] = fmt.Sprintf("k%d=v", i)
+	}
+	baggage := elasticapm.ParseBaggage(strings.Join(members, ","))
+	assert.Len(t, baggage, 64)
+	assert.Equal(t, "k63", baggage[63].Key)
+
+	baggage = append(baggage, elasticapm.BaggageMember{Key: "k64", Value: "v"})
+	assert.Equal(t, strings.Join(members[:64], ","), baggage.String())
+}
+
+func TestParseBaggageMaxLength(t *testing.T) {
+	// 2 members of 4095 characters, plus a comma: 8191 characters.
+	a := "a=" + strings.Repeat("x", 4093)
+	b := "b=" + strings.Repeat("x", 4093)
+	baggage := elasticapm.ParseBaggage(a + "," + b)
+	assert.Len(t, baggage, 2)
+	assert.Len(t, baggage.String(), 8191)
+
+	// Adding another member would exceed 8192 characters,
+	// so the baggage is truncated.
+	baggage = elasticapm.ParseBaggage(a + "," + b + ",c=x")
+	assert.Len(t, baggage, 2)
+
+	baggage = append(baggage, elasticapm.BaggageMember{Key: "c", Value: "x"})
+	assert.Len(t, baggage.String(), 8191)
+}
+
+func TestBaggageFromContext(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	assert.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+
+	assert.Nil(t, elasticapm.BaggageFromContext(context.Background()))
+
+	// Baggage is propagated even without a parent trace.
+	baggage := elasticapm.ParseBaggage("tenant=acme")
+	tx := tracer.StartTransactionOptions("name", "type", elasticapm.TransactionOptions{
+		TraceContext: elasticapm.TraceContext{Baggage: baggage},
+	})
+	defer tx.Done(-1)
+	assert.NoError(t, tx.TraceContext().Trace.Validate())
+
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	assert.Equal(t, baggage, elasticapm.BaggageFromContext(ctx))
+
+	span, ctx := elasticapm.StartSpan(ctx, "name", "type")
+	defer span.Done(-1)
+	assert.Equal(t, baggage, elasticapm.BaggageFromContext(ctx))
+	assert.Equal(t, baggage, span.TraceContext().Baggage)
+}
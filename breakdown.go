@@ -0,0 +1,267 @@
+package elasticapm
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport"
+)
+
+const (
+	transactionDurationCountMetricName = "transaction.duration.count"
+	transactionDurationSumMetricName   = "transaction.duration.sum.us"
+
+	// transactionBreakdownCountMetricName is the name of the metric
+	// counting all transactions recorded for breakdown metrics, for
+	// cross-checking against transaction counts reported elsewhere.
+	transactionBreakdownCountMetricName = "transaction.breakdown.count"
+
+	// transactionUnaccountedCountMetricName and
+	// transactionUnaccountedSumMetricName report the time within
+	// sampled transactions not covered by any of their spans.
+	transactionUnaccountedCountMetricName = "transaction.unaccounted.count"
+	transactionUnaccountedSumMetricName   = "transaction.unaccounted.sum.us"
+
+	// transactionBreakdownOverflowCountMetricName is the name of the
+	// metric counting transactions not aggregated by name and type,
+	// as maxBreakdownTransactionKeys had already been reached.
+	transactionBreakdownOverflowCountMetricName = "transaction.breakdown.overflow.count"
+
+	// maxBreakdownTransactionKeys is the maximum number of distinct
+	// transaction names and types aggregated between gatherings,
+	// bounding the memory used when names have high cardinality.
+	maxBreakdownTransactionKeys = 1000
+)
+
+// breakdownMetrics aggregates transaction durations by transaction
+// name and type, for reporting as metrics. The aggregated values are
+// reset each time they are gathered.
+type breakdownMetrics struct {
+	mu                   sync.Mutex
+	enabled              bool
+	exemplars            bool
+	gathered             bool
+	transactionCount     uint64
+	overflowCount        uint64
+	transactionDurations map[breakdownTransactionKey]*breakdownTiming
+}
+
+type breakdownTransactionKey struct {
+	name            string
+	transactionType string
+}
+
+type breakdownTiming struct {
+	count    uint64
+	sum      time.Duration
+	exemplar TraceID
+
+	// unaccountedCount and unaccountedSum hold the number of sampled
+	// transactions, and the sum of their time not covered by spans.
+	unaccountedCount uint64
+	unaccountedSum   time.Duration
+}
+
+func newBreakdownMetrics(enabled, exemplars bool) *breakdownMetrics {
+	return &breakdownMetrics{
+		enabled:              enabled,
+		exemplars:            exemplars,
+		transactionDurations: make(map[breakdownTransactionKey]*breakdownTiming),
+	}
+}
+
+// recordTransaction records the duration of tx, if breakdown
+// metrics are enabled and will be gathered and sent. The first
+// sampled transaction recorded for each name and type is used
+// as the exemplar. Once maxBreakdownTransactionKeys names and
+// types have been recorded, further ones are only counted.
+//
+// For sampled transactions, the time not covered by any span is
+// also recorded; see unaccountedDuration.
+func (b *breakdownMetrics) recordTransaction(tx *Transaction) {
+	b.mu.Lock()
+	enabled := b.enabled && b.gathered
+	b.mu.Unlock()
+	if !enabled {
+		return
+	}
+	if _, ok := tx.tracer.Transport.(transport.MetricsTransport); !ok {
+		return
+	}
+	sampled := tx.Sampled()
+	var unaccounted time.Duration
+	if sampled {
+		// Computed without holding b.mu, as the
+		// transaction may have many spans.
+		unaccounted = tx.unaccountedDuration()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := breakdownTransactionKey{name: tx.Name, transactionType: tx.Type}
+	b.transactionCount++
+	timing, ok := b.transactionDurations[key]
+	if !ok {
+		if len(b.transactionDurations) >= maxBreakdownTransactionKeys {
+			b.overflowCount++
+			return
+		}
+		timing = &breakdownTiming{}
+		b.transactionDurations[key] = timing
+	}
+	timing.count++
+	timing.sum += tx.Duration
+	if sampled {
+		timing.unaccountedCount++
+		timing.unaccountedSum += unaccounted
+		if timing.exemplar == (TraceID{}) {
+			timing.exemplar = tx.traceContext.Trace
+		}
+	}
+}
+
+// unaccountedDuration returns the transaction's duration minus the
+// union of its spans' intervals: time spent outside of any span, e.g.
+// in uninstrumented code or GC pauses. Spans which have not ended are
+// taken to extend to the end of the transaction, and spans dropped
+// due to the transaction's span limit are not accounted for.
+func (tx *Transaction) unaccountedDuration() time.Duration {
+	type interval struct{ start, end time.Duration }
+	tx.mu.Lock()
+	intervals := make([]interval, 0, len(tx.spans))
+	for _, s := range tx.spans {
+		s.mu.Lock()
+		start, end := s.Start, tx.Duration
+		if s.done {
+			end = s.Start + s.Duration
+		}
+		s.mu.Unlock()
+		if start < 0 {
+			start = 0
+		}
+		if end > tx.Duration {
+			end = tx.Duration
+		}
+		if end > start {
+			intervals = append(intervals, interval{start, end})
+		}
+	}
+	tx.mu.Unlock()
+
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start < intervals[j].start
+	})
+	var covered, coveredEnd time.Duration
+	for _, in := range intervals {
+		if in.start > coveredEnd {
+			coveredEnd = in.start
+		}
+		if in.end > coveredEnd {
+			covered += in.end - coveredEnd
+			coveredEnd = in.end
+		}
+	}
+	return tx.Duration - covered
+}
+
+// GatherMetrics adds the aggregated transaction durations to m,
+// along with the total number of transactions aggregated, and
+// resets the aggregation.
+func (b *breakdownMetrics) GatherMetrics(ctx context.Context, m *Metrics) error {
+	b.mu.Lock()
+	durations := b.transactionDurations
+	exemplars := b.exemplars
+	transactionCount := b.transactionCount
+	overflowCount := b.overflowCount
+	if len(durations) > 0 {
+		b.transactionDurations = make(map[breakdownTransactionKey]*breakdownTiming)
+	} else {
+		// The map is still in use, and must not
+		// be iterated without holding b.mu.
+		durations = nil
+	}
+	b.transactionCount = 0
+	b.overflowCount = 0
+	b.mu.Unlock()
+
+	if transactionCount > 0 {
+		m.add(transactionBreakdownCountMetricName, nil, model.Metric{
+			Value: float64(transactionCount),
+			Type:  model.MetricTypeDeltaCounter,
+		})
+	}
+	if overflowCount > 0 {
+		m.add(transactionBreakdownOverflowCountMetricName, nil, model.Metric{
+			Value: float64(overflowCount),
+			Type:  model.MetricTypeDeltaCounter,
+		})
+	}
+	for key, timing := range durations {
+		labels := []MetricLabel{
+			{Name: "transaction.name", Value: key.name},
+			{Name: "transaction.type", Value: key.transactionType},
+		}
+		var exemplar *model.MetricExemplar
+		if exemplars && timing.exemplar != (TraceID{}) {
+			exemplar = &model.MetricExemplar{TraceID: timing.exemplar.String()}
+		}
+		m.add(transactionDurationCountMetricName, labels, model.Metric{
+			Value:    float64(timing.count),
+			Exemplar: exemplar,
+			Type:     model.MetricTypeDeltaCounter,
+		})
+		m.add(transactionDurationSumMetricName, labels, model.Metric{
+			Value:    float64(timing.sum) / float64(time.Microsecond),
+			Exemplar: exemplar,
+			Type:     model.MetricTypeDeltaCounter,
+		})
+		if timing.unaccountedCount > 0 {
+			m.add(transactionUnaccountedCountMetricName, labels, model.Metric{
+				Value: float64(timing.unaccountedCount),
+				Type:  model.MetricTypeDeltaCounter,
+			})
+			m.add(transactionUnaccountedSumMetricName, labels, model.Metric{
+				Value: float64(timing.unaccountedSum) / float64(time.Microsecond),
+				Type:  model.MetricTypeDeltaCounter,
+			})
+		}
+	}
+	return nil
+}
+
+// SetBreakdownMetrics sets whether or not the tracer aggregates
+// transaction durations by transaction name and type, reporting
+// them as metrics. For sampled transactions, the time not covered
+// by any span is also reported, as "transaction.unaccounted.sum.us".
+func (t *Tracer) SetBreakdownMetrics(enabled bool) {
+	t.breakdownMetrics.mu.Lock()
+	t.breakdownMetrics.enabled = enabled
+	t.breakdownMetrics.mu.Unlock()
+}
+
+// setGathered records whether or not metrics are gathered periodically,
+// as set by Tracer.SetMetricsInterval. Transactions are not recorded
+// while metrics are not gathered, and any recorded are discarded.
+func (b *breakdownMetrics) setGathered(gathered bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gathered = gathered
+	if !gathered {
+		b.transactionDurations = make(map[breakdownTransactionKey]*breakdownTiming)
+		b.transactionCount = 0
+		b.overflowCount = 0
+	}
+}
+
+// SetMetricsExemplars sets whether or not aggregated metrics, such
+// as breakdown metrics, will carry an exemplar: the trace ID of a
+// sampled transaction contributing to the aggregate. Exemplars are
+// disabled by default, as not all servers consume them.
+func (t *Tracer) SetMetricsExemplars(enabled bool) {
+	t.breakdownMetrics.mu.Lock()
+	t.breakdownMetrics.exemplars = enabled
+	t.breakdownMetrics.mu.Unlock()
+}
@@ -0,0 +1,202 @@
+package elasticapm_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestBreakdownMetricsTransactionDuration(t *testing.T) {
+	for _, exemplars := range []bool{false, true} {
+		tracer, err := elasticapm.NewTracer("tracer.testing", "")
+		require.NoError(t, err)
+		defer tracer.Close()
+		metrics := make(chan transporttest.SendMetricsRequest)
+		tracer.Transport = &transporttest.ChannelTransport{Metrics: metrics}
+		tracer.SetMetricsExemplars(exemplars)
+
+		tx1 := tracer.StartTransaction("name", "type")
+		tx2 := tracer.StartTransaction("name", "type")
+		traceID := tx1.TraceContext().Trace
+		tx1.Done(10 * time.Millisecond)
+		tx2.Done(20 * time.Millisecond)
+		tracer.SetMetricsInterval(10 * time.Millisecond)
+
+		req := receiveMetrics(t, metrics)
+		req.Result <- nil
+		require.Len(t, req.Payload.Metrics, 2)
+		assert.Equal(t, model.Metric{
+			Value: 2,
+			Type:  model.MetricTypeDeltaCounter,
+		}, req.Payload.Metrics[0].Samples["transaction.breakdown.count"])
+
+		var exemplar *model.MetricExemplar
+		if exemplars {
+			exemplar = &model.MetricExemplar{TraceID: traceID.String()}
+		}
+		assert.Equal(t, &model.Metrics{
+			Timestamp: req.Payload.Metrics[0].Timestamp,
+			Labels: map[string]string{
+				"transaction.name": "name",
+				"transaction.type": "type",
+			},
+			Samples: map[string]model.Metric{
+				"transaction.duration.count":     {Value: 2, Exemplar: exemplar, Type: model.MetricTypeDeltaCounter},
+				"transaction.duration.sum.us":    {Value: 30000, Exemplar: exemplar, Type: model.MetricTypeDeltaCounter},
+				"transaction.unaccounted.count":  {Value: 2, Type: model.MetricTypeDeltaCounter},
+				"transaction.unaccounted.sum.us": {Value: 30000, Type: model.MetricTypeDeltaCounter},
+			},
+		}, req.Payload.Metrics[1])
+	}
+}
+
+func TestBreakdownMetricsUnaccountedDuration(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	metrics := make(chan transporttest.SendMetricsRequest)
+	tracer.Transport = &transporttest.ChannelTransport{Metrics: metrics}
+
+	// Spans cover 10-40ms and 50-70ms of a 100ms transaction, with
+	// one nested and one overlapping span, leaving 50ms unaccounted.
+	tx := tracer.StartTransaction("name", "type")
+	for _, timing := range [][2]time.Duration{
+		{10 * time.Millisecond, 20 * time.Millisecond},
+		{15 * time.Millisecond, 5 * time.Millisecond},
+		{20 * time.Millisecond, 20 * time.Millisecond},
+		{50 * time.Millisecond, 20 * time.Millisecond},
+	} {
+		span := tx.StartSpan("name", "type", nil)
+		span.Start = timing[0]
+		span.Done(timing[1])
+	}
+	tx.Done(100 * time.Millisecond)
+
+	// Non-sampled transactions have no spans, and
+	// are excluded from the unaccounted time.
+	tracer.SetSampler(elasticapm.NewRatioSampler(0, rand.NewSource(0)))
+	tracer.StartTransaction("name", "type").Done(100 * time.Millisecond)
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+
+	req := receiveMetrics(t, metrics)
+	req.Result <- nil
+	require.Len(t, req.Payload.Metrics, 2)
+	assert.Equal(t, map[string]model.Metric{
+		"transaction.duration.count":     {Value: 2, Type: model.MetricTypeDeltaCounter},
+		"transaction.duration.sum.us":    {Value: 200000, Type: model.MetricTypeDeltaCounter},
+		"transaction.unaccounted.count":  {Value: 1, Type: model.MetricTypeDeltaCounter},
+		"transaction.unaccounted.sum.us": {Value: 50000, Type: model.MetricTypeDeltaCounter},
+	}, req.Payload.Metrics[1].Samples)
+}
+
+func TestBreakdownMetricsConcurrentGather(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+
+	// Gather metrics frequently while transactions are recorded,
+	// so that some gathers find no aggregated durations while
+	// transactions are being added. Run with -race.
+	tracer.SetMetricsInterval(time.Millisecond)
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		tracer.StartTransaction("name", "type").Done(time.Millisecond)
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+func TestBreakdownMetricsDisabled(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	metrics := make(chan transporttest.SendMetricsRequest)
+	tracer.Transport = &transporttest.ChannelTransport{Metrics: metrics}
+	tracer.SetBreakdownMetrics(false)
+
+	tracer.StartTransaction("name", "type").Done(-1)
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+
+	req := receiveMetrics(t, metrics)
+	req.Result <- nil
+	require.Len(t, req.Payload.Metrics, 1)
+	assert.Nil(t, req.Payload.Metrics[0].Labels)
+	assert.NotContains(t, req.Payload.Metrics[0].Samples, "transaction.breakdown.count")
+}
+
+func TestBreakdownMetricsNotGathered(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	metrics := make(chan transporttest.SendMetricsRequest)
+	tracer.Transport = &transporttest.ChannelTransport{Metrics: metrics}
+
+	// Transactions ended while metrics are not gathered
+	// are not recorded, as they would never be reported.
+	tracer.SetMetricsInterval(0)
+	tracer.StartTransaction("name", "type").Done(-1)
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+
+	req := receiveMetrics(t, metrics)
+	req.Result <- nil
+	require.Len(t, req.Payload.Metrics, 1)
+	assert.NotContains(t, req.Payload.Metrics[0].Samples, "transaction.breakdown.count")
+}
+
+func TestBreakdownMetricsOverflow(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	metrics := make(chan transporttest.SendMetricsRequest)
+	transactions := make(chan transporttest.SendTransactionsRequest)
+	tracer.Transport = &transporttest.ChannelTransport{
+		Transactions: transactions,
+		Metrics:      metrics,
+	}
+	go func() {
+		for req := range transactions {
+			req.Result <- nil
+		}
+	}()
+
+	const maxKeys = 1000
+	for i := 0; i < maxKeys+2; i++ {
+		tracer.StartTransaction(fmt.Sprintf("name%d", i), "type").Done(-1)
+	}
+	tracer.StartTransaction("name0", "type").Done(-1)
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+
+	// Gathering this many metrics may take longer than the
+	// interval, so a later gathering may be sent first.
+	req := receiveMetrics(t, metrics)
+	req.Result <- nil
+	for len(req.Payload.Metrics) < 2 {
+		req = receiveMetrics(t, metrics)
+		req.Result <- nil
+	}
+	require.Len(t, req.Payload.Metrics, maxKeys+1)
+	assert.Equal(t, map[string]model.Metric{
+		"transaction.breakdown.count":          {Value: maxKeys + 3, Type: model.MetricTypeDeltaCounter},
+		"transaction.breakdown.overflow.count": {Value: 2, Type: model.MetricTypeDeltaCounter},
+	}, filterSamples(req.Payload.Metrics[0].Samples, "transaction.breakdown."))
+}
+
+// filterSamples returns the samples whose names have the given prefix.
+func filterSamples(samples map[string]model.Metric, prefix string) map[string]model.Metric {
+	filtered := make(map[string]model.Metric)
+	for name, sample := range samples {
+		if strings.HasPrefix(name, prefix) {
+			filtered[name] = sample
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,38 @@
+package elasticapm
+
+import (
+	"context"
+	"runtime"
+)
+
+// builtinMetricsGatherer is a MetricsGatherer which gathers
+// Go runtime metrics, and process metrics where supported.
+type builtinMetricsGatherer struct{}
+
+// GatherMetrics gathers Go runtime and process metrics.
+func (builtinMetricsGatherer) GatherMetrics(ctx context.Context, m *Metrics) error {
+	gatherRuntimeMetrics(m)
+	gatherProcessMetrics(m)
+	return nil
+}
+
+func gatherRuntimeMetrics(m *Metrics) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	m.Add("golang.goroutines", nil, float64(runtime.NumGoroutine()))
+	m.AddCounter("golang.heap.allocations.mallocs", nil, float64(mem.Mallocs))
+	m.AddCounter("golang.heap.allocations.frees", nil, float64(mem.Frees))
+	m.Add("golang.heap.allocations.objects", nil, float64(mem.HeapObjects))
+	m.AddCounter("golang.heap.allocations.total", nil, float64(mem.TotalAlloc))
+	m.Add("golang.heap.allocations.allocated", nil, float64(mem.HeapAlloc))
+	m.Add("golang.heap.allocations.idle", nil, float64(mem.HeapIdle))
+	m.Add("golang.heap.allocations.active", nil, float64(mem.HeapInuse))
+	m.Add("golang.heap.system.total", nil, float64(mem.Sys))
+	m.Add("golang.heap.system.obtained", nil, float64(mem.HeapSys))
+	m.Add("golang.heap.system.stack", nil, float64(mem.StackSys))
+	m.Add("golang.heap.system.released", nil, float64(mem.HeapReleased))
+	m.Add("golang.heap.gc.next_gc_limit", nil, float64(mem.NextGC))
+	m.AddCounter("golang.heap.gc.total_count", nil, float64(mem.NumGC))
+	m.AddCounter("golang.heap.gc.total_pause.ns", nil, float64(mem.PauseTotalNs))
+	m.Add("golang.heap.gc.cpu_fraction", nil, mem.GCCPUFraction)
+}
@@ -0,0 +1,53 @@
+package elasticapm
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// gatherProcessMetrics gathers process metrics from /proc/self.
+// Any metrics that cannot be read are omitted.
+func gatherProcessMetrics(m *Metrics) {
+	if f, err := os.Open("/proc/self/fd"); err == nil {
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err == nil {
+			// Exclude the file descriptor used to read the directory.
+			m.Add("system.process.fd.open", nil, float64(len(names)-1))
+		}
+	}
+
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) < 2 {
+			continue
+		}
+		var name string
+		var multiplier float64 = 1
+		switch string(fields[0]) {
+		case "Threads:":
+			name = "system.process.num_threads"
+		case "VmRSS:":
+			name = "system.process.memory.rss.bytes"
+			multiplier = 1024 // kB
+		case "VmSize:":
+			name = "system.process.memory.size"
+			multiplier = 1024 // kB
+		default:
+			continue
+		}
+		value, err := strconv.ParseFloat(string(fields[1]), 64)
+		if err != nil {
+			continue
+		}
+		m.Add(name, nil, value*multiplier)
+	}
+}
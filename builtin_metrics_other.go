@@ -0,0 +1,6 @@
+//+build !linux
+
+package elasticapm
+
+// gatherProcessMetrics is a no-op on platforms other than Linux.
+func gatherProcessMetrics(m *Metrics) {}
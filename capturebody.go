@@ -0,0 +1,50 @@
+package elasticapm
+
+// CaptureBodyMode holds a value indicating how a tracer should capture
+// HTTP request bodies: for transactions, for errors, for both, or neither.
+type CaptureBodyMode int
+
+const (
+	// CaptureBodyOff disables capturing of HTTP request bodies. This is
+	// the default mode.
+	CaptureBodyOff CaptureBodyMode = 0
+
+	// CaptureBodyErrors captures HTTP request bodies for only errors.
+	CaptureBodyErrors CaptureBodyMode = 1
+
+	// CaptureBodyTransactions captures HTTP request bodies for only
+	// transactions.
+	CaptureBodyTransactions CaptureBodyMode = 1 << 1
+
+	// CaptureBodyAll captures HTTP request bodies for both transactions
+	// and errors.
+	CaptureBodyAll CaptureBodyMode = CaptureBodyErrors | CaptureBodyTransactions
+)
+
+// CaptureBodyLimits holds limits on the HTTP body content captured
+// by instrumentation modules, protecting against excessive memory
+// usage and payload sizes when capturing large bodies.
+type CaptureBodyLimits struct {
+	// MaxFormFields is the maximum number of form fields (including
+	// uploaded files) captured for a request body. Additional fields
+	// are discarded, and the body is marked as truncated. If this is
+	// non-positive, the number of form fields is unlimited.
+	MaxFormFields int
+
+	// MaxSize is the maximum number of bytes of body content captured
+	// for a request or response body. Additional content is discarded,
+	// and the body is marked as truncated. If this is non-positive,
+	// the captured body size is unlimited.
+	MaxSize int
+}
+
+// Errors reports whether or not request bodies should be captured for errors.
+func (m CaptureBodyMode) Errors() bool {
+	return m&CaptureBodyErrors != 0
+}
+
+// Transactions reports whether or not request bodies should be captured
+// for transactions.
+func (m CaptureBodyMode) Transactions() bool {
+	return m&CaptureBodyTransactions != 0
+}
@@ -0,0 +1,46 @@
+package elasticapm
+
+import "time"
+
+// circuitBreaker tracks consecutive failures to send to the APM
+// server. Once the number of consecutive failures reaches the
+// threshold, the circuit is opened: sending is disabled, and
+// events are dropped, until the cooldown period has elapsed.
+//
+// After the cooldown period, sending is re-enabled on a trial
+// basis: the next failure will re-open the circuit immediately,
+// while a success will close it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+type circuitBreakerConfig struct {
+	threshold int
+	cooldown  time.Duration
+}
+
+// open reports whether or not the circuit is open at time now.
+func (c *circuitBreaker) open(now time.Time) bool {
+	return now.Before(c.openUntil)
+}
+
+// success records a successful send, closing the circuit.
+func (c *circuitBreaker) success() {
+	c.failures = 0
+	c.openUntil = time.Time{}
+}
+
+// failure records a failed send at time now, and reports whether
+// or not the failure caused the circuit to be opened. If the
+// threshold is non-positive, the circuit will never be opened.
+func (c *circuitBreaker) failure(now time.Time) bool {
+	c.failures++
+	if c.threshold <= 0 || c.failures < c.threshold {
+		return false
+	}
+	c.openUntil = now.Add(c.cooldown)
+	return true
+}
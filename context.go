@@ -1,6 +1,11 @@
 package elasticapm
 
-import "context"
+import (
+	"context"
+	"sync"
+
+	"github.com/elastic/apm-agent-go/model"
+)
 
 // ContextWithSpan returns a copy of parent in which the given span
 // is stored, associated with the key ContextSpanKey.
@@ -30,6 +35,39 @@ func TransactionFromContext(ctx context.Context) *Transaction {
 	return tx
 }
 
+// WithTransaction starts a new transaction with the specified name and
+// type using DefaultTracer, and returns a copy of parent in which the
+// transaction is stored, along with a function for ending the transaction.
+//
+// The returned function sets the transaction's result and calls its Done
+// method with a negative duration. It is safe to call the function more
+// than once; only the first call will have any effect. e.g.
+//
+//	ctx, done := elasticapm.WithTransaction(ctx, "name", "type")
+//	defer done("success")
+//
+// If parent already contains a transaction, the new transaction will be
+// nested within it, and an error will be logged; see DetectNestedTransaction.
+// If DefaultTracer is configured with SetNestedTransactionSpans(true), then
+// a span is started within the existing transaction instead, and the result
+// passed to the returned function is ignored.
+func WithTransaction(parent context.Context, name, transactionType string) (context.Context, func(result string)) {
+	var once sync.Once
+	if DefaultTracer.DetectNestedTransaction(parent, name, 1) && DefaultTracer.NestedTransactionSpans() {
+		span, ctx := StartSpan(parent, name, transactionType)
+		return ctx, func(string) {
+			once.Do(func() { span.DoneContext(ctx, -1) })
+		}
+	}
+	tx := DefaultTracer.StartTransaction(name, transactionType)
+	return ContextWithTransaction(parent, tx), func(result string) {
+		once.Do(func() {
+			tx.Result = result
+			tx.Done(-1)
+		})
+	}
+}
+
 // StartSpan starts and returns a new Span within the sampled transaction
 // and parent span in the context, if any, and returns the span along with
 // a new context containing the span.
@@ -37,14 +75,41 @@ func TransactionFromContext(ctx context.Context) *Transaction {
 // If there is no transaction in the context, or it is not being sampled,
 // StartSpan returns nil.
 func StartSpan(ctx context.Context, name, spanType string) (*Span, context.Context) {
+	return StartSpanOptions(ctx, name, spanType, SpanOptions{})
+}
+
+// StartSpanOptions is like StartSpan, but starts the span with the
+// given options. If opts.Parent is nil and opts.TransactionParent is
+// false, the span in the context, if any, is used as the parent.
+//
+// If the context records a retry attempt, the span is labelled with
+// the attempt number; see ContextWithRetryAttempt.
+func StartSpanOptions(ctx context.Context, name, spanType string, opts SpanOptions) (*Span, context.Context) {
 	tx := TransactionFromContext(ctx)
 	if tx == nil || !tx.Sampled() {
 		return nil, ctx
 	}
-	span := tx.StartSpan(name, spanType, SpanFromContext(ctx))
+	if opts.Parent == nil && !opts.TransactionParent {
+		opts.Parent = SpanFromContext(ctx)
+	}
+	span := tx.StartSpanOptions(name, spanType, opts)
+	ctx = setRetryAttempt(ctx, span)
 	return span, context.WithValue(ctx, contextSpanKey{}, span)
 }
 
+// RecordSpan records a completed span within the sampled transaction
+// in the context, if any, as a child of the span in the context, if any.
+// See Transaction.RecordSpan for details.
+//
+// If there is no transaction in the context, RecordSpan does nothing.
+func RecordSpan(ctx context.Context, span model.Span) error {
+	tx := TransactionFromContext(ctx)
+	if tx == nil {
+		return nil
+	}
+	return tx.RecordSpan(span, SpanFromContext(ctx))
+}
+
 // CaptureError returns a new Error related to the sampled transaction
 // present in the context, if any, and calls its SetException method
 // with the given error. The Exception.Handled field will be set to true.
@@ -67,5 +132,29 @@ func CaptureError(ctx context.Context, err error) *Error {
 	return e
 }
 
+// Trace starts a new span within the sampled transaction and parent
+// span in ctx, if any, and calls fn with a context containing the span.
+// The span is ended when fn returns, even if fn panics. If fn returns
+// a non-nil error, the error is captured with CaptureError and sent.
+//
+// If there is no transaction in the context, or it is not being sampled,
+// fn is still called, but no span is created and no error is captured.
+// In either case, Trace returns the error returned by fn. e.g.
+//
+//	err := elasticapm.Trace(ctx, "name", "type", func(ctx context.Context) error {
+//		return doSomething(ctx)
+//	})
+func Trace(ctx context.Context, name, spanType string, fn func(context.Context) error) error {
+	span, ctx := StartSpan(ctx, name, spanType)
+	if span != nil {
+		defer span.DoneContext(ctx, -1)
+	}
+	err := fn(ctx)
+	if e := CaptureError(ctx, err); e != nil {
+		e.Send()
+	}
+	return err
+}
+
 type contextSpanKey struct{}
 type contextTransactionKey struct{}
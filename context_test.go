@@ -0,0 +1,308 @@
+package elasticapm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestWithTransaction(t *testing.T) {
+	var r transporttest.RecorderTransport
+	transport := elasticapm.DefaultTracer.Transport
+	elasticapm.DefaultTracer.Transport = &r
+	defer func() { elasticapm.DefaultTracer.Transport = transport }()
+
+	ctx, done := elasticapm.WithTransaction(context.Background(), "name", "type")
+	tx := elasticapm.TransactionFromContext(ctx)
+	if assert.NotNil(t, tx) {
+		assert.Equal(t, "name", tx.Name)
+		assert.Equal(t, "type", tx.Type)
+	}
+	done("success")
+	done("failure") // no-op
+
+	elasticapm.DefaultTracer.Flush(nil)
+	payloads := r.Payloads()
+	assert.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	assert.Len(t, transactions, 1)
+	transaction := transactions[0].(map[string]interface{})
+	assert.Equal(t, "success", transaction["result"])
+}
+
+func TestTrace(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	assert.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	traceErr := errors.New("boom")
+	var spanInContext *elasticapm.Span
+	err = elasticapm.Trace(ctx, "span", "type", func(ctx context.Context) error {
+		spanInContext = elasticapm.SpanFromContext(ctx)
+		return traceErr
+	})
+	assert.Equal(t, traceErr, err)
+	assert.NotNil(t, spanInContext)
+
+	// The span is ended even if the function panics.
+	assert.Panics(t, func() {
+		elasticapm.Trace(ctx, "panicky", "type", func(ctx context.Context) error {
+			panic("oops")
+		})
+	})
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	assert.Len(t, payloads, 2)
+	errors := payloads[0]["errors"].([]interface{})
+	assert.Len(t, errors, 1)
+	exception := errors[0].(map[string]interface{})["exception"].(map[string]interface{})
+	assert.Equal(t, "boom", exception["message"])
+
+	transactions := payloads[1]["transactions"].([]interface{})
+	spans := transactions[0].(map[string]interface{})["spans"].([]interface{})
+	assert.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, "type", span.(map[string]interface{})["type"])
+	}
+}
+
+func TestTraceContextCancelled(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	assert.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx, cancel := context.WithTimeout(elasticapm.ContextWithTransaction(context.Background(), tx), time.Millisecond)
+	defer cancel()
+	elasticapm.Trace(ctx, "span", "type", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	spans := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 1)
+	span := spans[0].(map[string]interface{})
+	assert.Equal(t, "failure", span["outcome"])
+	assert.Equal(t, map[string]interface{}{
+		"tags": map[string]interface{}{"context_error": "deadline_exceeded"},
+	}, span["context"])
+}
+
+func TestStartSpanOptions(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	request, ctx := elasticapm.StartSpan(ctx, "request", "type")
+	other := tx.StartSpan("other", "type", nil)
+
+	child, _ := elasticapm.StartSpanOptions(ctx, "child", "type", elasticapm.SpanOptions{})
+	explicit, _ := elasticapm.StartSpanOptions(ctx, "explicit", "type", elasticapm.SpanOptions{Parent: other})
+	background, _ := elasticapm.StartSpanOptions(ctx, "background", "type", elasticapm.SpanOptions{
+		TransactionParent: true,
+	})
+	for _, span := range []*elasticapm.Span{background, explicit, child, other, request} {
+		span.Done(-1)
+	}
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	transaction := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	spans := transaction["spans"].([]interface{})
+	require.Len(t, spans, 5)
+	parentIDs := make(map[string]interface{})
+	spanIDs := make(map[string]interface{})
+	for _, span := range spans {
+		span := span.(map[string]interface{})
+		parentIDs[span["name"].(string)] = span["parent_id"]
+		spanIDs[span["name"].(string)] = span["span_id"]
+	}
+	assert.Equal(t, map[string]interface{}{
+		"request":    transaction["span_id"],
+		"other":      transaction["span_id"],
+		"child":      spanIDs["request"],
+		"explicit":   spanIDs["other"],
+		"background": transaction["span_id"],
+	}, parentIDs)
+}
+
+func TestStartSpanRetryAttempt(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	assert.Equal(t, 0, elasticapm.RetryAttemptFromContext(ctx))
+	for attempt := 1; attempt <= 2; attempt++ {
+		ctx := elasticapm.ContextWithRetryAttempt(ctx, attempt)
+		assert.Equal(t, attempt, elasticapm.RetryAttemptFromContext(ctx))
+		span, ctx := elasticapm.StartSpan(ctx, "call", "type")
+		assert.Equal(t, 0, elasticapm.RetryAttemptFromContext(ctx))
+		child, _ := elasticapm.StartSpan(ctx, "child", "type")
+		child.Done(-1)
+		span.Done(-1)
+	}
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	spans := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 4)
+	var attempts []interface{}
+	for _, span := range spans {
+		span := span.(map[string]interface{})
+		var attempt interface{}
+		if context, ok := span["context"].(map[string]interface{}); ok {
+			attempt = context["tags"].(map[string]interface{})["retry_attempt"]
+		}
+		attempts = append(attempts, []interface{}{span["name"], attempt})
+	}
+	assert.Equal(t, []interface{}{
+		[]interface{}{"call", "1"},
+		[]interface{}{"child", nil},
+		[]interface{}{"call", "2"},
+		[]interface{}{"child", nil},
+	}, attempts)
+}
+
+func TestRecordSpan(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tx := tracer.StartTransactionOptions("name", "type", elasticapm.TransactionOptions{
+		Start: time.Now().Add(-time.Second),
+	})
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	parent, ctx := elasticapm.StartSpan(ctx, "parent", "type")
+
+	err = elasticapm.RecordSpan(ctx, model.Span{
+		Name:     "external",
+		Type:     "ext.subprocess",
+		Start:    100 * time.Millisecond,
+		Duration: 200 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	// Timings are clamped to the transaction's elapsed time.
+	err = tx.RecordSpan(model.Span{
+		Name:     "clamped",
+		Type:     "ext.subprocess",
+		Start:    -time.Second,
+		Duration: time.Hour,
+	}, nil)
+	assert.NoError(t, err)
+
+	assert.EqualError(t, tx.RecordSpan(model.Span{Type: "type"}, nil), "span name must be specified")
+	assert.EqualError(t, tx.RecordSpan(model.Span{Name: "name"}, nil), "span type must be specified")
+
+	parent.Done(-1)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	transaction := transactions[0].(map[string]interface{})
+	spans := transaction["spans"].([]interface{})
+	require.Len(t, spans, 3)
+
+	span1 := spans[1].(map[string]interface{})
+	assert.Equal(t, "external", span1["name"])
+	assert.Equal(t, "ext.subprocess", span1["type"])
+	assert.Equal(t, float64(100), span1["start"])
+	assert.Equal(t, float64(200), span1["duration"])
+	assert.Equal(t, spans[0].(map[string]interface{})["id"], span1["parent"])
+
+	span2 := spans[2].(map[string]interface{})
+	assert.Equal(t, "clamped", span2["name"])
+	assert.Equal(t, float64(0), span2["start"])
+	assert.InDelta(t, float64(1000), span2["duration"], 1000)
+	assert.True(t, span2["duration"].(float64) <= transaction["duration"].(float64))
+	assert.NotContains(t, span2, "parent")
+}
+
+func TestRecordSpanNoTransaction(t *testing.T) {
+	err := elasticapm.RecordSpan(context.Background(), model.Span{})
+	assert.NoError(t, err)
+}
+
+func TestTraceNoTransaction(t *testing.T) {
+	var called bool
+	traceErr := errors.New("boom")
+	err := elasticapm.Trace(context.Background(), "span", "type", func(ctx context.Context) error {
+		called = true
+		assert.Nil(t, elasticapm.SpanFromContext(ctx))
+		return traceErr
+	})
+	assert.True(t, called)
+	assert.Equal(t, traceErr, err)
+}
+
+func TestWithTransactionNested(t *testing.T) {
+	var r transporttest.RecorderTransport
+	transport := elasticapm.DefaultTracer.Transport
+	elasticapm.DefaultTracer.Transport = &r
+	defer func() { elasticapm.DefaultTracer.Transport = transport }()
+	elasticapm.DefaultTracer.SetNestedTransactionSpans(true)
+	defer elasticapm.DefaultTracer.SetNestedTransactionSpans(false)
+	var logger recordingLogger
+	elasticapm.DefaultTracer.SetLogger(&logger)
+	defer elasticapm.DefaultTracer.SetLogger(nil)
+
+	ctx, done := elasticapm.WithTransaction(context.Background(), "outer", "type")
+	innerCtx, innerDone := elasticapm.WithTransaction(ctx, "inner", "type")
+	assert.Equal(t, elasticapm.TransactionFromContext(ctx), elasticapm.TransactionFromContext(innerCtx))
+	assert.NotNil(t, elasticapm.SpanFromContext(innerCtx))
+	innerDone("ignored")
+	done("success")
+
+	elasticapm.DefaultTracer.Flush(nil)
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 1)
+	transaction := transactions[0].(map[string]interface{})
+	assert.Equal(t, "outer", transaction["name"])
+	spans := transaction["spans"].([]interface{})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "inner", spans[0].(map[string]interface{})["name"])
+
+	// The nested transaction is reported at the caller of
+	// WithTransaction, rather than within WithTransaction.
+	logged := logger.errors()
+	require.Len(t, logged, 1)
+	assert.Regexp(t, `^transaction "inner" started within transaction "outer" by .*TestWithTransactionNested \(.*context_test.go:\d+\)$`, logged[0])
+}
@@ -0,0 +1,132 @@
+package apmamqp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmamqp"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestPublishConsume(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("apmamqp_test", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	var p recordingPublisher
+	headers := amqp.Table{"foo": "bar"}
+	tx := tracer.StartTransactionOptions("name", "type", elasticapm.TransactionOptions{
+		TraceContext: elasticapm.TraceContext{
+			Baggage: elasticapm.ParseBaggage("tenant=acme"),
+		},
+	})
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	err = apmamqp.Publish(ctx, &p, "orders", "orders.new", false, false, amqp.Publishing{
+		Headers:   headers,
+		Timestamp: time.Now().Add(-time.Second),
+		Body:      []byte("hello"),
+	})
+	require.NoError(t, err)
+	tx.Done(-1)
+	assert.Equal(t, amqp.Table{"foo": "bar"}, headers) // not modified
+
+	require.Len(t, p.published, 1)
+	msg := p.published[0]
+	d := apmamqp.WrapDelivery(tracer, "orders-queue", amqp.Delivery{
+		Headers:    msg.Headers,
+		Timestamp:  msg.Timestamp,
+		RoutingKey: "orders.new",
+		Body:       msg.Body,
+	})
+	assert.Equal(t, tx.TraceContext().Trace, d.Transaction.TraceContext().Trace)
+	assert.Equal(t, tx.TraceContext().Baggage, d.Transaction.TraceContext().Baggage)
+	assert.Equal(t, d.Transaction, elasticapm.TransactionFromContext(d.Context(context.Background())))
+	d.Transaction.Done(-1)
+	tracer.Flush(nil)
+
+	var transactions []map[string]interface{}
+	for _, p := range r.Payloads() {
+		for _, tx := range p["transactions"].([]interface{}) {
+			transactions = append(transactions, tx.(map[string]interface{}))
+		}
+	}
+	require.Len(t, transactions, 2)
+	spans := transactions[0]["spans"].([]interface{})
+	require.Len(t, spans, 1)
+	span := spans[0].(map[string]interface{})
+	assert.Equal(t, "RabbitMQ SEND to orders", span["name"])
+	assert.Equal(t, "messaging.rabbitmq.send", span["type"])
+	assert.Equal(t, true, span["exit"])
+	assert.Equal(t, map[string]interface{}{
+		"destination": map[string]interface{}{
+			"service": map[string]interface{}{
+				"type":     "messaging",
+				"name":     "rabbitmq",
+				"resource": "rabbitmq/orders",
+			},
+		},
+		"message": map[string]interface{}{
+			"queue":       map[string]interface{}{"name": "orders"},
+			"routing_key": "orders.new",
+		},
+	}, span["context"])
+
+	consumer := transactions[1]
+	assert.Equal(t, "RabbitMQ RECEIVE from orders-queue", consumer["name"])
+	assert.Equal(t, "messaging", consumer["type"])
+	assert.Equal(t, span["span_id"], consumer["parent_id"])
+	message := consumer["context"].(map[string]interface{})["message"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"name": "orders-queue"}, message["queue"])
+	assert.Equal(t, "orders.new", message["routing_key"])
+	assert.Contains(t, message, "age")
+}
+
+func TestPublishNoTransaction(t *testing.T) {
+	var p recordingPublisher
+	err := apmamqp.Publish(context.Background(), &p, "", "key", false, false, amqp.Publishing{})
+	require.NoError(t, err)
+	require.Len(t, p.published, 1)
+	assert.Nil(t, p.published[0].Headers)
+}
+
+func TestWrapDeliveryByteHeaders(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("apmamqp_test", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+
+	// Other clients may encode string headers as byte arrays.
+	d := apmamqp.WrapDelivery(tracer, "queue", amqp.Delivery{
+		Headers: amqp.Table{
+			apmamqp.TraceparentHeader: []byte("00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01"),
+			apmamqp.TracestateHeader:  []byte("es=s:1"),
+			apmamqp.BaggageHeader:     []byte("tenant=acme"),
+		},
+	})
+	defer d.Transaction.Done(-1)
+	assert.Equal(t, elasticapm.TraceContext{
+		Trace:   elasticapm.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Span:    elasticapm.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		Options: elasticapm.TraceOptions(0).WithSampled(true),
+		State:   elasticapm.TraceState{{Key: "es", Value: "s:1"}},
+		Baggage: elasticapm.Baggage{{Key: "tenant", Value: "acme"}},
+	}, d.Transaction.TraceContext())
+	assert.Nil(t, d.Transaction.Context.Message.Age) // no timestamp
+}
+
+type recordingPublisher struct {
+	published []amqp.Publishing
+}
+
+func (p *recordingPublisher) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.published = append(p.published, msg)
+	return nil
+}
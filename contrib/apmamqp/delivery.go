@@ -0,0 +1,55 @@
+package apmamqp
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+// Delivery wraps an amqp.Delivery, holding the transaction
+// started for processing the message.
+type Delivery struct {
+	amqp.Delivery
+
+	// Transaction is the transaction started for processing the
+	// message. The consumer must end it by calling its Done method
+	// when it has finished processing the message.
+	Transaction *elasticapm.Transaction
+}
+
+// WrapDelivery starts a transaction of type "messaging" for the
+// delivered message d, received from the named queue, and returns
+// a Delivery holding it.
+//
+// If the message headers hold a trace context propagated by Publish,
+// the transaction continues the trace. The transaction's message
+// context records the queue name and routing key, and the age of the
+// message if the publisher set the message timestamp.
+//
+// If tracer is nil, elasticapm.DefaultTracer will be used.
+func WrapDelivery(tracer *elasticapm.Tracer, queue string, d amqp.Delivery) Delivery {
+	if tracer == nil {
+		tracer = elasticapm.DefaultTracer
+	}
+	var opts elasticapm.TransactionOptions
+	if c, ok := extractTraceContext(d.Headers); ok {
+		opts.TraceContext = c
+	}
+	name := "RabbitMQ RECEIVE from " + queue
+	tx := tracer.StartTransactionOptions(name, "messaging", opts)
+	tx.SetMessageContext(elasticapm.MessageContext{
+		QueueName:  queue,
+		RoutingKey: d.RoutingKey,
+		Timestamp:  d.Timestamp,
+	})
+	return Delivery{Delivery: d, Transaction: tx}
+}
+
+// Context returns a copy of ctx with d.Transaction added, so that
+// operations performed while processing the message are reported
+// as spans of the transaction.
+func (d Delivery) Context(ctx context.Context) context.Context {
+	return elasticapm.ContextWithTransaction(ctx, d.Transaction)
+}
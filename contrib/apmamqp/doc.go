@@ -0,0 +1,5 @@
+// Package apmamqp provides tracing for RabbitMQ clients using
+// github.com/streadway/amqp: publishing messages is reported as
+// spans, and consuming messages as transactions, with the trace
+// context propagated via the message headers.
+package apmamqp
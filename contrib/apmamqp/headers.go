@@ -0,0 +1,79 @@
+package apmamqp
+
+import (
+	"github.com/streadway/amqp"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+const (
+	// TraceparentHeader is the message header for propagating
+	// trace context, in the W3C Trace Context traceparent format.
+	TraceparentHeader = "elastic-apm-traceparent"
+
+	// TracestateHeader is the message header for propagating
+	// vendor-specific trace state, in the W3C Trace Context
+	// tracestate format.
+	TracestateHeader = "tracestate"
+
+	// BaggageHeader is the message header for propagating
+	// application-defined baggage, in the W3C baggage format.
+	BaggageHeader = "baggage"
+)
+
+// injectTraceContext returns a copy of headers, with the trace
+// context headers for c added. The original table is not modified,
+// as it may be shared between messages.
+func injectTraceContext(headers amqp.Table, c elasticapm.TraceContext) amqp.Table {
+	out := make(amqp.Table, len(headers)+3)
+	for k, v := range headers {
+		out[k] = v
+	}
+	out[TraceparentHeader] = elasticapm.FormatTraceParentHeader(c)
+	if len(c.State) > 0 {
+		out[TracestateHeader] = c.State.String()
+	} else {
+		delete(out, TracestateHeader)
+	}
+	if len(c.Baggage) > 0 {
+		out[BaggageHeader] = c.Baggage.String()
+	} else {
+		delete(out, BaggageHeader)
+	}
+	return out
+}
+
+// extractTraceContext returns the trace context held in headers,
+// and reports whether a valid trace context was found.
+func extractTraceContext(headers amqp.Table) (elasticapm.TraceContext, bool) {
+	traceparent, ok := headerString(headers, TraceparentHeader)
+	if !ok {
+		return elasticapm.TraceContext{}, false
+	}
+	c, err := elasticapm.ParseTraceParentHeader(traceparent)
+	if err != nil {
+		return elasticapm.TraceContext{}, false
+	}
+	if tracestate, ok := headerString(headers, TracestateHeader); ok {
+		c.State = elasticapm.ParseTraceState(tracestate)
+	}
+	if baggage, ok := headerString(headers, BaggageHeader); ok {
+		c.Baggage = elasticapm.ParseBaggage(baggage)
+	}
+	return c, true
+}
+
+// headerString returns the string value of the named header.
+//
+// AMQP header tables are typed: the string headers injected by
+// this package are decoded as strings, but other clients may
+// encode them as byte arrays, so both are accepted.
+func headerString(headers amqp.Table, name string) (string, bool) {
+	switch v := headers[name].(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
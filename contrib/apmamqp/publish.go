@@ -0,0 +1,63 @@
+package apmamqp
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/model"
+)
+
+// Publisher is the interface for publishing messages, implemented
+// by *amqp.Channel.
+type Publisher interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Publish publishes msg via p, reporting the operation as a span
+// of type "messaging.rabbitmq.send", if ctx contains a sampled
+// transaction. The span records the exchange and routing key.
+//
+// The span's trace context is propagated to consumers by adding
+// headers to a copy of msg.Headers; see WrapDelivery.
+func Publish(
+	ctx context.Context,
+	p Publisher,
+	exchange, key string,
+	mandatory, immediate bool,
+	msg amqp.Publishing,
+) error {
+	span, _ := elasticapm.StartSpan(ctx, "RabbitMQ SEND to "+exchangeName(exchange), "messaging.rabbitmq.send")
+	if span == nil {
+		return p.Publish(exchange, key, mandatory, immediate, msg)
+	}
+	defer span.DoneContext(ctx, -1)
+	span.Exit = true
+	span.Context = &model.SpanContext{
+		Destination: &model.DestinationSpanContext{
+			Service: &model.DestinationServiceSpanContext{
+				Type:     "messaging",
+				Name:     "rabbitmq",
+				Resource: "rabbitmq/" + exchangeName(exchange),
+			},
+		},
+		Message: &model.MessageSpanContext{
+			Queue:      &model.MessageQueueSpanContext{Name: exchangeName(exchange)},
+			RoutingKey: key,
+		},
+	}
+	if !span.Dropped() {
+		msg.Headers = injectTraceContext(msg.Headers, span.TraceContext())
+	}
+	return p.Publish(exchange, key, mandatory, immediate, msg)
+}
+
+// exchangeName returns a name for exchange to use in span names
+// and context, as the default exchange has an empty name.
+func exchangeName(exchange string) string {
+	if exchange == "" {
+		return "<default>"
+	}
+	return exchange
+}
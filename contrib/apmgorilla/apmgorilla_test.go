@@ -0,0 +1,63 @@
+package apmgorilla_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmgorilla"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestInstrument(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, req *http.Request) {})
+	h := apmgorilla.Instrument(r, tracer)
+
+	for _, path := range []string{"/users/1", "/users/2", "/unknown"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://server.testing"+path, nil)
+		h.ServeHTTP(w, req)
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 3)
+	var names, originalNames []interface{}
+	for _, tx := range transactions {
+		tx := tx.(map[string]interface{})
+		names = append(names, tx["name"])
+		tags, _ := tx["context"].(map[string]interface{})["tags"].(map[string]interface{})
+		originalNames = append(originalNames, tags["original_name"])
+	}
+	assert.Equal(t, []interface{}{
+		"GET /users/{id}",
+		"GET /users/{id}",
+		"GET /unknown",
+	}, names)
+	assert.Equal(t, []interface{}{
+		"GET /users/1",
+		"GET /users/2",
+		nil,
+	}, originalNames)
+}
+
+func newRecordingTracer() (*elasticapm.Tracer, *transporttest.RecorderTransport) {
+	var transport transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("apmgorilla_test", "0.1")
+	if err != nil {
+		panic(err)
+	}
+	tracer.Transport = &transport
+	return tracer, &transport
+}
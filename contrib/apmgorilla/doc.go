@@ -0,0 +1,3 @@
+// Package apmgorilla provides middleware for naming transactions
+// according to the routes matched by gorilla/mux.
+package apmgorilla
@@ -0,0 +1,51 @@
+package apmgorilla
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmhttp"
+)
+
+// Instrument instruments the mux.Router so that requests are traced
+// using the given tracer, or elasticapm.DefaultTracer if the tracer
+// is nil, with transactions named according to the matched route.
+//
+// Instrument registers Middleware with r, and returns an
+// apmhttp.Handler wrapping r. The returned handler should be
+// used in place of r for serving requests.
+func Instrument(r *mux.Router, tracer *elasticapm.Tracer) http.Handler {
+	r.Use(Middleware())
+	return &apmhttp.Handler{
+		Handler: r,
+		Tracer:  tracer,
+	}
+}
+
+// Middleware returns a mux.MiddlewareFunc which renames the transaction
+// in the request context, if any, using the request method and the
+// path template of the matched route, e.g. "GET /users/{id}". The
+// name given by the wrapping handler is recorded in the transaction's
+// "original_name" tag; see elasticapm.Transaction.Rename.
+//
+// The route is resolved by mux while serving the request, so the
+// transaction must be started by a handler wrapping the router,
+// such as apmhttp.Handler, and the returned middleware registered
+// with the router via Router.Use. Requests that match no route
+// will retain the name given to them by the wrapping handler.
+func Middleware() mux.MiddlewareFunc {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if tx := elasticapm.TransactionFromContext(req.Context()); tx != nil {
+				if route := mux.CurrentRoute(req); route != nil {
+					if tpl, err := route.GetPathTemplate(); err == nil {
+						tx.Rename(req.Method + " " + tpl)
+					}
+				}
+			}
+			h.ServeHTTP(w, req)
+		})
+	}
+}
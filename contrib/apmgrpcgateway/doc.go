@@ -0,0 +1,3 @@
+// Package apmgrpcgateway provides support for naming transactions
+// according to the path templates matched by grpc-gateway.
+package apmgrpcgateway
@@ -0,0 +1,53 @@
+package apmgrpcgateway
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmhttp"
+)
+
+// Instrument returns an apmhttp.Handler wrapping mux, so that requests
+// are traced using the given tracer, or elasticapm.DefaultTracer if the
+// tracer is nil. The mux should be created with ServeMuxOption, so that
+// transactions are named according to the matched path template.
+func Instrument(mux *runtime.ServeMux, tracer *elasticapm.Tracer) http.Handler {
+	return &apmhttp.Handler{
+		Handler: mux,
+		Tracer:  tracer,
+	}
+}
+
+// ServeMuxOption returns a runtime.ServeMuxOption which renames the
+// transaction in the request context, if any, using the request method
+// and the path template of the matched pattern, e.g.
+// "GET /v1/users/{user_id}". The name given by the wrapping handler is
+// recorded in the transaction's "original_name" tag; see
+// elasticapm.Transaction.Rename.
+//
+// The pattern is resolved by the gateway while routing, and recorded in
+// the context passed to the ServeMux's metadata annotators, so the option
+// must be passed to runtime.NewServeMux. The transaction must be started
+// by a handler wrapping the mux, such as that returned by Instrument.
+// Requests that match no pattern will retain the name given to them by
+// the wrapping handler.
+func ServeMuxOption() runtime.ServeMuxOption {
+	return runtime.WithMetadata(nameTransaction)
+}
+
+// nameTransaction is a metadata annotator which names the transaction
+// in ctx by the matched pattern. It adds no metadata.
+func nameTransaction(ctx context.Context, req *http.Request) metadata.MD {
+	tx := elasticapm.TransactionFromContext(ctx)
+	if tx == nil {
+		return nil
+	}
+	if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+		tx.Rename(req.Method + " " + pattern)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package apmgrpcgateway_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmgrpcgateway"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestInstrument(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	mux := runtime.NewServeMux(apmgrpcgateway.ServeMuxOption())
+	const pattern = "/v1/users/{user_id}"
+	err := mux.HandlePath("GET", pattern, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		// Generated gateway handlers annotate the context
+		// with the matched pattern before calling the service.
+		_, err := runtime.AnnotateContext(
+			req.Context(), mux, req, "/users.Users/GetUser",
+			runtime.WithHTTPPathPattern(pattern),
+		)
+		assert.NoError(t, err)
+	})
+	require.NoError(t, err)
+	h := apmgrpcgateway.Instrument(mux, tracer)
+
+	for _, path := range []string{"/v1/users/1", "/v1/users/2", "/unknown"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://server.testing"+path, nil)
+		h.ServeHTTP(w, req)
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 3)
+	var names, originalNames []interface{}
+	for _, tx := range transactions {
+		tx := tx.(map[string]interface{})
+		names = append(names, tx["name"])
+		tags, _ := tx["context"].(map[string]interface{})["tags"].(map[string]interface{})
+		originalNames = append(originalNames, tags["original_name"])
+	}
+	assert.Equal(t, []interface{}{
+		"GET /v1/users/{user_id}",
+		"GET /v1/users/{user_id}",
+		"GET /unknown",
+	}, names)
+	assert.Equal(t, []interface{}{
+		"GET /v1/users/1",
+		"GET /v1/users/2",
+		nil,
+	}, originalNames)
+}
+
+func newRecordingTracer() (*elasticapm.Tracer, *transporttest.RecorderTransport) {
+	var transport transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("apmgrpcgateway_test", "0.1")
+	if err != nil {
+		panic(err)
+	}
+	tracer.Transport = &transport
+	return tracer, &transport
+}
@@ -0,0 +1,258 @@
+package apmhttp
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/internal/sanitize"
+	"github.com/elastic/apm-agent-go/model"
+)
+
+// bodyCapturer wraps an http.Request's body, recording
+// the body content as it is read by the handler.
+//
+// Multipart form bodies are not recorded, so that uploaded
+// files are not buffered in memory. Instead, the form parsed
+// by the handler, if any, is used to describe the body.
+//
+// The recorded content and form fields are subject to the
+// tracer's capture body limits; content beyond the limits
+// is discarded, and the body is marked as truncated.
+type bodyCapturer struct {
+	io.ReadCloser
+	request   *http.Request
+	multipart bool
+	limits    elasticapm.CaptureBodyLimits
+	buffer    bytes.Buffer
+	truncated bool
+}
+
+// captureBody wraps req.Body with a bodyCapturer, if the tracer is
+// configured to capture request bodies, and returns the bodyCapturer.
+// If the request body will not be captured, captureBody returns nil.
+func captureBody(t *elasticapm.Tracer, tx *elasticapm.Transaction, req *http.Request) *bodyCapturer {
+	if req.Body == nil {
+		return nil
+	}
+	mode := t.CaptureBody()
+	if !mode.Errors() && !(mode.Transactions() && tx.Sampled()) {
+		return nil
+	}
+	bc := &bodyCapturer{
+		ReadCloser: req.Body,
+		request:    req,
+		multipart:  mediaType(req) == "multipart/form-data",
+		limits:     t.CaptureBodyLimits(),
+	}
+	req.Body = bc
+	return bc
+}
+
+// bodyCounter wraps an http.Request's body of unknown length, e.g.
+// a chunked request body, counting the bytes read by the handler.
+type bodyCounter struct {
+	io.ReadCloser
+	n int64
+}
+
+// countBody wraps req.Body with a bodyCounter, if the transaction is
+// sampled and the request's content length is unknown, and returns
+// the bodyCounter. Otherwise, countBody returns nil.
+//
+// Servers set ContentLength to -1 for requests of unknown length;
+// a zero ContentLength with a body other than http.NoBody is also
+// treated as unknown, as for client requests.
+func countBody(tx *elasticapm.Transaction, req *http.Request) *bodyCounter {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength > 0 || !tx.Sampled() {
+		return nil
+	}
+	bc := &bodyCounter{ReadCloser: req.Body}
+	req.Body = bc
+	return bc
+}
+
+// Read reads from the original request body, counting the bytes read.
+func (bc *bodyCounter) Read(p []byte) (int, error) {
+	n, err := bc.ReadCloser.Read(p)
+	bc.n += int64(n)
+	return n, err
+}
+
+// Read reads from the original request body,
+// recording the content if the body is not
+// multipart form data, up to the maximum
+// captured body size.
+func (bc *bodyCapturer) Read(p []byte) (int, error) {
+	n, err := bc.ReadCloser.Read(p)
+	if n > 0 && !bc.multipart {
+		data := p[:n]
+		if max := bc.limits.MaxSize; max > 0 {
+			if remaining := max - bc.buffer.Len(); remaining < len(data) {
+				data = data[:remaining]
+				bc.truncated = true
+			}
+		}
+		bc.buffer.Write(data)
+	}
+	return n, err
+}
+
+// requestBody returns a model.RequestBody for the body content
+// read by the handler so far, or nil if there is none.
+//
+// For multipart form data, the non-file form fields parsed by
+// the handler are recorded, along with the sizes of any files.
+// Form field values are sanitized. Uploaded files count towards
+// the maximum number of captured form fields.
+func (bc *bodyCapturer) requestBody() *model.RequestBody {
+	maxFields := bc.limits.MaxFormFields
+	if bc.multipart {
+		form := bc.request.MultipartForm
+		if form == nil {
+			return nil
+		}
+		values, truncated := sanitizeForm(form.Value, maxFields)
+		body := &model.RequestBody{Form: values, Truncated: truncated}
+		if len(form.File) > 0 {
+			remaining := -1
+			if maxFields > 0 {
+				remaining = maxFields - len(values)
+			}
+			fileKeys := make([]string, 0, len(form.File))
+			for k := range form.File {
+				fileKeys = append(fileKeys, k)
+			}
+			sort.Strings(fileKeys)
+			for _, k := range fileKeys {
+				if remaining == 0 {
+					body.Truncated = true
+					break
+				}
+				files := form.File[k]
+				sizes := make([]int64, len(files))
+				for i, fh := range files {
+					sizes[i] = fileHeaderSize(fh)
+				}
+				if body.Files == nil {
+					body.Files = make(map[string][]int64)
+				}
+				body.Files[k] = sizes
+				remaining--
+			}
+		}
+		return body
+	}
+	if bc.buffer.Len() == 0 {
+		return nil
+	}
+	content := bc.buffer.String()
+	if mediaType(bc.request) == "application/x-www-form-urlencoded" {
+		if bc.truncated {
+			// Discard the final, possibly incomplete, field.
+			if i := strings.LastIndexByte(content, '&'); i >= 0 {
+				content = content[:i]
+			} else {
+				content = ""
+			}
+		}
+		if values, err := url.ParseQuery(content); err == nil {
+			values, truncated := sanitizeForm(values, maxFields)
+			return &model.RequestBody{
+				Form:      values,
+				Truncated: truncated || bc.truncated,
+			}
+		}
+	}
+	return &model.RequestBody{Raw: content, Truncated: bc.truncated}
+}
+
+// requestQueryParams returns the sanitized query parameters of req,
+// or nil if there are none. The number of parameters captured is
+// limited by the tracer's maximum number of captured form fields.
+func requestQueryParams(t *elasticapm.Tracer, req *http.Request) url.Values {
+	if req.URL.RawQuery == "" {
+		return nil
+	}
+	values, _ := sanitizeForm(req.URL.Query(), t.CaptureBodyLimits().MaxFormFields)
+	return values
+}
+
+// sanitizeForm returns a copy of values, with the
+// values of sensitive fields replaced by "[REDACTED]".
+//
+// If max is positive, at most max fields are copied,
+// in order of field name, and sanitizeForm reports
+// whether any fields were discarded.
+func sanitizeForm(values map[string][]string, max int) (url.Values, bool) {
+	var truncated bool
+	if max > 0 && len(values) > max {
+		truncated = true
+	} else {
+		max = len(values)
+	}
+	out := make(url.Values, max)
+	for _, k := range sortedKeys(values) {
+		if len(out) == max {
+			break
+		}
+		v := values[k]
+		if sanitize.SecretName(k) {
+			v = []string{sanitize.Redacted}
+		}
+		out[k] = v
+	}
+	return out, truncated
+}
+
+func mediaType(req *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// captureResponseBody reports whether the response body for tx
+// should be captured. Response bodies are captured only for
+// sampled transactions, when the tracer is configured to capture
+// bodies for errors, as they are reported only for responses
+// with error status codes.
+func captureResponseBody(t *elasticapm.Tracer, tx *elasticapm.Transaction) bool {
+	return t.CaptureBody().Errors() && tx.Sampled()
+}
+
+// captureBody records data in w.body, up to w.maxBodySize bytes
+// if w.maxBodySize is positive. Any content beyond this is discarded.
+func (w *responseWriter) captureBody(data []byte) {
+	if w.maxBodySize > 0 {
+		if remaining := w.maxBodySize - w.body.Len(); remaining < len(data) {
+			data = data[:remaining]
+		}
+	}
+	w.body.Write(data)
+}
+
+// responseBody returns the captured response body, if the response
+// status code indicates an error; otherwise it returns the empty string.
+func (w *responseWriter) responseBody() string {
+	if w.body == nil || w.statusCode < http.StatusBadRequest {
+		return ""
+	}
+	return w.body.String()
+}
+
+// sortedKeys returns the keys of values, in sorted order.
+func sortedKeys(values map[string][]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
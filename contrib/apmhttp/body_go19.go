@@ -0,0 +1,9 @@
+// +build go1.9
+
+package apmhttp
+
+import "mime/multipart"
+
+func fileHeaderSize(fh *multipart.FileHeader) int64 {
+	return fh.Size
+}
@@ -0,0 +1,24 @@
+// +build !go1.9
+
+package apmhttp
+
+import (
+	"io"
+	"mime/multipart"
+)
+
+func fileHeaderSize(fh *multipart.FileHeader) int64 {
+	// multipart.FileHeader.Size was added in Go 1.9.
+	// Files are either held in memory or stored on
+	// disk, so seeking to the end is cheap.
+	f, err := fh.Open()
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	return size
+}
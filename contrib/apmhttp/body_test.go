@@ -0,0 +1,205 @@
+package apmhttp_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmhttp"
+)
+
+func TestHandlerCaptureBodyRaw(t *testing.T) {
+	body := testHandlerCaptureBody(t, elasticapm.CaptureBodyAll, "text/plain", strings.NewReader("ahoj"))
+	assert.Equal(t, "ahoj", body)
+}
+
+func TestHandlerCaptureBodyOff(t *testing.T) {
+	body := testHandlerCaptureBody(t, elasticapm.CaptureBodyErrors, "text/plain", strings.NewReader("ahoj"))
+	assert.Nil(t, body)
+}
+
+func TestHandlerCaptureBodyForm(t *testing.T) {
+	body := testHandlerCaptureBody(t,
+		elasticapm.CaptureBodyTransactions,
+		"application/x-www-form-urlencoded",
+		strings.NewReader("foo=bar&foo=baz&password=hunter2"),
+	)
+	assert.Equal(t, map[string]interface{}{
+		"foo":      []interface{}{"bar", "baz"},
+		"password": "[REDACTED]",
+	}, body)
+}
+
+func TestHandlerCaptureBodyMultipart(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("foo", "bar")
+	mw.WriteField("api_key", "abc123")
+	fw, err := mw.CreateFormFile("upload", "upload.txt")
+	require.NoError(t, err)
+	fw.Write([]byte("file contents"))
+	require.NoError(t, mw.Close())
+
+	body := testHandlerCaptureBody(t, elasticapm.CaptureBodyAll, mw.FormDataContentType(), &buf)
+	assert.Equal(t, map[string]interface{}{
+		"foo":     "bar",
+		"api_key": "[REDACTED]",
+		"upload":  "[file: 13 bytes]",
+	}, body)
+}
+
+func TestHandlerCaptureBodyRawTruncated(t *testing.T) {
+	limits := elasticapm.CaptureBodyLimits{MaxSize: 4}
+	body := testHandlerCaptureBodyLimits(t, elasticapm.CaptureBodyAll, limits, "text/plain", strings.NewReader("ahoj, svete"))
+	assert.Equal(t, "ahoj[truncated]", body)
+}
+
+func TestHandlerCaptureBodyFormTruncated(t *testing.T) {
+	limits := elasticapm.CaptureBodyLimits{MaxFormFields: 2}
+	body := testHandlerCaptureBodyLimits(t,
+		elasticapm.CaptureBodyAll, limits,
+		"application/x-www-form-urlencoded",
+		strings.NewReader("c=3&a=1&b=2"),
+	)
+	assert.Equal(t, map[string]interface{}{
+		"a":           "1",
+		"b":           "2",
+		"[truncated]": true,
+	}, body)
+
+	// When the body size limit is exceeded, the
+	// final, incomplete field is discarded.
+	limits = elasticapm.CaptureBodyLimits{MaxSize: 9}
+	body = testHandlerCaptureBodyLimits(t,
+		elasticapm.CaptureBodyAll, limits,
+		"application/x-www-form-urlencoded",
+		strings.NewReader("a=1&b=2&c=123"),
+	)
+	assert.Equal(t, map[string]interface{}{
+		"a":           "1",
+		"b":           "2",
+		"[truncated]": true,
+	}, body)
+}
+
+func TestHandlerCaptureBodyMultipartTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("foo", "bar")
+	fw, err := mw.CreateFormFile("upload1", "upload1.txt")
+	require.NoError(t, err)
+	fw.Write([]byte("file contents"))
+	fw, err = mw.CreateFormFile("upload2", "upload2.txt")
+	require.NoError(t, err)
+	fw.Write([]byte("more file contents"))
+	require.NoError(t, mw.Close())
+
+	limits := elasticapm.CaptureBodyLimits{MaxFormFields: 2}
+	body := testHandlerCaptureBodyLimits(t, elasticapm.CaptureBodyAll, limits, mw.FormDataContentType(), &buf)
+	assert.Equal(t, map[string]interface{}{
+		"foo":         "bar",
+		"upload1":     "[file: 13 bytes]",
+		"[truncated]": true,
+	}, body)
+}
+
+func testHandlerCaptureBody(t *testing.T, mode elasticapm.CaptureBodyMode, contentType string, r io.Reader) interface{} {
+	return testHandlerCaptureBodyLimits(t, mode, elasticapm.CaptureBodyLimits{}, contentType, r)
+}
+
+func testHandlerCaptureBodyLimits(
+	t *testing.T,
+	mode elasticapm.CaptureBodyMode,
+	limits elasticapm.CaptureBodyLimits,
+	contentType string,
+	r io.Reader,
+) interface{} {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+	tracer.SetCaptureBody(mode)
+	if limits != (elasticapm.CaptureBodyLimits{}) {
+		tracer.SetCaptureBodyLimits(limits)
+	}
+
+	h := &apmhttp.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch {
+			case strings.HasPrefix(contentType, "multipart/form-data"):
+				if err := req.ParseMultipartForm(1024); err != nil {
+					panic(err)
+				}
+			case contentType == "application/x-www-form-urlencoded":
+				if err := req.ParseForm(); err != nil {
+					panic(err)
+				}
+			default:
+				ioutil.ReadAll(req.Body)
+			}
+		}),
+		Tracer: tracer,
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://server.testing/foo", r)
+	req.Header.Set("Content-Type", contentType)
+	h.ServeHTTP(w, req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	transaction := transactions[0].(map[string]interface{})
+	context := transaction["context"].(map[string]interface{})
+	request := context["request"].(map[string]interface{})
+	return request["body"]
+}
+
+func TestHandlerCaptureResponseBody(t *testing.T) {
+	for _, test := range []struct {
+		mode       elasticapm.CaptureBodyMode
+		statusCode int
+		body       string
+		expected   interface{}
+	}{
+		{elasticapm.CaptureBodyErrors, http.StatusInternalServerError, `{"error":"boom"}`, `{"error":"boom"}`},
+		{elasticapm.CaptureBodyAll, http.StatusNotFound, "not found", "not found"},
+		{elasticapm.CaptureBodyAll, http.StatusOK, "ok", nil},
+		{elasticapm.CaptureBodyTransactions, http.StatusInternalServerError, "boom", nil},
+		{elasticapm.CaptureBodyOff, http.StatusInternalServerError, "boom", nil},
+		{elasticapm.CaptureBodyErrors, http.StatusInternalServerError, strings.Repeat("x", 20*1024), strings.Repeat("x", 10*1024)},
+	} {
+		tracer, transport := newRecordingTracer()
+		tracer.SetCaptureBody(test.mode)
+		h := &apmhttp.Handler{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(test.statusCode)
+				w.Write([]byte(test.body))
+			}),
+			Tracer: tracer,
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		h.ServeHTTP(w, req)
+		tracer.Flush(nil)
+		tracer.Close()
+		assert.Equal(t, test.body, w.Body.String())
+
+		payloads := transport.Payloads()
+		require.Len(t, payloads, 1)
+		transactions := payloads[0]["transactions"].([]interface{})
+		transaction := transactions[0].(map[string]interface{})
+		context := transaction["context"].(map[string]interface{})
+		response := context["response"].(map[string]interface{})
+		assert.Equal(t, test.expected, response["body"])
+	}
+}
@@ -0,0 +1,225 @@
+package apmhttp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/model"
+)
+
+// WrapClient returns a new *http.Client with all fields copied
+// across, and the Transport field wrapped with WrapRoundTripper,
+// passing along the given options.
+//
+// If c is nil, then http.DefaultClient is wrapped.
+func WrapClient(c *http.Client, o ...ClientOption) *http.Client {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	copied := *c
+	copied.Transport = WrapRoundTripper(copied.Transport, o...)
+	return &copied
+}
+
+// WrapRoundTripper returns an http.RoundTripper wrapping r, reporting
+// each request as a span to Elastic APM, if the request's context
+// contains a sampled transaction.
+//
+// The span will be a child of the span in the request's context, if
+// any. The span's trace context will be propagated to the server via
+// the Elastic-Apm-Traceparent and W3C traceparent headers, and the
+// tracestate header if the trace has vendor-specific state; see
+// SetTraceContextHeaders. If no span is recorded, as the transaction is
+// not sampled or has reached its span limit, then the trace context of
+// the parent span or transaction is propagated with the sampled flag
+// cleared, so the server continues the trace without recording it.
+//
+// If r is nil, then http.DefaultTransport is wrapped.
+func WrapRoundTripper(r http.RoundTripper, o ...ClientOption) http.RoundTripper {
+	if r == nil {
+		r = http.DefaultTransport
+	}
+	rt := &roundTripper{r: r}
+	for _, o := range o {
+		o(rt)
+	}
+	return rt
+}
+
+type roundTripper struct {
+	r            http.RoundTripper
+	clientTrace  bool
+	traceHeaders []TraceHeadersFunc
+}
+
+// RoundTrip delegates to r.r, emitting a span if req's context
+// contains a sampled transaction. The span's outcome is a failure
+// if the request fails or the response has a 5xx status code, and
+// a success otherwise.
+//
+// The span ends when the response body has been fully read or
+// closed, so that it covers the streaming of the body, or when
+// the request fails. Response bodies which are neither read nor
+// closed before the transaction ends are reported as truncated.
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	span, _ := elasticapm.StartSpan(ctx, req.Method+" "+req.URL.Host, "ext.http")
+	if span == nil {
+		if traceContext, ok := unsampledTraceContext(ctx); ok {
+			req = r.setTraceHeaders(req, traceContext)
+		}
+		return r.r.RoundTrip(req)
+	}
+	span.Exit = true
+	url := RequestURL(req)
+	span.Context = &model.SpanContext{
+		Destination: destinationSpanContext(req),
+		HTTP:        &model.HTTPSpanContext{URL: &url},
+	}
+	if !span.Dropped() {
+		req = r.setTraceHeaders(req, span.TraceContext())
+		if r.clientTrace {
+			tx := elasticapm.TransactionFromContext(ctx)
+			traceCtx, trace := withClientTrace(ctx, tx, span)
+			defer trace.end()
+			req = req.WithContext(traceCtx)
+		}
+	} else if traceContext, ok := unsampledTraceContext(ctx); ok {
+		req = r.setTraceHeaders(req, traceContext)
+	}
+	resp, err := r.r.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 500 {
+		span.Outcome = model.OutcomeFailure
+	} else {
+		span.Outcome = model.OutcomeSuccess
+	}
+	if err != nil {
+		span.DoneContext(ctx, -1)
+		return resp, err
+	}
+	span.Context.HTTP.StatusCode = resp.StatusCode
+	if span.Dropped() || resp.Body == nil || resp.Body == http.NoBody {
+		span.DoneContext(ctx, -1)
+		return resp, nil
+	}
+	resp.Body = newResponseBody(ctx, span, resp.Body)
+	return resp, nil
+}
+
+// setTraceHeaders returns a copy of req with the trace headers for
+// traceContext added. RoundTrippers must not modify the request, so
+// the headers are copied before they are added.
+func (r *roundTripper) setTraceHeaders(req *http.Request, traceContext elasticapm.TraceContext) *http.Request {
+	reqCopy := *req
+	reqCopy.Header = make(http.Header, len(req.Header)+2)
+	for k, v := range req.Header {
+		reqCopy.Header[k] = v
+	}
+	SetTraceContextHeaders(reqCopy.Header, traceContext)
+	for _, f := range r.traceHeaders {
+		f(reqCopy.Header, traceContext)
+	}
+	return &reqCopy
+}
+
+// unsampledTraceContext returns the trace context to propagate for a
+// request made without a span, as the transaction in ctx is not sampled
+// or has reached its span limit: that of the span in ctx, if it was not
+// dropped, or else that of the transaction, with the sampled flag cleared.
+// If there is no transaction in ctx, or it has no ID as the tracer is not
+// recording, unsampledTraceContext returns false.
+func unsampledTraceContext(ctx context.Context) (elasticapm.TraceContext, bool) {
+	tx := elasticapm.TransactionFromContext(ctx)
+	if tx == nil {
+		return elasticapm.TraceContext{}, false
+	}
+	var traceContext elasticapm.TraceContext
+	if span := elasticapm.SpanFromContext(ctx); !span.Dropped() {
+		traceContext = span.TraceContext()
+	} else {
+		traceContext = elasticapm.TransactionTraceContext(tx)
+	}
+	if traceContext.Span.Validate() != nil {
+		return elasticapm.TraceContext{}, false
+	}
+	traceContext.Options = traceContext.Options.WithSampled(false)
+	return traceContext, true
+}
+
+// destinationSpanContext returns the destination span context
+// for an outgoing HTTP request.
+func destinationSpanContext(req *http.Request) *model.DestinationSpanContext {
+	host, port, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		host = req.URL.Host
+		port = ""
+	}
+	name := req.URL.Scheme + "://" + req.URL.Host
+	if port == "" {
+		switch req.URL.Scheme {
+		case "https":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+	return &model.DestinationSpanContext{
+		Service: &model.DestinationServiceSpanContext{
+			Type:     "external",
+			Name:     name,
+			Resource: net.JoinHostPort(host, port),
+		},
+	}
+}
+
+// responseBody wraps a response body, ending the request's
+// span when the body has been fully read or closed.
+type responseBody struct {
+	io.ReadCloser
+	ctx  context.Context
+	span *elasticapm.Span
+	once sync.Once
+}
+
+// responseBodyWriter is a responseBody for a writable
+// body, as returned for "101 Switching Protocols".
+type responseBodyWriter struct {
+	*responseBody
+	io.Writer
+}
+
+func newResponseBody(ctx context.Context, span *elasticapm.Span, body io.ReadCloser) io.ReadCloser {
+	rb := &responseBody{ReadCloser: body, ctx: ctx, span: span}
+	if w, ok := body.(io.Writer); ok {
+		return responseBodyWriter{rb, w}
+	}
+	return rb
+}
+
+// Read reads from the body, ending the span
+// when the end of the body or an error is reached.
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.done()
+	}
+	return n, err
+}
+
+// Close closes the body and ends the span,
+// if it has not already been ended.
+func (b *responseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+func (b *responseBody) done() {
+	b.once.Do(func() {
+		b.span.DoneContext(b.ctx, -1)
+	})
+}
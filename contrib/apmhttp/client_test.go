@@ -0,0 +1,394 @@
+package apmhttp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmhttp"
+)
+
+func TestClient(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers = append(headers, req.Header)
+		if req.URL.Path == "/redirect" {
+			http.Redirect(w, req, "/", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	tx := tracer.StartTransactionOptions("name", "type", elasticapm.TransactionOptions{
+		TraceContext: elasticapm.TraceContext{
+			Trace:   elasticapm.TraceID{1},
+			Span:    elasticapm.SpanID{1},
+			Options: elasticapm.TraceOptions(0).WithSampled(true),
+			State:   elasticapm.ParseTraceState("foo=bar"),
+			Baggage: elasticapm.ParseBaggage("tenant=acme"),
+		},
+	})
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+
+	client := apmhttp.WrapClient(&http.Client{})
+	req, _ := http.NewRequest("GET", server.URL+"/redirect", nil)
+	req.Header.Set("X-Foo", "bar")
+	resp, err := client.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, req.Header.Get(apmhttp.TraceparentHeader)) // request is unmodified
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 1)
+	spans := transactions[0].(map[string]interface{})["spans"].([]interface{})
+
+	// Each request, including redirects, is reported as a separate span.
+	require.Len(t, spans, 2)
+	require.Len(t, headers, 2)
+	for i, span := range spans {
+		span := span.(map[string]interface{})
+		assert.Equal(t, "GET "+serverURL.Host, span["name"])
+		assert.Equal(t, "ext.http", span["type"])
+		assert.Equal(t, true, span["exit"])
+		assert.Equal(t, map[string]interface{}{
+			"service": map[string]interface{}{
+				"type":     "external",
+				"name":     server.URL,
+				"resource": serverURL.Host,
+			},
+		}, span["context"].(map[string]interface{})["destination"])
+
+		assert.Equal(t,
+			"00-"+span["trace_id"].(string)+"-"+span["span_id"].(string)+"-01",
+			headers[i].Get(apmhttp.TraceparentHeader),
+		)
+		assert.Equal(t, "foo=bar", headers[i].Get(apmhttp.TracestateHeader))
+		assert.Equal(t, "tenant=acme", headers[i].Get(apmhttp.BaggageHeader))
+		assert.Equal(t, "bar", headers[i].Get("X-Foo"))
+	}
+}
+
+func TestClientResponseBody(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	req, _ := http.NewRequest("GET", server.URL+"/path?secret=x", nil)
+	resp, err := apmhttp.WrapClient(nil).Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(body))
+	resp.Body.Close()
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	transactions := transport.Payloads()[0]["transactions"].([]interface{})
+	spans := transactions[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 1)
+	span := spans[0].(map[string]interface{})
+	assert.Equal(t, "ext.http", span["type"])
+
+	// The span covers the streaming of the response body.
+	assert.True(t, span["duration"].(float64) >= 50)
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"url": map[string]interface{}{
+			"full":     server.URL + "/path?secret=[REDACTED]",
+			"protocol": "http",
+			"hostname": serverURL.Hostname(),
+			"port":     serverURL.Port(),
+			"pathname": "/path",
+			"search":   "secret=[REDACTED]",
+		},
+		"status_code": float64(http.StatusAccepted),
+	}, span["context"].(map[string]interface{})["http"])
+}
+
+func TestClientNoTransaction(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+	}))
+	defer server.Close()
+
+	client := apmhttp.WrapClient(nil)
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, header.Get(apmhttp.TraceparentHeader))
+	assert.Nil(t, http.DefaultClient.Transport) // DefaultClient is unmodified
+}
+
+func TestClientUnsampled(t *testing.T) {
+	tracer, _ := newRecordingTracer()
+	defer tracer.Close()
+	tracer.SetSampler(elasticapm.NewRatioSampler(0, rand.NewSource(0)))
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+	}))
+	defer server.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.Done(-1)
+	require.False(t, tx.Sampled())
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := apmhttp.WrapClient(nil).Do(req.WithContext(elasticapm.ContextWithTransaction(context.Background(), tx)))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// The transaction is propagated as the parent,
+	// with the sampled flag cleared.
+	traceContext := elasticapm.TransactionTraceContext(tx)
+	assert.False(t, traceContext.Options.Sampled())
+	assert.Equal(t, elasticapm.FormatTraceParentHeader(traceContext), header.Get(apmhttp.TraceparentHeader))
+	assert.Equal(t, elasticapm.FormatTraceParentHeader(traceContext), header.Get(apmhttp.W3CTraceparentHeader))
+}
+
+func TestClientSpanLimit(t *testing.T) {
+	tracer, _ := newRecordingTracer()
+	defer tracer.Close()
+	tracer.SetMaxSpans(1)
+
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers = append(headers, req.Header)
+	}))
+	defer server.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.Done(-1)
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	parent, ctx := elasticapm.StartSpan(ctx, "parent", "type")
+	defer parent.Done(-1)
+	require.False(t, parent.Dropped())
+
+	// The request span is dropped, so the parent span is
+	// propagated as the parent, with the sampled flag cleared.
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := apmhttp.WrapClient(nil).Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// Without a recorded span in the context,
+	// the transaction is propagated instead.
+	ctx = elasticapm.ContextWithTransaction(context.Background(), tx)
+	resp, err = apmhttp.WrapClient(nil).Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	parentContext := parent.TraceContext()
+	parentContext.Options = parentContext.Options.WithSampled(false)
+	txContext := elasticapm.TransactionTraceContext(tx)
+	txContext.Options = txContext.Options.WithSampled(false)
+	require.Len(t, headers, 2)
+	assert.Equal(t, elasticapm.FormatTraceParentHeader(parentContext), headers[0].Get(apmhttp.TraceparentHeader))
+	assert.Equal(t, elasticapm.FormatTraceParentHeader(txContext), headers[1].Get(apmhttp.TraceparentHeader))
+}
+
+func TestClientTrace(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	serverURL.Host = "localhost:" + serverURL.Port()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	client := apmhttp.WrapClient(&http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}, apmhttp.WithClientTrace())
+	req, _ := http.NewRequest("GET", serverURL.String(), nil)
+	resp, err := client.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 1)
+	spans := transactions[0].(map[string]interface{})["spans"].([]interface{})
+
+	var requestSpanID string
+	subSpanParents := make(map[string]interface{})
+	for _, span := range spans {
+		span := span.(map[string]interface{})
+		spanType := span["type"].(string)
+		if spanType == "ext.http" {
+			requestSpanID = span["span_id"].(string)
+			continue
+		}
+		subSpanParents[spanType] = span["parent_id"]
+	}
+	require.NotEmpty(t, requestSpanID)
+	for _, spanType := range []string{
+		"ext.http.dns",
+		"ext.http.connect",
+		"ext.http.tls",
+		"ext.http.response",
+	} {
+		assert.Contains(t, subSpanParents, spanType)
+		assert.Equal(t, requestSpanID, subSpanParents[spanType], spanType)
+	}
+}
+
+func TestClientTraceDisabled(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := apmhttp.WrapClient(&http.Client{}).Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	transactions := transport.Payloads()[0]["transactions"].([]interface{})
+	spans := transactions[0].(map[string]interface{})["spans"].([]interface{})
+	assert.Len(t, spans, 1)
+}
+
+func TestClientTraceHeaders(t *testing.T) {
+	tracer, _ := newRecordingTracer()
+	defer tracer.Close()
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+	}))
+	defer server.Close()
+
+	var propagated elasticapm.TraceContext
+	client := apmhttp.WrapClient(nil, apmhttp.WithTraceHeaders(func(h http.Header, c elasticapm.TraceContext) {
+		propagated = c
+		h.Set("X-Trace", c.Span.String())
+	}))
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.Done(-1)
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(req.WithContext(elasticapm.ContextWithTransaction(context.Background(), tx)))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, propagated.Span.String(), header.Get("X-Trace"))
+	assert.Equal(t, elasticapm.FormatTraceParentHeader(propagated), header.Get(apmhttp.TraceparentHeader))
+	assert.Equal(t, elasticapm.FormatTraceParentHeader(propagated), header.Get(apmhttp.W3CTraceparentHeader))
+}
+
+func TestTraceContextHeaders(t *testing.T) {
+	in := elasticapm.TraceContext{
+		Trace:   elasticapm.TraceID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		Span:    elasticapm.SpanID{0, 1, 2, 3, 4, 5, 6, 7},
+		Options: elasticapm.TraceOptions(0).WithSampled(true),
+		State:   elasticapm.ParseTraceState("es=s:0.5,vendor=value"),
+		Baggage: elasticapm.ParseBaggage("tenant=acme"),
+	}
+	header := make(http.Header)
+	apmhttp.SetTraceContextHeaders(header, in)
+	assert.Equal(t, http.Header{
+		"Elastic-Apm-Traceparent": {"00-000102030405060708090a0b0c0d0e0f-0001020304050607-01"},
+		"Traceparent":             {"00-000102030405060708090a0b0c0d0e0f-0001020304050607-01"},
+		"Tracestate":              {"es=s:0.5,vendor=value"},
+		"Baggage":                 {"tenant=acme"},
+	}, header)
+
+	out, ok := apmhttp.TraceContextFromHeaders(header)
+	assert.True(t, ok)
+	assert.Equal(t, in, out)
+
+	// The Elastic-Apm-Traceparent header takes precedence.
+	header.Set(apmhttp.W3CTraceparentHeader, "00-0f0e0d0c0b0a09080706050403020100-0706050403020100-00")
+	out, ok = apmhttp.TraceContextFromHeaders(header)
+	assert.True(t, ok)
+	assert.Equal(t, in.Trace, out.Trace)
+
+	// Baggage is returned even without a valid traceparent.
+	header.Set(apmhttp.TraceparentHeader, "invalid")
+	header.Del(apmhttp.W3CTraceparentHeader)
+	out, ok = apmhttp.TraceContextFromHeaders(header)
+	assert.False(t, ok)
+	assert.Equal(t, elasticapm.TraceContext{Baggage: in.Baggage}, out)
+}
+
+func TestClientRetryAttempts(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if requests++; requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	client := apmhttp.WrapClient(nil)
+	for attempt := 1; attempt <= 3; attempt++ {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req = req.WithContext(elasticapm.ContextWithRetryAttempt(ctx, attempt))
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+	}
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	spans := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 2)
+	for i, outcome := range []string{"failure", "success"} {
+		span := spans[i].(map[string]interface{})
+		assert.Equal(t, outcome, span["outcome"])
+		tags := span["context"].(map[string]interface{})["tags"]
+		assert.Equal(t, map[string]interface{}{"retry_attempt": strconv.Itoa(i + 1)}, tags)
+	}
+}
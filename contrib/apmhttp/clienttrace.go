@@ -0,0 +1,153 @@
+package apmhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+// ClientOption sets options for tracing client requests.
+type ClientOption func(*roundTripper)
+
+// TraceHeadersFunc is the type of a function for use with
+// WithTraceHeaders, setting trace context headers in h for
+// propagating c to the server, in addition to the standard
+// headers set by the client.
+type TraceHeadersFunc func(h http.Header, c elasticapm.TraceContext)
+
+// WithTraceHeaders returns a ClientOption which propagates trace
+// context on outgoing requests with f, in addition to the standard
+// headers, e.g. for interoperating with other tracing systems such
+// as AWS X-Ray; see package contrib/apmxray.
+func WithTraceHeaders(f TraceHeadersFunc) ClientOption {
+	return func(r *roundTripper) {
+		r.traceHeaders = append(r.traceHeaders, f)
+	}
+}
+
+// WithClientTrace returns a ClientOption which enables the reporting
+// of the network phases of each request as sub-spans of the request's
+// span, using net/http/httptrace: DNS lookup ("ext.http.dns"), TCP
+// connect ("ext.http.connect"), TLS handshake ("ext.http.tls"), and
+// the time from writing the request to receiving the first byte of
+// the response ("ext.http.response").
+//
+// Phases which do not occur, e.g. when a connection is reused, are
+// not reported. This is disabled by default, as it adds overhead to
+// every request, and up to four spans per request.
+func WithClientTrace() ClientOption {
+	return func(r *roundTripper) {
+		r.clientTrace = true
+	}
+}
+
+// clientTrace records the network phases of a single request as
+// sub-spans of the request's span.
+//
+// The httptrace hooks may be called concurrently, e.g. when dialing
+// multiple addresses, and after RoundTrip returns, so access to the
+// sub-spans is synchronised, and hooks are ignored once the request's
+// span has ended.
+type clientTrace struct {
+	tx     *elasticapm.Transaction
+	parent *elasticapm.Span
+
+	mu       sync.Mutex
+	ended    bool
+	dns      *elasticapm.Span
+	connect  map[string]*elasticapm.Span
+	tls      *elasticapm.Span
+	response *elasticapm.Span
+}
+
+// withClientTrace returns a copy of ctx with httptrace hooks for
+// reporting sub-spans of span, and the clientTrace recording them.
+// The clientTrace's end method must be called before span ends.
+func withClientTrace(ctx context.Context, tx *elasticapm.Transaction, span *elasticapm.Span) (context.Context, *clientTrace) {
+	t := &clientTrace{tx: tx, parent: span}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.start(&t.dns, "DNS lookup", "ext.http.dns")
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.done(&t.dns)
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.ended {
+				return
+			}
+			if t.connect == nil {
+				t.connect = make(map[string]*elasticapm.Span)
+			}
+			key := network + " " + addr
+			if t.connect[key] == nil {
+				t.connect[key] = t.tx.StartSpan("Connect "+addr, "ext.http.connect", t.parent)
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			key := network + " " + addr
+			if span := t.connect[key]; span != nil {
+				delete(t.connect, key)
+				span.Done(-1)
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.start(&t.tls, "TLS handshake", "ext.http.tls")
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.done(&t.tls)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.start(&t.response, "Time to first byte", "ext.http.response")
+		},
+		GotFirstResponseByte: func() {
+			t.done(&t.response)
+		},
+	}), t
+}
+
+// start starts a sub-span, storing it in *span, unless the request's
+// span has ended or the sub-span has already been started.
+func (t *clientTrace) start(span **elasticapm.Span, name, spanType string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.ended && *span == nil {
+		*span = t.tx.StartSpan(name, spanType, t.parent)
+	}
+}
+
+// done ends the sub-span stored in *span, if any.
+func (t *clientTrace) done(span **elasticapm.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if *span != nil {
+		(*span).Done(-1)
+		*span = nil
+	}
+}
+
+// end ends any sub-spans still in progress, e.g. because the request
+// failed, and ignores any further hook calls.
+func (t *clientTrace) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ended = true
+	for _, span := range []**elasticapm.Span{&t.dns, &t.tls, &t.response} {
+		if *span != nil {
+			(*span).Done(-1)
+			*span = nil
+		}
+	}
+	for key, span := range t.connect {
+		delete(t.connect, key)
+		span.Done(-1)
+	}
+}
@@ -4,17 +4,53 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 
+	"github.com/elastic/apm-agent-go/internal/sanitize"
 	"github.com/elastic/apm-agent-go/model"
 )
 
 // RequestName returns the name to use in model.Transaction.Name
-// for HTTP requests.
+// for HTTP requests. The name is made up of the request method and
+// URL path; the query string is excluded, as it would otherwise lead
+// to high-cardinality transaction names, and may contain secrets.
 func RequestName(req *http.Request) string {
 	return fmt.Sprintf("%s %s", req.Method, req.URL.Path)
 }
 
+// StatusCodeResult returns the result to use in model.Transaction.Result
+// for HTTP responses with the given status code: the status class, e.g.
+// "HTTP 2xx". Status codes outside the range 100-599 are reported in
+// full, e.g. "HTTP 999".
+func StatusCodeResult(statusCode int) string {
+	if statusCode < 100 || statusCode >= 600 {
+		return fmt.Sprintf("HTTP %d", statusCode)
+	}
+	return fmt.Sprintf("HTTP %dxx", statusCode/100)
+}
+
+// requestNameQueryParams returns RequestName(req), followed by the
+// values of the given query parameters in the order specified, for
+// those present in the request, e.g. "GET /api?action=list".
+func requestNameQueryParams(req *http.Request, params []string) string {
+	name := RequestName(req)
+	if len(params) == 0 || req.URL.RawQuery == "" {
+		return name
+	}
+	query := req.URL.Query()
+	values := make([]string, 0, len(params))
+	for _, param := range params {
+		for _, value := range query[param] {
+			values = append(values, url.QueryEscape(param)+"="+url.QueryEscape(value))
+		}
+	}
+	if len(values) == 0 {
+		return name
+	}
+	return name + "?" + strings.Join(values, "&")
+}
+
 // RequestContext returns the context to use in model.Transaction.Context
 // for HTTP requests.
 //
@@ -47,6 +83,10 @@ func RequestContext(req *http.Request) *model.Context {
 			},
 		},
 	}
+	if req.ContentLength > 0 {
+		size := req.ContentLength
+		ctx.Request.BodySize = &size
+	}
 	if username != "" {
 		ctx.User = &model.User{
 			Username: username,
@@ -58,12 +98,39 @@ func RequestContext(req *http.Request) *model.Context {
 // RequestURL returns a model.URL for the given HTTP request.
 //
 // If the URL contains user info, it will be removed and
-// excluded from the URL's "full" field.
+// excluded from the URL's "full" field, and from the host.
+//
+// The values of sensitive query parameters, such as tokens, are
+// redacted in the URL's "search" and "full" fields.
+//
+// For server-side requests, the host and protocol are taken from
+// the "host" and "proto" parameters of the Forwarded header, if
+// specified, or else the X-Forwarded-Host and X-Forwarded-Proto
+// headers, if specified.
 func RequestURL(req *http.Request) model.URL {
-	fullHost := req.Host
+	var forwarded forwardedHeader
+	if req.URL.Scheme == "" {
+		forwarded = parseForwardedHeader(req.Header.Get("Forwarded"))
+		if forwarded.Host == "" {
+			forwarded.Host = req.Header.Get("X-Forwarded-Host")
+		}
+		if forwarded.Proto == "" {
+			forwarded.Proto = strings.ToLower(req.Header.Get("X-Forwarded-Proto"))
+		}
+	}
+	fullHost := forwarded.Host
+	if fullHost == "" {
+		fullHost = req.Host
+	}
 	if fullHost == "" {
 		fullHost = req.URL.Host
 	}
+	// The host should never contain user info, but the Host and
+	// forwarding headers are controlled by the client, so remove
+	// any to be sure it is not recorded.
+	if at := strings.LastIndexByte(fullHost, '@'); at >= 0 {
+		fullHost = fullHost[at+1:]
+	}
 	host, port, err := net.SplitHostPort(fullHost)
 	if err != nil {
 		host = fullHost
@@ -73,38 +140,70 @@ func RequestURL(req *http.Request) model.URL {
 		Hostname: host,
 		Port:     port,
 		Path:     req.URL.Path,
-		Search:   req.URL.RawQuery,
+		Search:   sanitizeQuery(req.URL.RawQuery),
 		Hash:     req.URL.Fragment,
 	}
 	if req.URL.Scheme != "" {
 		// If the URL contains user info, remove it before formatting
 		// so it doesn't make its way into the "full" URL, to avoid
 		// leaking PII or secrets.
-		user := req.URL.User
-		req.URL.User = nil
-		out.Full = req.URL.String()
+		u := *req.URL
+		u.User = nil
+		u.RawQuery = out.Search
+		out.Full = u.String()
 		out.Protocol = req.URL.Scheme
-		req.URL.User = user
 	} else {
 		// Server-side, req.URL contains the
 		// URI only. We synthesize the URL
 		// by adding in req.Host, and the
 		// scheme determined by the presence
 		// of TLS configuration.
-		scheme := "http"
-		if req.TLS != nil {
-			scheme = "https"
+		scheme := forwarded.Proto
+		if scheme == "" {
+			scheme = "http"
+			if req.TLS != nil {
+				scheme = "https"
+			}
 		}
 		u := *req.URL
 		u.Scheme = scheme
 		u.User = nil
 		u.Host = fullHost
+		u.RawQuery = out.Search
 		out.Full = u.String()
 		out.Protocol = scheme
 	}
 	return out
 }
 
+// sanitizeQuery returns the raw query string, with the values of
+// sensitive parameters replaced by "[REDACTED]". The order and
+// encoding of the parameters is otherwise preserved.
+func sanitizeQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	var sanitized bool
+	for i, param := range params {
+		key := param
+		if eq := strings.IndexByte(param, '='); eq >= 0 {
+			key = param[:eq]
+		}
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if sanitize.SecretName(key) {
+			params[i] = strings.SplitN(param, "=", 2)[0] + "=" + sanitize.Redacted
+			sanitized = true
+		}
+	}
+	if !sanitized {
+		return rawQuery
+	}
+	return strings.Join(params, "&")
+}
+
 // RequestHeaders returns the headers for the HTTP request relevant to tracing.
 func RequestHeaders(req *http.Request) *model.RequestHeaders {
 	return &model.RequestHeaders{
@@ -117,11 +216,17 @@ func RequestHeaders(req *http.Request) *model.RequestHeaders {
 // RequestRemoteAddress returns the remote address for the HTTP request.
 //
 // In order:
+//  - if the Forwarded header is set, and its first element has
+//    a "for" parameter identifying a node by IP address or host
+//    name, then the host portion of its value is returned.
 //  - if the X-Real-IP header is set, then its value is returned.
 //  - if the X-Forwarded-For header is set, then the first value
 //    in the comma-separated list is returned.
 //  - otherwise, the host portion of req.RemoteAddr is returned.
 func RequestRemoteAddress(req *http.Request) string {
+	if forwarded := parseForwardedHeader(req.Header.Get("Forwarded")); forwarded.For != "" {
+		return forwarded.For
+	}
 	if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
 		return realIP
 	}
@@ -1,4 +1,5 @@
 // Package apmhttp provides the Handler middleware for
-// tracing HTTP requests, and functions for extracting
-// transaction context from HTTP requests.
+// tracing HTTP requests, functions for extracting
+// transaction context from HTTP requests, and functions
+// for tracing outgoing HTTP requests.
 package apmhttp
@@ -0,0 +1,73 @@
+package apmhttp
+
+import (
+	"net"
+	"strings"
+)
+
+// forwardedHeader holds information extracted from
+// a Forwarded HTTP header, as defined by RFC 7239.
+type forwardedHeader struct {
+	For   string
+	Host  string
+	Proto string
+}
+
+// parseForwardedHeader parses the first element of a Forwarded
+// header, which describes the request as received by the proxy
+// closest to the client.
+//
+// The "for" parameter's port, and any brackets surrounding an IPv6
+// address, are removed. Unknown ("unknown") and obfuscated (e.g.
+// "_hidden") node identifiers are ignored, as they do not identify
+// the client's address. Malformed parameters are ignored.
+func parseForwardedHeader(header string) forwardedHeader {
+	var out forwardedHeader
+	if sep := strings.IndexRune(header, ','); sep >= 0 {
+		header = header[:sep]
+	}
+	for _, pair := range strings.Split(header, ";") {
+		sep := strings.IndexRune(pair, '=')
+		if sep <= 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(pair[:sep]))
+		value := strings.TrimSpace(pair[sep+1:])
+		if strings.HasPrefix(value, `"`) {
+			if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+				continue
+			}
+			value = value[1 : len(value)-1]
+		}
+		switch key {
+		case "for":
+			out.For = forwardedNodeAddress(value)
+		case "host":
+			out.Host = value
+		case "proto":
+			out.Proto = strings.ToLower(value)
+		}
+	}
+	return out
+}
+
+// forwardedNodeAddress returns the host portion of a
+// Forwarded header node identifier, or the empty string
+// if the node is unknown or obfuscated.
+func forwardedNodeAddress(node string) string {
+	if node == "" || node == "unknown" || node[0] == '_' {
+		return ""
+	}
+	if node[0] == '[' {
+		// Quoted IPv6 address, optionally with a port.
+		end := strings.IndexRune(node, ']')
+		if end < 0 {
+			return ""
+		}
+		return node[1:end]
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
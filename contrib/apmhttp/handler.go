@@ -1,8 +1,10 @@
 package apmhttp
 
 import (
+	"bufio"
+	"bytes"
+	"net"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/elastic/apm-agent-go"
@@ -18,48 +20,196 @@ type Handler struct {
 
 	// Recovery is an optional panic recovery handler. If this is
 	// non-nil, panics will be recovered and passed to this function,
-	// along with the request and response writer. If Recovery is
-	// nil, panics will not be recovered.
+	// along with the request and response writer. The request is
+	// passed unmodified; RedactPath applies only to the request
+	// context recorded by NewTraceRecovery. If Recovery is nil,
+	// panics will not be recovered.
 	Recovery RecoveryFunc
 
 	// Tracer is an optional elasticapm.Tracer for tracing transactions.
 	// If this is nil, elasticapm.DefaultTracer will be used instead.
 	Tracer *elasticapm.Tracer
+
+	// NameQueryParams optionally holds the names of URL query
+	// parameters to include in transaction names, for APIs which
+	// route on them, e.g. "GET /api?action=list". By default,
+	// transaction names are made up of the method and path only.
+	NameQueryParams []string
+
+	// Synthetic is an optional function for reporting whether or
+	// not a request is synthetic traffic, e.g. from an uptime
+	// monitor, in which case the transaction is marked as such.
+	// If this is nil, DefaultSynthetic will be used.
+	Synthetic SyntheticFunc
+
+	// RedactPath is an optional function for redacting the URL
+	// path of requests, e.g. replacing IDs with a placeholder,
+	// in both the transaction name and the recorded request URL.
+	// This reduces the cardinality of transaction names, and the
+	// leakage of personally identifiable information. See
+	// NewPathSegmentRedactor.
+	RedactPath PathRedactor
+
+	// RequestName is an optional function for deriving transaction
+	// names from requests, e.g. using the route template matched by
+	// a router. If this is non-nil, NameQueryParams and RedactPath
+	// do not apply to the transaction name.
+	RequestName RequestNameFunc
+}
+
+// RequestNameFunc is the type of a function for use in
+// Handler.RequestName, returning the transaction name for
+// a request.
+type RequestNameFunc func(req *http.Request) string
+
+// ServerOption sets options for tracing server requests, for use
+// with Wrap.
+type ServerOption func(*Handler)
+
+// Wrap returns an http.Handler wrapping h, reporting a transaction
+// for each request as described by Handler.ServeHTTP. Unless another
+// RecoveryFunc is specified with WithRecovery, panics are recovered
+// and reported as errors with NewTraceRecovery.
+func Wrap(h http.Handler, o ...ServerOption) http.Handler {
+	handler := &Handler{Handler: h}
+	for _, o := range o {
+		o(handler)
+	}
+	if handler.Recovery == nil {
+		handler.Recovery = NewTraceRecovery(handler.Tracer)
+	}
+	return handler
+}
+
+// WithTracer returns a ServerOption which sets the tracer used for
+// tracing requests, and reporting recovered panics.
+func WithTracer(t *elasticapm.Tracer) ServerOption {
+	return func(h *Handler) {
+		h.Tracer = t
+	}
+}
+
+// WithRecovery returns a ServerOption which sets the RecoveryFunc
+// for recovered panics.
+func WithRecovery(r RecoveryFunc) ServerOption {
+	return func(h *Handler) {
+		h.Recovery = r
+	}
+}
+
+// WithRequestName returns a ServerOption which sets the function
+// used for deriving transaction names from requests.
+func WithRequestName(f RequestNameFunc) ServerOption {
+	return func(h *Handler) {
+		h.RequestName = f
+	}
 }
 
 // ServeHTTP delegates to h.Handler, tracing the transaction with
 // h.Tracer, or elasticapm.DefaultTracer if h.Tracer is nil.
+//
+// If the tracer is configured to capture request bodies, then
+// the request body will be recorded as it is read by h.Handler.
+// Multipart form data is not recorded; instead, the non-file
+// form fields parsed by h.Handler are reported, along with the
+// sizes of uploaded files.
+//
+// If the tracer is configured to capture bodies for errors, then
+// the response body will also be recorded, up to the tracer's
+// maximum captured body size, and reported when the response
+// status code indicates an error.
+//
+// If the request carries a valid traceparent header, as set by
+// a client wrapped with WrapClient or another W3C Trace Context
+// tracer, then the transaction continues the trace; otherwise a
+// new trace is started. See TraceContextFromHeaders.
+//
+// Baggage propagated in the request's baggage headers is made
+// available to h.Handler through elasticapm.BaggageFromContext.
+//
+// If the request's context already contains a transaction, e.g.
+// because the handler has been wrapped twice, an error is logged.
+// If the tracer is configured to replace nested transactions with
+// spans, then a span is started within the existing transaction
+// instead of a new transaction.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	t := h.Tracer
 	if t == nil {
 		t = elasticapm.DefaultTracer
 	}
 
-	tx := t.StartTransaction(RequestName(req), "request")
+	var name string
+	if h.RequestName != nil {
+		name = h.RequestName(req)
+	} else {
+		name = requestNameQueryParams(redactRequestPath(req, h.RedactPath), h.NameQueryParams)
+	}
+	if t.DetectNestedTransaction(req.Context(), name, 0) && t.NestedTransactionSpans() {
+		span, ctx := elasticapm.StartSpan(req.Context(), name, "request")
+		defer span.Done(-1)
+		h.Handler.ServeHTTP(w, req.WithContext(ctx))
+		return
+	}
+
+	var opts elasticapm.TransactionOptions
+	opts.TraceContext, _ = TraceContextFromHeaders(req.Header)
+	tx := t.StartTransactionOptions(name, "request", opts)
+	synthetic := h.Synthetic
+	if synthetic == nil {
+		synthetic = DefaultSynthetic
+	}
+	tx.Synthetic = synthetic(req)
 	ctx := elasticapm.ContextWithTransaction(req.Context(), tx)
+	ctx = contextWithPathRedactor(ctx, h.RedactPath)
 	req = req.WithContext(ctx)
+	body := captureBody(t, tx, req)
+	bodySize := countBody(tx, req)
 
 	// TODO(axw) optimise allocations
 
 	rw := newResponseWriter(w)
+	if captureResponseBody(t, tx) {
+		rw.body = &bytes.Buffer{}
+		rw.maxBodySize = t.CaptureBodyLimits().MaxSize
+	}
 	w = wrapResponseWriter(rw)
 
+	// traced is the request as recorded by the tracer,
+	// with its path redacted if configured to do so.
+	traced := redactRequestPath(req, h.RedactPath)
+
 	var finished bool
 	defer func() {
 		duration := time.Since(tx.Timestamp)
 		if h.Recovery != nil {
 			if v := recover(); v != nil {
 				h.Recovery(rw, req, tx, v)
+				if !rw.written {
+					rw.WriteHeader(http.StatusInternalServerError)
+				}
 			}
 		}
-		tx.Result = strconv.Itoa(rw.statusCode)
+		tx.Result = StatusCodeResult(rw.statusCode)
 		if tx.Sampled() {
-			tx.Context = RequestContext(req)
+			tx.Context = RequestContext(traced)
+			if t.CaptureQueryParams() {
+				tx.Context.Request.QueryParams = requestQueryParams(t, req)
+			}
+			if body != nil && t.CaptureBody().Transactions() {
+				tx.Context.Request.Body = body.requestBody()
+			}
+			if bodySize != nil && bodySize.n > 0 {
+				tx.Context.Request.BodySize = &bodySize.n
+			}
 			tx.Context.Response = &model.Response{
 				StatusCode:  rw.statusCode,
 				Headers:     ResponseHeaders(rw),
 				HeadersSent: &rw.written,
 				Finished:    &finished,
+				Body:        rw.responseBody(),
+			}
+			if rw.bodySize > 0 {
+				tx.Context.Response.BodySize = &rw.bodySize
 			}
 		}
 		tx.Done(duration)
@@ -70,8 +220,11 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
-	written    bool
+	statusCode  int
+	written     bool
+	bodySize    int64
+	body        *bytes.Buffer
+	maxBodySize int
 
 	closeNotify func() <-chan bool
 	flush       func()
@@ -96,10 +249,16 @@ func (w *responseWriter) WriteHeader(statusCode int) {
 	w.written = true
 }
 
-// Write sets w.written, and calls through to the embedded ResponseWriter.
+// Write sets w.written, and calls through to the embedded ResponseWriter,
+// counting the bytes written. If the response body is being captured,
+// the written data is recorded.
 func (w *responseWriter) Write(data []byte) (int, error) {
 	n, err := w.ResponseWriter.Write(data)
 	w.written = true
+	w.bodySize += int64(n)
+	if w.body != nil {
+		w.captureBody(data[:n])
+	}
 	return n, err
 }
 
@@ -113,11 +272,24 @@ func (w *responseWriter) CloseNotify() <-chan bool {
 }
 
 // Flush calls w.flush() if w.flush is non-nil, otherwise
-// it does nothing.
+// it does nothing. Flushing sends the response headers,
+// so w.written is set if w.flush is non-nil.
 func (w *responseWriter) Flush() {
 	if w.flush != nil {
 		w.flush()
+		w.written = true
+	}
+}
+
+// hijack calls h.Hijack, setting w.written if the connection
+// is successfully hijacked, since the handler then takes over
+// responsibility for writing the response.
+func (w *responseWriter) hijack(h http.Hijacker) (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.written = true
 	}
+	return conn, rw, err
 }
 
 // wrapResponseWriter wraps a responseWriter so that the Pusher and Hijacker
@@ -152,6 +324,11 @@ type responseWriterHijacker struct {
 	http.Hijacker
 }
 
+// Hijack calls through to the embedded Hijacker.
+func (w responseWriterHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack(w.Hijacker)
+}
+
 type responseWriterPusher struct {
 	*responseWriter
 	http.Pusher
@@ -162,3 +339,8 @@ type responseWriterHijackerPusher struct {
 	http.Hijacker
 	http.Pusher
 }
+
+// Hijack calls through to the embedded Hijacker.
+func (w responseWriterHijackerPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack(w.Hijacker)
+}
@@ -2,11 +2,15 @@ package apmhttp_test
 
 import (
 	"crypto/tls"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -48,7 +52,7 @@ func TestHandler(t *testing.T) {
 	transaction := transactions[0].(map[string]interface{})
 	assert.Equal(t, "GET /foo", transaction["name"])
 	assert.Equal(t, "request", transaction["type"])
-	assert.Equal(t, "418", transaction["result"])
+	assert.Equal(t, "HTTP 4xx", transaction["result"])
 
 	context := transaction["context"].(map[string]interface{})
 	assert.Equal(t, map[string]interface{}{
@@ -72,10 +76,369 @@ func TestHandler(t *testing.T) {
 			"status_code":  float64(418),
 			"headers_sent": true,
 			"finished":     true,
+			"body_size":    float64(3),
 		},
 	}, context)
 }
 
+func TestHandlerBodySize(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	h := &apmhttp.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Body != nil {
+				body, _ := ioutil.ReadAll(req.Body)
+				w.Write(body)
+				w.Write(body)
+			}
+		}),
+		Tracer: tracer,
+	}
+
+	// The request body size is taken from Content-Length if
+	// known, or else counted as the body is read by the handler.
+	req, _ := http.NewRequest("POST", "http://server.testing/foo", strings.NewReader("hello"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("POST", "http://server.testing/foo", ioutil.NopCloser(strings.NewReader("chunked")))
+	req.ContentLength = -1 // as for chunked requests received by a server
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("GET", "http://server.testing/foo", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 3)
+	var sizes [][2]interface{}
+	for _, tx := range transactions {
+		context := tx.(map[string]interface{})["context"].(map[string]interface{})
+		sizes = append(sizes, [2]interface{}{
+			context["request"].(map[string]interface{})["body_size"],
+			context["response"].(map[string]interface{})["body_size"],
+		})
+	}
+	assert.Equal(t, [][2]interface{}{
+		{float64(5), float64(10)},
+		{float64(7), float64(14)},
+		{nil, nil},
+	}, sizes)
+}
+
+func TestWrap(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithRequestName(func(req *http.Request) string {
+			return req.Method + " /users/{id}"
+		}),
+	)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://server.testing/users/123?q=1", nil)
+	req.RemoteAddr = "client.testing:1234"
+	h.ServeHTTP(w, req)
+	tracer.Flush(nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transaction := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "POST /users/{id}", transaction["name"])
+	assert.Equal(t, "HTTP 2xx", transaction["result"])
+	request := transaction["context"].(map[string]interface{})["request"].(map[string]interface{})
+	assert.Equal(t, "POST", request["method"])
+	assert.Equal(t, map[string]interface{}{
+		"full":     "http://server.testing/users/123?q=1",
+		"protocol": "http",
+		"hostname": "server.testing",
+		"pathname": "/users/123",
+		"search":   "q=1",
+	}, request["url"])
+	assert.Equal(t, map[string]interface{}{
+		"remote_address": "client.testing",
+	}, request["socket"])
+}
+
+func TestWrapRecovery(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	}), apmhttp.WithTracer(tracer))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	h.ServeHTTP(w, req)
+	tracer.Flush(nil)
+
+	// The panic is recovered and reported as an error, and the
+	// unwritten response is reported as an internal server error.
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 2)
+	exception := payloads[0]["errors"].([]interface{})[0].(map[string]interface{})["exception"]
+	assert.Equal(t, "boom", exception.(map[string]interface{})["message"])
+	transaction := payloads[1]["transactions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "GET /foo", transaction["name"])
+	assert.Equal(t, "HTTP 5xx", transaction["result"])
+}
+
+func TestStatusCodeResult(t *testing.T) {
+	for code, expect := range map[int]string{
+		101: "HTTP 1xx",
+		200: "HTTP 2xx",
+		304: "HTTP 3xx",
+		404: "HTTP 4xx",
+		599: "HTTP 5xx",
+		99:  "HTTP 99",
+		999: "HTTP 999",
+	} {
+		assert.Equal(t, expect, apmhttp.StatusCodeResult(code))
+	}
+}
+
+func TestHandlerNested(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+	tracer.SetNestedTransactionSpans(true)
+
+	h := &apmhttp.Handler{
+		Handler: &apmhttp.Handler{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}),
+			Tracer: tracer,
+		},
+		Tracer: tracer,
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	h.ServeHTTP(w, req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 1)
+	transaction := transactions[0].(map[string]interface{})
+	assert.Equal(t, "HTTP 4xx", transaction["result"])
+	spans := transaction["spans"].([]interface{})
+	require.Len(t, spans, 1)
+	span := spans[0].(map[string]interface{})
+	assert.Equal(t, "GET /foo", span["name"])
+	assert.Equal(t, "request", span["type"])
+}
+
+func TestHandlerCaptureQueryParams(t *testing.T) {
+	for _, capture := range []bool{false, true} {
+		tracer, transport := newRecordingTracer()
+		tracer.SetCaptureQueryParams(capture)
+		h := &apmhttp.Handler{
+			Handler: http.NotFoundHandler(),
+			Tracer:  tracer,
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://server.testing/foo?q=search&q=again&access_token=secret", nil)
+		h.ServeHTTP(w, req)
+		tracer.Flush(nil)
+		tracer.Close()
+
+		payloads := transport.Payloads()
+		require.Len(t, payloads, 1)
+		transaction := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+		request := transaction["context"].(map[string]interface{})["request"].(map[string]interface{})
+		if !capture {
+			assert.NotContains(t, request, "query_params")
+			continue
+		}
+		assert.Equal(t, map[string]interface{}{
+			"q":            []interface{}{"search", "again"},
+			"access_token": []interface{}{"[REDACTED]"},
+		}, request["query_params"])
+	}
+}
+
+func TestHandlerNameQueryParams(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	h := &apmhttp.Handler{
+		Handler:         http.NotFoundHandler(),
+		Tracer:          tracer,
+		NameQueryParams: []string{"version", "action"},
+	}
+	for _, url := range []string{
+		"http://server.testing/api?action=list&version=2&token=secret",
+		"http://server.testing/api?other=1",
+		"http://server.testing/api",
+	} {
+		req, _ := http.NewRequest("GET", url, nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	var names []string
+	for _, p := range transport.Payloads() {
+		for _, tx := range p["transactions"].([]interface{}) {
+			names = append(names, tx.(map[string]interface{})["name"].(string))
+		}
+	}
+	assert.Equal(t, []string{
+		"GET /api?version=2&action=list",
+		"GET /api",
+		"GET /api",
+	}, names)
+}
+
+func TestHandlerBaggage(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	var baggage elasticapm.Baggage
+	h := &apmhttp.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			baggage = elasticapm.BaggageFromContext(req.Context())
+		}),
+		Tracer: tracer,
+	}
+	req, _ := http.NewRequest("GET", "http://server.testing/", nil)
+	req.Header.Add(apmhttp.BaggageHeader, "tenant=acme")
+	req.Header.Add(apmhttp.BaggageHeader, "cohort=beta%20users;ttl=60")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush(nil)
+
+	assert.Equal(t, elasticapm.Baggage{
+		{Key: "tenant", Value: "acme"},
+		{Key: "cohort", Value: "beta users", Properties: "ttl=60"},
+	}, baggage)
+
+	// Baggage is not recorded as tags.
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	tx := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	assert.NotContains(t, tx["context"], "tags")
+}
+
+func TestHandlerTraceContext(t *testing.T) {
+	for _, header := range []string{apmhttp.TraceparentHeader, apmhttp.W3CTraceparentHeader} {
+		tracer, transport := newRecordingTracer()
+		defer tracer.Close()
+
+		h := &apmhttp.Handler{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}),
+			Tracer:  tracer,
+		}
+		req, _ := http.NewRequest("GET", "http://server.testing/", nil)
+		req.Header.Set(header, "00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		tracer.Flush(nil)
+
+		payloads := transport.Payloads()
+		require.Len(t, payloads, 1)
+		tx := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", tx["trace_id"], header)
+		assert.Equal(t, "0102030405060708", tx["parent_id"], header)
+	}
+}
+
+func TestHandlerTraceContextInvalid(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	h := &apmhttp.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}),
+		Tracer:  tracer,
+	}
+	for _, traceparent := range []string{
+		"ff-0102030405060708090a0b0c0d0e0f10-0102030405060708-01", // forbidden version
+		"00-0102030405060708090a0b0c0d0e0f10-0102030405060708-0g", // invalid flags
+		"00-00000000000000000000000000000000-0102030405060708-01", // zero trace ID
+	} {
+		req, _ := http.NewRequest("GET", "http://server.testing/", nil)
+		req.Header.Set(apmhttp.W3CTraceparentHeader, traceparent)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	// Malformed headers are ignored, starting new traces.
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 3)
+	for _, tx := range transactions {
+		tx := tx.(map[string]interface{})
+		assert.NotEqual(t, "0102030405060708090a0b0c0d0e0f10", tx["trace_id"])
+		assert.NotContains(t, tx, "parent_id")
+	}
+}
+
+func TestHandlerSynthetic(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	serve := func(h *apmhttp.Handler, header, value string) {
+		req, _ := http.NewRequest("GET", "http://server.testing/", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	h := &apmhttp.Handler{Handler: http.NotFoundHandler(), Tracer: tracer}
+	serve(h, "", "")
+	serve(h, "User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
+	serve(h, "Elastic-Synthetics-Agent", "1")
+	serve(h, "User-Agent", "Mozilla/5.0 (compatible; UptimeRobot/2.0)")
+
+	h.Synthetic = apmhttp.NewSyntheticFunc([]string{"X-Test"}, "my-monitor")
+	serve(h, "x-test", "")
+	serve(h, "User-Agent", "My-Monitor/1.0")
+	serve(h, "Elastic-Synthetics-Agent", "1")
+	tracer.Flush(nil)
+
+	var synthetic []bool
+	for _, p := range transport.Payloads() {
+		for _, tx := range p["transactions"].([]interface{}) {
+			_, ok := tx.(map[string]interface{})["synthetic"]
+			synthetic = append(synthetic, ok)
+		}
+	}
+	assert.Equal(t, []bool{false, false, true, true, true, true, false}, synthetic)
+}
+
+func TestHandlerRedactPath(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	var path string
+	h := &apmhttp.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			path = req.URL.Path
+		}),
+		Tracer:     tracer,
+		RedactPath: apmhttp.NewPathSegmentRedactor(regexp.MustCompile(`[0-9]+`), ":id"),
+	}
+	req, _ := http.NewRequest("GET", "http://server.testing/users/123/orders?page=2", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush(nil)
+	assert.Equal(t, "/users/123/orders", path) // handler sees the original path
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	tx := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "GET /users/:id/orders", tx["name"])
+	url := tx["context"].(map[string]interface{})["request"].(map[string]interface{})["url"].(map[string]interface{})
+	assert.Equal(t, "/users/:id/orders", url["pathname"])
+	assert.Equal(t, "http://server.testing/users/:id/orders?page=2", url["full"])
+}
+
 func TestHandlerHTTP2(t *testing.T) {
 	tracer, transport := newRecordingTracer()
 	defer tracer.Close()
@@ -140,6 +503,7 @@ func TestHandlerHTTP2(t *testing.T) {
 			"status_code":  float64(418),
 			"headers_sent": true,
 			"finished":     true,
+			"body_size":    float64(3),
 		},
 	}, context)
 }
@@ -180,6 +544,97 @@ func TestHandlerRecovery(t *testing.T) {
 	}, context["response"])
 }
 
+func TestHandlerRecoveryRedactPath(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	var path string
+	recovery := apmhttp.NewTraceRecovery(tracer)
+	h := &apmhttp.Handler{
+		Handler: http.HandlerFunc(panicHandler),
+		Recovery: func(w http.ResponseWriter, req *http.Request, tx *elasticapm.Transaction, recovered interface{}) {
+			path = req.URL.Path
+			recovery(w, req, tx, recovered)
+		},
+		Tracer:     tracer,
+		RedactPath: apmhttp.NewPathSegmentRedactor(regexp.MustCompile(`[0-9]+`), ":id"),
+	}
+	req, _ := http.NewRequest("GET", "http://server.testing/users/123", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush(nil)
+	assert.Equal(t, "/users/123", path) // recovery sees the original path
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 2)
+	error0 := payloads[0]["errors"].([]interface{})[0].(map[string]interface{})
+	url := error0["context"].(map[string]interface{})["request"].(map[string]interface{})["url"].(map[string]interface{})
+	assert.Equal(t, "/users/:id", url["pathname"])
+	tx := payloads[1]["transactions"].([]interface{})[0].(map[string]interface{})
+	url = tx["context"].(map[string]interface{})["request"].(map[string]interface{})["url"].(map[string]interface{})
+	assert.Equal(t, "/users/:id", url["pathname"])
+}
+
+func TestHandlerStreaming(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	h := &apmhttp.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// Flushing without writing sends the headers.
+			w.(http.Flusher).Flush()
+		}),
+		Tracer: tracer,
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://server.testing/events", nil)
+	h.ServeHTTP(w, req)
+	assert.True(t, w.Flushed)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads, 1)
+	transaction := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	context := transaction["context"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"headers_sent": true,
+		"finished":     true,
+		"status_code":  float64(200),
+	}, context["response"])
+}
+
+func TestHandlerHijack(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	server := httptest.NewServer(&apmhttp.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: close\r\n\r\n")
+			rw.Flush()
+		}),
+		Tracer: tracer,
+	})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	for len(transport.Payloads()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+		tracer.Flush(nil)
+	}
+	transaction := transport.Payloads()[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	response := transaction["context"].(map[string]interface{})["response"].(map[string]interface{})
+	assert.Equal(t, true, response["headers_sent"])
+	assert.Equal(t, true, response["finished"])
+}
+
 func panicHandler(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusTeapot)
 	panic("foo")
@@ -13,7 +13,11 @@ type RecoveryFunc func(w http.ResponseWriter, req *http.Request, tx *elasticapm.
 //
 // The returned RecoveryFunc will report recovered error to Elastic APM
 // using the given Tracer, or elasticapm.DefaultTracer if t is nil. The
-// error will be linked to the given transaction.
+// error will be linked to the given transaction. If the tracer is
+// configured to capture request bodies for errors, and the request
+// is being handled by Handler, the request body will be included.
+// If the Handler is configured with RedactPath, the recorded request
+// URL path is redacted.
 func NewTraceRecovery(t *elasticapm.Tracer) RecoveryFunc {
 	if t == nil {
 		t = elasticapm.DefaultTracer
@@ -23,7 +27,13 @@ func NewTraceRecovery(t *elasticapm.Tracer) RecoveryFunc {
 		if e.Exception.Stacktrace == nil {
 			e.SetExceptionStacktrace(1)
 		}
-		e.Context = RequestContext(req)
+		e.Context = RequestContext(redactedRequest(req))
+		if t.CaptureQueryParams() {
+			e.Context.Request.QueryParams = requestQueryParams(t, req)
+		}
+		if body, ok := req.Body.(*bodyCapturer); ok && t.CaptureBody().Errors() {
+			e.Context.Request.Body = body.requestBody()
+		}
 		e.Send()
 	}
 }
@@ -0,0 +1,71 @@
+package apmhttp
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// PathRedactor is the type of a function for use in Handler.RedactPath,
+// returning a redacted copy of a URL path.
+type PathRedactor func(path string) string
+
+// NewPathSegmentRedactor returns a PathRedactor which replaces each
+// segment of a path that is matched in full by re with replacement,
+// e.g. replacing UUIDs with ":id", so "/users/<uuid>/orders" becomes
+// "/users/:id/orders".
+func NewPathSegmentRedactor(re *regexp.Regexp, replacement string) PathRedactor {
+	full := regexp.MustCompile("^(?:" + re.String() + ")$")
+	return func(path string) string {
+		segments := strings.Split(path, "/")
+		var redacted bool
+		for i, segment := range segments {
+			if segment != "" && full.MatchString(segment) {
+				segments[i] = replacement
+				redacted = true
+			}
+		}
+		if !redacted {
+			return path
+		}
+		return strings.Join(segments, "/")
+	}
+}
+
+// redactRequestPath returns req if redact is nil, or else a shallow
+// copy of req with its URL path redacted. The original request is
+// not modified.
+func redactRequestPath(req *http.Request, redact PathRedactor) *http.Request {
+	if redact == nil {
+		return req
+	}
+	path := redact(req.URL.Path)
+	if path == req.URL.Path {
+		return req
+	}
+	reqCopy := *req
+	urlCopy := *req.URL
+	urlCopy.Path = path
+	urlCopy.RawPath = ""
+	reqCopy.URL = &urlCopy
+	return &reqCopy
+}
+
+// pathRedactorKey is the context key for the PathRedactor of the
+// Handler serving a request, if any.
+type pathRedactorKey struct{}
+
+// redactedRequest returns req with its URL path redacted by the
+// PathRedactor recorded in its context by Handler, if any.
+func redactedRequest(req *http.Request) *http.Request {
+	redact, _ := req.Context().Value(pathRedactorKey{}).(PathRedactor)
+	return redactRequestPath(req, redact)
+}
+
+func contextWithPathRedactor(ctx context.Context, redact PathRedactor) context.Context {
+	if redact == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, pathRedactorKey{}, redact)
+}
@@ -0,0 +1,67 @@
+package apmhttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SyntheticsAgentHeader is the HTTP header set by Elastic Synthetics
+// monitors, identifying requests as synthetic.
+const SyntheticsAgentHeader = "Elastic-Synthetics-Agent"
+
+// SyntheticFunc is the type of a function for use in Handler.Synthetic,
+// reporting whether or not a request is synthetic traffic, e.g. from an
+// uptime monitor, rather than from a real user.
+type SyntheticFunc func(req *http.Request) bool
+
+// DefaultSynthetic is the SyntheticFunc used by Handler if Handler.Synthetic
+// is nil. Requests are reported as synthetic if they have the
+// Elastic-Synthetics-Agent header, or a User-Agent identifying a common
+// uptime monitoring service.
+var DefaultSynthetic = NewSyntheticFunc(
+	[]string{SyntheticsAgentHeader},
+	"Elastic-Synthetics",
+	"Elastic-Heartbeat",
+	"CloudWatchSynthetics",
+	"Datadog/Synthetics",
+	"GoogleStackdriverMonitoring",
+	"NewRelicPinger",
+	"Pingdom",
+	"Site24x7",
+	"StatusCake",
+	"UptimeRobot",
+)
+
+// NewSyntheticFunc returns a SyntheticFunc which reports requests as
+// synthetic if they have any of the given headers, or a User-Agent
+// header containing any of the given substrings, ignoring case.
+func NewSyntheticFunc(headers []string, userAgents ...string) SyntheticFunc {
+	headers = append([]string(nil), headers...)
+	for i, header := range headers {
+		headers[i] = http.CanonicalHeaderKey(header)
+	}
+	lowerUserAgents := make([]string, len(userAgents))
+	for i, userAgent := range userAgents {
+		lowerUserAgents[i] = strings.ToLower(userAgent)
+	}
+	return func(req *http.Request) bool {
+		for _, header := range headers {
+			if _, ok := req.Header[header]; ok {
+				return true
+			}
+		}
+		if len(lowerUserAgents) == 0 {
+			return false
+		}
+		userAgent := strings.ToLower(req.UserAgent())
+		if userAgent == "" {
+			return false
+		}
+		for _, substr := range lowerUserAgents {
+			if strings.Contains(userAgent, substr) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,88 @@
+package apmhttp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+const (
+	// TraceparentHeader is the HTTP header for propagating
+	// trace context, in the W3C Trace Context traceparent format.
+	TraceparentHeader = "Elastic-Apm-Traceparent"
+
+	// W3CTraceparentHeader is the standard W3C Trace Context
+	// traceparent header. It is propagated along with
+	// TraceparentHeader, for interoperability with other
+	// tracers, and is used to continue a trace if
+	// TraceparentHeader is absent.
+	W3CTraceparentHeader = "Traceparent"
+
+	// TracestateHeader is the HTTP header for propagating
+	// vendor-specific trace state, in the W3C Trace Context
+	// tracestate format.
+	TracestateHeader = "Tracestate"
+
+	// BaggageHeader is the HTTP header for propagating
+	// application-defined baggage, in the W3C baggage format.
+	BaggageHeader = "Baggage"
+)
+
+// SetTraceContextHeaders sets the traceparent headers,
+// TraceparentHeader and W3CTraceparentHeader, the tracestate
+// header if c has any state, and the baggage header if c
+// has any baggage, in h.
+func SetTraceContextHeaders(h http.Header, c elasticapm.TraceContext) {
+	traceparent := elasticapm.FormatTraceParentHeader(c)
+	h.Set(TraceparentHeader, traceparent)
+	h.Set(W3CTraceparentHeader, traceparent)
+	if len(c.State) > 0 {
+		h.Set(TracestateHeader, c.State.String())
+	}
+	if len(c.Baggage) > 0 {
+		h.Set(BaggageHeader, c.Baggage.String())
+	}
+}
+
+// TraceContextFromHeaders returns the trace context propagated in h,
+// as set by SetTraceContextHeaders, and reports whether h holds a valid
+// traceparent header. TraceparentHeader takes precedence over
+// W3CTraceparentHeader if both are present.
+//
+// If there is no valid traceparent header, then the trace context
+// returned holds only the baggage, if any, which may be propagated
+// independently of the trace; a transaction started with it will
+// start a new trace.
+func TraceContextFromHeaders(h http.Header) (elasticapm.TraceContext, bool) {
+	baggage := headerBaggage(h)
+	traceparent := h.Get(TraceparentHeader)
+	if traceparent == "" {
+		traceparent = h.Get(W3CTraceparentHeader)
+	}
+	if traceparent == "" {
+		return elasticapm.TraceContext{Baggage: baggage}, false
+	}
+	c, err := elasticapm.ParseTraceParentHeader(traceparent)
+	if err != nil {
+		return elasticapm.TraceContext{Baggage: baggage}, false
+	}
+	c.Baggage = baggage
+	if values := h[TracestateHeader]; len(values) > 0 {
+		// Multiple headers are combined, as
+		// permitted by the tracestate format.
+		c.State = elasticapm.ParseTraceState(strings.Join(values, ","))
+	}
+	return c, true
+}
+
+// headerBaggage returns the baggage held in the baggage
+// headers of h, if any. Multiple headers are combined,
+// as permitted by the W3C baggage format.
+func headerBaggage(h http.Header) elasticapm.Baggage {
+	values := h[BaggageHeader]
+	if len(values) == 0 {
+		return nil
+	}
+	return elasticapm.ParseBaggage(strings.Join(values, ","))
+}
@@ -0,0 +1,3 @@
+// Package apmio provides wrappers for io.Readers and io.Writers,
+// reporting the time spent transferring data as spans.
+package apmio
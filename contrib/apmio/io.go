@@ -0,0 +1,135 @@
+package apmio
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/model"
+)
+
+const (
+	spanType    = "io"
+	bytesTagKey = "bytes"
+)
+
+// TracedReader returns an io.ReadCloser wrapping r, reporting a span
+// of type "io" with the given name for the time and bytes read from r, if ctx
+// contains a sampled transaction. The span starts when TracedReader
+// is called, and ends when r returns io.EOF or another error, or when
+// the returned reader is closed; the number of bytes read is recorded
+// in the span's "bytes" tag.
+//
+// If r implements io.Closer, then closing the returned reader will
+// close r. If ctx does not contain a sampled transaction, then the
+// returned reader simply delegates to r.
+func TracedReader(ctx context.Context, r io.Reader, name string) io.ReadCloser {
+	span, _ := elasticapm.StartSpan(ctx, name, spanType)
+	return &reader{r: r, s: newTracedSpan(span)}
+}
+
+// TracedWriter returns an io.WriteCloser wrapping w, reporting a span
+// of type "io" with the given name for the time and bytes written to w, if ctx
+// contains a sampled transaction. The span starts when TracedWriter
+// is called, and ends when w returns an error, or when the returned
+// writer is closed; the number of bytes written is recorded in the
+// span's "bytes" tag.
+//
+// If w implements io.Closer, then closing the returned writer will
+// close w. If ctx does not contain a sampled transaction, then the
+// returned writer simply delegates to w.
+func TracedWriter(ctx context.Context, w io.Writer, name string) io.WriteCloser {
+	span, _ := elasticapm.StartSpan(ctx, name, spanType)
+	return &writer{w: w, s: newTracedSpan(span)}
+}
+
+type reader struct {
+	r io.Reader
+	s *tracedSpan
+}
+
+// Read reads from the wrapped reader, ending the span
+// if the wrapped reader returns an error, including EOF.
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.s.add(n)
+	if err != nil {
+		r.s.done()
+	}
+	return n, err
+}
+
+// Close ends the span, and closes the wrapped
+// reader if it implements io.Closer.
+func (r *reader) Close() error {
+	r.s.done()
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type writer struct {
+	w io.Writer
+	s *tracedSpan
+}
+
+// Write writes to the wrapped writer, ending the span
+// if the wrapped writer returns an error.
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.s.add(n)
+	if err != nil {
+		w.s.done()
+	}
+	return n, err
+}
+
+// Close ends the span, and closes the wrapped
+// writer if it implements io.Closer.
+func (w *writer) Close() error {
+	w.s.done()
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// tracedSpan records the number of bytes transferred,
+// and ends the span exactly once.
+type tracedSpan struct {
+	span  *elasticapm.Span
+	mu    sync.Mutex
+	bytes int64
+	ended bool
+}
+
+func newTracedSpan(span *elasticapm.Span) *tracedSpan {
+	return &tracedSpan{span: span}
+}
+
+func (s *tracedSpan) add(n int) {
+	s.mu.Lock()
+	s.bytes += int64(n)
+	s.mu.Unlock()
+}
+
+func (s *tracedSpan) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+	if s.span.Dropped() {
+		return
+	}
+	s.span.Context = &model.SpanContext{
+		Tags: map[string]string{
+			bytesTagKey: strconv.FormatInt(s.bytes, 10),
+		},
+	}
+	s.span.Done(-1)
+}
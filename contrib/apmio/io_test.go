@@ -0,0 +1,126 @@
+package apmio_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmio"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestTracedReader(t *testing.T) {
+	spans := withTransaction(t, func(ctx context.Context) {
+		r := apmio.TracedReader(ctx, strings.NewReader("hello, world"), "upload")
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "hello, world", string(data))
+		assert.NoError(t, r.Close()) // no-op, span already ended at EOF
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "upload", spans[0]["name"])
+	assert.Equal(t, "io", spans[0]["type"])
+	assert.Equal(t, map[string]interface{}{
+		"tags": map[string]interface{}{"bytes": "12"},
+	}, spans[0]["context"])
+}
+
+func TestTracedReaderClose(t *testing.T) {
+	var closed bool
+	spans := withTransaction(t, func(ctx context.Context) {
+		r := apmio.TracedReader(ctx, readCloser{strings.NewReader("hello"), &closed}, "upload")
+		var buf [2]byte
+		_, err := r.Read(buf[:])
+		require.NoError(t, err)
+		assert.NoError(t, r.Close())
+	})
+	assert.True(t, closed)
+	require.Len(t, spans, 1)
+	assert.Equal(t, map[string]interface{}{
+		"tags": map[string]interface{}{"bytes": "2"},
+	}, spans[0]["context"])
+}
+
+func TestTracedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	spans := withTransaction(t, func(ctx context.Context) {
+		w := apmio.TracedWriter(ctx, &buf, "download")
+		w.Write([]byte("hello, "))
+		w.Write([]byte("world"))
+		assert.NoError(t, w.Close())
+	})
+	assert.Equal(t, "hello, world", buf.String())
+	require.Len(t, spans, 1)
+	assert.Equal(t, "download", spans[0]["name"])
+	assert.Equal(t, "io", spans[0]["type"])
+	assert.Equal(t, map[string]interface{}{
+		"tags": map[string]interface{}{"bytes": "12"},
+	}, spans[0]["context"])
+}
+
+func TestTracedWriterError(t *testing.T) {
+	spans := withTransaction(t, func(ctx context.Context) {
+		w := apmio.TracedWriter(ctx, errorWriter{}, "download")
+		_, err := w.Write([]byte("hello"))
+		assert.EqualError(t, err, "broken pipe")
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, map[string]interface{}{
+		"tags": map[string]interface{}{"bytes": "0"},
+	}, spans[0]["context"])
+}
+
+func TestTracedReaderNoTransaction(t *testing.T) {
+	r := apmio.TracedReader(context.Background(), strings.NewReader("hello"), "upload")
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.NoError(t, r.Close())
+}
+
+func withTransaction(t *testing.T, f func(ctx context.Context)) []map[string]interface{} {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("apmio_test", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tx := tracer.StartTransaction("name", "type")
+	f(elasticapm.ContextWithTransaction(context.Background(), tx))
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	transaction := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	var spans []map[string]interface{}
+	if transactionSpans, ok := transaction["spans"].([]interface{}); ok {
+		for _, span := range transactionSpans {
+			spans = append(spans, span.(map[string]interface{}))
+		}
+	}
+	return spans
+}
+
+type readCloser struct {
+	*strings.Reader
+	closed *bool
+}
+
+func (r readCloser) Close() error {
+	*r.closed = true
+	return nil
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
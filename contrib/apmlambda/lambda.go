@@ -10,6 +10,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/internal/xray"
 	"github.com/elastic/apm-agent-go/model"
 	"github.com/elastic/apm-agent-go/stacktrace"
 
@@ -69,7 +70,16 @@ func (f *Function) Ping(req *messages.PingRequest, response *messages.PingRespon
 }
 
 func (f *Function) Invoke(req *messages.InvokeRequest, response *messages.InvokeResponse) error {
-	tx := f.tracer.StartTransaction(lambdacontext.FunctionName, "function")
+	// If the invocation was triggered by an upstream AWS service
+	// traced with X-Ray, continue its trace. Otherwise, or if the
+	// header cannot be parsed, a new trace is started.
+	var opts elasticapm.TransactionOptions
+	if req.XAmznTraceId != "" {
+		if traceContext, err := xray.ParseTraceHeader(req.XAmznTraceId); err == nil {
+			opts.TraceContext = traceContext
+		}
+	}
+	tx := f.tracer.StartTransactionOptions(lambdacontext.FunctionName, "function", opts)
 	defer f.tracer.Flush(nonBlocking)
 	defer tx.Done(-1)
 	defer f.tracer.Recover(tx)
@@ -0,0 +1,154 @@
+package apmprometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+const namespace = "elasticapm_tracer"
+
+var (
+	transactionsSentDesc = newDesc(
+		"transactions_sent_total",
+		"Number of transactions successfully sent to the APM server.",
+	)
+	transactionsDroppedDesc = newDesc(
+		"transactions_dropped_total",
+		"Number of transactions dropped without being sent.",
+	)
+	transactionsBufferedDesc = newDesc(
+		"transactions_buffered",
+		"Number of transactions buffered, waiting to be queued for sending.",
+	)
+	errorsSentDesc = newDesc(
+		"errors_sent_total",
+		"Number of errors successfully sent to the APM server.",
+	)
+	errorsDroppedDesc = newDesc(
+		"errors_dropped_total",
+		"Number of errors dropped without being sent.",
+	)
+	errorsBufferedDesc = newDesc(
+		"errors_buffered",
+		"Number of errors buffered, waiting to be queued for sending.",
+	)
+	eventsRejectedDesc = newDesc(
+		"events_rejected_total",
+		"Number of events rejected by the APM server.",
+	)
+	failuresDesc = newDesc(
+		"failures_total",
+		"Number of failures encountered by the tracer, by operation.",
+		"operation",
+	)
+	circuitBreakerOpenedDesc = newDesc(
+		"circuit_breaker_opened_total",
+		"Number of times the circuit breaker has been opened.",
+	)
+	circuitBreakerOpenDesc = newDesc(
+		"circuit_breaker_open",
+		"Whether (1) or not (0) the circuit breaker is currently open.",
+	)
+	transactionDropsDesc = newDesc(
+		"transaction_drops_total",
+		"Number of transactions not sent in full, by cause.",
+		"cause",
+	)
+	spansStartedDesc = newDesc(
+		"spans_started_total",
+		"Number of spans started within sampled transactions, including those dropped.",
+	)
+	spansRecordedDesc = newDesc(
+		"spans_recorded_total",
+		"Number of spans recorded in transactions enqueued for sending.",
+	)
+	spansDroppedDesc = newDesc(
+		"spans_dropped_total",
+		"Number of spans dropped, by cause.",
+		"cause",
+	)
+)
+
+func newDesc(name, help string, variableLabels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", name),
+		help, variableLabels, nil,
+	)
+}
+
+// NewCollector returns a new prometheus.Collector which reports
+// the statistics of tracer, as returned by tracer.Stats and
+// tracer.Buffered.
+//
+// If tracer is nil, elasticapm.DefaultTracer will be used.
+func NewCollector(tracer *elasticapm.Tracer) prometheus.Collector {
+	if tracer == nil {
+		tracer = elasticapm.DefaultTracer
+	}
+	return collector{tracer}
+}
+
+type collector struct {
+	tracer *elasticapm.Tracer
+}
+
+// Describe is part of the prometheus.Collector interface.
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- transactionsSentDesc
+	ch <- transactionsDroppedDesc
+	ch <- transactionsBufferedDesc
+	ch <- errorsSentDesc
+	ch <- errorsDroppedDesc
+	ch <- errorsBufferedDesc
+	ch <- eventsRejectedDesc
+	ch <- failuresDesc
+	ch <- circuitBreakerOpenedDesc
+	ch <- circuitBreakerOpenDesc
+	ch <- transactionDropsDesc
+	ch <- spansStartedDesc
+	ch <- spansRecordedDesc
+	ch <- spansDroppedDesc
+}
+
+// Collect is part of the prometheus.Collector interface.
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.tracer.Stats()
+	transactionsBuffered, errorsBuffered := c.tracer.Buffered()
+	counter := func(desc *prometheus.Desc, value uint64, labelValues ...string) {
+		ch <- prometheus.MustNewConstMetric(
+			desc, prometheus.CounterValue, float64(value), labelValues...,
+		)
+	}
+	gauge := func(desc *prometheus.Desc, value int) {
+		ch <- prometheus.MustNewConstMetric(
+			desc, prometheus.GaugeValue, float64(value),
+		)
+	}
+
+	counter(transactionsSentDesc, stats.TransactionsSent)
+	counter(transactionsDroppedDesc, stats.TransactionsDropped)
+	gauge(transactionsBufferedDesc, transactionsBuffered)
+	counter(errorsSentDesc, stats.ErrorsSent)
+	counter(errorsDroppedDesc, stats.ErrorsDropped)
+	gauge(errorsBufferedDesc, errorsBuffered)
+	counter(eventsRejectedDesc, stats.EventsRejected)
+	counter(failuresDesc, stats.Errors.SetContext, "set_context")
+	counter(failuresDesc, stats.Errors.SendTransactions, "send_transactions")
+	counter(failuresDesc, stats.Errors.SendErrors, "send_errors")
+	counter(failuresDesc, stats.Errors.SendMetrics, "send_metrics")
+	counter(circuitBreakerOpenedDesc, stats.CircuitBreakerOpened)
+	var circuitBreakerOpen int
+	if stats.CircuitBreakerOpen {
+		circuitBreakerOpen = 1
+	}
+	gauge(circuitBreakerOpenDesc, circuitBreakerOpen)
+	counter(transactionDropsDesc, stats.TransactionDrops.BufferFull, "buffer_full")
+	counter(transactionDropsDesc, stats.TransactionDrops.CircuitOpen, "circuit_open")
+	counter(transactionDropsDesc, stats.TransactionDrops.NotSampled, "not_sampled")
+	counter(transactionDropsDesc, stats.TransactionDrops.Discarded, "discarded")
+	counter(spansStartedDesc, stats.Spans.Started)
+	counter(spansRecordedDesc, stats.Spans.Recorded)
+	counter(spansDroppedDesc, stats.Spans.DroppedMaxSpans, "max_spans")
+	counter(spansDroppedDesc, stats.Spans.DroppedTransactionEnded, "transaction_ended")
+}
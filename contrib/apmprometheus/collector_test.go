@@ -0,0 +1,75 @@
+package apmprometheus_test
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmprometheus"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestCollector(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("apmprometheus_test", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+
+	for i := 0; i < 3; i++ {
+		tx := tracer.StartTransaction("name", "type")
+		tx.StartSpan("name", "type", nil).Done(-1)
+		tx.Done(-1)
+	}
+	tracer.SetMaxSpans(1)
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("name", "type", nil).Done(-1)
+	tx.StartSpan("name", "type", nil).Done(-1)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(apmprometheus.NewCollector(tracer)))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			for _, label := range m.GetLabel() {
+				name += "{" + label.GetName() + "=" + label.GetValue() + "}"
+			}
+			if counter := m.GetCounter(); counter != nil {
+				values[name] = counter.GetValue()
+			} else {
+				values[name] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		"elasticapm_tracer_transactions_sent_total":                      4,
+		"elasticapm_tracer_transactions_dropped_total":                   0,
+		"elasticapm_tracer_transactions_buffered":                        0,
+		"elasticapm_tracer_errors_sent_total":                            0,
+		"elasticapm_tracer_errors_dropped_total":                         0,
+		"elasticapm_tracer_errors_buffered":                              0,
+		"elasticapm_tracer_events_rejected_total":                        0,
+		"elasticapm_tracer_failures_total{operation=set_context}":        0,
+		"elasticapm_tracer_failures_total{operation=send_transactions}":  0,
+		"elasticapm_tracer_failures_total{operation=send_errors}":        0,
+		"elasticapm_tracer_failures_total{operation=send_metrics}":       0,
+		"elasticapm_tracer_circuit_breaker_opened_total":                 0,
+		"elasticapm_tracer_circuit_breaker_open":                         0,
+		"elasticapm_tracer_transaction_drops_total{cause=buffer_full}":   0,
+		"elasticapm_tracer_transaction_drops_total{cause=circuit_open}":  0,
+		"elasticapm_tracer_transaction_drops_total{cause=not_sampled}":   0,
+		"elasticapm_tracer_transaction_drops_total{cause=discarded}":     0,
+		"elasticapm_tracer_spans_started_total":                          5,
+		"elasticapm_tracer_spans_recorded_total":                         4,
+		"elasticapm_tracer_spans_dropped_total{cause=max_spans}":         1,
+		"elasticapm_tracer_spans_dropped_total{cause=transaction_ended}": 0,
+	}, values)
+}
@@ -0,0 +1,3 @@
+// Package apmprometheus provides a Prometheus collector for
+// observing the health of an elasticapm.Tracer.
+package apmprometheus
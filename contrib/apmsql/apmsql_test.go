@@ -0,0 +1,290 @@
+package apmsql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmsql"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func init() {
+	apmsql.Register("mysql", fakeDriver{})
+	apmsql.Register("fakedb", fakeDriver{}, apmsql.WithMaxStatementLength(10))
+	apmsql.Register("fakedb_rows", fakeDriver{}, apmsql.WithRowsAffected())
+	for _, name := range []string{"postgres", "pgx", "sqlite", "sqlite3", "mssql", "unknowndb"} {
+		apmsql.Register(name, fakeDriver{})
+	}
+	apmsql.RegisterDriverSubtype("cockroachdb", "cockroach")
+	apmsql.Register("cockroachdb", fakeDriver{})
+}
+
+func TestQuerySpans(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	db, err := apmsql.Open("mysql", "user:secret@tcp(localhost:3306)/dbname?parseTime=true")
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	_, err = db.ExecContext(ctx, "CREATE TABLE foo (bar INT)")
+	require.NoError(t, err)
+	rows, err := db.QueryContext(ctx, "SELECT * FROM foo")
+	require.NoError(t, err)
+	rows.Close()
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	spans := payloadSpans(t, transport)
+	require.Len(t, spans, 3)
+	assert.Equal(t, "db.mysql.connect", spans[0].(map[string]interface{})["type"])
+	for i, expect := range []struct {
+		name, typ, statement string
+	}{
+		{"CREATE", "db.mysql.exec", "CREATE TABLE foo (bar INT)"},
+		{"SELECT", "db.mysql.query", "SELECT * FROM foo"},
+	} {
+		span := spans[i+1].(map[string]interface{})
+		assert.Equal(t, expect.name, span["name"])
+		assert.Equal(t, expect.typ, span["type"])
+		context := span["context"].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{
+			"statement": expect.statement,
+			"type":      "sql",
+			"instance":  "dbname",
+			"user":      "user",
+		}, context["db"])
+	}
+}
+
+func TestQuerySpansNoTransaction(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	db, err := apmsql.Open("mysql", "/dbname")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(context.Background(), "DELETE FROM foo")
+	require.NoError(t, err)
+	tracer.Flush(nil)
+	assert.Empty(t, transport.Payloads())
+}
+
+func TestMaxStatementLength(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	db, err := apmsql.Open("fakedb", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	_, err = db.ExecContext(ctx, "INSERT INTO ünïcödé VALUES (1)")
+	require.NoError(t, err)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	spans := payloadSpans(t, transport)
+	require.Len(t, spans, 2) // connect, exec
+	span := spans[1].(map[string]interface{})
+	assert.Equal(t, "db.fakedb.exec", span["type"])
+	context := span["context"].(map[string]interface{})
+	db0 := context["db"].(map[string]interface{})
+	assert.Equal(t, "INSERT INT", db0["statement"])
+}
+
+func TestRowsAffected(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	db, err := apmsql.Open("fakedb_rows", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	_, err = db.ExecContext(ctx, "DELETE FROM foo")
+	require.NoError(t, err)
+	_, err = db.QueryContext(ctx, "SELECT * FROM foo")
+	require.NoError(t, err)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	spans := payloadSpans(t, transport)
+	require.Len(t, spans, 3) // connect, exec, query
+	exec := spans[1].(map[string]interface{})["context"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"rows_affected": "1"}, exec["tags"])
+	assert.Equal(t, "DELETE FROM foo", exec["db"].(map[string]interface{})["statement"])
+	query := spans[2].(map[string]interface{})["context"].(map[string]interface{})
+	assert.NotContains(t, query, "tags")
+}
+
+func TestDriverSubtypes(t *testing.T) {
+	for _, test := range []struct {
+		name, subtype string
+	}{
+		{"mysql", "mysql"},
+		{"postgres", "postgresql"},
+		{"pgx", "postgresql"},
+		{"sqlite", "sqlite"},
+		{"sqlite3", "sqlite"},
+		{"mssql", "sqlserver"},
+		{"cockroachdb", "cockroach"}, // RegisterDriverSubtype
+		{"unknowndb", "unknowndb"},   // no mapping, unrecognised driver
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, transport := newRecordingTracer()
+			defer tracer.Close()
+
+			db, err := apmsql.Open(test.name, "")
+			require.NoError(t, err)
+			defer db.Close()
+
+			tx := tracer.StartTransaction("name", "type")
+			ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+			_, err = db.ExecContext(ctx, "DELETE FROM foo")
+			require.NoError(t, err)
+			tx.Done(-1)
+			tracer.Flush(nil)
+
+			spans := payloadSpans(t, transport)
+			require.Len(t, spans, 2) // connect, exec
+			span := spans[1].(map[string]interface{})
+			assert.Equal(t, "db."+test.subtype+".exec", span["type"])
+			destination := span["context"].(map[string]interface{})["destination"].(map[string]interface{})
+			assert.Equal(t, test.subtype, destination["service"].(map[string]interface{})["resource"])
+		})
+	}
+}
+
+func TestErrorOutcome(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	db, err := apmsql.Open("mysql", "/fail")
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	_, err = db.QueryContext(ctx, "FAIL")
+	assert.EqualError(t, err, "query failed")
+	_, err = db.PrepareContext(ctx, "SELECT 1")
+	assert.EqualError(t, err, "Prepare not implemented")
+	err = db.PingContext(ctx)
+	assert.EqualError(t, err, "ping failed")
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	spans := payloadSpans(t, transport)
+	require.Len(t, spans, 4) // connect, query, prepare, ping
+	for i, name := range []string{"FAIL", "SELECT", "ping"} {
+		span := spans[i+1].(map[string]interface{})
+		assert.Equal(t, name, span["name"])
+		assert.Equal(t, "failure", span["outcome"])
+	}
+
+	// The errors are also reported.
+	var errorEvents []interface{}
+	for _, payload := range transport.Payloads() {
+		if e, ok := payload["errors"].([]interface{}); ok {
+			errorEvents = append(errorEvents, e...)
+		}
+	}
+	assert.Len(t, errorEvents, 3)
+}
+
+func payloadSpans(t *testing.T, transport *transporttest.RecorderTransport) []interface{} {
+	for _, payload := range transport.Payloads() {
+		if transactions, ok := payload["transactions"].([]interface{}); ok {
+			require.Len(t, transactions, 1)
+			transaction := transactions[0].(map[string]interface{})
+			spans, _ := transaction["spans"].([]interface{})
+			return spans
+		}
+	}
+	t.Fatal("no transactions payload")
+	return nil
+}
+
+func newRecordingTracer() (*elasticapm.Tracer, *transporttest.RecorderTransport) {
+	var transport transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("apmsql_test", "0.1")
+	if err != nil {
+		panic(err)
+	}
+	tracer.Transport = &transport
+	return tracer, &transport
+}
+
+// fakeDriver is a database/sql/driver.Driver whose connections
+// accept any statement, failing those starting with "FAIL". Pings
+// fail if the data source name ends with "/fail".
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{failPing: strings.HasSuffix(name, "/fail")}, nil
+}
+
+type fakeConn struct {
+	failPing bool
+}
+
+func (c fakeConn) Ping(ctx context.Context) error {
+	if c.failPing {
+		return errors.New("ping failed")
+	}
+	return nil
+}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("Prepare not implemented")
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("Begin not implemented")
+}
+
+func (fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if strings.HasPrefix(query, "FAIL") {
+		return nil, errors.New("exec failed")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if strings.HasPrefix(query, "FAIL") {
+		return nil, errors.New("query failed")
+	}
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string {
+	return nil
+}
+
+func (fakeRows) Close() error {
+	return nil
+}
+
+func (fakeRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
@@ -4,23 +4,21 @@ import (
 	"context"
 	"database/sql/driver"
 	"errors"
+	"strconv"
 
 	"github.com/elastic/apm-agent-go"
 	apmsqldsn "github.com/elastic/apm-agent-go/contrib/apmsql/dsn"
 	"github.com/elastic/apm-agent-go/model"
 )
 
-func newConn(in driver.Conn, d *tracingDriver, dsn string) driver.Conn {
+func newConn(in driver.Conn, d *tracingDriver, dsnInfo apmsqldsn.Info) driver.Conn {
 	conn := &conn{Conn: in, driver: d}
-	var dsnInfo apmsqldsn.Info
-	if d.dsnParser != nil {
-		dsnInfo = d.dsnParser(dsn)
-	}
 	conn.spanContextBase.Database = &model.DatabaseSpanContext{
 		Type:     "sql",
 		Instance: dsnInfo.Database,
 		User:     dsnInfo.User,
 	}
+	conn.spanContextBase.Destination = d.destinationSpanContext()
 	conn.pinger, _ = in.(driver.Pinger)
 	conn.queryer, _ = in.(driver.Queryer)
 	conn.queryerContext, _ = in.(driver.QueryerContext)
@@ -65,7 +63,13 @@ func (c *conn) finishSpan(ctx context.Context, span *elasticapm.Span, query stri
 	if span.Context == nil {
 		span.Context = c.spanContext(query)
 	}
-	span.Done(-1)
+	span.Exit = true
+	if resultError != nil {
+		span.Outcome = model.OutcomeFailure
+	} else {
+		span.Outcome = model.OutcomeSuccess
+	}
+	span.DoneContext(ctx, -1)
 	if e := elasticapm.CaptureError(ctx, resultError); e != nil {
 		if e.Exception.Stacktrace == nil {
 			e.SetExceptionStacktrace(2)
@@ -74,9 +78,32 @@ func (c *conn) finishSpan(ctx context.Context, span *elasticapm.Span, query stri
 	}
 }
 
+// finishExecSpan finishes a span for an exec operation, recording
+// the number of rows affected if the driver was wrapped with the
+// WithRowsAffected option.
+func (c *conn) finishExecSpan(ctx context.Context, span *elasticapm.Span, query string, result driver.Result, resultError error) {
+	if c.driver.rowsAffected && result != nil && resultError == nil {
+		if rows, err := result.RowsAffected(); err == nil {
+			var spanContext model.SpanContext
+			if span.Context != nil {
+				spanContext = *span.Context
+			} else {
+				spanContext = *c.spanContext(query)
+			}
+			spanContext.Tags = map[string]string{
+				"rows_affected": strconv.FormatInt(rows, 10),
+			}
+			span.Context = &spanContext
+		}
+	}
+	c.finishSpan(ctx, span, query, resultError)
+}
+
 func (c *conn) spanContext(statement string) *model.SpanContext {
 	spanContext := c.spanContextBase
-	spanContext.Database.Statement = statement
+	database := *spanContext.Database
+	database.Statement = c.driver.truncateStatement(statement)
+	spanContext.Database = &database
 	return &spanContext
 }
 
@@ -86,7 +113,9 @@ func (c *conn) Ping(ctx context.Context) (resultError error) {
 	}
 	span, ctx := elasticapm.StartSpan(ctx, "ping", c.driver.spanType("ping"))
 	if span != nil {
-		defer c.finishSpan(ctx, span, "", resultError)
+		defer func() {
+			c.finishSpan(ctx, span, "", resultError)
+		}()
 	}
 	return c.pinger.Ping(ctx)
 }
@@ -97,7 +126,9 @@ func (c *conn) QueryContext(ctx context.Context, query string, args []driver.Nam
 	}
 	span, ctx := elasticapm.StartSpan(ctx, "", c.driver.spanType("query"))
 	if span != nil {
-		defer c.finishSpan(ctx, span, query, resultError)
+		defer func() {
+			c.finishSpan(ctx, span, query, resultError)
+		}()
 	}
 
 	if c.queryerContext != nil {
@@ -122,7 +153,9 @@ func (*conn) Query(query string, args []driver.Value) (driver.Rows, error) {
 func (c *conn) PrepareContext(ctx context.Context, query string) (_ driver.Stmt, resultError error) {
 	span, ctx := elasticapm.StartSpan(ctx, "", c.driver.spanType("prepare"))
 	if span != nil {
-		defer c.finishSpan(ctx, span, query, resultError)
+		defer func() {
+			c.finishSpan(ctx, span, query, resultError)
+		}()
 	}
 	var stmt driver.Stmt
 	var err error
@@ -145,13 +178,15 @@ func (c *conn) PrepareContext(ctx context.Context, query string) (_ driver.Stmt,
 	return stmt, err
 }
 
-func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (_ driver.Result, resultError error) {
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (result driver.Result, resultError error) {
 	if c.execerContext == nil && c.execer == nil {
 		return nil, driver.ErrSkip
 	}
 	span, ctx := elasticapm.StartSpan(ctx, "", c.driver.spanType("exec"))
 	if span != nil {
-		defer c.finishSpan(ctx, span, query, resultError)
+		defer func() {
+			c.finishExecSpan(ctx, span, query, result, resultError)
+		}()
 	}
 
 	if c.execerContext != nil {
@@ -0,0 +1,36 @@
+package apmsql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+// NewDBStatsGatherer returns an elasticapm.MetricsGatherer which
+// gathers connection pool statistics for db, as reported by
+// sql.DB.Stats. The gatherer must be registered with a tracer
+// using Tracer.RegisterMetricsGatherer.
+//
+// If name is non-empty, the metrics will be labeled with "db"
+// set to name, for distinguishing multiple databases.
+func NewDBStatsGatherer(db *sql.DB, name string) elasticapm.MetricsGatherer {
+	g := &dbStatsGatherer{db: db}
+	if name != "" {
+		g.labels = []elasticapm.MetricLabel{{Name: "db", Value: name}}
+	}
+	return g
+}
+
+type dbStatsGatherer struct {
+	db     *sql.DB
+	labels []elasticapm.MetricLabel
+}
+
+// GatherMetrics gathers the database connection pool statistics.
+func (g *dbStatsGatherer) GatherMetrics(ctx context.Context, m *elasticapm.Metrics) error {
+	stats := g.db.Stats()
+	m.Add("db.sql.connections.open", g.labels, float64(stats.OpenConnections))
+	gatherDBStatsGo111(stats, g.labels, m)
+	return nil
+}
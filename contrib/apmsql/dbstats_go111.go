@@ -0,0 +1,19 @@
+// +build go1.11
+
+package apmsql
+
+import (
+	"database/sql"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+// gatherDBStatsGo111 adds the connection pool statistics
+// introduced in Go 1.11.
+func gatherDBStatsGo111(stats sql.DBStats, labels []elasticapm.MetricLabel, m *elasticapm.Metrics) {
+	m.Add("db.sql.connections.max_open", labels, float64(stats.MaxOpenConnections))
+	m.Add("db.sql.connections.in_use", labels, float64(stats.InUse))
+	m.Add("db.sql.connections.idle", labels, float64(stats.Idle))
+	m.AddCounter("db.sql.connections.wait.total_count", labels, float64(stats.WaitCount))
+	m.AddCounter("db.sql.connections.wait.total_duration.ns", labels, float64(stats.WaitDuration))
+}
@@ -0,0 +1,58 @@
+// +build go1.11
+
+package apmsql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmsql"
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestDBStatsGatherer(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("apmsql_test", "0.1")
+	require.NoError(t, err)
+	defer tracer.Close()
+	metrics := make(chan transporttest.SendMetricsRequest)
+	tracer.Transport = &transporttest.ChannelTransport{Metrics: metrics}
+
+	db, err := apmsql.Open("fakedb", "")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(2)
+	require.NoError(t, db.PingContext(context.Background()))
+
+	tracer.RegisterMetricsGatherer(apmsql.NewDBStatsGatherer(db, "primary"))
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+
+	var req transporttest.SendMetricsRequest
+	select {
+	case req = <-metrics:
+		req.Result <- nil
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for metrics")
+	}
+
+	var dbMetrics *model.Metrics
+	for _, m := range req.Payload.Metrics {
+		if m.Labels["db"] == "primary" {
+			dbMetrics = m
+		}
+	}
+	require.NotNil(t, dbMetrics)
+	assert.Equal(t, map[string]model.Metric{
+		"db.sql.connections.open":                   {Value: 1},
+		"db.sql.connections.max_open":               {Value: 2},
+		"db.sql.connections.in_use":                 {Value: 0},
+		"db.sql.connections.idle":                   {Value: 1},
+		"db.sql.connections.wait.total_count":       {Value: 0, Type: model.MetricTypeCounter},
+		"db.sql.connections.wait.total_duration.ns": {Value: 0, Type: model.MetricTypeCounter},
+	}, dbMetrics.Samples)
+}
@@ -0,0 +1,11 @@
+// +build !go1.11
+
+package apmsql
+
+import (
+	"database/sql"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+func gatherDBStatsGo111(sql.DBStats, []elasticapm.MetricLabel, *elasticapm.Metrics) {}
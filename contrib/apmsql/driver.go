@@ -7,20 +7,73 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/elastic/apm-agent-go/contrib/apmsql/dsn"
+	"github.com/elastic/apm-agent-go/model"
 )
 
 // DriverPrefix should be used as a driver name prefix when
 // registering via sql.Register.
 const DriverPrefix = "elasticapm/"
 
+var (
+	driverSubtypesMu sync.RWMutex
+	driverSubtypes   = map[string]string{
+		"postgres":   "postgresql",
+		"postgresql": "postgresql",
+		"pgx":        "postgresql",
+		"mysql":      "mysql",
+		"sqlite":     "sqlite",
+		"sqlite3":    "sqlite",
+		"sqlserver":  "sqlserver",
+		"mssql":      "sqlserver",
+	}
+)
+
+// RegisterDriverSubtype registers the database engine subtype to use
+// in span types for the driver registered with the given name, e.g.
+// "cockroach" for a driver registered as "cockroachdb". Mappings for
+// the names of common drivers, such as "postgres" and "mysql", are
+// registered by default.
+//
+// RegisterDriverSubtype must be called before the driver is registered
+// with Register for it to have any effect. It may also be used to
+// override the default mappings, e.g. to keep the subtype "sqlite3",
+// rather than "sqlite", for the "sqlite3" driver.
+func RegisterDriverSubtype(name, subtype string) {
+	driverSubtypesMu.Lock()
+	driverSubtypes[name] = subtype
+	driverSubtypesMu.Unlock()
+}
+
+// driverSubtype returns the database engine subtype for the
+// driver registered with the given name, and reports whether
+// a mapping exists.
+func driverSubtype(name string) (string, bool) {
+	driverSubtypesMu.RLock()
+	defer driverSubtypesMu.RUnlock()
+	subtype, ok := driverSubtypes[name]
+	return subtype, ok
+}
+
 // Register registers a traced version of the given driver.
 //
 // The name and driver values should be the same as given to
 // sql.Register: the name of the driver (e.g. "postgres"), and
 // the driver (e.g. &github.com/lib/pq.Driver{}).
+//
+// Unless WithDriverName is specified, the driver name used in span
+// types, e.g. "postgresql" in "db.postgresql.query", is the database
+// engine subtype registered for name with RegisterDriverSubtype. If
+// there is no such mapping, the driver name is inferred from driver,
+// as for Wrap, falling back to name if the driver is not recognised.
 func Register(name string, driver driver.Driver, opts ...WrapOption) {
+	if subtype, ok := driverSubtype(name); ok {
+		opts = append([]WrapOption{WithDriverName(subtype)}, opts...)
+	} else if driverName(driver) == "generic" {
+		opts = append([]WrapOption{WithDriverName(name)}, opts...)
+	}
 	wrapped := Wrap(driver, opts...)
 	sql.Register(DriverPrefix+name, wrapped)
 }
@@ -75,16 +128,50 @@ func WithDSNParser(f dsn.ParserFunc) WrapOption {
 	}
 }
 
+// WithRowsAffected returns a WrapOption which enables recording
+// the number of rows affected by exec operations, in the "rows_affected"
+// span tag. This is disabled by default, as some drivers must make an
+// additional round trip to the database to obtain the value.
+func WithRowsAffected() WrapOption {
+	return func(d *tracingDriver) {
+		d.rowsAffected = true
+	}
+}
+
+// WithMaxStatementLength returns a WrapOption which limits the length
+// of statements recorded in span context to n characters. Longer
+// statements are truncated. If WithMaxStatementLength is not supplied
+// to Wrap, or n is zero or negative, statements are recorded in full.
+func WithMaxStatementLength(n int) WrapOption {
+	return func(d *tracingDriver) {
+		d.maxStatementLength = n
+	}
+}
+
 type tracingDriver struct {
 	driver.Driver
-	driverName string
-	dsnParser  dsn.ParserFunc
+	driverName         string
+	dsnParser          dsn.ParserFunc
+	rowsAffected       bool
+	maxStatementLength int
 }
 
 func (d *tracingDriver) spanType(suffix string) string {
 	return fmt.Sprintf("db.%s.%s", d.driverName, suffix)
 }
 
+// destinationSpanContext returns the destination span
+// context for database operation spans.
+func (d *tracingDriver) destinationSpanContext() *model.DestinationSpanContext {
+	return &model.DestinationSpanContext{
+		Service: &model.DestinationServiceSpanContext{
+			Type:     "db",
+			Name:     d.driverName,
+			Resource: d.driverName,
+		},
+	}
+}
+
 // querySignature returns the value to use in Span.Name for
 // a database query.
 func (d *tracingDriver) querySignature(query string) string {
@@ -98,6 +185,31 @@ func (d *tracingDriver) querySignature(query string) string {
 	return strings.ToUpper(fields[0])
 }
 
+// truncateStatement returns the statement truncated to
+// d.maxStatementLength characters, if it is positive.
+func (d *tracingDriver) truncateStatement(statement string) string {
+	if d.maxStatementLength <= 0 || len(statement) <= d.maxStatementLength {
+		return statement
+	}
+	var n int
+	for i := range statement {
+		if n == d.maxStatementLength {
+			return statement[:i]
+		}
+		n++
+	}
+	return statement
+}
+
+// parseDSN parses the given data source name
+// using d.dsnParser, if it is non-nil.
+func (d *tracingDriver) parseDSN(name string) dsn.Info {
+	if d.dsnParser == nil {
+		return dsn.Info{}
+	}
+	return d.dsnParser(name)
+}
+
 func (d *tracingDriver) Open(name string) (driver.Conn, error) {
 	return nil, errors.New("Open should not be called")
 }
@@ -109,13 +221,16 @@ func driverName(d driver.Driver) string {
 	}
 	switch t.Name() {
 	case "SQLiteDriver":
-		return "sqlite3"
+		return "sqlite"
 	case "MySQLDriver":
 		return "mysql"
 	case "Driver":
 		// Check suffix in case of vendoring.
-		if strings.HasSuffix(t.PkgPath(), "github.com/lib/pq") {
+		switch {
+		case strings.HasSuffix(t.PkgPath(), "github.com/lib/pq"):
 			return "postgresql"
+		case strings.HasSuffix(t.PkgPath(), "github.com/denisenkom/go-mssqldb"):
+			return "sqlserver"
 		}
 	}
 	// TODO include the package path of the driver in context
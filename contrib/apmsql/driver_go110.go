@@ -5,40 +5,85 @@ package apmsql
 import (
 	"context"
 	"database/sql/driver"
+	"reflect"
 
 	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmsql/dsn"
+	"github.com/elastic/apm-agent-go/model"
 )
 
+// WrapConnector wraps a database/sql/driver.Connector such that
+// connections made by the connector are traced, for use with
+// sql.OpenDB. This enables tracing of databases opened with custom
+// connectors, e.g. for dynamic credentials. The tracer will be
+// obtained from the context supplied to methods that accept it.
+//
+// The name value should be the name of the underlying driver, as
+// described for WithDriverName (e.g. "postgresql"). If name is empty,
+// then the driver name will be inferred from the connector's driver.
+//
+// Connectors do not expose a data source name, so the database
+// instance recorded in spans is derived from the connector's type.
+func WrapConnector(connector driver.Connector, name string) driver.Connector {
+	d := &tracingDriver{
+		Driver:     connector.Driver(),
+		driverName: name,
+	}
+	if d.driverName == "" {
+		d.driverName = driverName(d.Driver)
+	}
+	return &driverConnector{
+		connect: connector.Connect,
+		driver:  d,
+		dsnInfo: dsn.Info{Database: connectorInstanceName(connector)},
+	}
+}
+
+// connectorInstanceName returns a best-effort database
+// instance name for a connector, based on its type.
+func connectorInstanceName(connector driver.Connector) string {
+	t := reflect.TypeOf(connector)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
 func (d *tracingDriver) OpenConnector(name string) (driver.Connector, error) {
+	dsnInfo := d.parseDSN(name)
 	if dc, ok := d.Driver.(driver.DriverContext); ok {
 		oc, err := dc.OpenConnector(name)
 		if err != nil {
 			return nil, err
 		}
-		return &driverConnector{oc.Connect, d, name}, nil
+		return &driverConnector{oc.Connect, d, dsnInfo}, nil
 	}
 	connect := func(context.Context) (driver.Conn, error) {
 		return d.Driver.Open(name)
 	}
-	return &driverConnector{connect, d, name}, nil
+	return &driverConnector{connect, d, dsnInfo}, nil
 }
 
 type driverConnector struct {
 	connect func(context.Context) (driver.Conn, error)
 	driver  *tracingDriver
-	name    string
+	dsnInfo dsn.Info
 }
 
 func (d *driverConnector) Connect(ctx context.Context) (driver.Conn, error) {
 	span, ctx := elasticapm.StartSpan(ctx, "connect", d.driver.spanType("connect"))
 	if span != nil {
-		defer span.Done(-1)
+		span.Exit = true
+		span.Context = &model.SpanContext{
+			Destination: d.driver.destinationSpanContext(),
+		}
+		defer span.DoneContext(ctx, -1)
 	}
 	conn, err := d.connect(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return newConn(conn, d.driver, d.name), nil
+	return newConn(conn, d.driver, d.dsnInfo), nil
 }
 
 func (d *driverConnector) Driver() driver.Driver {
@@ -0,0 +1,75 @@
+// +build go1.10
+
+package apmsql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmsql"
+)
+
+func TestWrapConnector(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	db := sql.OpenDB(apmsql.WrapConnector(fakeConnector{}, "postgresql"))
+	defer db.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	_, err := db.ExecContext(ctx, "DELETE FROM foo")
+	require.NoError(t, err)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	spans := payloadSpans(t, transport)
+	require.Len(t, spans, 2)
+	assert.Equal(t, "db.postgresql.connect", spans[0].(map[string]interface{})["type"])
+	span := spans[1].(map[string]interface{})
+	assert.Equal(t, "db.postgresql.exec", span["type"])
+	context := span["context"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"statement": "DELETE FROM foo",
+		"type":      "sql",
+		"instance":  "apmsql_test.fakeConnector",
+	}, context["db"])
+}
+
+func TestWrapConnectorDriverName(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	// The driver name is inferred from the connector's
+	// driver, which is not recognised.
+	db := sql.OpenDB(apmsql.WrapConnector(fakeConnector{}, ""))
+	defer db.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	require.NoError(t, db.PingContext(ctx))
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	spans := payloadSpans(t, transport)
+	require.Len(t, spans, 2) // connect, ping
+	assert.Equal(t, "db.generic.connect", spans[0].(map[string]interface{})["type"])
+}
+
+// fakeConnector is a database/sql/driver.Connector
+// which connects using fakeDriver.
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return fakeDriver{}.Open("")
+}
+
+func (fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
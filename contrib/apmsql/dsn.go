@@ -2,6 +2,7 @@ package apmsql
 
 import (
 	"github.com/elastic/apm-agent-go/contrib/apmsql/dsn"
+	"github.com/elastic/apm-agent-go/contrib/apmsql/mysql/mysqldsn"
 	"github.com/elastic/apm-agent-go/contrib/apmsql/pq/pqdsn"
 	"github.com/elastic/apm-agent-go/contrib/apmsql/sqlite3/sqlite3dsn"
 )
@@ -10,7 +11,9 @@ func dsnParser(driverName string) dsn.ParserFunc {
 	switch driverName {
 	case "postgresql":
 		return pqdsn.ParseDSN
-	case "sqlite3":
+	case "mysql":
+		return mysqldsn.ParseDSN
+	case "sqlite", "sqlite3":
 		return sqlite3dsn.ParseDSN
 	default:
 		return genericDSNParser
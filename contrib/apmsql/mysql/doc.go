@@ -0,0 +1,3 @@
+// Package apmmysql registers the "mysql" driver with
+// apmsql, so that you can trace go-sql-driver/mysql database connections.
+package apmmysql
@@ -0,0 +1,33 @@
+package mysqldsn
+
+import (
+	"strings"
+
+	"github.com/elastic/apm-agent-go/contrib/apmsql/dsn"
+)
+
+// ParseDSN parses the given go-sql-driver/mysql datasource name, of
+// the form "[user[:password]@][protocol[(address)]]/dbname[?params]".
+// The password, if any, is never included in the result.
+func ParseDSN(name string) dsn.Info {
+	// The password may contain '/' and '@', so search
+	// backwards for the separators, as the driver does.
+	pos := strings.LastIndex(name, "/")
+	if pos < 0 {
+		// mysql.Open will fail with the same DSN,
+		// so just return a zero value.
+		return dsn.Info{}
+	}
+	var info dsn.Info
+	info.Database = name[pos+1:]
+	if pos := strings.IndexRune(info.Database, '?'); pos >= 0 {
+		info.Database = info.Database[:pos]
+	}
+	if at := strings.LastIndex(name[:pos], "@"); at >= 0 {
+		info.User = name[:at]
+		if colon := strings.IndexRune(info.User, ':'); colon >= 0 {
+			info.User = info.User[:colon]
+		}
+	}
+	return info
+}
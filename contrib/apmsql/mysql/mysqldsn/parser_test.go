@@ -0,0 +1,19 @@
+package mysqldsn_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-agent-go/contrib/apmsql/dsn"
+	"github.com/elastic/apm-agent-go/contrib/apmsql/mysql/mysqldsn"
+)
+
+func TestParseDSN(t *testing.T) {
+	assert.Equal(t, dsn.Info{Database: "dbname", User: "user"}, mysqldsn.ParseDSN("user:pass@tcp(localhost:3306)/dbname?parseTime=true"))
+	assert.Equal(t, dsn.Info{Database: "dbname", User: "user"}, mysqldsn.ParseDSN("user@unix(/tmp/mysql.sock)/dbname"))
+	assert.Equal(t, dsn.Info{Database: "dbname", User: "user"}, mysqldsn.ParseDSN("user:p@ss/word@/dbname"))
+	assert.Equal(t, dsn.Info{Database: "dbname"}, mysqldsn.ParseDSN("/dbname"))
+	assert.Equal(t, dsn.Info{}, mysqldsn.ParseDSN("/"))
+	assert.Equal(t, dsn.Info{}, mysqldsn.ParseDSN("invalid"))
+}
@@ -39,6 +39,11 @@ func (s *stmt) finishSpan(ctx context.Context, span *elasticapm.Span, resultErro
 	s.conn.finishSpan(ctx, span, "", resultError)
 }
 
+func (s *stmt) finishExecSpan(ctx context.Context, span *elasticapm.Span, result driver.Result, resultError error) {
+	span.Context = s.spanContext
+	s.conn.finishExecSpan(ctx, span, "", result, resultError)
+}
+
 func (s *stmt) ColumnConverter(idx int) driver.ValueConverter {
 	if s.columnConverter != nil {
 		return s.columnConverter.ColumnConverter(idx)
@@ -46,10 +51,12 @@ func (s *stmt) ColumnConverter(idx int) driver.ValueConverter {
 	return driver.DefaultParameterConverter
 }
 
-func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (_ driver.Result, resultError error) {
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (result driver.Result, resultError error) {
 	span, ctx := elasticapm.StartSpan(ctx, s.signature, s.conn.driver.spanType("exec"))
 	if span != nil {
-		defer s.finishSpan(ctx, span, resultError)
+		defer func() {
+			s.finishExecSpan(ctx, span, result, resultError)
+		}()
 	}
 	if s.stmtExecContext != nil {
 		return s.stmtExecContext.ExecContext(ctx, args)
@@ -69,7 +76,9 @@ func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (_ dri
 func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (_ driver.Rows, resultError error) {
 	span, ctx := elasticapm.StartSpan(ctx, s.signature, s.conn.driver.spanType("query"))
 	if span != nil {
-		defer s.finishSpan(ctx, span, resultError)
+		defer func() {
+			s.finishSpan(ctx, span, resultError)
+		}()
 	}
 	if s.stmtQueryContext != nil {
 		return s.stmtQueryContext.QueryContext(ctx, args)
@@ -0,0 +1,3 @@
+// Package apmtemplate provides functions for tracing the execution
+// of html/template and text/template templates as spans.
+package apmtemplate
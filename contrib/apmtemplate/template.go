@@ -0,0 +1,61 @@
+package apmtemplate
+
+import (
+	"context"
+	"io"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+// Template is an interface implemented by both
+// *html/template.Template and *text/template.Template.
+type Template interface {
+	// Name returns the name of the template.
+	Name() string
+
+	// Execute applies the template to the specified
+	// data object, writing the output to w.
+	Execute(w io.Writer, data interface{}) error
+
+	// ExecuteTemplate applies the template associated with
+	// t that has the given name to the specified data object,
+	// writing the output to w.
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+// Execute calls t.Execute, reporting a span of type "template" named
+// by t.Name() within the transaction and parent span in ctx, if any.
+//
+// If t.Execute returns an error, it will be reported to Elastic APM
+// by the transaction's tracer.
+func Execute(ctx context.Context, t Template, w io.Writer, data interface{}) error {
+	span, _ := elasticapm.StartSpan(ctx, t.Name(), "template")
+	if span != nil {
+		defer span.DoneContext(ctx, -1)
+	}
+	err := t.Execute(w, data)
+	captureError(ctx, err)
+	return err
+}
+
+// ExecuteTemplate calls t.ExecuteTemplate, reporting a span of type
+// "template" named by name within the transaction and parent span in
+// ctx, if any.
+//
+// If t.ExecuteTemplate returns an error, it will be reported to Elastic
+// APM by the transaction's tracer.
+func ExecuteTemplate(ctx context.Context, t Template, w io.Writer, name string, data interface{}) error {
+	span, _ := elasticapm.StartSpan(ctx, name, "template")
+	if span != nil {
+		defer span.DoneContext(ctx, -1)
+	}
+	err := t.ExecuteTemplate(w, name, data)
+	captureError(ctx, err)
+	return err
+}
+
+func captureError(ctx context.Context, err error) {
+	if e := elasticapm.CaptureError(ctx, err); e != nil {
+		e.Send()
+	}
+}
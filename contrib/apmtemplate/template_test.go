@@ -0,0 +1,78 @@
+package apmtemplate_test
+
+import (
+	"bytes"
+	"context"
+	htmltemplate "html/template"
+	"testing"
+	texttemplate "text/template"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmtemplate"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestExecute(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	html := htmltemplate.Must(htmltemplate.New("html").Parse(`<p>{{.}}</p>`))
+	text := texttemplate.Must(texttemplate.New("text").Parse(`{{.}}`))
+	texttemplate.Must(text.New("inner").Parse(`[{{.}}]`))
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+
+	var buf bytes.Buffer
+	assert.NoError(t, apmtemplate.Execute(ctx, html, &buf, "<b>"))
+	assert.NoError(t, apmtemplate.ExecuteTemplate(ctx, text, &buf, "inner", "x"))
+	assert.Equal(t, "<p>&lt;b&gt;</p>[x]", buf.String())
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	assert.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	transaction := transactions[0].(map[string]interface{})
+	spans := transaction["spans"].([]interface{})
+	assert.Len(t, spans, 2)
+	for i, name := range []string{"html", "inner"} {
+		span := spans[i].(map[string]interface{})
+		assert.Equal(t, name, span["name"])
+		assert.Equal(t, "template", span["type"])
+	}
+}
+
+func TestExecuteError(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	text := texttemplate.Must(texttemplate.New("text").Parse(`{{.Missing}}`))
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+
+	var buf bytes.Buffer
+	err := apmtemplate.Execute(ctx, text, &buf, struct{}{})
+	assert.Error(t, err)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	assert.Len(t, payloads, 2)
+	errors := payloads[0]["errors"].([]interface{})
+	error0 := errors[0].(map[string]interface{})
+	exception := error0["exception"].(map[string]interface{})
+	assert.Equal(t, err.Error(), exception["message"])
+}
+
+func newRecordingTracer() (*elasticapm.Tracer, *transporttest.RecorderTransport) {
+	var transport transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("apmtemplate_test", "0.1")
+	if err != nil {
+		panic(err)
+	}
+	tracer.Transport = &transport
+	return tracer, &transport
+}
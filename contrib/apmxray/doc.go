@@ -0,0 +1,11 @@
+// Package apmxray provides interoperability with AWS X-Ray: generating
+// trace IDs in the X-Ray format, and propagating trace context in the
+// X-Amzn-Trace-Id header.
+//
+// This is intended for services straddling X-Ray and Elastic APM, e.g.
+// during a migration, so that the same trace ID is meaningful in both
+// systems. Both features are opt-in:
+//
+//	tracer.SetIDGenerator(apmxray.NewIDGenerator())
+//	client := apmhttp.WrapClient(nil, apmhttp.WithTraceHeaders(apmxray.SetTraceHeader))
+package apmxray
@@ -0,0 +1,45 @@
+package apmxray
+
+import (
+	"net/http"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/internal/xray"
+)
+
+// TraceHeader is the HTTP header for propagating AWS X-Ray
+// trace context.
+const TraceHeader = "X-Amzn-Trace-Id"
+
+// SetTraceHeader sets the X-Amzn-Trace-Id header in h to the X-Ray
+// encoding of c, as returned by FormatTraceHeader. Its signature
+// matches apmhttp.TraceHeadersFunc, for propagating X-Ray trace
+// context on outgoing requests with apmhttp.WithTraceHeaders.
+func SetTraceHeader(h http.Header, c elasticapm.TraceContext) {
+	h.Set(TraceHeader, FormatTraceHeader(c))
+}
+
+// FormatTraceHeader formats the given trace context as an AWS X-Ray
+// trace header, e.g.
+//
+//	Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1
+//
+// The first 4 bytes of the trace ID form the X-Ray trace ID's epoch,
+// and the remaining 12 bytes its unique ID. The epoch is meaningful
+// to X-Ray only if the trace ID was generated in the X-Ray format;
+// see NewIDGenerator.
+func FormatTraceHeader(c elasticapm.TraceContext) string {
+	return xray.FormatTraceHeader(c)
+}
+
+// ParseTraceHeader parses the AWS X-Ray trace header, e.g.
+//
+//	Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1
+//
+// returning an elasticapm.TraceContext. The X-Ray trace ID's
+// version is discarded, and its epoch and unique ID are combined
+// to form the 16-byte trace ID. If the sampling decision is
+// absent or deferred ("?"), the trace is considered sampled.
+func ParseTraceHeader(h string) (elasticapm.TraceContext, error) {
+	return xray.ParseTraceHeader(h)
+}
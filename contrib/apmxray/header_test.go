@@ -0,0 +1,34 @@
+package apmxray_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmxray"
+)
+
+func TestFormatTraceHeader(t *testing.T) {
+	c := elasticapm.TraceContext{
+		Trace:   elasticapm.TraceID{0x57, 0x59, 0xe9, 0x88, 0xbd, 0x86, 0x2e, 0x3f, 0xe1, 0xbe, 0x46, 0xa9, 0x94, 0x27, 0x27, 0x93},
+		Span:    elasticapm.SpanID{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8},
+		Options: elasticapm.TraceOptions(0).WithSampled(true),
+	}
+	h := apmxray.FormatTraceHeader(c)
+	assert.Equal(t, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1", h)
+
+	parsed, err := apmxray.ParseTraceHeader(h)
+	require.NoError(t, err)
+	assert.Equal(t, c, parsed)
+
+	c.Options = c.Options.WithSampled(false)
+	header := make(http.Header)
+	apmxray.SetTraceHeader(header, c)
+	assert.Equal(t,
+		"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0",
+		header.Get(apmxray.TraceHeader),
+	)
+}
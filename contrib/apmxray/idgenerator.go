@@ -0,0 +1,45 @@
+package apmxray
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/internal/uuid"
+)
+
+// NewIDGenerator returns an elasticapm.IDGenerator which generates
+// trace IDs in the AWS X-Ray format, for use with Tracer.SetIDGenerator.
+//
+// X-Ray trace IDs begin with the trace's start time: the first 4 bytes
+// of each trace ID hold the current Unix time in seconds, big-endian,
+// and the remaining 12 bytes are random. Span IDs and UUIDs are random.
+func NewIDGenerator() elasticapm.IDGenerator {
+	return idGenerator{}
+}
+
+type idGenerator struct{}
+
+// NewTraceID returns a new X-Ray format trace ID.
+func (idGenerator) NewTraceID() elasticapm.TraceID {
+	var id elasticapm.TraceID
+	binary.BigEndian.PutUint32(id[:4], uint32(time.Now().Unix()))
+	// We ignore the error from the entropy source, which will
+	// only occur if it fails; the epoch ensures the ID is valid.
+	cryptorand.Read(id[4:])
+	return id
+}
+
+// NewSpanID returns a random span ID.
+func (idGenerator) NewSpanID() elasticapm.SpanID {
+	var id elasticapm.SpanID
+	cryptorand.Read(id[:]) // ignore error, as in NewTraceID
+	return id
+}
+
+// NewUUID returns a random (version 4) UUID.
+func (idGenerator) NewUUID() [16]byte {
+	id, _ := uuid.NewV4() // ignore error, as in NewTraceID
+	return id
+}
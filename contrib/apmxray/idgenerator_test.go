@@ -0,0 +1,37 @@
+package apmxray_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmxray"
+)
+
+func TestIDGenerator(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.SetIDGenerator(apmxray.NewIDGenerator())
+
+	before := time.Now().Unix()
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.Done(-1)
+	after := time.Now().Unix()
+
+	// The trace ID's epoch is the start time of the trace.
+	traceID := tx.TraceContext().Trace
+	epoch := int64(binary.BigEndian.Uint32(traceID[:4]))
+	assert.True(t, epoch >= before && epoch <= after, "epoch %d not in [%d,%d]", epoch, before, after)
+	assert.NotEqual(t, traceID, tracer.StartTransaction("name", "type").TraceContext().Trace)
+
+	traceContext := tx.TraceContext()
+	traceContext.Span = tx.StartSpan("name", "type", nil).TraceContext().Span
+	parsed, err := apmxray.ParseTraceHeader(apmxray.FormatTraceHeader(traceContext))
+	require.NoError(t, err)
+	assert.Equal(t, traceID, parsed.Trace)
+}
@@ -0,0 +1,174 @@
+package apmzap
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+const transactionFieldKey = "elasticapm.transaction"
+
+// WrapCore returns a zapcore.Core which wraps c, additionally reporting
+// entries at zapcore.ErrorLevel and above as errors to Elastic APM, using
+// the given tracer, or elasticapm.DefaultTracer if the tracer is nil.
+//
+// All entries are passed through to c unchanged, so logging otherwise
+// proceeds as normal.
+//
+// If an entry has a field of type error (e.g. as added with zap.Error),
+// then the reported error's exception will be initialized from it using
+// Error.SetException. The reported error's log message will be set to
+// the entry's message.
+//
+// If an entry has a field created with TransactionField, the reported
+// error will be associated with the transaction.
+func WrapCore(c zapcore.Core, tracer *elasticapm.Tracer) zapcore.Core {
+	if tracer == nil {
+		tracer = elasticapm.DefaultTracer
+	}
+	return &core{Core: c, errorCore: errorCore{tracer: tracer}}
+}
+
+// TransactionField returns a zap.Field holding the transaction in ctx,
+// if any, for associating errors reported by the zapcore.Core returned
+// by WrapCore with the transaction. If there is no transaction in ctx,
+// then a no-op field is returned.
+//
+// The field is never encoded by the wrapped zapcore.Core.
+func TransactionField(ctx context.Context) zap.Field {
+	tx := elasticapm.TransactionFromContext(ctx)
+	if tx == nil {
+		return zap.Skip()
+	}
+	return zap.Field{
+		Key:       transactionFieldKey,
+		Type:      zapcore.SkipType,
+		Interface: tx,
+	}
+}
+
+// TraceFields returns zap.Fields holding the hex-encoded IDs of the
+// trace, transaction, and span in ctx, if any, for correlating logs
+// with traces. The fields are named "trace.id", "transaction.id", and
+// "span.id", and are omitted if the corresponding ID is unknown.
+//
+// Unlike TransactionField, the fields are encoded by the wrapped
+// zapcore.Core. e.g.
+//
+//	logger.With(apmzap.TraceFields(ctx)...).Info("handling request")
+func TraceFields(ctx context.Context) []zap.Field {
+	ids := elasticapm.CorrelationIDsFromContext(ctx)
+	var fields []zap.Field
+	if ids.TraceID != "" {
+		fields = append(fields, zap.String("trace.id", ids.TraceID))
+	}
+	if ids.TransactionID != "" {
+		fields = append(fields, zap.String("transaction.id", ids.TransactionID))
+	}
+	if ids.SpanID != "" {
+		fields = append(fields, zap.String("span.id", ids.SpanID))
+	}
+	return fields
+}
+
+// core wraps a zapcore.Core, adding errorCore to checked entries at
+// zapcore.ErrorLevel or above.
+type core struct {
+	zapcore.Core
+	errorCore errorCore
+}
+
+// With returns a new core with the given fields added to both the
+// wrapped zapcore.Core and the APM error reporting core.
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{
+		Core:      c.Core.With(fields),
+		errorCore: c.errorCore.with(fields),
+	}
+}
+
+// Check checks the entry against the wrapped zapcore.Core, and adds
+// the APM error reporting core if the entry's level is zapcore.ErrorLevel
+// or above.
+func (c *core) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	ce = c.Core.Check(entry, ce)
+	if c.errorCore.Enabled(entry.Level) {
+		ce = ce.AddCore(entry, &c.errorCore)
+	}
+	return ce
+}
+
+// errorCore is a zapcore.Core that reports entries as errors.
+type errorCore struct {
+	tracer *elasticapm.Tracer
+	fields []zapcore.Field
+}
+
+func (c errorCore) with(fields []zapcore.Field) errorCore {
+	// Copy the fields slice, to avoid sharing
+	// the backing array between cores.
+	all := make([]zapcore.Field, len(c.fields), len(c.fields)+len(fields))
+	copy(all, c.fields)
+	c.fields = append(all, fields...)
+	return c
+}
+
+// Enabled reports whether or not the level is zapcore.ErrorLevel or above.
+func (c *errorCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.ErrorLevel
+}
+
+// With returns a new errorCore with the given fields added.
+func (c *errorCore) With(fields []zapcore.Field) zapcore.Core {
+	c2 := c.with(fields)
+	return &c2
+}
+
+// Check adds c to the checked entry if the entry's level
+// is zapcore.ErrorLevel or above.
+func (c *errorCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		ce = ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+// Write reports the entry as an error to Elastic APM.
+func (c *errorCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	var err error
+	var tx *elasticapm.Transaction
+	for _, fields := range [][]zapcore.Field{c.fields, fields} {
+		for _, f := range fields {
+			switch {
+			case f.Type == zapcore.ErrorType:
+				if fieldErr, ok := f.Interface.(error); ok && err == nil {
+					err = fieldErr
+				}
+			case f.Type == zapcore.SkipType && f.Key == transactionFieldKey:
+				tx, _ = f.Interface.(*elasticapm.Transaction)
+			}
+		}
+	}
+
+	e := c.tracer.NewError()
+	e.Timestamp = entry.Time
+	e.Transaction = tx
+	if err != nil {
+		e.SetException(err)
+		e.Exception.Handled = true
+	}
+	e.SetLog(entry.Message)
+	e.Log.Level = entry.Level.String()
+	e.Log.LoggerName = entry.LoggerName
+	e.Send()
+	return nil
+}
+
+// Sync is a no-op; errors are sent to Elastic APM
+// asynchronously by the tracer.
+func (c *errorCore) Sync() error {
+	return nil
+}
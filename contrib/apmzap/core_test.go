@@ -0,0 +1,97 @@
+package apmzap_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/contrib/apmzap"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestWrapCore(t *testing.T) {
+	tracer, transport := newRecordingTracer()
+	defer tracer.Close()
+
+	observed, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(apmzap.WrapCore(observed, tracer))
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	logger.Info("not reported")
+	logger.With(zap.String("foo", "bar")).Error(
+		"reported", zap.Error(errors.New("boom")),
+		apmzap.TransactionField(ctx),
+	)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	// All entries should be passed through to the wrapped core,
+	// and the transaction field should be omitted.
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, map[string]interface{}{
+		"foo":   "bar",
+		"error": "boom",
+	}, entries[1].ContextMap())
+
+	payloads := transport.Payloads()
+	assert.Len(t, payloads, 2)
+	errors := payloads[0]["errors"].([]interface{})
+	assert.Len(t, errors, 1)
+	error0 := errors[0].(map[string]interface{})
+	exception := error0["exception"].(map[string]interface{})
+	assert.Equal(t, "boom", exception["message"])
+	assert.Equal(t, true, exception["handled"])
+	log := error0["log"].(map[string]interface{})
+	assert.Equal(t, "reported", log["message"])
+	assert.Equal(t, "error", log["level"])
+
+	transactions := payloads[1]["transactions"].([]interface{})
+	transaction0 := transactions[0].(map[string]interface{})
+	errorTransaction := error0["transaction"].(map[string]interface{})
+	assert.Equal(t, transaction0["id"], errorTransaction["id"])
+}
+
+func TestTraceFields(t *testing.T) {
+	tracer, _ := newRecordingTracer()
+	defer tracer.Close()
+
+	observed, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(observed)
+	logger.Info("no transaction", apmzap.TraceFields(context.Background())...)
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.Done(-1)
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	span, ctx := elasticapm.StartSpan(ctx, "name", "type")
+	defer span.Done(-1)
+	logger.Info("span", apmzap.TraceFields(ctx)...)
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	assert.Empty(t, entries[0].ContextMap())
+
+	ids := elasticapm.CorrelationIDsFromContext(ctx)
+	assert.Equal(t, map[string]interface{}{
+		"trace.id":       ids.TraceID,
+		"transaction.id": ids.TransactionID,
+		"span.id":        ids.SpanID,
+	}, entries[1].ContextMap())
+}
+
+func newRecordingTracer() (*elasticapm.Tracer, *transporttest.RecorderTransport) {
+	var transport transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("apmzap_test", "0.1")
+	if err != nil {
+		panic(err)
+	}
+	tracer.Transport = &transport
+	return tracer, &transport
+}
@@ -0,0 +1,3 @@
+// Package apmzap provides a zapcore.Core implementation for
+// reporting errors logged with go.uber.org/zap to Elastic APM.
+package apmzap
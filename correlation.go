@@ -0,0 +1,46 @@
+package elasticapm
+
+import (
+	"context"
+)
+
+// CorrelationIDs holds the IDs of the current trace, transaction,
+// and span, for correlating logs with traces.
+//
+// A field will be empty if the corresponding ID is unknown.
+type CorrelationIDs struct {
+	// TraceID holds the ID of the current trace, for
+	// recording in logs as "trace.id".
+	TraceID string
+
+	// TransactionID holds the UUID of the current transaction,
+	// for recording in logs as "transaction.id".
+	TransactionID string
+
+	// SpanID holds the ID of the current span, if any, for
+	// recording in logs as "span.id".
+	SpanID string
+}
+
+// CorrelationIDsFromContext returns the CorrelationIDs for the
+// transaction and span in ctx, if any. If ctx does not contain a
+// transaction, then the zero value is returned.
+//
+// The IDs are the same as those reported to Elastic APM in the
+// transaction "trace_id" and "id" fields, and the span "span_id"
+// field.
+func CorrelationIDsFromContext(ctx context.Context) CorrelationIDs {
+	var ids CorrelationIDs
+	tx := TransactionFromContext(ctx)
+	if tx == nil {
+		return ids
+	}
+	if tx.traceContext.Trace.Validate() == nil {
+		ids.TraceID = tx.traceContext.Trace.String()
+	}
+	ids.TransactionID = tx.setID()
+	if span := SpanFromContext(ctx); !span.Dropped() {
+		ids.SpanID = span.id.String()
+	}
+	return ids
+}
@@ -0,0 +1,60 @@
+package elasticapm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestCorrelationIDsFromContext(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	assert.Zero(t, elasticapm.CorrelationIDsFromContext(context.Background()))
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+	txIDs := elasticapm.CorrelationIDsFromContext(ctx)
+	assert.Len(t, txIDs.TraceID, 32)
+	assert.Len(t, txIDs.TransactionID, 36)
+	assert.Empty(t, txIDs.SpanID)
+
+	span, ctx := elasticapm.StartSpan(ctx, "name", "type")
+	spanIDs := elasticapm.CorrelationIDsFromContext(ctx)
+	assert.Equal(t, txIDs.TraceID, spanIDs.TraceID)
+	assert.Equal(t, txIDs.TransactionID, spanIDs.TransactionID)
+	assert.Len(t, spanIDs.SpanID, 16)
+	e := tracer.NewError()
+	e.Transaction = tx
+	e.SetLog("message")
+	e.Send()
+	span.Done(-1)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	var transaction, errorEvent map[string]interface{}
+	for _, p := range r.Payloads() {
+		if transactions, ok := p["transactions"].([]interface{}); ok {
+			transaction = transactions[0].(map[string]interface{})
+		}
+		if errorEvents, ok := p["errors"].([]interface{}); ok {
+			errorEvent = errorEvents[0].(map[string]interface{})
+		}
+	}
+	require.NotNil(t, transaction)
+	require.NotNil(t, errorEvent)
+	assert.Equal(t, txIDs.TransactionID, errorEvent["transaction"].(map[string]interface{})["id"])
+	assert.Equal(t, txIDs.TraceID, transaction["trace_id"])
+	assert.Equal(t, txIDs.TransactionID, transaction["id"])
+	spans := transaction["spans"].([]interface{})
+	require.Len(t, spans, 1)
+	assert.Equal(t, spanIDs.SpanID, spans[0].(map[string]interface{})["span_id"])
+}
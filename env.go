@@ -4,20 +4,60 @@ import (
 	"math/rand"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
 const (
-	envFlushInterval         = "ELASTIC_APM_FLUSH_INTERVAL"
-	envMaxQueueSize          = "ELASTIC_APM_MAX_QUEUE_SIZE"
-	envMaxSpans              = "ELASTIC_APM_TRANSACTION_MAX_SPANS"
-	envTransactionSampleRate = "ELASTIC_APM_TRANSACTION_SAMPLE_RATE"
-
-	defaultFlushInterval           = 10 * time.Second
-	defaultMaxTransactionQueueSize = 500
-	defaultMaxSpans                = 500
+	envFlushInterval            = "ELASTIC_APM_FLUSH_INTERVAL"
+	envMaxQueueSize             = "ELASTIC_APM_MAX_QUEUE_SIZE"
+	envMaxSpans                 = "ELASTIC_APM_TRANSACTION_MAX_SPANS"
+	envMaxSpanStacktraces       = "ELASTIC_APM_TRANSACTION_MAX_SPAN_STACKTRACES"
+	envTransactionSampleRate    = "ELASTIC_APM_TRANSACTION_SAMPLE_RATE"
+	envRecording                = "ELASTIC_APM_RECORDING"
+	envCaptureBody              = "ELASTIC_APM_CAPTURE_BODY"
+	envAPIRequestConcurrency    = "ELASTIC_APM_API_REQUEST_CONCURRENCY"
+	envMetricsInterval          = "ELASTIC_APM_METRICS_INTERVAL"
+	envBreakdownMetrics         = "ELASTIC_APM_BREAKDOWN_METRICS"
+	envMetricsExemplars         = "ELASTIC_APM_METRICS_EXEMPLARS"
+	envCircuitBreakerThreshold  = "ELASTIC_APM_CIRCUIT_BREAKER_THRESHOLD"
+	envCircuitBreakerCooldown   = "ELASTIC_APM_CIRCUIT_BREAKER_COOLDOWN"
+	envCaptureEnv               = "ELASTIC_APM_CAPTURE_ENV"
+	envTopLevelSpans            = "ELASTIC_APM_TOP_LEVEL_SPANS"
+	envCaptureBodyMaxFormFields = "ELASTIC_APM_CAPTURE_BODY_MAX_FORM_FIELDS"
+	envCaptureBodyMaxSize       = "ELASTIC_APM_CAPTURE_BODY_MAX_SIZE"
+	envCaptureQueryParams       = "ELASTIC_APM_CAPTURE_QUERY_PARAMS"
+	envPerEventService          = "ELASTIC_APM_PER_EVENT_SERVICE"
+	envTransactionMaxDuration   = "ELASTIC_APM_TRANSACTION_MAX_DURATION"
+	envNestedTransactionSpans   = "ELASTIC_APM_NESTED_TRANSACTION_SPANS"
+	envCaptureGoroutines        = "ELASTIC_APM_CAPTURE_GOROUTINES"
+	envErrorRateLimit           = "ELASTIC_APM_ERROR_RATE_LIMIT"
+	envAPIRequestMinInterval    = "ELASTIC_APM_API_REQUEST_MIN_INTERVAL"
+
+	defaultFlushInterval            = 10 * time.Second
+	defaultMaxTransactionQueueSize  = 500
+	defaultMaxSpans                 = 500
+	defaultMaxSpanStacktraces       = 0
+	defaultRecording                = true
+	defaultCaptureBody              = CaptureBodyOff
+	defaultAPIRequestConcurrency    = 1
+	defaultMetricsInterval          = 30 * time.Second
+	defaultBreakdownMetrics         = true
+	defaultMetricsExemplars         = false
+	defaultCircuitBreakerThreshold  = 0
+	defaultCircuitBreakerCooldown   = 30 * time.Second
+	defaultTopLevelSpans            = false
+	defaultCaptureBodyMaxFormFields = 500
+	defaultCaptureBodyMaxSize       = 10 * 1024
+	defaultCaptureQueryParams       = false
+	defaultPerEventService          = false
+	defaultTransactionMaxDuration   = 0
+	defaultNestedTransactionSpans   = false
+	defaultCaptureGoroutines        = false
+	defaultErrorRateLimit           = 0
+	defaultAPIRequestMinInterval    = 0
 )
 
 func initialFlushInterval() (time.Duration, error) {
@@ -42,6 +82,63 @@ func initialFlushInterval() (time.Duration, error) {
 	return d, nil
 }
 
+func initialMetricsInterval() (time.Duration, error) {
+	value := os.Getenv(envMetricsInterval)
+	if value == "" {
+		return defaultMetricsInterval, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		// As with the flush interval, we allow the value
+		// to have no suffix, in which case we assume seconds.
+		var err2 error
+		d, err2 = time.ParseDuration(value + "s")
+		if err2 == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envMetricsInterval)
+	}
+	return d, nil
+}
+
+func initialCircuitBreakerThreshold() (int, error) {
+	value := os.Getenv(envCircuitBreakerThreshold)
+	if value == "" {
+		return defaultCircuitBreakerThreshold, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envCircuitBreakerThreshold)
+	}
+	return n, nil
+}
+
+func initialCircuitBreakerCooldown() (time.Duration, error) {
+	value := os.Getenv(envCircuitBreakerCooldown)
+	if value == "" {
+		return defaultCircuitBreakerCooldown, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envCircuitBreakerCooldown)
+	}
+	return d, nil
+}
+
+func initialTransactionMaxDuration() (time.Duration, error) {
+	value := os.Getenv(envTransactionMaxDuration)
+	if value == "" {
+		return defaultTransactionMaxDuration, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envTransactionMaxDuration)
+	}
+	return d, nil
+}
+
 func initialMaxTransactionQueueSize() (int, error) {
 	value := os.Getenv(envMaxQueueSize)
 	if value == "" {
@@ -66,6 +163,150 @@ func initialMaxSpans() (int, error) {
 	return max, nil
 }
 
+func initialMaxSpanStacktraces() (int, error) {
+	value := os.Getenv(envMaxSpanStacktraces)
+	if value == "" {
+		return defaultMaxSpanStacktraces, nil
+	}
+	max, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envMaxSpanStacktraces)
+	}
+	return max, nil
+}
+
+func initialAPIRequestConcurrency() (int, error) {
+	value := os.Getenv(envAPIRequestConcurrency)
+	if value == "" {
+		return defaultAPIRequestConcurrency, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envAPIRequestConcurrency)
+	}
+	return n, nil
+}
+
+func initialAPIRequestMinInterval() (time.Duration, error) {
+	value := os.Getenv(envAPIRequestMinInterval)
+	if value == "" {
+		return defaultAPIRequestMinInterval, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envAPIRequestMinInterval)
+	}
+	return d, nil
+}
+
+func initialRecording() (bool, error) {
+	return parseBoolEnv(envRecording, defaultRecording)
+}
+
+func initialBreakdownMetrics() (bool, error) {
+	return parseBoolEnv(envBreakdownMetrics, defaultBreakdownMetrics)
+}
+
+func initialMetricsExemplars() (bool, error) {
+	return parseBoolEnv(envMetricsExemplars, defaultMetricsExemplars)
+}
+
+func initialTopLevelSpans() (bool, error) {
+	return parseBoolEnv(envTopLevelSpans, defaultTopLevelSpans)
+}
+
+func initialCaptureQueryParams() (bool, error) {
+	return parseBoolEnv(envCaptureQueryParams, defaultCaptureQueryParams)
+}
+
+func initialPerEventService() (bool, error) {
+	return parseBoolEnv(envPerEventService, defaultPerEventService)
+}
+
+func initialNestedTransactionSpans() (bool, error) {
+	return parseBoolEnv(envNestedTransactionSpans, defaultNestedTransactionSpans)
+}
+
+func initialCaptureGoroutines() (bool, error) {
+	return parseBoolEnv(envCaptureGoroutines, defaultCaptureGoroutines)
+}
+
+func initialErrorRateLimit() (int, error) {
+	value := os.Getenv(envErrorRateLimit)
+	if value == "" {
+		return defaultErrorRateLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envErrorRateLimit)
+	}
+	return limit, nil
+}
+
+func parseBoolEnv(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to parse %s", key)
+	}
+	return b, nil
+}
+
+// initialCaptureEnv returns the environment variable name
+// patterns to capture, or nil if none should be captured.
+func initialCaptureEnv() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(os.Getenv(envCaptureEnv), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func initialCaptureBody() (CaptureBodyMode, error) {
+	value := os.Getenv(envCaptureBody)
+	if value == "" {
+		return defaultCaptureBody, nil
+	}
+	switch strings.TrimSpace(strings.ToLower(value)) {
+	case "off":
+		return CaptureBodyOff, nil
+	case "errors":
+		return CaptureBodyErrors, nil
+	case "transactions":
+		return CaptureBodyTransactions, nil
+	case "all":
+		return CaptureBodyAll, nil
+	}
+	return -1, errors.Errorf("invalid %s value %q", envCaptureBody, value)
+}
+
+func initialCaptureBodyLimits() (CaptureBodyLimits, error) {
+	limits := CaptureBodyLimits{
+		MaxFormFields: defaultCaptureBodyMaxFormFields,
+		MaxSize:       defaultCaptureBodyMaxSize,
+	}
+	if value := os.Getenv(envCaptureBodyMaxFormFields); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil {
+			return limits, errors.Wrapf(err, "failed to parse %s", envCaptureBodyMaxFormFields)
+		}
+		limits.MaxFormFields = max
+	}
+	if value := os.Getenv(envCaptureBodyMaxSize); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil {
+			return limits, errors.Wrapf(err, "failed to parse %s", envCaptureBodyMaxSize)
+		}
+		limits.MaxSize = max
+	}
+	return limits, nil
+}
+
 // initialSampler returns a nil Sampler if all transactions should be sampled.
 func initialSampler() (Sampler, error) {
 	value := os.Getenv(envTransactionSampleRate)
@@ -1,7 +1,13 @@
 package elasticapm_test
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -87,3 +93,199 @@ func testTracerTransactionRateEnv(t *testing.T, envValue string, ratio float64)
 	}
 	assert.InDelta(t, N*ratio, sampled, N*0.02) // allow 2% error
 }
+
+func TestTracerCaptureEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		"ELASTIC_APM_CAPTURE_ENV": " APMTEST_*_CONFIG , APMTEST_EXACT,",
+		"APMTEST_FOO_CONFIG":      "foo",
+		"APMTEST_FOO_BAR_CONFIG":  "bar",
+		"APMTEST_SECRET_CONFIG":   "hunter2",
+		"APMTEST_EXACT":           "exact",
+		"APMTEST_EXACTLY":         "nope",
+		"APMTEST_CONFIG":          "nope",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tracer.StartTransaction("name", "type").Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	process := payloads[0]["process"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"APMTEST_FOO_CONFIG":     "foo",
+		"APMTEST_FOO_BAR_CONFIG": "bar",
+		"APMTEST_SECRET_CONFIG":  "[REDACTED]",
+		"APMTEST_EXACT":          "exact",
+	}, process["env"])
+}
+
+func TestTracerCaptureEnvDefault(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tracer.StartTransaction("name", "type").Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	assert.NotContains(t, payloads[0]["process"], "env")
+}
+
+func TestTracerCaptureBodyLimitsEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_CAPTURE_BODY_MAX_FORM_FIELDS", "10")
+	defer os.Unsetenv("ELASTIC_APM_CAPTURE_BODY_MAX_FORM_FIELDS")
+	os.Setenv("ELASTIC_APM_CAPTURE_BODY_MAX_SIZE", "1024")
+	defer os.Unsetenv("ELASTIC_APM_CAPTURE_BODY_MAX_SIZE")
+
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	assert.Equal(t, elasticapm.CaptureBodyLimits{
+		MaxFormFields: 10,
+		MaxSize:       1024,
+	}, tracer.CaptureBodyLimits())
+}
+
+func TestTracerCaptureBodyLimitsEnvDefault(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	assert.Equal(t, elasticapm.CaptureBodyLimits{
+		MaxFormFields: 500,
+		MaxSize:       10 * 1024,
+	}, tracer.CaptureBodyLimits())
+}
+
+func TestTracerCaptureQueryParamsEnv(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	assert.False(t, tracer.CaptureQueryParams())
+	tracer.Close()
+
+	os.Setenv("ELASTIC_APM_CAPTURE_QUERY_PARAMS", "true")
+	defer os.Unsetenv("ELASTIC_APM_CAPTURE_QUERY_PARAMS")
+	tracer, err = elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	assert.True(t, tracer.CaptureQueryParams())
+}
+
+func TestTracerTransactionMaxDurationEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TRANSACTION_MAX_DURATION", "10ms")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_MAX_DURATION")
+
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tracer.StartTransaction("name", "type")
+	for len(r.Payloads()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+		tracer.Flush(nil)
+	}
+	transaction := r.Payloads()[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "timeout", transaction["result"])
+}
+
+func TestTracerTransactionMaxDurationEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TRANSACTION_MAX_DURATION", "aeon")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_MAX_DURATION")
+
+	_, err := elasticapm.NewTracer("tracer.testing", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse ELASTIC_APM_TRANSACTION_MAX_DURATION")
+}
+
+func TestTracerErrorRateLimitEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_ERROR_RATE_LIMIT", "1")
+	defer os.Unsetenv("ELASTIC_APM_ERROR_RATE_LIMIT")
+
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	for i := 0; i < 2; i++ {
+		e := tracer.NewError()
+		e.SetLog("message")
+		e.Send()
+	}
+	tracer.Flush(nil)
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	assert.Len(t, payloads[0]["errors"], 1)
+}
+
+func TestTracerServiceNameEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_SERVICE_NAME", "foo_bar")
+	defer os.Unsetenv("ELASTIC_APM_SERVICE_NAME")
+	assert.Equal(t, "foo_bar", serviceNameFromExecutable(t, "my-service"))
+}
+
+func TestTracerServiceNameExecutable(t *testing.T) {
+	os.Unsetenv("ELASTIC_APM_SERVICE_NAME")
+	assert.Equal(t, "my-service", serviceNameFromExecutable(t, "my-service"))
+	assert.Equal(t, "my-service", serviceNameFromExecutable(t, "my-service.exe"))
+	assert.Equal(t, "my service_v2", serviceNameFromExecutable(t, "my service.v2.exe"))
+}
+
+// serviceNameFromExecutable runs the test binary, copied to a path with
+// the given base name in a directory containing spaces, and returns the
+// name of the service reported by a tracer created with no service name.
+func serviceNameFromExecutable(t *testing.T, base string) string {
+	dir, err := ioutil.TempDir("", "apm agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, base)
+	copyFile(t, os.Args[0], path)
+	cmd := exec.Command(path, "-test.run=^TestServiceNameHelperProcess$")
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+	output, err := cmd.Output()
+	require.NoError(t, err)
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "service: ") {
+			return strings.TrimPrefix(line, "service: ")
+		}
+	}
+	t.Fatalf("service name not found in output: %s", output)
+	panic("unreachable")
+}
+
+func copyFile(t *testing.T, from, to string) {
+	in, err := os.Open(from)
+	require.NoError(t, err)
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	require.NoError(t, err)
+	_, err = io.Copy(out, in)
+	require.NoError(t, err)
+	require.NoError(t, out.Close())
+}
+
+// TestServiceNameHelperProcess is not a real test; it is run in a
+// subprocess by serviceNameFromExecutable.
+func TestServiceNameHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	tracer, err := elasticapm.NewTracer("", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	fmt.Printf("service: %s\n", tracer.Service.Name)
+}
@@ -1,6 +1,7 @@
 package elasticapm
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -47,14 +48,17 @@ func (t *Tracer) Recover(tx *Transaction) {
 func (t *Tracer) Recovered(v interface{}, tx *Transaction) *Error {
 	e := t.NewError()
 	e.Transaction = tx
-	e.ID, _ = NewUUID() // ignore error result
+	e.ID = t.newUUID()
 	switch v := v.(type) {
 	case error:
 		e.SetException(v)
 	default:
+		// The recovered value is not an error, so we report the
+		// value's type in place of the error type.
 		e.Exception = &model.Exception{
 			Message: fmt.Sprint(v),
 		}
+		e.Exception.Module, e.Exception.Type = typeName(v)
 	}
 	return e
 }
@@ -84,7 +88,29 @@ func (e *Error) reset() {
 
 // Send enqueues the error for sending to the Elastic APM server.
 // The Error must not be used after this.
+//
+// If the tracer is not recording, the error will be discarded. If the
+// tracer is configured to capture goroutines, a summary of goroutines
+// is recorded at this point; see Tracer.SetCaptureGoroutines. If the
+// error exceeds the tracer's error rate limit, it will be discarded;
+// see Tracer.SetErrorRateLimit.
 func (e *Error) Send() {
+	if !e.tracer.Recording() || !e.tracer.errorRateLimiter.allow(e, time.Now()) {
+		e.reset()
+		e.tracer.errorPool.Put(e)
+		return
+	}
+	if e.Transaction != nil {
+		e.Transaction.mu.Lock()
+		e.Transaction.hasErrors = true
+		e.Transaction.mu.Unlock()
+		if e.Service == nil {
+			e.Service = e.Transaction.Service
+		}
+	}
+	if e.tracer.CaptureGoroutines() {
+		e.setGoroutines()
+	}
 	select {
 	case e.tracer.errors <- e:
 	default:
@@ -155,7 +181,9 @@ func initException(e *model.Exception, err error) {
 		e.Attributes[k] = v
 	}
 
-	// Set Module, Type, Attributes, and Code.
+	// Set Module, Type, Attributes, and Code. Errors reporting a
+	// status code, such as those returned by HTTP clients, have it
+	// recorded in the "status_code" attribute.
 	switch err := err.(type) {
 	case *net.OpError:
 		e.Module, e.Type = "net", "OpError"
@@ -179,11 +207,7 @@ func initException(e *model.Exception, err error) {
 		e.Module, e.Type = "syscall", "Errno"
 		e.Code = uintptr(err)
 	default:
-		t := reflect.TypeOf(err)
-		if t.Name() == "" && t.Kind() == reflect.Ptr {
-			t = t.Elem()
-		}
-		e.Module, e.Type = t.PkgPath(), t.Name()
+		e.Module, e.Type = typeName(err)
 	}
 	if errTemporary(err) {
 		setAttr("temporary", true)
@@ -191,6 +215,27 @@ func initException(e *model.Exception, err error) {
 	if errTimeout(err) {
 		setAttr("timeout", true)
 	}
+	if code, ok := errStatusCode(err); ok {
+		setAttr("status_code", code)
+	}
+}
+
+// typeName returns the package path and name of v's type. If v's
+// type is an unnamed pointer type, the pointer's element type is
+// used. If the type is unnamed, its string representation is used
+// as the name.
+func typeName(v interface{}) (pkgPath, name string) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return "", ""
+	}
+	if t.Name() == "" && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return "", t.String()
+	}
+	return t.PkgPath(), t.Name()
 }
 
 func initErrorsStacktrace(e *model.Exception, err error) {
@@ -207,6 +252,30 @@ func initErrorsStacktrace(e *model.Exception, err error) {
 	}
 }
 
+// SetExceptionAttribute sets the exception attribute with the given
+// key and value, allocating Exception.Attributes if necessary. The
+// Exception must have been set, e.g. with SetException.
+//
+// The characters '.', '*', and '"' in key are replaced with '_', as
+// for tag keys. An error is returned, and the attribute is not set,
+// if key is empty, or if value cannot be encoded as JSON.
+func (e *Error) SetExceptionAttribute(key string, value interface{}) error {
+	if e.Exception == nil {
+		return errors.New("exception not set")
+	}
+	if key == "" {
+		return errors.New("exception attribute key must be specified")
+	}
+	if _, err := json.Marshal(value); err != nil {
+		return errors.Wrapf(err, "invalid value for exception attribute %q", key)
+	}
+	if e.Exception.Attributes == nil {
+		e.Exception.Attributes = make(map[string]interface{})
+	}
+	e.Exception.Attributes[model.SanitizeTagKey(key)] = value
+	return nil
+}
+
 // SetLog initialises the Error.Log field with the given message.
 // The other Log fields will be empty.
 func (e *Error) SetLog(message string) {
@@ -243,3 +312,16 @@ func errTimeout(err error) bool {
 	terr, ok := err.(timeoutError)
 	return ok && terr.Timeout()
 }
+
+// errStatusCode returns the status code of err, e.g. an HTTP status
+// code, if err reports one through a StatusCode method.
+func errStatusCode(err error) (int, bool) {
+	type statusCodeError interface {
+		StatusCode() int
+	}
+	serr, ok := err.(statusCodeError)
+	if !ok {
+		return 0, false
+	}
+	return serr.StatusCode(), true
+}
@@ -0,0 +1,82 @@
+package elasticapm_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func BenchmarkErrorStacktrace(b *testing.B) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+
+	b.Run("exception", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e := tracer.NewError()
+			e.SetException(errors.New("boom"))
+			e.Send()
+		}
+	})
+	b.Run("log", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e := tracer.NewError()
+			e.SetLog("boom")
+			e.SetLogStacktrace(0)
+			e.Send()
+		}
+	})
+}
+
+func BenchmarkErrorBurst(b *testing.B) {
+	b.Run("no_min_interval", func(b *testing.B) {
+		benchmarkErrorBurst(b, 0)
+	})
+	b.Run("min_interval_10ms", func(b *testing.B) {
+		benchmarkErrorBurst(b, 10*time.Millisecond)
+	})
+}
+
+// benchmarkErrorBurst sends b.N errors, 10µs apart, and logs
+// the number of requests made to send them. With a minimum interval
+// between requests, errors in the burst are coalesced.
+func benchmarkErrorBurst(b *testing.B, minInterval time.Duration) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tracer.Close()
+	var requests, errorsSent int64
+	tracer.Transport = transporttest.CallbackTransport{
+		Errors: func(ctx context.Context, p *model.ErrorsPayload) error {
+			atomic.AddInt64(&requests, 1)
+			atomic.AddInt64(&errorsSent, int64(len(p.Errors)))
+			return nil
+		},
+	}
+	tracer.SetAPIRequestMinInterval(minInterval)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := tracer.NewError()
+		e.SetLog("boom")
+		e.Send()
+		time.Sleep(10 * time.Microsecond)
+	}
+	tracer.Flush(nil)
+	b.StopTimer()
+	b.Logf("%d errors sent in %d requests", atomic.LoadInt64(&errorsSent), atomic.LoadInt64(&requests))
+}
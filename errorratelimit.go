@@ -0,0 +1,115 @@
+package elasticapm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	errorsRateLimitedMetricName = "errors.rate_limited.count"
+
+	// defaultErrorRateLimitInterval is the interval over which
+	// the limit configured with ELASTIC_APM_ERROR_RATE_LIMIT
+	// applies.
+	defaultErrorRateLimitInterval = time.Minute
+)
+
+// errorRateLimiter limits the number of errors sent per group of
+// similar errors, identified by errorGroupingKey, within each fixed
+// interval. The number of errors dropped is reported as a metric.
+type errorRateLimiter struct {
+	mu          sync.Mutex
+	limit       int
+	interval    time.Duration
+	windowStart time.Time
+	counts      map[string]int
+	dropped     uint64
+}
+
+func newErrorRateLimiter(limit int, interval time.Duration) *errorRateLimiter {
+	return &errorRateLimiter{limit: limit, interval: interval}
+}
+
+// allow reports whether or not e may be sent, recording it against
+// its group's count for the current interval. If e exceeds the limit,
+// it is counted as dropped.
+func (l *errorRateLimiter) allow(e *Error, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit <= 0 || l.interval <= 0 {
+		return true
+	}
+	if l.counts == nil || now.Sub(l.windowStart) >= l.interval {
+		l.counts = make(map[string]int)
+		l.windowStart = now
+	}
+	key := errorGroupingKey(e)
+	if l.counts[key] >= l.limit {
+		l.dropped++
+		return false
+	}
+	l.counts[key]++
+	return true
+}
+
+// GatherMetrics adds the number of errors dropped due to rate
+// limiting since the last call to m, if any were dropped.
+func (l *errorRateLimiter) GatherMetrics(ctx context.Context, m *Metrics) error {
+	l.mu.Lock()
+	dropped := l.dropped
+	l.dropped = 0
+	l.mu.Unlock()
+	if dropped > 0 {
+		m.Add(errorsRateLimitedMetricName, nil, float64(dropped))
+	}
+	return nil
+}
+
+// errorGroupingKey returns a key identifying the group of similar
+// errors that e belongs to: errors with the same exception type, or
+// log message, occurring in the same function. The exception message
+// is used only if the function is unknown, as messages often contain
+// request-specific values.
+func errorGroupingKey(e *Error) string {
+	culprit := e.Culprit
+	var key string
+	if e.Exception != nil {
+		if culprit == "" {
+			culprit = stacktraceCulprit(e.Exception.Stacktrace)
+		}
+		key = e.Exception.Module + "." + e.Exception.Type
+		if culprit == "" {
+			key += ":" + e.Exception.Message
+		}
+	}
+	if e.Log != nil {
+		if culprit == "" {
+			culprit = stacktraceCulprit(e.Log.Stacktrace)
+		}
+		message := e.Log.ParamMessage
+		if message == "" {
+			message = e.Log.Message
+		}
+		key += "\x00" + message
+	}
+	return key + "\x00" + culprit
+}
+
+// SetErrorRateLimit sets the maximum number of similar errors that
+// will be sent within each interval. Errors are considered similar
+// if they have the same exception type or log message, and occur in
+// the same function. Errors beyond the limit are dropped, and the
+// number dropped is reported as the "errors.rate_limited.count"
+// metric. If limit or interval is zero or negative, errors are not
+// rate limited.
+//
+// This protects the server from storms of identical errors, e.g.
+// following a bad deployment.
+func (t *Tracer) SetErrorRateLimit(limit int, interval time.Duration) {
+	t.errorRateLimiter.mu.Lock()
+	t.errorRateLimiter.limit = limit
+	t.errorRateLimiter.interval = interval
+	t.errorRateLimiter.counts = nil
+	t.errorRateLimiter.mu.Unlock()
+}
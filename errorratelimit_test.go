@@ -0,0 +1,110 @@
+package elasticapm_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestTracerErrorRateLimit(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+	tracer.SetErrorRateLimit(2, time.Hour)
+
+	sendError := func(err error) {
+		e := tracer.NewError()
+		e.SetException(err)
+		e.SetExceptionStacktrace(1)
+		e.Send()
+	}
+	for i := 0; i < 5; i++ {
+		// Messages are ignored when grouping errors with a culprit.
+		sendError(errors.New(string(rune('a' + i))))
+	}
+	sendError(&os.PathError{Op: "open", Path: "/", Err: errors.New("boom")})
+	sendLog := func(message string) {
+		e := tracer.NewError()
+		e.SetLog(message)
+		e.SetLogStacktrace(1)
+		e.Send()
+	}
+	for i := 0; i < 3; i++ {
+		sendLog("one")
+		sendLog("two")
+	}
+	tracer.Flush(nil)
+
+	var messages []string
+	for _, p := range r.Payloads() {
+		for _, e := range p["errors"].([]interface{}) {
+			e := e.(map[string]interface{})
+			if exception, ok := e["exception"].(map[string]interface{}); ok {
+				messages = append(messages, exception["message"].(string))
+			} else {
+				messages = append(messages, e["log"].(map[string]interface{})["message"].(string))
+			}
+		}
+	}
+	assert.Equal(t, []string{"a", "b", "open /: boom", "one", "two", "one", "two"}, messages)
+
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if dropped, ok := rateLimitedMetric(r.Payloads()); ok {
+			assert.Equal(t, float64(5), dropped)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for metrics")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTracerErrorRateLimitZeroInterval(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	// A zero interval disables rate limiting,
+	// rather than resetting the limit on every error.
+	tracer.SetErrorRateLimit(1, 0)
+	for i := 0; i < 3; i++ {
+		e := tracer.NewError()
+		e.SetLog("message")
+		e.SetLogStacktrace(1)
+		e.Send()
+	}
+	tracer.Flush(nil)
+
+	var n int
+	for _, p := range r.Payloads() {
+		n += len(p["errors"].([]interface{}))
+	}
+	assert.Equal(t, 3, n)
+}
+
+func rateLimitedMetric(payloads []map[string]interface{}) (float64, bool) {
+	for _, p := range payloads {
+		metrics, _ := p["metrics"].([]interface{})
+		for _, m := range metrics {
+			samples := m.(map[string]interface{})["samples"].(map[string]interface{})
+			if sample, ok := samples["errors.rate_limited.count"]; ok {
+				return sample.(map[string]interface{})["value"].(float64), true
+			}
+		}
+	}
+	return 0, false
+}
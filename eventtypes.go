@@ -0,0 +1,42 @@
+package elasticapm
+
+import (
+	"context"
+	"time"
+
+	"github.com/elastic/apm-agent-go/transport"
+)
+
+// eventTypesProbeTimeout is the maximum amount of time to wait
+// for the transport to report the event types accepted by the
+// server, after which all event types are assumed to be accepted.
+const eventTypesProbeTimeout = 10 * time.Second
+
+type eventTypesResult struct {
+	eventTypes []string
+	err        error
+}
+
+// accepts reports whether or not eventType is one of the
+// event types accepted by the server.
+func (r eventTypesResult) accepts(eventType string) bool {
+	for _, t := range r.eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// probeEventTypes queries tr for the event types accepted by the
+// server, delivering the result to probed.
+func (t *Tracer) probeEventTypes(ctx context.Context, tr transport.EventTypesTransport, probed chan<- eventTypesResult) {
+	ctx, cancel := context.WithTimeout(ctx, eventTypesProbeTimeout)
+	defer cancel()
+	var result eventTypesResult
+	result.eventTypes, result.err = tr.SupportedEventTypes(ctx)
+	select {
+	case probed <- result:
+	case <-t.closed:
+	}
+}
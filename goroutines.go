@@ -0,0 +1,128 @@
+package elasticapm
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+
+	"github.com/elastic/apm-agent-go/model"
+)
+
+const (
+	// goroutineStackBufferSize is the size of the buffer into which
+	// all goroutine stacks are dumped for summarising their states.
+	// If the dump exceeds this, the states of the remaining goroutines
+	// are not counted, and the summary is marked as truncated.
+	goroutineStackBufferSize = 256 * 1024
+
+	// maxGoroutineStates is the maximum number of distinct goroutine
+	// states in a summary. States beyond the most frequent ones are
+	// counted together as "other".
+	maxGoroutineStates = 10
+
+	// maxGoroutineStateLength is the maximum length of a goroutine
+	// state name in a summary.
+	maxGoroutineStateLength = 64
+)
+
+// SetCaptureGoroutines sets whether or not errors should be reported
+// with the number of goroutines at the time the error is sent, and a
+// summary of their states (e.g. "chan receive": 5), in the error's
+// custom context under "goroutines". This aids in diagnosing goroutine
+// leaks and deadlocks which manifest as errors such as timeouts.
+//
+// Capturing the summary requires dumping the stacks of all goroutines,
+// which stops the world, so this is disabled by default.
+func (t *Tracer) SetCaptureGoroutines(capture bool) {
+	t.captureGoroutinesMu.Lock()
+	t.captureGoroutines = capture
+	t.captureGoroutinesMu.Unlock()
+}
+
+// CaptureGoroutines reports whether or not errors are reported with
+// a summary of goroutines. See SetCaptureGoroutines.
+func (t *Tracer) CaptureGoroutines() bool {
+	t.captureGoroutinesMu.RLock()
+	capture := t.captureGoroutines
+	t.captureGoroutinesMu.RUnlock()
+	return capture
+}
+
+// setGoroutines records a summary of the current goroutines
+// in the error's custom context.
+func (e *Error) setGoroutines() {
+	if e.Context == nil {
+		e.Context = &model.Context{}
+	}
+	if e.Context.Custom == nil {
+		e.Context.Custom = make(map[string]interface{})
+	}
+	e.Context.Custom["goroutines"] = goroutineSummary()
+}
+
+// goroutineSummary returns the number of goroutines, and the number
+// of goroutines in each state, bounded by maxGoroutineStates.
+func goroutineSummary() map[string]interface{} {
+	count := runtime.NumGoroutine()
+	buf := make([]byte, goroutineStackBufferSize)
+	n := runtime.Stack(buf, true)
+	counts := goroutineStates(buf[:n])
+
+	states := make([]string, 0, len(counts))
+	for state := range counts {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if counts[states[i]] != counts[states[j]] {
+			return counts[states[i]] > counts[states[j]]
+		}
+		return states[i] < states[j]
+	})
+	summaryStates := make(map[string]int)
+	for i, state := range states {
+		if i < maxGoroutineStates {
+			summaryStates[state] = counts[state]
+		} else {
+			summaryStates["other"] += counts[state]
+		}
+	}
+	summary := map[string]interface{}{
+		"count":  count,
+		"states": summaryStates,
+	}
+	if n == len(buf) {
+		summary["truncated"] = true
+	}
+	return summary
+}
+
+// goroutineStates counts the goroutines in each state, given the
+// output of runtime.Stack. Each goroutine's stack begins with a
+// header line such as "goroutine 18 [chan receive, 5 minutes]:",
+// in which the state is the bracketed text up to the first comma.
+func goroutineStates(stacks []byte) map[string]int {
+	counts := make(map[string]int)
+	prefix := []byte("goroutine ")
+	for len(stacks) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(stacks, '\n'); i >= 0 {
+			line, stacks = stacks[:i], stacks[i+1:]
+		} else {
+			line, stacks = stacks, nil
+		}
+		if !bytes.HasPrefix(line, prefix) {
+			continue
+		}
+		start := bytes.IndexByte(line, '[')
+		end := bytes.LastIndexByte(line, ']')
+		if start < 0 || end < start {
+			continue
+		}
+		state := line[start+1 : end]
+		if i := bytes.IndexByte(state, ','); i >= 0 {
+			state = state[:i]
+		}
+		counts[truncateBytes(string(state), maxGoroutineStateLength)]++
+	}
+	return counts
+}
@@ -0,0 +1,61 @@
+package elasticapm_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestTracerCaptureGoroutines(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	e := tracer.NewError()
+	e.SetException(errors.New("boom"))
+	e.Send()
+	assert.False(t, tracer.CaptureGoroutines())
+
+	tracer.SetCaptureGoroutines(true)
+	blocked := make(chan struct{})
+	defer close(blocked)
+	var started sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		started.Add(1)
+		go func() {
+			started.Done()
+			<-blocked
+		}()
+	}
+	// Give the goroutines a chance to block.
+	started.Wait()
+	time.Sleep(10 * time.Millisecond)
+	e = tracer.NewError()
+	e.SetException(errors.New("context deadline exceeded"))
+	e.Send()
+	tracer.Flush(nil)
+
+	var errorsSent []interface{}
+	for _, p := range r.Payloads() {
+		errorsSent = append(errorsSent, p["errors"].([]interface{})...)
+	}
+	require.Len(t, errorsSent, 2)
+	assert.NotContains(t, errorsSent[0], "context")
+
+	context := errorsSent[1].(map[string]interface{})["context"].(map[string]interface{})
+	goroutines := context["custom"].(map[string]interface{})["goroutines"].(map[string]interface{})
+	assert.True(t, goroutines["count"].(float64) >= 4)
+	states := goroutines["states"].(map[string]interface{})
+	assert.Contains(t, states, "running")
+	assert.True(t, states["chan receive"].(float64) >= 3)
+	assert.True(t, len(states) <= 11)
+}
@@ -0,0 +1,78 @@
+package elasticapm
+
+import (
+	cryptorand "crypto/rand"
+
+	"github.com/elastic/apm-agent-go/internal/uuid"
+)
+
+// IDGenerator is an interface for generating the IDs of traces,
+// spans, transactions, and errors.
+//
+// The default IDGenerator uses crypto/rand. A custom IDGenerator may
+// be installed with Tracer.SetIDGenerator, e.g. to produce predictable
+// IDs in tests. IDGenerator methods may be called concurrently.
+type IDGenerator interface {
+	// NewTraceID returns a new, non-zero trace ID.
+	NewTraceID() TraceID
+
+	// NewSpanID returns a new, non-zero span ID, for identifying
+	// transactions and spans within a trace.
+	NewSpanID() SpanID
+
+	// NewUUID returns the bytes of a new UUID, for identifying
+	// transactions and errors.
+	NewUUID() [16]byte
+}
+
+type randomIDGenerator struct{}
+
+// NewTraceID returns a random trace ID.
+func (randomIDGenerator) NewTraceID() TraceID {
+	var id TraceID
+	// We ignore the error from the entropy source, which will
+	// only occur if it fails. In that case, there's nothing we
+	// can do. We don't want to panic inside the user's application.
+	cryptorand.Read(id[:])
+	return id
+}
+
+// NewSpanID returns a random span ID.
+func (randomIDGenerator) NewSpanID() SpanID {
+	var id SpanID
+	cryptorand.Read(id[:]) // ignore error, as in NewTraceID
+	return id
+}
+
+// NewUUID returns a random (version 4) UUID.
+func (randomIDGenerator) NewUUID() [16]byte {
+	id, _ := uuid.NewV4() // ignore error, as in NewTraceID
+	return id
+}
+
+// SetIDGenerator sets the IDGenerator used for generating the IDs of
+// traces, spans, transactions, and errors. If g is nil, the default
+// crypto/rand based generator is used.
+func (t *Tracer) SetIDGenerator(g IDGenerator) {
+	if g == nil {
+		g = randomIDGenerator{}
+	}
+	t.idGeneratorMu.Lock()
+	t.idGenerator = g
+	t.idGeneratorMu.Unlock()
+}
+
+// generator returns the tracer's IDGenerator. The returned generator
+// should be called without holding any locks, as it may be slow.
+func (t *Tracer) generator() IDGenerator {
+	t.idGeneratorMu.RLock()
+	g := t.idGenerator
+	t.idGeneratorMu.RUnlock()
+	return g
+}
+
+// newUUID returns a new hex-encoded UUID using the tracer's
+// IDGenerator, suitable for use as a transaction or error ID.
+func (t *Tracer) newUUID() string {
+	return uuid.UUID(t.generator().NewUUID()).String()
+}
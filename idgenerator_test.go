@@ -0,0 +1,89 @@
+package elasticapm_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestTracerIDGenerator(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+	tracer.SetIDGenerator(&sequentialIDGenerator{})
+
+	tx := tracer.StartTransaction("name", "type")
+	assert.Equal(t,
+		"00-00000000000000000000000000000001-0000000000000002-01",
+		elasticapm.TraceParentHeader(tx),
+	)
+	span := tx.StartSpan("name", "type", nil)
+	assert.Equal(t, elasticapm.TraceContext{
+		Trace:   elasticapm.TraceID{15: 1},
+		Span:    elasticapm.SpanID{7: 3},
+		Options: elasticapm.TraceOptions(0).WithSampled(true),
+		State:   elasticapm.ParseTraceState("es=s:1"),
+	}, span.TraceContext())
+	span.Done(-1)
+	e := tracer.Recovered(errors.New("boom"), tx)
+	e.Send()
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 2)
+	errorPayload := payloads[0]["errors"].([]interface{})[0].(map[string]interface{})
+	transaction := payloads[1]["transactions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "00000000-0000-0000-0000-000000000004", errorPayload["id"])
+	assert.Equal(t, "00000000-0000-0000-0000-000000000005", transaction["id"])
+	assert.Equal(t, "00000000000000000000000000000001", transaction["trace_id"])
+	assert.Equal(t, "0000000000000003", transaction["spans"].([]interface{})[0].(map[string]interface{})["span_id"])
+
+	// Setting a nil IDGenerator restores the default, random, generator.
+	tracer.SetIDGenerator(nil)
+	tx = tracer.StartTransaction("name", "type")
+	defer tx.Done(-1)
+	assert.NotEqual(t, elasticapm.TraceID{15: 6}, tx.TraceContext().Trace)
+	assert.NoError(t, tx.TraceContext().Trace.Validate())
+}
+
+// sequentialIDGenerator is an elasticapm.IDGenerator which
+// generates IDs from a sequence shared by all ID types.
+type sequentialIDGenerator struct {
+	mu sync.Mutex
+	n  uint64
+}
+
+func (g *sequentialIDGenerator) next() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.n++
+	return g.n
+}
+
+func (g *sequentialIDGenerator) NewTraceID() elasticapm.TraceID {
+	var id elasticapm.TraceID
+	binary.BigEndian.PutUint64(id[8:], g.next())
+	return id
+}
+
+func (g *sequentialIDGenerator) NewSpanID() elasticapm.SpanID {
+	var id elasticapm.SpanID
+	binary.BigEndian.PutUint64(id[:], g.next())
+	return id
+}
+
+func (g *sequentialIDGenerator) NewUUID() [16]byte {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[8:], g.next())
+	return id
+}
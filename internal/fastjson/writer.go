@@ -0,0 +1,159 @@
+// Package fastjson provides a Writer for encoding JSON with few
+// allocations, producing the same output as encoding/json.
+package fastjson
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const hex = "0123456789abcdef"
+
+// Writer is a buffer for encoding JSON values. The zero value
+// is an empty buffer ready to use.
+type Writer struct {
+	buf []byte
+}
+
+// Bytes returns the contents of the buffer. The returned slice is
+// valid only until the next modification of the buffer.
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+// Size returns the number of bytes in the buffer.
+func (w *Writer) Size() int {
+	return len(w.buf)
+}
+
+// Reset empties the buffer, retaining its storage for reuse.
+func (w *Writer) Reset() {
+	w.buf = w.buf[:0]
+}
+
+// Rewind truncates the buffer to the given size, e.g. to discard
+// a partially written value.
+func (w *Writer) Rewind(size int) {
+	w.buf = w.buf[:size]
+}
+
+// RawByte appends c to the buffer.
+func (w *Writer) RawByte(c byte) {
+	w.buf = append(w.buf, c)
+}
+
+// RawString appends s to the buffer, without escaping.
+func (w *Writer) RawString(s string) {
+	w.buf = append(w.buf, s...)
+}
+
+// Bool appends the JSON encoding of v to the buffer.
+func (w *Writer) Bool(v bool) {
+	w.buf = strconv.AppendBool(w.buf, v)
+}
+
+// Int64 appends the JSON encoding of v to the buffer.
+func (w *Writer) Int64(v int64) {
+	w.buf = strconv.AppendInt(w.buf, v, 10)
+}
+
+// Float64 appends the JSON encoding of v to the buffer, formatted
+// as by encoding/json. Float64 returns an error if v is NaN or
+// infinite, as these cannot be represented in JSON.
+func (w *Writer) Float64(v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return &json.UnsupportedValueError{
+			Str: strconv.FormatFloat(v, 'g', -1, 64),
+		}
+	}
+	// Use the same format as encoding/json: exponential
+	// for very small and very large magnitudes, with the
+	// exponent's leading zero removed.
+	abs := math.Abs(v)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	w.buf = strconv.AppendFloat(w.buf, v, format, -1, 64)
+	if format == 'e' {
+		n := len(w.buf)
+		if n >= 4 && w.buf[n-4] == 'e' && w.buf[n-3] == '-' && w.buf[n-2] == '0' {
+			w.buf[n-2] = w.buf[n-1]
+			w.buf = w.buf[:n-1]
+		}
+	}
+	return nil
+}
+
+// Time appends t, formatted with the given layout, as a
+// JSON string to the buffer.
+func (w *Writer) Time(t time.Time, layout string) {
+	w.buf = append(w.buf, '"')
+	w.buf = t.AppendFormat(w.buf, layout)
+	w.buf = append(w.buf, '"')
+}
+
+// String appends s as a JSON string to the buffer, escaped as by
+// encoding/json: HTML characters and U+2028/U+2029 are escaped,
+// and invalid UTF-8 is replaced with U+FFFD.
+func (w *Writer) String(s string) {
+	w.buf = append(w.buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			w.buf = append(w.buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				w.buf = append(w.buf, '\\', b)
+			case '\n':
+				w.buf = append(w.buf, '\\', 'n')
+			case '\r':
+				w.buf = append(w.buf, '\\', 'r')
+			case '\t':
+				w.buf = append(w.buf, '\\', 't')
+			default:
+				w.buf = append(w.buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			w.buf = append(w.buf, s[start:i]...)
+			w.buf = append(w.buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if c == '\u2028' || c == '\u2029' {
+			w.buf = append(w.buf, s[start:i]...)
+			w.buf = append(w.buf, '\\', 'u', '2', '0', '2', hex[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	w.buf = append(w.buf, s[start:]...)
+	w.buf = append(w.buf, '"')
+}
+
+// Marshal appends the encoding/json encoding of v to the buffer.
+// This is intended for values that are uncommon, or complex enough
+// that encoding them by hand is not worthwhile.
+func (w *Writer) Marshal(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.buf = append(w.buf, data...)
+	return nil
+}
@@ -0,0 +1,63 @@
+package fastjson_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-agent-go/internal/fastjson"
+)
+
+func TestWriterFloat64(t *testing.T) {
+	for _, v := range []float64{
+		0, 1, -1, 0.5, 123.456, 0.009, 1e-6, 1e-7, 1.5e-9, 1e20, 1e21, 1.5e300, -2.5e-11,
+		math.MaxFloat64, math.SmallestNonzeroFloat64,
+	} {
+		var w fastjson.Writer
+		assert.NoError(t, w.Float64(v))
+		expect, err := json.Marshal(v)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expect), string(w.Bytes()))
+	}
+}
+
+func TestWriterFloat64Invalid(t *testing.T) {
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		var w fastjson.Writer
+		assert.Error(t, w.Float64(v))
+		assert.Equal(t, 0, w.Size())
+	}
+}
+
+func TestWriterString(t *testing.T) {
+	for _, s := range []string{
+		"", "abc", `"\/`, "<a href='x'>&amp;</a>", "\n\r\t\x00\x1f\x7f",
+		"ünïcödé", "\u2028\u2029", "\xff", "a\xe2\x82b",
+	} {
+		var w fastjson.Writer
+		w.String(s)
+		expect, err := json.Marshal(s)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expect), string(w.Bytes()))
+	}
+}
+
+func TestWriterTime(t *testing.T) {
+	var w fastjson.Writer
+	w.Time(time.Unix(123, 456000000).UTC(), time.RFC3339Nano)
+	assert.Equal(t, `"1970-01-01T00:02:03.456Z"`, string(w.Bytes()))
+}
+
+func TestWriterRewind(t *testing.T) {
+	var w fastjson.Writer
+	w.RawString("[1,")
+	size := w.Size()
+	w.Bool(true)
+	w.Rewind(size)
+	w.Int64(-2)
+	w.RawByte(']')
+	assert.Equal(t, `[1,-2]`, string(w.Bytes()))
+}
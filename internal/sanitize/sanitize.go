@@ -0,0 +1,40 @@
+// Package sanitize provides the redaction of captured values whose
+// names suggest they hold secrets, such as form fields, query
+// parameters, and environment variables.
+package sanitize
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the values of names matched by SecretName.
+const Redacted = "[REDACTED]"
+
+var secretNames = regexp.MustCompile(
+	`(?i)^(.*password.*|.*passwd.*|.*pwd.*|.*secret.*|.*key|.*token.*|.*session.*|.*credit.*|.*card.*)$`,
+)
+
+// SecretName reports whether or not name suggests that its value
+// holds a secret, e.g. "password" or "API_KEY", and so should be
+// redacted. Names are matched case-insensitively.
+func SecretName(name string) bool {
+	return secretNames.MatchString(name)
+}
+
+// Value returns Redacted if name is matched by SecretName, and
+// value otherwise. If value is a URL with user info, which may hold
+// a password, it is returned with the user info removed.
+func Value(name, value string) string {
+	if SecretName(name) {
+		return Redacted
+	}
+	if strings.Contains(value, "@") {
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			u.User = nil
+			return u.String()
+		}
+	}
+	return value
+}
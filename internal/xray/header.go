@@ -0,0 +1,97 @@
+// Package xray provides encoding and decoding of AWS X-Ray trace
+// headers, shared by contrib/apmxray and contrib/apmlambda.
+package xray
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+// FormatTraceHeader formats the given trace context as an AWS X-Ray
+// trace header, e.g.
+//
+//	Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1
+//
+// The first 4 bytes of the trace ID form the X-Ray trace ID's epoch,
+// and the remaining 12 bytes its unique ID. The epoch is meaningful
+// to X-Ray only if the trace ID was generated in the X-Ray format.
+func FormatTraceHeader(c elasticapm.TraceContext) string {
+	trace := hex.EncodeToString(c.Trace[:])
+	sampled := "0"
+	if c.Options.Sampled() {
+		sampled = "1"
+	}
+	return "Root=1-" + trace[:8] + "-" + trace[8:] +
+		";Parent=" + hex.EncodeToString(c.Span[:]) +
+		";Sampled=" + sampled
+}
+
+// ParseTraceHeader parses the AWS X-Ray trace header, e.g.
+//
+//	Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1
+//
+// returning an elasticapm.TraceContext. The X-Ray trace ID's
+// version is discarded, and its epoch and unique ID are combined
+// to form the 16-byte trace ID. If the sampling decision is
+// absent or deferred ("?"), the trace is considered sampled.
+func ParseTraceHeader(h string) (elasticapm.TraceContext, error) {
+	var out elasticapm.TraceContext
+	var haveRoot, haveParent bool
+	sampled := true
+	for _, field := range strings.Split(h, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		sep := strings.IndexRune(field, '=')
+		if sep < 0 {
+			return out, fmt.Errorf("invalid X-Ray trace header field %q", field)
+		}
+		key, value := field[:sep], field[sep+1:]
+		switch key {
+		case "Root":
+			parts := strings.Split(value, "-")
+			if len(parts) != 3 || parts[0] != "1" || len(parts[1]) != 8 || len(parts[2]) != 24 {
+				return out, fmt.Errorf("invalid X-Ray trace ID %q", value)
+			}
+			if _, err := hex.Decode(out.Trace[:], []byte(parts[1]+parts[2])); err != nil {
+				return out, fmt.Errorf("invalid X-Ray trace ID %q: %v", value, err)
+			}
+			if err := out.Trace.Validate(); err != nil {
+				return out, err
+			}
+			haveRoot = true
+		case "Parent":
+			if len(value) != 16 {
+				return out, fmt.Errorf("invalid X-Ray parent ID %q", value)
+			}
+			if _, err := hex.Decode(out.Span[:], []byte(value)); err != nil {
+				return out, fmt.Errorf("invalid X-Ray parent ID %q: %v", value, err)
+			}
+			if err := out.Span.Validate(); err != nil {
+				return out, err
+			}
+			haveParent = true
+		case "Sampled":
+			switch value {
+			case "0":
+				sampled = false
+			case "1", "?":
+			default:
+				return out, fmt.Errorf("invalid X-Ray sampling decision %q", value)
+			}
+		}
+	}
+	if !haveRoot {
+		return out, errors.New("X-Ray trace header missing Root")
+	}
+	if !haveParent {
+		return out, errors.New("X-Ray trace header missing Parent")
+	}
+	out.Options = out.Options.WithSampled(sampled)
+	return out, nil
+}
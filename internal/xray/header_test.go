@@ -0,0 +1,61 @@
+package xray_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/internal/xray"
+)
+
+func TestFormatTraceHeader(t *testing.T) {
+	c := elasticapm.TraceContext{
+		Trace:   elasticapm.TraceID{0x57, 0x59, 0xe9, 0x88, 0xbd, 0x86, 0x2e, 0x3f, 0xe1, 0xbe, 0x46, 0xa9, 0x94, 0x27, 0x27, 0x93},
+		Span:    elasticapm.SpanID{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8},
+		Options: elasticapm.TraceOptions(0).WithSampled(true),
+	}
+	h := xray.FormatTraceHeader(c)
+	assert.Equal(t, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1", h)
+
+	parsed, err := xray.ParseTraceHeader(h)
+	require.NoError(t, err)
+	assert.Equal(t, c, parsed)
+
+	c.Options = c.Options.WithSampled(false)
+	assert.Equal(t,
+		"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0",
+		xray.FormatTraceHeader(c),
+	)
+}
+
+func TestParseTraceHeader(t *testing.T) {
+	c, err := xray.ParseTraceHeader("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=?")
+	require.NoError(t, err)
+	assert.True(t, c.Options.Sampled()) // deferred decisions are sampled
+
+	// Unknown fields, such as those added by AWS services,
+	// and surrounding whitespace are ignored; an absent
+	// sampling decision is treated as sampled.
+	c, err = xray.ParseTraceHeader("Self=1-5759e988-bd862e3fe1be46a994272794; Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;")
+	require.NoError(t, err)
+	assert.Equal(t, elasticapm.TraceContext{
+		Trace:   elasticapm.TraceID{0x57, 0x59, 0xe9, 0x88, 0xbd, 0x86, 0x2e, 0x3f, 0xe1, 0xbe, 0x46, 0xa9, 0x94, 0x27, 0x27, 0x93},
+		Span:    elasticapm.SpanID{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8},
+		Options: elasticapm.TraceOptions(0).WithSampled(true),
+	}, c)
+
+	for h, expect := range map[string]string{
+		"Root=1-5759e988-bd862e3fe1be46a994272793":                                   "X-Ray trace header missing Parent",
+		"Parent=53995c3f42cd8ad8":                                                    "X-Ray trace header missing Root",
+		"Root=2-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8":           `invalid X-Ray trace ID "2-5759e988-bd862e3fe1be46a994272793"`,
+		"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=x": `invalid X-Ray sampling decision "x"`,
+		"Root=1-5759e988-bd862e3fe1be46a99427279z;Parent=53995c3f42cd8ad8":           `invalid X-Ray trace ID "1-5759e988-bd862e3fe1be46a99427279z": encoding/hex: invalid byte: U+007A 'z'`,
+		"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f":                   `invalid X-Ray parent ID "53995c3f"`,
+		"Root": `invalid X-Ray trace header field "Root"`,
+	} {
+		_, err := xray.ParseTraceHeader(h)
+		assert.EqualError(t, err, expect, h)
+	}
+}
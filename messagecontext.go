@@ -0,0 +1,50 @@
+package elasticapm
+
+import (
+	"time"
+
+	"github.com/elastic/apm-agent-go/model"
+)
+
+// MessageContext describes a message received from a message
+// broker, for recording in a consumer transaction's context.
+type MessageContext struct {
+	// QueueName holds the name of the queue or topic from
+	// which the message was received.
+	QueueName string
+
+	// RoutingKey holds the message's routing key, for AMQP.
+	RoutingKey string
+
+	// Timestamp holds the time at which the message was
+	// enqueued, as provided by the message broker. If the
+	// broker does not provide a timestamp, this should be
+	// left as the zero value.
+	Timestamp time.Time
+}
+
+// SetMessageContext sets the transaction's message context,
+// describing the message being consumed by the transaction.
+//
+// If mc.Timestamp is non-zero, the message's age is recorded
+// as the time elapsed between the message being enqueued and
+// the transaction starting. If the timestamp is after the
+// transaction's start, e.g. due to clock skew between the
+// broker and the consumer, the age is omitted.
+func (tx *Transaction) SetMessageContext(mc MessageContext) {
+	message := &model.MessageSpanContext{RoutingKey: mc.RoutingKey}
+	if mc.QueueName != "" {
+		message.Queue = &model.MessageQueueSpanContext{Name: mc.QueueName}
+	}
+	if !mc.Timestamp.IsZero() {
+		if age := tx.Timestamp.Sub(mc.Timestamp); age >= 0 {
+			message.Age = &model.MessageAgeSpanContext{
+				Milliseconds: int64(age / time.Millisecond),
+			}
+		}
+	}
+	if tx.Context == nil {
+		tx.Context = &model.Context{}
+	}
+	tx.Context.Message = message
+}
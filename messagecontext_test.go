@@ -0,0 +1,61 @@
+package elasticapm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestTransactionSetMessageContext(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	start := time.Now()
+	tx := tracer.StartTransactionOptions("name", "messaging", elasticapm.TransactionOptions{Start: start})
+	tx.SetMessageContext(elasticapm.MessageContext{
+		QueueName:  "orders",
+		RoutingKey: "orders.new",
+		Timestamp:  start.Add(-1500 * time.Millisecond),
+	})
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	transaction := payloads[0]["transactions"].([]interface{})[0].(map[string]interface{})
+	context := transaction["context"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"queue":       map[string]interface{}{"name": "orders"},
+		"age":         map[string]interface{}{"ms": float64(1500)},
+		"routing_key": "orders.new",
+	}, context["message"])
+}
+
+func TestTransactionSetMessageContextNoAge(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	start := time.Now()
+	tx := tracer.StartTransactionOptions("name", "messaging", elasticapm.TransactionOptions{Start: start})
+	defer tx.Done(-1)
+
+	// Missing broker timestamp.
+	tx.SetMessageContext(elasticapm.MessageContext{QueueName: "orders"})
+	assert.Nil(t, tx.Context.Message.Age)
+
+	// Broker timestamp after the transaction started.
+	tx.SetMessageContext(elasticapm.MessageContext{
+		QueueName: "orders",
+		Timestamp: start.Add(time.Second),
+	})
+	assert.Nil(t, tx.Context.Message.Age)
+}
@@ -0,0 +1,157 @@
+package elasticapm
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/apm-agent-go/model"
+)
+
+// Metrics holds a set of metrics.
+type Metrics struct {
+	mu      sync.Mutex
+	metrics []*model.Metrics
+}
+
+// MetricLabel is a name/value pair for labeling metrics.
+type MetricLabel struct {
+	// Name is the label name.
+	Name string
+
+	// Value is the label value.
+	Value string
+}
+
+// Add adds a metric with the given name, labels, and value. Metrics
+// with the same set of labels are grouped together.
+func (m *Metrics) Add(name string, labels []MetricLabel, value float64) {
+	m.add(name, labels, model.Metric{Value: value})
+}
+
+// AddCounter adds a counter metric with the given name, labels, and
+// value, which must be the cumulative total since the process started,
+// e.g. the number of garbage collections. Counters are reported as
+// gauges to the APM server, but exporters that distinguish metric
+// types, such as OTLP, report them as monotonic sums.
+func (m *Metrics) AddCounter(name string, labels []MetricLabel, value float64) {
+	m.add(name, labels, model.Metric{Value: value, Type: model.MetricTypeCounter})
+}
+
+func (m *Metrics) add(name string, labels []MetricLabel, metric model.Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics := m.metricsWithLabels(labels)
+	metrics.Samples[name] = metric
+}
+
+func (m *Metrics) metricsWithLabels(labels []MetricLabel) *model.Metrics {
+	for _, metrics := range m.metrics {
+		if labelsEqual(metrics.Labels, labels) {
+			return metrics
+		}
+	}
+	metrics := &model.Metrics{Samples: make(map[string]model.Metric)}
+	if len(labels) > 0 {
+		metrics.Labels = make(map[string]string, len(labels))
+		for _, label := range labels {
+			metrics.Labels[label.Name] = label.Value
+		}
+	}
+	m.metrics = append(m.metrics, metrics)
+	return metrics
+}
+
+func labelsEqual(m map[string]string, labels []MetricLabel) bool {
+	if len(m) != len(labels) {
+		return false
+	}
+	for _, label := range labels {
+		if value, ok := m[label.Name]; !ok || value != label.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// MetricsGatherer provides an interface for gathering metrics.
+type MetricsGatherer interface {
+	// GatherMetrics gathers metrics and adds them to m.
+	//
+	// If ctx.Done() is signaled, gathering should be aborted and
+	// ctx.Err() returned. If GatherMetrics returns an error, it
+	// will be logged, but otherwise there is no effect; the
+	// implementation must take care not to leave m in an invalid
+	// state due to errors.
+	GatherMetrics(ctx context.Context, m *Metrics) error
+}
+
+// GatherMetricsFunc is a function type implementing MetricsGatherer.
+type GatherMetricsFunc func(context.Context, *Metrics) error
+
+// GatherMetrics calls f(ctx, m).
+func (f GatherMetricsFunc) GatherMetrics(ctx context.Context, m *Metrics) error {
+	return f(ctx, m)
+}
+
+// RegisterMetricsGatherer registers g for periodic (or forced) metrics
+// gathering by t.
+//
+// RegisterMetricsGatherer returns a function which will deregister g.
+// It may safely be called multiple times.
+func (t *Tracer) RegisterMetricsGatherer(g MetricsGatherer) func() {
+	// Wrap g in a pointer-to-struct, so we can safely compare.
+	wrapped := &struct{ MetricsGatherer }{MetricsGatherer: g}
+	t.metricsGatherersMu.Lock()
+	t.metricsGatherers = append(t.metricsGatherers, wrapped)
+	t.metricsGatherersMu.Unlock()
+	return func() {
+		t.metricsGatherersMu.Lock()
+		defer t.metricsGatherersMu.Unlock()
+		for i, g := range t.metricsGatherers {
+			if g != wrapped {
+				continue
+			}
+			t.metricsGatherers = append(t.metricsGatherers[:i], t.metricsGatherers[i+1:]...)
+			break
+		}
+	}
+}
+
+// gatherMetrics gathers metrics from each of the registered
+// metrics gatherers, and sends the result to the gathered
+// channel. Errors returned by metrics gatherers are logged.
+func (t *Tracer) gatherMetrics(ctx context.Context, logger Logger, gathered chan<- []*model.Metrics) {
+	timestamp := time.Now()
+	var m Metrics
+
+	t.metricsGatherersMu.Lock()
+	gatherers := make([]MetricsGatherer, len(t.metricsGatherers))
+	for i, g := range t.metricsGatherers {
+		gatherers[i] = g
+	}
+	t.metricsGatherersMu.Unlock()
+
+	for _, g := range gatherers {
+		if err := g.GatherMetrics(ctx, &m); err != nil {
+			if logger != nil && err != context.Canceled {
+				logger.Debugf("gathering metrics failed: %s", err)
+			}
+		}
+	}
+	for _, metrics := range m.metrics {
+		metrics.Timestamp = timestamp
+	}
+
+	// Sort the metrics so that those without labels come
+	// first, for consistency.
+	sort.SliceStable(m.metrics, func(i, j int) bool {
+		return len(m.metrics[i].Labels) < len(m.metrics[j].Labels)
+	})
+
+	select {
+	case <-ctx.Done():
+	case gathered <- m.metrics:
+	}
+}
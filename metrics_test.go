@@ -0,0 +1,184 @@
+package elasticapm_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestTracerMetrics(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	metrics := make(chan transporttest.SendMetricsRequest)
+	tracer.Transport = &transporttest.ChannelTransport{Metrics: metrics}
+
+	deregister := tracer.RegisterMetricsGatherer(elasticapm.GatherMetricsFunc(
+		func(ctx context.Context, m *elasticapm.Metrics) error {
+			m.Add("http.requests", []elasticapm.MetricLabel{{Name: "code", Value: "200"}}, 3)
+			m.Add("http.requests", []elasticapm.MetricLabel{{Name: "code", Value: "404"}}, 1)
+			m.Add("custom", nil, 123)
+			m.AddCounter("custom.total", nil, 456)
+			return nil
+		},
+	))
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+
+	req := receiveMetrics(t, metrics)
+	req.Result <- nil
+	payload := req.Payload
+	require.Len(t, payload.Metrics, 3)
+	assert.Nil(t, payload.Metrics[0].Labels)
+	assert.Equal(t, model.Metric{Value: 123}, payload.Metrics[0].Samples["custom"])
+	assert.Equal(t, model.Metric{Value: 456, Type: model.MetricTypeCounter}, payload.Metrics[0].Samples["custom.total"])
+	assert.Equal(t, model.MetricTypeCounter, payload.Metrics[0].Samples["golang.heap.gc.total_count"].Type)
+	assert.Contains(t, payload.Metrics[0].Samples, "golang.goroutines")
+	assert.Contains(t, payload.Metrics[0].Samples, "golang.heap.allocations.allocated")
+	if runtime.GOOS == "linux" {
+		for _, name := range []string{
+			"system.process.fd.open",
+			"system.process.num_threads",
+			"system.process.memory.rss.bytes",
+			"system.process.memory.size",
+		} {
+			assert.Contains(t, payload.Metrics[0].Samples, name)
+			assert.NotZero(t, payload.Metrics[0].Samples[name].Value, name)
+		}
+	}
+	assert.Equal(t, &model.Metrics{
+		Timestamp: payload.Metrics[0].Timestamp,
+		Labels:    map[string]string{"code": "200"},
+		Samples:   map[string]model.Metric{"http.requests": {Value: 3}},
+	}, payload.Metrics[1])
+	assert.Equal(t, &model.Metrics{
+		Timestamp: payload.Metrics[0].Timestamp,
+		Labels:    map[string]string{"code": "404"},
+		Samples:   map[string]model.Metric{"http.requests": {Value: 1}},
+	}, payload.Metrics[2])
+
+	// Once deregistered, the gatherer's
+	// metrics will no longer be reported.
+	deregister()
+	deregister()
+	for {
+		req := receiveMetrics(t, metrics)
+		req.Result <- nil
+		if len(req.Payload.Metrics) == 1 {
+			assert.NotContains(t, req.Payload.Metrics[0].Samples, "custom")
+			break
+		}
+	}
+}
+
+func TestTracerMetricsEventTypes(t *testing.T) {
+	var logger recordingLogger
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.SetLogger(&logger)
+	metrics := make(chan transporttest.SendMetricsRequest)
+	transactions := make(chan transporttest.SendTransactionsRequest)
+	eventTypes := make(chan []string)
+	tracer.Transport = &eventTypesTransport{
+		ChannelTransport: &transporttest.ChannelTransport{
+			Metrics:      metrics,
+			Transactions: transactions,
+		},
+		eventTypes: eventTypes,
+	}
+	tracer.SetFlushInterval(10 * time.Millisecond)
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+
+	// The server does not accept metrics, so none are sent.
+	eventTypes <- []string{"transaction", "error"}
+	select {
+	case <-metrics:
+		t.Fatal("unexpected metrics request")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(t, []string{"server does not accept metrics, metrics will not be sent"}, logger.errors())
+
+	// After recovering from a send failure, the event
+	// types are queried again, and metrics are resumed.
+	tracer.StartTransaction("name", "type").Done(-1)
+	req := <-transactions
+	req.Result <- errors.New("connection refused")
+	req = <-transactions
+	req.Result <- nil
+	eventTypes <- []string{"transaction", "error", "metric"}
+	receiveMetrics(t, metrics).Result <- nil
+	assert.Contains(t, logger.debugs(), "server accepts metrics, resuming sending metrics")
+	assert.Len(t, logger.errors(), 1)
+}
+
+func TestTracerMetricsTransportUnsupported(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	var r transporttest.RecorderTransport
+	tracer.Transport = struct{ transport.Transport }{&r}
+
+	gathered := make(chan struct{}, 1)
+	tracer.RegisterMetricsGatherer(elasticapm.GatherMetricsFunc(
+		func(ctx context.Context, m *elasticapm.Metrics) error {
+			select {
+			case gathered <- struct{}{}:
+			default:
+			}
+			m.Add("custom", nil, 123)
+			m.AddCounter("custom.total", nil, 456)
+			return nil
+		},
+	))
+	tracer.SetMetricsInterval(10 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-gathered:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for metrics to be gathered")
+		}
+	}
+
+	// The transport does not implement MetricsTransport,
+	// so the gathered metrics are discarded.
+	tracer.StartTransaction("name", "type").Done(-1)
+	tracer.Flush(nil)
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	assert.Contains(t, payloads[0], "transactions")
+	assert.Zero(t, tracer.Stats().Errors)
+}
+
+type eventTypesTransport struct {
+	*transporttest.ChannelTransport
+	eventTypes <-chan []string
+}
+
+func (t *eventTypesTransport) SupportedEventTypes(ctx context.Context) ([]string, error) {
+	select {
+	case eventTypes := <-t.eventTypes:
+		return eventTypes, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func receiveMetrics(t *testing.T, metrics <-chan transporttest.SendMetricsRequest) transporttest.SendMetricsRequest {
+	select {
+	case req := <-metrics:
+		return req
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for metrics")
+	}
+	panic("unreachable")
+}
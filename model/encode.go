@@ -0,0 +1,511 @@
+package model
+
+import (
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/elastic/apm-agent-go/internal/fastjson"
+)
+
+// The functions in this file encode transactions and spans, which
+// account for most of the data sent to the server, without going
+// through encoding/json's reflection. The output is identical to
+// that of encoding/json; values that are uncommon, or which have
+// custom MarshalJSON methods, such as Context, are still encoded
+// with encoding/json.
+//
+// When adding fields to the types encoded here, the corresponding
+// encode method must be updated.
+
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		return &fastjson.Writer{}
+	},
+}
+
+// EncodeTransaction writes the JSON encoding of t to w, as would
+// be produced by encoding/json, but with far fewer allocations.
+func EncodeTransaction(w io.Writer, t *Transaction) error {
+	return encode(w, t.encode)
+}
+
+// EncodeTransactionsPayload writes the JSON encoding of p to w,
+// as would be produced by encoding/json, but with far fewer
+// allocations.
+func EncodeTransactionsPayload(w io.Writer, p *TransactionsPayload) error {
+	return encode(w, p.encode)
+}
+
+// EncodeSpansPayload writes the JSON encoding of p to w, as would
+// be produced by encoding/json, but with far fewer allocations.
+func EncodeSpansPayload(w io.Writer, p *SpansPayload) error {
+	return encode(w, p.encode)
+}
+
+func encode(w io.Writer, f func(*fastjson.Writer) error) error {
+	fw := writerPool.Get().(*fastjson.Writer)
+	defer func() {
+		fw.Reset()
+		writerPool.Put(fw)
+	}()
+	if err := f(fw); err != nil {
+		return err
+	}
+	_, err := w.Write(fw.Bytes())
+	return err
+}
+
+func (p *TransactionsPayload) encode(w *fastjson.Writer) error {
+	if err := encodePayloadMetadata(w, p.Service, p.Process, p.System); err != nil {
+		return err
+	}
+	w.RawString(`,"transactions":`)
+	if p.Transactions == nil {
+		w.RawString("null")
+	} else {
+		w.RawByte('[')
+		for i, t := range p.Transactions {
+			if i > 0 {
+				w.RawByte(',')
+			}
+			if err := t.encode(w); err != nil {
+				return err
+			}
+		}
+		w.RawByte(']')
+	}
+	w.RawByte('}')
+	return nil
+}
+
+func (p *SpansPayload) encode(w *fastjson.Writer) error {
+	if err := encodePayloadMetadata(w, p.Service, p.Process, p.System); err != nil {
+		return err
+	}
+	w.RawString(`,"spans":`)
+	if err := encodeSpans(w, p.Spans); err != nil {
+		return err
+	}
+	w.RawByte('}')
+	return nil
+}
+
+// encodePayloadMetadata writes the opening brace of a payload,
+// followed by its service, process and system fields.
+func encodePayloadMetadata(w *fastjson.Writer, service *Service, process *Process, system *System) error {
+	w.RawString(`{"service":`)
+	if err := w.Marshal(service); err != nil {
+		return err
+	}
+	if process != nil {
+		w.RawString(`,"process":`)
+		if err := w.Marshal(process); err != nil {
+			return err
+		}
+	}
+	if system != nil {
+		w.RawString(`,"system":`)
+		if err := w.Marshal(system); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Transaction) encode(w *fastjson.Writer) error {
+	if t == nil {
+		w.RawString("null")
+		return nil
+	}
+	w.RawString(`{"id":`)
+	w.String(t.ID)
+	if t.TraceID != "" {
+		w.RawString(`,"trace_id":`)
+		w.String(t.TraceID)
+	}
+	if t.SpanID != "" {
+		w.RawString(`,"span_id":`)
+		w.String(t.SpanID)
+	}
+	if t.ParentID != "" {
+		w.RawString(`,"parent_id":`)
+		w.String(t.ParentID)
+	}
+	w.RawString(`,"name":`)
+	w.String(t.Name)
+	w.RawString(`,"type":`)
+	w.String(t.Type)
+	if t.Result != "" {
+		w.RawString(`,"result":`)
+		w.String(t.Result)
+	}
+	if t.Context != nil {
+		w.RawString(`,"context":`)
+		if err := w.Marshal(t.Context); err != nil {
+			return err
+		}
+	}
+	if t.Sampled != nil {
+		w.RawString(`,"sampled":`)
+		w.Bool(*t.Sampled)
+	}
+	if t.SampleRate != nil {
+		w.RawString(`,"sample_rate":`)
+		if err := w.Float64(*t.SampleRate); err != nil {
+			return err
+		}
+	}
+	if t.Synthetic {
+		w.RawString(`,"synthetic":true`)
+	}
+	if t.SpanCount != nil {
+		w.RawString(`,"span_count":{`)
+		if t.SpanCount.Dropped != nil {
+			w.RawString(`"dropped":{"total":`)
+			w.Int64(int64(t.SpanCount.Dropped.Total))
+			w.RawByte('}')
+		}
+		w.RawByte('}')
+	}
+	if len(t.Spans) != 0 {
+		w.RawString(`,"spans":`)
+		if err := encodeSpans(w, t.Spans); err != nil {
+			return err
+		}
+	}
+	if t.Service != nil {
+		w.RawString(`,"service":`)
+		if err := w.Marshal(t.Service); err != nil {
+			return err
+		}
+	}
+	w.RawString(`,"timestamp":`)
+	w.Time(t.Timestamp.UTC(), dateTimeFormat)
+	w.RawString(`,"duration":`)
+	if err := w.Float64(milliseconds(t.Duration)); err != nil {
+		return err
+	}
+	w.RawByte('}')
+	return nil
+}
+
+func encodeSpans(w *fastjson.Writer, spans []*Span) error {
+	if spans == nil {
+		w.RawString("null")
+		return nil
+	}
+	w.RawByte('[')
+	for i, s := range spans {
+		if i > 0 {
+			w.RawByte(',')
+		}
+		if err := s.encode(w); err != nil {
+			return err
+		}
+	}
+	w.RawByte(']')
+	return nil
+}
+
+func (s *Span) encode(w *fastjson.Writer) error {
+	if s == nil {
+		w.RawString("null")
+		return nil
+	}
+	w.RawString(`{"name":`)
+	w.String(s.Name)
+	w.RawString(`,"type":`)
+	w.String(s.Type)
+	if s.ID != nil {
+		w.RawString(`,"id":`)
+		w.Int64(*s.ID)
+	}
+	if s.Parent != nil {
+		w.RawString(`,"parent":`)
+		w.Int64(*s.Parent)
+	}
+	if s.TraceID != "" {
+		w.RawString(`,"trace_id":`)
+		w.String(s.TraceID)
+	}
+	if s.SpanID != "" {
+		w.RawString(`,"span_id":`)
+		w.String(s.SpanID)
+	}
+	if s.ParentID != "" {
+		w.RawString(`,"parent_id":`)
+		w.String(s.ParentID)
+	}
+	if s.TransactionID != "" {
+		w.RawString(`,"transaction_id":`)
+		w.String(s.TransactionID)
+	}
+	if s.Exit {
+		w.RawString(`,"exit":true`)
+	}
+	if s.Outcome != "" {
+		w.RawString(`,"outcome":`)
+		w.String(s.Outcome)
+	}
+	if s.Context != nil {
+		w.RawString(`,"context":`)
+		s.Context.encode(w)
+	}
+	if len(s.Stacktrace) != 0 {
+		w.RawString(`,"stacktrace":[`)
+		for i := range s.Stacktrace {
+			if i > 0 {
+				w.RawByte(',')
+			}
+			if err := s.Stacktrace[i].encode(w); err != nil {
+				return err
+			}
+		}
+		w.RawByte(']')
+	}
+	if len(s.Links) != 0 {
+		w.RawString(`,"links":[`)
+		for i, link := range s.Links {
+			if i > 0 {
+				w.RawByte(',')
+			}
+			w.RawString(`{"trace_id":`)
+			w.String(link.TraceID)
+			w.RawString(`,"span_id":`)
+			w.String(link.SpanID)
+			w.RawByte('}')
+		}
+		w.RawByte(']')
+	}
+	w.RawString(`,"start":`)
+	if err := w.Float64(milliseconds(s.Start)); err != nil {
+		return err
+	}
+	w.RawString(`,"duration":`)
+	if err := w.Float64(milliseconds(s.Duration)); err != nil {
+		return err
+	}
+	w.RawByte('}')
+	return nil
+}
+
+func (c *SpanContext) encode(w *fastjson.Writer) {
+	var f fields
+	w.RawByte('{')
+	if c.Database != nil {
+		f.next(w, "db")
+		c.Database.encode(w)
+	}
+	if c.Cache != nil {
+		f.next(w, "cache")
+		c.Cache.encode(w)
+	}
+	if c.Destination != nil {
+		f.next(w, "destination")
+		c.Destination.encode(w)
+	}
+	if c.HTTP != nil {
+		f.next(w, "http")
+		c.HTTP.encode(w)
+	}
+	if c.Message != nil {
+		f.next(w, "message")
+		c.Message.encode(w)
+	}
+	if len(c.Tags) != 0 {
+		f.next(w, "tags")
+		encodeStringMap(w, c.Tags)
+	}
+	w.RawByte('}')
+}
+
+func (d *DatabaseSpanContext) encode(w *fastjson.Writer) {
+	var f fields
+	w.RawByte('{')
+	f.string(w, "instance", d.Instance)
+	f.string(w, "statement", d.Statement)
+	f.string(w, "type", d.Type)
+	f.string(w, "user", d.User)
+	w.RawByte('}')
+}
+
+func (c *CacheSpanContext) encode(w *fastjson.Writer) {
+	var f fields
+	w.RawByte('{')
+	f.string(w, "key_pattern", c.KeyPattern)
+	if c.Hit != nil {
+		f.next(w, "hit")
+		w.Bool(*c.Hit)
+	}
+	w.RawByte('}')
+}
+
+func (d *DestinationSpanContext) encode(w *fastjson.Writer) {
+	var f fields
+	w.RawByte('{')
+	f.string(w, "address", d.Address)
+	if d.Port != 0 {
+		f.next(w, "port")
+		w.Int64(int64(d.Port))
+	}
+	if d.Service != nil {
+		f.next(w, "service")
+		var sf fields
+		w.RawByte('{')
+		sf.string(w, "type", d.Service.Type)
+		sf.string(w, "name", d.Service.Name)
+		sf.string(w, "resource", d.Service.Resource)
+		w.RawByte('}')
+	}
+	w.RawByte('}')
+}
+
+func (h *HTTPSpanContext) encode(w *fastjson.Writer) {
+	var f fields
+	w.RawByte('{')
+	if h.URL != nil {
+		f.next(w, "url")
+		h.URL.encode(w)
+	}
+	if h.StatusCode != 0 {
+		f.next(w, "status_code")
+		w.Int64(int64(h.StatusCode))
+	}
+	w.RawByte('}')
+}
+
+func (u *URL) encode(w *fastjson.Writer) {
+	var f fields
+	w.RawByte('{')
+	f.string(w, "full", u.Full)
+	f.string(w, "protocol", u.Protocol)
+	f.string(w, "hostname", u.Hostname)
+	f.string(w, "port", u.Port)
+	f.string(w, "pathname", u.Path)
+	f.string(w, "search", u.Search)
+	f.string(w, "hash", u.Hash)
+	w.RawByte('}')
+}
+
+func (m *MessageSpanContext) encode(w *fastjson.Writer) {
+	var f fields
+	w.RawByte('{')
+	if m.Queue != nil {
+		f.next(w, "queue")
+		w.RawString(`{"name":`)
+		w.String(m.Queue.Name)
+		w.RawByte('}')
+	}
+	if m.Age != nil {
+		f.next(w, "age")
+		w.RawString(`{"ms":`)
+		w.Int64(m.Age.Milliseconds)
+		w.RawByte('}')
+	}
+	f.string(w, "routing_key", m.RoutingKey)
+	w.RawByte('}')
+}
+
+func (f *StacktraceFrame) encode(w *fastjson.Writer) error {
+	// abs_path is always encoded, as its struct tag
+	// misspells omitempty, so encoding/json ignores it.
+	w.RawString(`{"abs_path":`)
+	w.String(f.AbsolutePath)
+	w.RawString(`,"filename":`)
+	w.String(f.File)
+	w.RawString(`,"lineno":`)
+	w.Int64(int64(f.Line))
+	if f.Column != nil {
+		w.RawString(`,"colno":`)
+		w.Int64(int64(*f.Column))
+	}
+	if f.Module != "" {
+		w.RawString(`,"module":`)
+		w.String(f.Module)
+	}
+	if f.Function != "" {
+		w.RawString(`,"function":`)
+		w.String(f.Function)
+	}
+	if f.LibraryFrame {
+		w.RawString(`,"library_frame":true`)
+	}
+	if f.ContextLine != "" {
+		w.RawString(`,"context_line":`)
+		w.String(f.ContextLine)
+	}
+	if len(f.PreContext) != 0 {
+		w.RawString(`,"pre_context":`)
+		encodeStrings(w, f.PreContext)
+	}
+	if len(f.PostContext) != 0 {
+		w.RawString(`,"post_context":`)
+		encodeStrings(w, f.PostContext)
+	}
+	if len(f.Vars) != 0 {
+		w.RawString(`,"vars":`)
+		if err := w.Marshal(f.Vars); err != nil {
+			return err
+		}
+	}
+	w.RawByte('}')
+	return nil
+}
+
+func encodeStrings(w *fastjson.Writer, values []string) {
+	w.RawByte('[')
+	for i, v := range values {
+		if i > 0 {
+			w.RawByte(',')
+		}
+		w.String(v)
+	}
+	w.RawByte(']')
+}
+
+// encodeStringMap encodes m with its keys sorted,
+// as encoding/json does.
+func encodeStringMap(w *fastjson.Writer, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.RawByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			w.RawByte(',')
+		}
+		w.String(k)
+		w.RawByte(':')
+		w.String(m[k])
+	}
+	w.RawByte('}')
+}
+
+// fields tracks whether a comma is needed before the
+// next field of an object whose fields are all optional.
+type fields struct {
+	started bool
+}
+
+// next writes the name of the next field, preceded by a
+// comma if it is not the first field in the object.
+func (f *fields) next(w *fastjson.Writer, name string) {
+	if f.started {
+		w.RawByte(',')
+	}
+	f.started = true
+	w.RawByte('"')
+	w.RawString(name)
+	w.RawString(`":`)
+}
+
+// string writes the named string field, if value is non-empty.
+func (f *fields) string(w *fastjson.Writer, name, value string) {
+	if value != "" {
+		f.next(w, name)
+		w.String(value)
+	}
+}
@@ -0,0 +1,187 @@
+package model_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go/model"
+)
+
+func TestEncodeTransaction(t *testing.T) {
+	tx := fakeTransaction()
+	tx.TraceID = "0102030405060708090a0b0c0d0e0f10"
+	tx.SpanID = "0102030405060708"
+	tx.ParentID = "0807060504030201"
+	sampled, sampleRate := true, 0.25
+	tx.Sampled = &sampled
+	tx.SampleRate = &sampleRate
+	tx.Synthetic = true
+	tx.Service = &model.EventService{Name: "other", Version: "1.0"}
+	tx.Spans = append(tx.Spans, fakeSpan(), nil, &model.Span{Name: "empty"})
+	assertEncodeTransaction(t, tx)
+
+	// Optional fields omitted.
+	assertEncodeTransaction(t, &model.Transaction{})
+	assertEncodeTransaction(t, &model.Transaction{SpanCount: &model.SpanCount{}})
+}
+
+func TestEncodeTransactionSchema(t *testing.T) {
+	var buf bytes.Buffer
+	tx := fakeTransaction()
+	tx.Timestamp = time.Date(2018, 1, 2, 3, 4, 5, 678901234, time.FixedZone("x", 3600))
+	require.NoError(t, model.EncodeTransaction(&buf, tx))
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, "2018-01-02T02:04:05.678Z", decoded["timestamp"])
+	assert.Equal(t, 123.456, decoded["duration"])
+	span := decoded["spans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, float64(2), span["start"])
+	assert.Equal(t, float64(3), span["duration"])
+}
+
+func TestEncodeTransactionStrings(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"plain",
+		`"quoted\" \\ slashed/`,
+		"<html> & </html>",
+		"new\nline\rreturn\ttab\x00\x01\x1f",
+		"ünïcödé ☃ 𝄞",
+		"line paragraph separators",
+		"invalid \xff\xfe utf-8 \xe2\x82",
+	} {
+		tx := &model.Transaction{Name: s, Spans: []*model.Span{{
+			Name: s,
+			Context: &model.SpanContext{
+				Tags: map[string]string{s: s},
+			},
+		}}}
+		assertEncodeTransaction(t, tx)
+	}
+}
+
+func TestEncodeTransactionInvalidFloat(t *testing.T) {
+	sampleRate := math.NaN()
+	tx := &model.Transaction{SampleRate: &sampleRate}
+	var buf bytes.Buffer
+	assert.Error(t, model.EncodeTransaction(&buf, tx))
+	_, err := json.Marshal(tx)
+	assert.Error(t, err)
+}
+
+func TestEncodeTransactionsPayload(t *testing.T) {
+	for _, payload := range []model.TransactionsPayload{
+		fakeTransactionsPayload(3),
+		{Service: fakeService()},
+		{Service: fakeService(), Transactions: []*model.Transaction{}},
+	} {
+		expect, err := json.Marshal(&payload)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		require.NoError(t, model.EncodeTransactionsPayload(&buf, &payload))
+		assert.Equal(t, string(expect), buf.String())
+	}
+}
+
+func TestEncodeSpansPayload(t *testing.T) {
+	span := fakeSpan()
+	span.TransactionID = "0102030405060708"
+	payload := model.SpansPayload{
+		Service: fakeService(),
+		Process: fakeProcess(),
+		System:  fakeSystem(),
+		Spans:   []*model.Span{span, fakeTransaction().Spans[0]},
+	}
+	expect, err := json.Marshal(&payload)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, model.EncodeSpansPayload(&buf, &payload))
+	assert.Equal(t, string(expect), buf.String())
+}
+
+func assertEncodeTransaction(t *testing.T, tx *model.Transaction) {
+	expect, err := json.Marshal(tx)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, model.EncodeTransaction(&buf, tx))
+	assert.Equal(t, string(expect), buf.String())
+}
+
+// fakeSpan returns a span with all fields set.
+func fakeSpan() *model.Span {
+	id, parent := int64(1), int64(0)
+	column := 7
+	hit := false
+	return &model.Span{
+		Name:     "GET testing.invalid",
+		Start:    1500 * time.Microsecond,
+		Duration: 9 * time.Microsecond,
+		Type:     "ext.http",
+		ID:       &id,
+		Parent:   &parent,
+		TraceID:  "0102030405060708090a0b0c0d0e0f10",
+		SpanID:   "1112131415161718",
+		ParentID: "0102030405060708",
+		Exit:     true,
+		Outcome:  model.OutcomeFailure,
+		Context: &model.SpanContext{
+			Database: &model.DatabaseSpanContext{Statement: "GET foo"},
+			Cache:    &model.CacheSpanContext{KeyPattern: "user:*", Hit: &hit},
+			Destination: &model.DestinationSpanContext{
+				Address: "testing.invalid",
+				Port:    443,
+				Service: &model.DestinationServiceSpanContext{
+					Type:     "external",
+					Name:     "https://testing.invalid",
+					Resource: "testing.invalid:443",
+				},
+			},
+			HTTP: &model.HTTPSpanContext{
+				URL: &model.URL{
+					Full:     "https://testing.invalid:443/foo?bar#baz",
+					Protocol: "https",
+					Hostname: "testing.invalid",
+					Port:     "443",
+					Path:     "/foo",
+					Search:   "bar",
+					Hash:     "baz",
+				},
+				StatusCode: 503,
+			},
+			Message: &model.MessageSpanContext{
+				Queue:      &model.MessageQueueSpanContext{Name: "orders"},
+				Age:        &model.MessageAgeSpanContext{Milliseconds: 1234},
+				RoutingKey: "orders.created",
+			},
+			Tags: map[string]string{"z": "last", "a": "first"},
+		},
+		Stacktrace: []model.StacktraceFrame{{
+			File:     "main.go",
+			Line:     12,
+			Function: "main",
+		}, {
+			AbsolutePath: "/src/foo/foo.go",
+			File:         "foo.go",
+			Line:         34,
+			Column:       &column,
+			Module:       "foo",
+			Function:     "Foo",
+			LibraryFrame: true,
+			ContextLine:  "return nil",
+			PreContext:   []string{"func Foo() error {"},
+			PostContext:  []string{"}"},
+			Vars:         map[string]interface{}{"x": 1, "y": []string{"z"}},
+		}},
+		Links: []model.SpanLink{
+			{TraceID: "2122232425262728292a2b2c2d2e2f30", SpanID: "3132333435363738"},
+			{TraceID: "4142434445464748494a4b4c4d4e4f50", SpanID: "5152535455565758"},
+		},
+	}
+}
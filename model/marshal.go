@@ -3,11 +3,19 @@ package model
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"time"
 )
 
 const (
 	// YYYY-MM-DDTHH:mm:ss.sssZ
 	dateTimeFormat = "2006-01-02T15:04:05.999Z"
+
+	// truncatedIndicator is appended to truncated raw
+	// request bodies, and used as a key in truncated
+	// form request bodies.
+	truncatedIndicator = "[truncated]"
 )
 
 // MarshalJSON returns the JSON encoding of t.
@@ -22,7 +30,7 @@ func (t *Transaction) MarshalJSON() ([]byte, error) {
 	}{
 		(*TransactionInternal)(t),
 		t.Timestamp.UTC().Format(dateTimeFormat),
-		t.Duration.Seconds() * 1000,
+		milliseconds(t.Duration),
 	}
 	return json.Marshal(ti)
 }
@@ -38,12 +46,22 @@ func (s *Span) MarshalJSON() ([]byte, error) {
 		Duration float64 `json:"duration"`
 	}{
 		(*SpanInternal)(s),
-		s.Start.Seconds() * 1000,
-		s.Duration.Seconds() * 1000,
+		milliseconds(s.Start),
+		milliseconds(s.Duration),
 	}
 	return json.Marshal(si)
 }
 
+// milliseconds returns d as a fractional number of milliseconds,
+// as expected by the server, preserving sub-millisecond precision.
+//
+// The division is performed directly on the nanosecond count, as
+// d.Seconds()*1000 introduces rounding errors, e.g. encoding 9µs
+// as 0.009000000000000001.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 // MarshalJSON returns the JSON encoding of r.
 func (r *Request) MarshalJSON() ([]byte, error) {
 	var cookies map[string]interface{}
@@ -72,8 +90,23 @@ func (b *RequestBody) MarshalJSON() ([]byte, error) {
 		if b.Raw != "" {
 			return nil, errors.New("only one of Form and Raw may be set in Request.Body")
 		}
-		out := make(map[string]interface{})
+		values := make(map[string][]string, len(b.Form)+len(b.Files))
 		for k, v := range b.Form {
+			values[k] = v
+		}
+		for k, sizes := range b.Files {
+			// Files are listed after any form values with the
+			// same field name, copying rather than appending
+			// to the form values.
+			v := values[k]
+			v = v[:len(v):len(v)]
+			for _, size := range sizes {
+				v = append(v, fmt.Sprintf("[file: %d bytes]", size))
+			}
+			values[k] = v
+		}
+		out := make(map[string]interface{}, len(values))
+		for k, v := range values {
 			if len(v) == 1 {
 				// Just one item, add the item directly.
 				out[k] = v[0]
@@ -82,6 +115,15 @@ func (b *RequestBody) MarshalJSON() ([]byte, error) {
 				out[k] = v
 			}
 		}
+		if b.Truncated {
+			out[truncatedIndicator] = true
+		}
+		return json.Marshal(out)
+	} else if b.Files != nil {
+		return nil, errors.New("Files may only be set in Request.Body if Form is set")
+	}
+	if b.Truncated {
+		return json.Marshal(b.Raw + truncatedIndicator)
 	}
 	return json.Marshal(b.Raw)
 }
@@ -109,3 +151,68 @@ func (e *Error) MarshalJSON() ([]byte, error) {
 	}
 	return json.Marshal(ei)
 }
+
+// MarshalJSON returns the JSON encoding of m.
+func (m *Metrics) MarshalJSON() ([]byte, error) {
+	// Wrap the Metrics type so we can format the
+	// timestamp field according to the JSON Schema.
+	type MetricsInternal Metrics
+	var mi = struct {
+		*MetricsInternal
+		Timestamp string `json:"timestamp"`
+	}{
+		(*MetricsInternal)(m),
+		m.Timestamp.UTC().Format(dateTimeFormat),
+	}
+	return json.Marshal(mi)
+}
+
+// MarshalJSON returns the JSON encoding of u.
+func (u *User) MarshalJSON() ([]byte, error) {
+	// Wrap the User type so we can encode integral
+	// floating point IDs as integers.
+	type UserInternal User
+	var ui = struct {
+		*UserInternal
+		ID interface{} `json:"id,omitempty"`
+	}{
+		(*UserInternal)(u),
+		integralNumber(u.ID),
+	}
+	return json.Marshal(ui)
+}
+
+// MarshalJSON returns the JSON encoding of e.
+func (e *Exception) MarshalJSON() ([]byte, error) {
+	// Wrap the Exception type so we can encode integral
+	// floating point codes as integers.
+	type ExceptionInternal Exception
+	var ei = struct {
+		*ExceptionInternal
+		Code interface{} `json:"code,omitempty"`
+	}{
+		(*ExceptionInternal)(e),
+		integralNumber(e.Code),
+	}
+	return json.Marshal(ei)
+}
+
+// integralNumber returns v converted to an int64 if v is a
+// floating point number with an integral value representable
+// by int64, such as those produced by decoding JSON into an
+// interface{}; otherwise v is returned unchanged.
+func integralNumber(v interface{}) interface{} {
+	var f float64
+	switch v := v.(type) {
+	case float32:
+		f = float64(v)
+	case float64:
+		f = v
+	default:
+		return v
+	}
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return v
+	}
+	return int64(f)
+}
@@ -2,11 +2,15 @@ package model_test
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"testing"
+
+	"github.com/elastic/apm-agent-go/model"
 )
 
 func BenchmarkMarshalTransactionStdlib(b *testing.B) {
 	t := fakeTransaction()
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		if _, err := json.Marshal(t); err != nil {
 			b.Fatalf("encoding/json.Marshal failed: %v", err)
@@ -14,3 +18,33 @@ func BenchmarkMarshalTransactionStdlib(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkMarshalTransactionsPayloadStdlib(b *testing.B) {
+	p := fakeTransactionsPayload(100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(&p); err != nil {
+			b.Fatalf("encoding/json.Marshal failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkEncodeTransaction(b *testing.B) {
+	t := fakeTransaction()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := model.EncodeTransaction(ioutil.Discard, t); err != nil {
+			b.Fatalf("EncodeTransaction failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkEncodeTransactionsPayload(b *testing.B) {
+	p := fakeTransactionsPayload(100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := model.EncodeTransactionsPayload(ioutil.Discard, &p); err != nil {
+			b.Fatalf("EncodeTransactionsPayload failed: %v", err)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/apm-agent-go/model"
 )
@@ -106,6 +107,74 @@ func TestMarshalJSON(t *testing.T) {
 	assert.Equal(t, expect, in)
 }
 
+func TestSpanDurationMarshalJSON(t *testing.T) {
+	for _, test := range []struct {
+		duration time.Duration
+		expect   string
+	}{
+		{1234 * time.Microsecond, "1.234"},
+		{9 * time.Microsecond, "0.009"},
+		{1234567 * time.Nanosecond, "1.234567"},
+		{0, "0"},
+	} {
+		span := model.Span{Start: test.duration, Duration: test.duration}
+		out, err := json.Marshal(&span)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"","type":"","start":`+test.expect+`,"duration":`+test.expect+`}`, string(out))
+
+		tx := model.Transaction{Duration: test.duration}
+		out, err = json.Marshal(&tx)
+		assert.NoError(t, err)
+		var decoded struct {
+			Duration json.Number `json:"duration"`
+		}
+		assert.NoError(t, json.Unmarshal(out, &decoded))
+		assert.Equal(t, test.expect, decoded.Duration.String())
+	}
+}
+
+func TestRequestBodyMarshalJSON(t *testing.T) {
+	form := url.Values{"name": {"value"}, "other": {"a", "b"}}
+	body := model.RequestBody{
+		Form: form,
+		Files: map[string][]int64{
+			"name":   {13},
+			"upload": {1, 2},
+		},
+	}
+	out, err := json.Marshal(&body)
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, map[string]interface{}{
+		"name":   []interface{}{"value", "[file: 13 bytes]"},
+		"other":  []interface{}{"a", "b"},
+		"upload": []interface{}{"[file: 1 bytes]", "[file: 2 bytes]"},
+	}, decoded)
+	assert.Equal(t, url.Values{"name": {"value"}, "other": {"a", "b"}}, form)
+}
+
+func TestCacheSpanContextMarshalJSON(t *testing.T) {
+	hit := false
+	span := model.Span{
+		Name: "GET",
+		Type: "cache.redis",
+		Context: &model.SpanContext{
+			Cache: &model.CacheSpanContext{KeyPattern: "user:*:session", Hit: &hit},
+		},
+	}
+	out, err := json.Marshal(&span)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"GET","type":"cache.redis",`+
+		`"context":{"cache":{"key_pattern":"user:*:session","hit":false}},"start":0,"duration":0}`, string(out))
+
+	span.Context.Cache = &model.CacheSpanContext{}
+	out, err = json.Marshal(&span)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"GET","type":"cache.redis","context":{"cache":{}},"start":0,"duration":0}`, string(out))
+}
+
 func TestErrorMarshalJSON(t *testing.T) {
 	var e model.Error
 	out, err := json.Marshal(&e)
@@ -118,6 +187,89 @@ func TestErrorMarshalJSON(t *testing.T) {
 	assert.Equal(t, `{"timestamp":"0001-01-01T00:00:00Z","transaction":{"id":"xyz"}}`, string(out))
 }
 
+func TestExceptionCodeMarshalJSON(t *testing.T) {
+	var e model.Exception
+	for _, test := range []struct {
+		code   interface{}
+		expect string
+	}{
+		{nil, `{"message":"","handled":false}`},
+		{float64(404), `{"message":"","handled":false,"code":404}`},
+		{float64(1.5), `{"message":"","handled":false,"code":1.5}`},
+		{"ENOENT", `{"message":"","handled":false,"code":"ENOENT"}`},
+	} {
+		e.Code = test.code
+		out, err := json.Marshal(&e)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expect, string(out))
+	}
+
+	e.SetCode(123)
+	assert.Equal(t, 123, e.Code)
+	e.SetCodeString("abc")
+	assert.Equal(t, "abc", e.Code)
+}
+
+func TestUserIDMarshalJSON(t *testing.T) {
+	var u model.User
+	for _, test := range []struct {
+		id     interface{}
+		expect string
+	}{
+		{nil, `{}`},
+		{float64(123), `{"id":123}`},
+		{"abc", `{"id":"abc"}`},
+	} {
+		u.ID = test.id
+		out, err := json.Marshal(&u)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expect, string(out))
+	}
+
+	u.SetUserID(123)
+	assert.Equal(t, 123, u.ID)
+	u.SetUserIDString("abc")
+	assert.Equal(t, "abc", u.ID)
+}
+
+func TestContextSetTag(t *testing.T) {
+	var c model.Context
+	c.SetTag("a.b*c\"d", "1")
+	c.SetTagNumber("number", 1.5)
+	c.SetTagNumber("integer", 42)
+	assert.Equal(t, map[string]string{
+		"a_b_c_d": "1",
+		"number":  "1.5",
+		"integer": "42",
+	}, c.Tags)
+
+	// Keys which sanitize to the same value overwrite each other.
+	c.SetTag("a_b_c_d", "2")
+	c.SetTag("a.b.c.d", "3")
+	assert.Equal(t, "3", c.Tags["a_b_c_d"])
+	assert.Len(t, c.Tags, 3)
+}
+
+func TestSpanContextSetTag(t *testing.T) {
+	var c model.SpanContext
+	c.SetTag("cache.hit", "true")
+	c.SetTagNumber("rows", 10)
+	c.SetTag("rows", "11")
+	assert.Equal(t, map[string]string{
+		"cache_hit": "true",
+		"rows":      "11",
+	}, c.Tags)
+
+	out, err := json.Marshal(&c)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"tags":{"cache_hit":"true","rows":"11"}}`, string(out))
+}
+
+func TestSanitizeTagKey(t *testing.T) {
+	assert.Equal(t, "key", model.SanitizeTagKey("key"))
+	assert.Equal(t, "a_b_c_d", model.SanitizeTagKey(`a.b*c"d`))
+}
+
 func fakeTransaction() *model.Transaction {
 	return &model.Transaction{
 		ID:        "d51ae41d-93da-4984-bba3-ae15e9b2247f",
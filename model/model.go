@@ -3,6 +3,8 @@ package model
 import (
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -34,6 +36,21 @@ type Service struct {
 	Runtime *Runtime `json:"runtime,omitempty"`
 }
 
+// EventService holds a service identity for an individual event,
+// overriding the service described in the payload metadata. This
+// requires a server which supports per-event service metadata.
+type EventService struct {
+	// Name is the name of the service.
+	Name string `json:"name,omitempty"`
+
+	// Version is the version of the service, if it has one.
+	Version string `json:"version,omitempty"`
+
+	// Environment is the name of the service's environment,
+	// if it has one, e.g. "production" or "staging".
+	Environment string `json:"environment,omitempty"`
+}
+
 // Agent holds information about the Elastic APM agent.
 type Agent struct {
 	// Name is the name of the Elastic APM agent, e.g. "Go".
@@ -41,6 +58,11 @@ type Agent struct {
 
 	// Version is the version of the Elastic APM agent, e.g. "1.0.0".
 	Version string `json:"version"`
+
+	// Config holds the agent's effective configuration, keyed by
+	// option name, e.g. "transaction_sample_rate". The values of
+	// options whose names suggest they hold secrets are redacted.
+	Config map[string]string `json:"config,omitempty"`
 }
 
 // Framework holds information about the framework (typically web)
@@ -97,6 +119,10 @@ type Process struct {
 
 	// Argv holds the command line arguments used to start the process.
 	Argv []string `json:"argv,omitempty"`
+
+	// Env holds the environment variables captured for the process,
+	// as configured with ELASTIC_APM_CAPTURE_ENV.
+	Env map[string]string `json:"env,omitempty"`
 }
 
 // Transaction represents a transaction handled by the service.
@@ -104,6 +130,20 @@ type Transaction struct {
 	// ID holds the hex-formatted UUID of the transaction.
 	ID string `json:"id"`
 
+	// TraceID holds the hex-encoded ID of the trace to
+	// which the transaction belongs.
+	TraceID string `json:"trace_id,omitempty"`
+
+	// SpanID holds the hex-encoded, trace-wide unique span
+	// ID of the transaction, which may be referenced as the
+	// parent of spans, and of transactions in other services.
+	SpanID string `json:"span_id,omitempty"`
+
+	// ParentID holds the hex-encoded span ID of the
+	// transaction's parent, if any. The parent may belong
+	// to another service.
+	ParentID string `json:"parent_id,omitempty"`
+
 	// Name holds the name of the transaction.
 	Name string `json:"name"`
 
@@ -132,11 +172,29 @@ type Transaction struct {
 	// it to true.
 	Sampled *bool `json:"sampled,omitempty"`
 
+	// SampleRate holds the rate at which transactions in the trace
+	// were sampled when the sampling decision was made, in the
+	// range [0,1.0], for extrapolating aggregate metrics from
+	// sampled transactions. If the rate is unknown, SampleRate
+	// is nil.
+	SampleRate *float64 `json:"sample_rate,omitempty"`
+
+	// Synthetic indicates that the transaction was initiated by
+	// synthetic traffic, such as an uptime monitor, rather than by
+	// a real user, so that it may be excluded from user-facing
+	// statistics.
+	Synthetic bool `json:"synthetic,omitempty"`
+
 	// SpanCount holds statistics on spans within a transaction.
 	SpanCount *SpanCount `json:"span_count,omitempty"`
 
 	// Spans holds the transaction's spans.
 	Spans []*Span `json:"spans,omitempty"`
+
+	// Service holds the identity of the service to which the
+	// transaction belongs, if it differs from the service
+	// described in the payload metadata.
+	Service *EventService `json:"service,omitempty"`
 }
 
 // SpanCount holds statistics on spans within a transaction.
@@ -175,11 +233,62 @@ type Span struct {
 	// Parent holds the identifier of the parent span, if any.
 	Parent *int64 `json:"parent,omitempty"`
 
+	// TraceID holds the hex-encoded ID of the trace to
+	// which the span belongs.
+	TraceID string `json:"trace_id,omitempty"`
+
+	// SpanID holds the hex-encoded, trace-wide unique ID of
+	// the span. Unlike ID, the span ID may be referenced by
+	// transactions in other services.
+	SpanID string `json:"span_id,omitempty"`
+
+	// ParentID holds the hex-encoded span ID of the span's
+	// parent: either another span, or the containing
+	// transaction.
+	ParentID string `json:"parent_id,omitempty"`
+
+	// TransactionID holds the ID of the span's transaction. This
+	// is set only when spans are sent independently of their
+	// transaction, in a SpansPayload.
+	TransactionID string `json:"transaction_id,omitempty"`
+
+	// Exit indicates that the span describes an operation leaving
+	// the process, such as a database query or an outgoing HTTP
+	// request. Exit spans should have Context.Destination.Service
+	// set, identifying the destination resource.
+	Exit bool `json:"exit,omitempty"`
+
+	// Outcome records the outcome of the operation described by
+	// the span: OutcomeSuccess, OutcomeFailure, or OutcomeUnknown.
+	// If empty, the outcome is unknown.
+	Outcome string `json:"outcome,omitempty"`
+
 	// Context holds contextual information relating to the span.
 	Context *SpanContext `json:"context,omitempty"`
 
 	// Stacktrace holds stack frames corresponding to the span.
 	Stacktrace []StacktraceFrame `json:"stacktrace,omitempty"`
+
+	// Links holds references to spans, other than the parent,
+	// that are causally related to the span, e.g. the spans of
+	// requests whose work is performed by a batching span.
+	Links []SpanLink `json:"links,omitempty"`
+}
+
+// Span outcomes, for Span.Outcome.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeUnknown = "unknown"
+)
+
+// SpanLink holds a reference to a span, possibly in another trace.
+type SpanLink struct {
+	// TraceID holds the hex-encoded ID of the linked span's trace.
+	TraceID string `json:"trace_id"`
+
+	// SpanID holds the hex-encoded ID of the linked span.
+	SpanID string `json:"span_id"`
 }
 
 // SpanContext holds contextual information relating to the span.
@@ -187,6 +296,114 @@ type SpanContext struct {
 	// Database holds contextual information for database
 	// operation spans.
 	Database *DatabaseSpanContext `json:"db,omitempty"`
+
+	// Cache holds contextual information for cache operation
+	// spans, e.g. Redis or memcached commands. Cache spans should
+	// have the type "cache", with the cache engine as the subtype,
+	// e.g. "cache.redis". Commands on data stores which are not
+	// used as caches should be described by Database instead.
+	Cache *CacheSpanContext `json:"cache,omitempty"`
+
+	// Destination holds contextual information about the
+	// destination of exit spans.
+	Destination *DestinationSpanContext `json:"destination,omitempty"`
+
+	// HTTP holds contextual information for outgoing
+	// HTTP request spans.
+	HTTP *HTTPSpanContext `json:"http,omitempty"`
+
+	// Message holds contextual information for messaging
+	// spans, e.g. publishing a message to a queue.
+	Message *MessageSpanContext `json:"message,omitempty"`
+
+	// Tags holds user-defined key/value pairs.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// SetTag sets the tag with the given key in c.Tags to value,
+// allocating c.Tags if it is nil, and replacing any existing
+// value. See SanitizeTagKey for how the key is sanitized.
+func (c *SpanContext) SetTag(key, value string) {
+	if c.Tags == nil {
+		c.Tags = make(map[string]string)
+	}
+	c.Tags[SanitizeTagKey(key)] = value
+}
+
+// SetTagNumber sets the tag with the given key in c.Tags to
+// the decimal string representation of value, as for SetTag.
+func (c *SpanContext) SetTagNumber(key string, value float64) {
+	c.SetTag(key, formatTagNumber(value))
+}
+
+// MessageSpanContext holds contextual information about a message
+// sent or received via a message broker. It is used both by spans
+// sending messages, and by transactions consuming messages.
+type MessageSpanContext struct {
+	// Queue describes the queue or topic of the message.
+	Queue *MessageQueueSpanContext `json:"queue,omitempty"`
+
+	// Age holds the age of the message, when it was received,
+	// if the message broker provides the time at which it was
+	// enqueued.
+	Age *MessageAgeSpanContext `json:"age,omitempty"`
+
+	// RoutingKey holds the message's routing key, for AMQP.
+	RoutingKey string `json:"routing_key,omitempty"`
+}
+
+// MessageQueueSpanContext describes a message queue or topic.
+type MessageQueueSpanContext struct {
+	// Name holds the name of the queue or topic.
+	Name string `json:"name"`
+}
+
+// MessageAgeSpanContext holds the age of a received message.
+type MessageAgeSpanContext struct {
+	// Milliseconds holds the time elapsed between the message
+	// being enqueued and dequeued, in milliseconds.
+	Milliseconds int64 `json:"ms"`
+}
+
+// HTTPSpanContext holds contextual information for outgoing
+// HTTP request spans.
+type HTTPSpanContext struct {
+	// URL describes the URL requested.
+	URL *URL `json:"url,omitempty"`
+
+	// StatusCode holds the HTTP response status code, if
+	// a response was received.
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+// DestinationSpanContext holds contextual information about
+// the destination of an exit span.
+type DestinationSpanContext struct {
+	// Address holds the network address of the destination,
+	// e.g. a host name or IP address.
+	Address string `json:"address,omitempty"`
+
+	// Port holds the network port of the destination.
+	Port int `json:"port,omitempty"`
+
+	// Service describes the destination service.
+	Service *DestinationServiceSpanContext `json:"service,omitempty"`
+}
+
+// DestinationServiceSpanContext describes the destination
+// service of an exit span.
+type DestinationServiceSpanContext struct {
+	// Type holds the destination service type, e.g. "db".
+	Type string `json:"type,omitempty"`
+
+	// Name holds the destination service name, e.g. "postgresql".
+	Name string `json:"name,omitempty"`
+
+	// Resource identifies the destination service resource
+	// being operated on, e.g. "postgresql" or "elasticsearch:9200".
+	// Spans with the same destination resource are considered
+	// to be operating on the same downstream dependency.
+	Resource string `json:"resource,omitempty"`
 }
 
 // DatabaseSpanContext holds contextual information for database
@@ -207,6 +424,20 @@ type DatabaseSpanContext struct {
 	User string `json:"user,omitempty"`
 }
 
+// CacheSpanContext holds contextual information for cache
+// operation spans.
+type CacheSpanContext struct {
+	// KeyPattern holds the pattern of the keys operated on, with
+	// variable components replaced, e.g. "user:*:session". The
+	// keys themselves should not be recorded, as they may be of
+	// high cardinality or contain sensitive values.
+	KeyPattern string `json:"key_pattern,omitempty"`
+
+	// Hit records whether or not a cache lookup found the key.
+	// This should be nil for operations other than lookups.
+	Hit *bool `json:"hit,omitempty"`
+}
+
 // Context holds contextual information relating to a transaction or error.
 type Context struct {
 	// Request holds details of the HTTP request relating to the
@@ -221,6 +452,10 @@ type Context struct {
 	// transaction or error, if relevant.
 	User *User `json:"user,omitempty"`
 
+	// Message holds details of the message received by a
+	// consumer transaction, if relevant.
+	Message *MessageSpanContext `json:"message,omitempty"`
+
 	// Custom holds arbitrary additional metadata.
 	Custom map[string]interface{} `json:"custom,omitempty"`
 
@@ -228,6 +463,22 @@ type Context struct {
 	Tags map[string]string `json:"tags,omitempty"`
 }
 
+// SetTag sets the tag with the given key in c.Tags to value,
+// allocating c.Tags if it is nil, and replacing any existing
+// value. See SanitizeTagKey for how the key is sanitized.
+func (c *Context) SetTag(key, value string) {
+	if c.Tags == nil {
+		c.Tags = make(map[string]string)
+	}
+	c.Tags[SanitizeTagKey(key)] = value
+}
+
+// SetTagNumber sets the tag with the given key in c.Tags to
+// the decimal string representation of value, as for SetTag.
+func (c *Context) SetTagNumber(key string, value float64) {
+	c.SetTag(key, formatTagNumber(value))
+}
+
 // User holds information about an authenticated user.
 type User struct {
 	// Username holds the username of the user.
@@ -241,6 +492,16 @@ type User struct {
 	Email string `json:"email,omitempty"`
 }
 
+// SetUserID sets u.ID to the given integer ID.
+func (u *User) SetUserID(id int) {
+	u.ID = id
+}
+
+// SetUserIDString sets u.ID to the given string ID.
+func (u *User) SetUserIDString(id string) {
+	u.ID = id
+}
+
 // Error represents an error occurring in the service.
 type Error struct {
 	// Timestamp holds the time at which the error occurred.
@@ -253,6 +514,13 @@ type Error struct {
 	// this error relates, if any.
 	TransactionID string `json:"-"`
 
+	// TraceID and ParentID hold the hex-encoded trace ID and span ID
+	// of the transaction to which this error relates, if any. These
+	// are not sent to the APM server, but are used by exporters that
+	// correlate errors with traces, such as OTLP.
+	TraceID  string `json:"-"`
+	ParentID string `json:"-"`
+
 	// Culprit holds the name of the function which
 	// produced the error.
 	Culprit string `json:"culprit,omitempty"`
@@ -266,6 +534,11 @@ type Error struct {
 
 	// Log holds additional information added when logging the error.
 	Log *Log `json:"log,omitempty"`
+
+	// Service holds the identity of the service to which the
+	// error belongs, if it differs from the service described
+	// in the payload metadata.
+	Service *EventService `json:"service,omitempty"`
 }
 
 // Exception represents an exception: an error or panic.
@@ -292,6 +565,16 @@ type Exception struct {
 	Handled bool `json:"handled"`
 }
 
+// SetCode sets e.Code to the given integer code.
+func (e *Exception) SetCode(code int) {
+	e.Code = code
+}
+
+// SetCodeString sets e.Code to the given string code.
+func (e *Exception) SetCodeString(code string) {
+	e.Code = code
+}
+
 // StacktraceFrame describes a stack frame.
 type StacktraceFrame struct {
 	// AbsolutePath holds the absolute path of the source file for the
@@ -368,6 +651,18 @@ type Request struct {
 	// Body holds the request body, if body capture is enabled.
 	Body *RequestBody `json:"body,omitempty"`
 
+	// BodySize holds the size of the request body in bytes, as
+	// given by the Content-Length header, or if that is unknown,
+	// e.g. for chunked requests, the number of bytes read by the
+	// request handler. BodySize is recorded whether or not the
+	// body is captured.
+	BodySize *int64 `json:"body_size,omitempty"`
+
+	// QueryParams holds the parsed URL query parameters, if
+	// query parameter capture is enabled. The values of
+	// sensitive parameters are redacted.
+	QueryParams url.Values `json:"query_params,omitempty"`
+
 	// HTTPVersion holds the HTTP version of the request.
 	HTTPVersion string `json:"http_version,omitempty"`
 
@@ -384,13 +679,24 @@ type Request struct {
 
 // RequestBody holds a request body.
 //
-// Exactly one of Raw or Form must be set.
+// Exactly one of Raw or Form must be set. Files may
+// only be set if Form is set.
 type RequestBody struct {
 	// Raw holds the raw body content.
 	Raw string
 
 	// Form holds the form data from POST, PATCH, or PUT body parameters.
 	Form url.Values
+
+	// Files holds the sizes, in bytes, of files uploaded in
+	// multipart form data, keyed by form field name. File
+	// contents are never captured. Files are encoded after
+	// any Form values with the same field name.
+	Files map[string][]int64
+
+	// Truncated indicates that the body content or form fields
+	// were truncated when captured, due to exceeding limits.
+	Truncated bool
 }
 
 // RequestHeaders holds a limited subset of HTTP request headers.
@@ -456,6 +762,14 @@ type Response struct {
 
 	// Finished indicates whether or not the response was finished.
 	Finished *bool `json:"finished,omitempty"`
+
+	// Body holds the response body, if it was captured.
+	// The body may be truncated.
+	Body string `json:"body,omitempty"`
+
+	// BodySize holds the number of bytes written for the response
+	// body. BodySize is recorded whether or not the body is captured.
+	BodySize *int64 `json:"body_size,omitempty"`
 }
 
 // ResponseHeaders holds a limited subset of HTTP respponse headers.
@@ -463,3 +777,73 @@ type ResponseHeaders struct {
 	// ContentType holds the content-type header.
 	ContentType string `json:"content-type,omitempty"`
 }
+
+// Metrics holds a set of metric samples, with an optional set of labels.
+type Metrics struct {
+	// Timestamp holds the time at which the metric samples were taken.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Labels holds a set of labels associated with the metrics.
+	// The labels apply uniformly to all metric samples in the set.
+	Labels map[string]string `json:"tags,omitempty"`
+
+	// Samples holds a set of metric samples, keyed by metric name.
+	Samples map[string]Metric `json:"samples"`
+}
+
+// Metric holds metric values.
+type Metric struct {
+	// Value holds the metric value.
+	Value float64 `json:"value"`
+
+	// Exemplar optionally holds a reference to a trace
+	// contributing to the metric value.
+	Exemplar *MetricExemplar `json:"exemplar,omitempty"`
+
+	// Type holds the type of the metric: MetricTypeGauge, the
+	// default if empty, MetricTypeCounter, or MetricTypeDeltaCounter.
+	// Type is not sent to the APM server, but is used by exporters
+	// that distinguish metric types, such as OTLP.
+	Type string `json:"-"`
+}
+
+// Metric types, for Metric.Type.
+const (
+	// MetricTypeGauge identifies a metric whose value
+	// may go up or down, e.g. the number of goroutines.
+	MetricTypeGauge = "gauge"
+
+	// MetricTypeCounter identifies a monotonic counter whose
+	// value is the cumulative total since the process started.
+	MetricTypeCounter = "counter"
+
+	// MetricTypeDeltaCounter identifies a monotonic counter
+	// whose value is the total since the metric was last
+	// gathered.
+	MetricTypeDeltaCounter = "delta_counter"
+)
+
+// MetricExemplar holds a reference to a trace which
+// contributed to an aggregated metric value.
+type MetricExemplar struct {
+	// TraceID holds the hex-encoded ID of the trace.
+	TraceID string `json:"trace_id"`
+}
+
+// tagKeyReplacer replaces the characters which the server
+// rejects in tag keys with underscores.
+var tagKeyReplacer = strings.NewReplacer(".", "_", "*", "_", `"`, "_")
+
+// SanitizeTagKey returns key with each of the characters that are
+// invalid in tag keys, '.', '*', and '"', replaced by '_'. The server
+// rejects events whose tag keys contain these characters, so keys are
+// sanitized by each of the agent's methods for setting tags or labels:
+// Context.SetTag, SpanContext.SetTag, and the Transaction.SetTag and
+// Span.SetLabel methods of package elasticapm.
+func SanitizeTagKey(key string) string {
+	return tagKeyReplacer.Replace(key)
+}
+
+func formatTagNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
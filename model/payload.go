@@ -21,3 +21,24 @@ type ErrorsPayload struct {
 	System  *System  `json:"system,omitempty"`
 	Errors  []*Error `json:"errors"`
 }
+
+// SpansPayload defines the payload structure for sending spans
+// independently of their transactions, to servers which support
+// it. Each span references its transaction by TransactionID.
+type SpansPayload struct {
+	Service *Service `json:"service"`
+	Process *Process `json:"process,omitempty"`
+	System  *System  `json:"system,omitempty"`
+	Spans   []*Span  `json:"spans"`
+}
+
+// MetricsPayload defines the payload structure expected
+// by the metrics intake API.
+//
+// https://www.elastic.co/guide/en/apm/server/current/metrics-api.html
+type MetricsPayload struct {
+	Service *Service   `json:"service"`
+	Process *Process   `json:"process,omitempty"`
+	System  *System    `json:"system,omitempty"`
+	Metrics []*Metrics `json:"metrics"`
+}
@@ -0,0 +1,69 @@
+package elasticapm
+
+import (
+	"context"
+	"runtime"
+)
+
+// SetNestedTransactionSpans sets whether or not to start a span within
+// the existing transaction, rather than a nested transaction, when a
+// transaction is started with a context that already contains one.
+// This is done by WithTransaction, and by instrumentation modules that
+// detect nested transactions, such as apmhttp.
+func (t *Tracer) SetNestedTransactionSpans(enabled bool) {
+	t.nestedTransactionsMu.Lock()
+	t.nestedTransactionSpans = enabled
+	t.nestedTransactionsMu.Unlock()
+}
+
+// NestedTransactionSpans reports whether or not nested transactions
+// should be replaced by spans. See SetNestedTransactionSpans.
+func (t *Tracer) NestedTransactionSpans() bool {
+	t.nestedTransactionsMu.Lock()
+	enabled := t.nestedTransactionSpans
+	t.nestedTransactionsMu.Unlock()
+	return enabled
+}
+
+// DetectNestedTransaction reports whether ctx already contains a
+// transaction, in which case a transaction named name, about to be
+// started, would be nested within it. Spans of the outer transaction
+// started from the inner one's context are then silently orphaned,
+// which usually indicates an instrumentation bug such as middleware
+// being installed twice.
+//
+// When a nested transaction is detected, an error is logged with the
+// tracer's logger, at most once per call site. The call site is that
+// of the caller of DetectNestedTransaction, skipping skip additional
+// stack frames.
+func (t *Tracer) DetectNestedTransaction(ctx context.Context, name string, skip int) bool {
+	outer := TransactionFromContext(ctx)
+	if outer == nil {
+		return false
+	}
+	var pcs [1]uintptr
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return true
+	}
+	pc := pcs[0]
+	t.nestedTransactionsMu.Lock()
+	logged := t.nestedTransactionSites[pc]
+	if !logged {
+		if t.nestedTransactionSites == nil {
+			t.nestedTransactionSites = make(map[uintptr]bool)
+		}
+		t.nestedTransactionSites[pc] = true
+	}
+	t.nestedTransactionsMu.Unlock()
+	if logger := t.currentLogger(); !logged && logger != nil {
+		// runtime.CallersFrames, unlike runtime.FuncForPC,
+		// expands inlined calls, reporting the inlined function
+		// rather than the function it was inlined into.
+		frame, _ := runtime.CallersFrames(pcs[:]).Next()
+		logger.Errorf(
+			"transaction %q started within transaction %q by %s (%s:%d)",
+			name, outer.Name, frame.Function, frame.File, frame.Line,
+		)
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package elasticapm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+func TestDetectNestedTransaction(t *testing.T) {
+	var logger recordingLogger
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.SetLogger(&logger)
+
+	assert.False(t, tracer.DetectNestedTransaction(context.Background(), "inner", 0))
+
+	outer := tracer.StartTransaction("outer", "type")
+	defer outer.Done(-1)
+	ctx := elasticapm.ContextWithTransaction(context.Background(), outer)
+	for i := 0; i < 2; i++ {
+		// The warning is only logged once per call site.
+		assert.True(t, tracer.DetectNestedTransaction(ctx, "inner", 0))
+	}
+	assert.True(t, tracer.DetectNestedTransaction(ctx, "other", 0))
+
+	errors := logger.errors()
+	require.Len(t, errors, 2)
+	assert.Regexp(t, `^transaction "inner" started within transaction "outer" by .*TestDetectNestedTransaction \(.*nested_test.go:\d+\)$`, errors[0])
+	assert.Regexp(t, `^transaction "other" started within transaction "outer"`, errors[1])
+}
@@ -0,0 +1,62 @@
+package elasticapm
+
+import (
+	"strings"
+
+	"github.com/elastic/apm-agent-go/internal/sanitize"
+)
+
+// captureEnv returns the entries of environ, a list of "key=value"
+// strings as returned by os.Environ, whose names match any of the
+// given patterns. Patterns may contain "*" wildcards, matching any
+// sequence of characters. The values of variables whose names look
+// like they may hold secrets are redacted.
+func captureEnv(environ []string, patterns []string) map[string]string {
+	var env map[string]string
+	for _, kv := range environ {
+		equal := strings.IndexRune(kv, '=')
+		if equal <= 0 {
+			continue
+		}
+		name, value := kv[:equal], kv[equal+1:]
+		if !matchAnyWildcard(patterns, name) {
+			continue
+		}
+		if env == nil {
+			env = make(map[string]string)
+		}
+		env[name] = sanitize.Value(name, value)
+	}
+	return env
+}
+
+func matchAnyWildcard(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if matchWildcard(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard reports whether or not s matches pattern,
+// where "*" in pattern matches any sequence of characters.
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
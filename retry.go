@@ -0,0 +1,56 @@
+package elasticapm
+
+import (
+	"context"
+	"strconv"
+)
+
+// retryAttemptLabel is the span label recording the attempt number
+// of a retried operation, as set by StartSpan for contexts created
+// with ContextWithRetryAttempt.
+const retryAttemptLabel = "retry_attempt"
+
+// ContextWithRetryAttempt returns a copy of parent recording that
+// operations within it are the given attempt, numbered from 1, of a
+// retried operation. Spans started with StartSpan or StartSpanOptions
+// using the returned context are labelled with "retry_attempt", so
+// that each attempt made by a retrying client appears in the trace as
+// a separate, numbered sibling span, rather than hiding within one
+// long span, e.g.
+//
+//	for attempt := 1; attempt <= maxAttempts; attempt++ {
+//		ctx := elasticapm.ContextWithRetryAttempt(ctx, attempt)
+//		if err = call(ctx); err == nil {
+//			break
+//		}
+//	}
+//
+// Only the outermost span of each attempt is labelled; spans started
+// within it are not. An attempt of zero or less clears the attempt.
+func ContextWithRetryAttempt(parent context.Context, attempt int) context.Context {
+	if attempt < 0 {
+		attempt = 0
+	}
+	return context.WithValue(parent, contextRetryAttemptKey{}, attempt)
+}
+
+// RetryAttemptFromContext returns the retry attempt number recorded
+// in ctx by ContextWithRetryAttempt, or zero if there is none.
+func RetryAttemptFromContext(ctx context.Context) int {
+	attempt, _ := ctx.Value(contextRetryAttemptKey{}).(int)
+	return attempt
+}
+
+// setRetryAttempt labels span with the retry attempt in ctx, if any,
+// returning a context in which the attempt is cleared, so that spans
+// started within span are not also labelled.
+func setRetryAttempt(ctx context.Context, span *Span) context.Context {
+	attempt := RetryAttemptFromContext(ctx)
+	if attempt <= 0 {
+		return ctx
+	}
+	span.SetLabel(retryAttemptLabel, strconv.Itoa(attempt))
+	return ContextWithRetryAttempt(ctx, 0)
+}
+
+type contextRetryAttemptKey struct{}
@@ -1,6 +1,7 @@
 package elasticapm
 
 import (
+	"encoding/binary"
 	"math/rand"
 	"sync"
 
@@ -17,6 +18,24 @@ type Sampler interface {
 	Sample(*Transaction) bool
 }
 
+// RatedSampler is an optional interface that may be implemented by a
+// Sampler to report the rate at which it samples transactions, so
+// the server can extrapolate aggregate metrics, such as throughput,
+// from sampled transactions.
+//
+// The sample rate is recorded in each transaction started as the
+// root of a trace, sampled or not, and is propagated to downstream
+// services via the tracestate header, so that transactions which
+// inherit the sampling decision also record the rate.
+type RatedSampler interface {
+	Sampler
+
+	// SampleRate returns the rate, in the range [0,1.0], at which
+	// transactions like the given one are sampled. This method is
+	// invoked after Sample, and must also be goroutine-safe.
+	SampleRate(*Transaction) float64
+}
+
 // RatioSampler is a Sampler that samples probabilistically
 // based on the given ratio within the range [0,1.0].
 //
@@ -53,3 +72,93 @@ func (s *RatioSampler) Sample(*Transaction) bool {
 	s.mu.Unlock()
 	return s.r > v
 }
+
+// SampleRate returns the configured ratio.
+func (s *RatioSampler) SampleRate(*Transaction) float64 {
+	return s.r
+}
+
+// NewTraceIDRatioSampler returns a new Sampler which samples the given
+// ratio, within the range [0,1.0], of transactions deterministically
+// based on their trace IDs: a transaction is always given the same
+// sampling decision for the same trace ID. This means that services
+// which start transactions for the same trace ID, without propagating
+// the sampling decision, will agree on whether or not to sample it.
+//
+// The decision is based on the last 8 bytes of the trace ID, which are
+// random for trace IDs produced by the default IDGenerator, as well as
+// for those with a timestamp prefix, such as AWS X-Ray trace IDs.
+//
+// If the ratio provided does not lie within the range [0,1.0],
+// NewTraceIDRatioSampler will panic.
+func NewTraceIDRatioSampler(r float64) RatedSampler {
+	if r < 0 || r > 1.0 {
+		panic(errors.Errorf("ratio %v out of range [0,1.0]", r))
+	}
+	return traceIDRatioSampler{r}
+}
+
+type traceIDRatioSampler struct {
+	r float64
+}
+
+// Sample samples the transaction if its trace ID, interpreted as a
+// fraction of the range of trace IDs, is less than the ratio.
+func (s traceIDRatioSampler) Sample(tx *Transaction) bool {
+	if s.r >= 1 {
+		return true
+	}
+	if tx == nil {
+		return false
+	}
+	trace := tx.traceContext.Trace
+	// Use the top 53 bits, which float64 represents exactly.
+	v := binary.BigEndian.Uint64(trace[8:]) >> 11
+	return float64(v)/(1<<53) < s.r
+}
+
+// SampleRate returns the configured ratio.
+func (s traceIDRatioSampler) SampleRate(*Transaction) float64 {
+	return s.r
+}
+
+// NewErrorSampler returns a Sampler which samples all transactions
+// during which errors are reported, and applies s to the rest. If
+// s is nil, all transactions will be sampled.
+//
+// Whether or not errors are reported is known only once the
+// transaction ends, so the sampling decision is deferred until
+// then: spans are recorded for every transaction, and context and
+// spans are discarded at the end for non-sampled transactions with
+// no errors. Errors are associated with a transaction by setting
+// Error.Transaction, e.g. via Tracer.Recovered, and must be sent
+// before the transaction ends.
+//
+// This comes at a cost: every transaction holds its spans in memory
+// until it ends, as if all transactions were sampled, up to the
+// maximum number of spans per transaction. Downstream services
+// continuing the trace are told that the transaction is sampled,
+// as the final decision is not yet known when propagating the
+// trace context: if the transaction is then not sampled, they will
+// have recorded a partial trace, missing the transaction and its
+// spans. For the same reason, no sample rate is recorded for the
+// transactions.
+func NewErrorSampler(s Sampler) Sampler {
+	return errorSampler{s}
+}
+
+type errorSampler struct {
+	sampler Sampler
+}
+
+// Sample always returns true, so spans are recorded until
+// the final sampling decision is made by deferredSample.
+func (errorSampler) Sample(*Transaction) bool {
+	return true
+}
+
+// deferredSample returns the sampling decision for tx in the
+// case that no errors are reported during the transaction.
+func (s errorSampler) deferredSample(tx *Transaction) bool {
+	return s.sampler == nil || s.sampler.Sample(tx)
+}
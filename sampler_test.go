@@ -6,7 +6,10 @@ import (
 	"testing"
 
 	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRatioSampler(t *testing.T) {
@@ -41,3 +44,180 @@ func TestRatioSampler(t *testing.T) {
 	}
 	assert.InDelta(t, ratio, float64(total)/(numGoroutines*numIterations), 0.1)
 }
+
+func TestTraceIDRatioSampler(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+
+	const ratio = 0.25
+	s := elasticapm.NewTraceIDRatioSampler(ratio)
+	tracer.SetSampler(s)
+
+	const n = 10000
+	var sampled int
+	decisions := make(map[elasticapm.TraceID]bool)
+	for i := 0; i < n; i++ {
+		tx := tracer.StartTransaction("name", "type")
+		if tx.Sampled() {
+			sampled++
+		}
+		decisions[tx.TraceContext().Trace] = tx.Sampled()
+		tx.Done(-1)
+	}
+	assert.InDelta(t, ratio, float64(sampled)/n, 0.02)
+
+	// The decision is the same whenever a trace ID is sampled.
+	var checked int
+	for traceID, decision := range decisions {
+		if checked++; checked > 100 {
+			break
+		}
+		tracer.SetIDGenerator(&fixedTraceIDGenerator{trace: traceID})
+		tx := tracer.StartTransaction("name", "type")
+		assert.Equal(t, decision, tx.Sampled())
+		tx.Done(-1)
+	}
+	assert.Equal(t, ratio, s.SampleRate(nil))
+}
+
+func TestTraceIDRatioSamplerBounds(t *testing.T) {
+	assert.True(t, elasticapm.NewTraceIDRatioSampler(1).Sample(nil))
+	assert.False(t, elasticapm.NewTraceIDRatioSampler(0).Sample(nil))
+	assert.Panics(t, func() { elasticapm.NewTraceIDRatioSampler(1.5) })
+	assert.Panics(t, func() { elasticapm.NewTraceIDRatioSampler(-0.5) })
+}
+
+// fixedTraceIDGenerator is an elasticapm.IDGenerator which
+// always generates the same trace ID.
+type fixedTraceIDGenerator struct {
+	sequentialIDGenerator
+	trace elasticapm.TraceID
+}
+
+func (g *fixedTraceIDGenerator) NewTraceID() elasticapm.TraceID {
+	return g.trace
+}
+
+func TestErrorSampler(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+	tracer.SetSampler(elasticapm.NewErrorSampler(
+		elasticapm.NewRatioSampler(0, rand.NewSource(0)),
+	))
+
+	// Spans are recorded for all transactions, as the
+	// sampling decision is not made until they end.
+	tx1 := tracer.StartTransaction("no_error", "type")
+	assert.True(t, tx1.Sampled())
+	tx1.StartSpan("name", "type", nil).Done(-1)
+	tx1.Context = &model.Context{Tags: map[string]string{"foo": "bar"}}
+	tx1.Done(-1)
+
+	tx2 := tracer.StartTransaction("error", "type")
+	tx2.StartSpan("name", "type", nil).Done(-1)
+	tracer.Recovered("boom", tx2).Send()
+	tx2.Done(-1)
+	tracer.Flush(nil)
+
+	var transactions []interface{}
+	for _, p := range r.Payloads() {
+		if p, ok := p["transactions"].([]interface{}); ok {
+			transactions = append(transactions, p...)
+		}
+	}
+	require.Len(t, transactions, 2)
+	transaction1 := transactions[0].(map[string]interface{})
+	transaction2 := transactions[1].(map[string]interface{})
+	assert.Equal(t, false, transaction1["sampled"])
+	assert.NotContains(t, transaction1, "spans")
+	assert.NotContains(t, transaction1, "context")
+	assert.Equal(t, true, transaction2["sampled"])
+	assert.Len(t, transaction2["spans"], 1)
+}
+
+func TestErrorSamplerConcurrentSampled(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+	tracer.SetSampler(elasticapm.NewErrorSampler(
+		elasticapm.NewRatioSampler(0, rand.NewSource(0)),
+	))
+
+	// The sampling decision is made when the transaction ends,
+	// while other goroutines may be starting spans within it.
+	// Run with -race.
+	tx := tracer.StartTransaction("name", "type")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tx.Sampled()
+		}
+	}()
+	tx.Done(-1)
+	wg.Wait()
+}
+
+func TestSampleRate(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = &r
+
+	tx := tracer.StartTransaction("default", "type")
+	assert.Equal(t, 1.0, *tx.SampleRate)
+	assert.Equal(t, "es=s:1", tx.TraceContext().State.String())
+	tx.Done(-1)
+
+	// Non-sampled transactions record the rate too, with the
+	// rate rounded to 4 decimal places.
+	tracer.SetSampler(elasticapm.NewRatioSampler(0.123456, rand.NewSource(0)))
+	tx = tracer.StartTransactionOptions("ratio", "type", elasticapm.TransactionOptions{
+		TraceContext: elasticapm.TraceContext{State: elasticapm.ParseTraceState("foo=bar")},
+	})
+	assert.Equal(t, 0.1235, *tx.SampleRate)
+	assert.Equal(t, "es=s:0.1235", tx.TraceContext().State.String())
+	tx.Done(-1)
+
+	// Transactions continuing a trace take the rate from the
+	// trace state, preserving other fields of the "es" entry.
+	tx = tracer.StartTransactionOptions("continued", "type", elasticapm.TransactionOptions{
+		TraceContext: elasticapm.TraceContext{
+			Trace: elasticapm.TraceID{1},
+			Span:  elasticapm.SpanID{1},
+			State: elasticapm.ParseTraceState("foo=bar,es=x:y;s:0.5"),
+		},
+	})
+	assert.False(t, tx.Sampled())
+	assert.Equal(t, 0.5, *tx.SampleRate)
+	tx.Done(-1)
+
+	tx = tracer.StartTransactionOptions("unknown", "type", elasticapm.TransactionOptions{
+		TraceContext: elasticapm.TraceContext{
+			Trace: elasticapm.TraceID{1},
+			Span:  elasticapm.SpanID{1},
+			State: elasticapm.ParseTraceState("es=s:2"),
+		},
+	})
+	assert.Nil(t, tx.SampleRate)
+	tx.Done(-1)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 4)
+	var rates []interface{}
+	for _, tx := range transactions {
+		rates = append(rates, tx.(map[string]interface{})["sample_rate"])
+	}
+	assert.Equal(t, []interface{}{1.0, 0.1235, 0.5, nil}, rates)
+}
@@ -0,0 +1,130 @@
+package elasticapm
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// drainPollInterval is the interval at which the number of in-flight
+// transactions is checked while waiting for them to end.
+const drainPollInterval = 10 * time.Millisecond
+
+// FlushOnShutdown returns a function, intended to be deferred in main,
+// which flushes any events queued by tracer and then closes it, waiting
+// at most timeout for the flush to complete. If tracer is nil, then
+// DefaultTracer is used.
+//
+// If signals is non-nil, then the tracer will also be flushed and closed
+// when a signal is received on it. The channel would typically be
+// registered with signal.Notify for syscall.SIGTERM and os.Interrupt,
+// since deferred functions do not run when a process is terminated by a
+// signal. Once the tracer has been closed, signal.Stop is called for the
+// channel and the received signal is redelivered to the process, so that
+// the default behaviour (e.g. termination) takes effect if nothing else
+// is handling the signal.
+//
+// The returned function may be called multiple times, and concurrently
+// with signal handling; the tracer will be flushed and closed only once.
+func FlushOnShutdown(tracer *Tracer, timeout time.Duration, signals chan os.Signal) func() {
+	return flushOnShutdown(tracer, ShutdownOptions{FlushTimeout: timeout}, signals)
+}
+
+// ShutdownOptions holds options for HandleShutdownSignals.
+type ShutdownOptions struct {
+	// GracePeriod is the maximum amount of time to wait for
+	// in-flight transactions to end, before flushing the tracer.
+	// If GracePeriod is zero, in-flight transactions are not
+	// waited for, and will not be sent.
+	GracePeriod time.Duration
+
+	// FlushTimeout is the maximum amount of time to wait for
+	// queued events to be sent, after the grace period.
+	FlushTimeout time.Duration
+
+	// NoRedeliver, if true, prevents the received signal from
+	// being redelivered to the process once the tracer has been
+	// closed. This is intended for applications which perform
+	// their own graceful shutdown in response to the signal.
+	NoRedeliver bool
+}
+
+// HandleShutdownSignals installs a handler for SIGTERM and SIGINT which,
+// when either signal is received, stops the tracer from starting new
+// recording transactions, waits up to opts.GracePeriod for in-flight
+// transactions to end, and then flushes and closes the tracer as in
+// FlushOnShutdown. The signal is then redelivered to the process, unless
+// opts.NoRedeliver is set. If tracer is nil, then DefaultTracer is used.
+//
+// This ensures that requests in progress when a process is terminated,
+// e.g. during a rolling deployment, are still reported. The handler is
+// not installed by default, as it changes the process's signal handling.
+//
+// The returned function, intended to be deferred in main, shuts down the
+// tracer in the same way if no signal has been received, and uninstalls
+// the handler.
+func HandleShutdownSignals(tracer *Tracer, opts ShutdownOptions) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	return flushOnShutdown(tracer, opts, signals)
+}
+
+func flushOnShutdown(tracer *Tracer, opts ShutdownOptions, signals chan os.Signal) func() {
+	if tracer == nil {
+		tracer = DefaultTracer
+	}
+	var once sync.Once
+	done := make(chan struct{})
+	shutdown := func() {
+		once.Do(func() {
+			defer close(done)
+			if opts.GracePeriod > 0 {
+				tracer.SetRecording(false)
+				if n := tracer.waitTransactions(opts.GracePeriod); n > 0 {
+					if logger := tracer.currentLogger(); logger != nil {
+						logger.Debugf("%d transaction(s) still in flight after %s, not waiting", n, opts.GracePeriod)
+					}
+				}
+			}
+			abort := make(chan struct{})
+			timer := time.AfterFunc(opts.FlushTimeout, func() { close(abort) })
+			defer timer.Stop()
+			tracer.Flush(abort)
+			tracer.Close()
+		})
+	}
+	if signals != nil {
+		go func() {
+			select {
+			case sig := <-signals:
+				shutdown()
+				signal.Stop(signals)
+				if opts.NoRedeliver {
+					return
+				}
+				if p, err := os.FindProcess(os.Getpid()); err == nil {
+					p.Signal(sig)
+				}
+			case <-done:
+				signal.Stop(signals)
+			}
+		}()
+	}
+	return shutdown
+}
+
+// waitTransactions waits up to timeout for all recording transactions
+// to end, returning the number still in flight.
+func (t *Tracer) waitTransactions(timeout time.Duration) int64 {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := atomic.LoadInt64(t.activeTransactions)
+		if n <= 0 || !time.Now().Before(deadline) {
+			return n
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
@@ -0,0 +1,155 @@
+package elasticapm_test
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func TestFlushOnShutdown(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	tracer.Transport = &r
+	tracer.SetFlushInterval(time.Hour)
+
+	shutdown := elasticapm.FlushOnShutdown(tracer, time.Second, nil)
+	tracer.StartTransaction("name", "type").Done(-1)
+	shutdown()
+	shutdown() // idempotent
+	assert.Len(t, r.Payloads(), 1)
+	assertTracerClosed(t, tracer, &r)
+}
+
+func TestFlushOnShutdownSignal(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	tracer.Transport = &r
+	tracer.SetFlushInterval(time.Hour)
+
+	signals := make(chan os.Signal, 1)
+	shutdown := elasticapm.FlushOnShutdown(tracer, time.Second, signals)
+
+	tracer.StartTransaction("name", "type").Done(-1)
+	signals <- fakeSignal{}
+	for len(r.Payloads()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Calling shutdown blocks until the signal-triggered shutdown completes.
+	shutdown()
+	assert.Len(t, r.Payloads(), 1)
+	assertTracerClosed(t, tracer, &r)
+}
+
+func TestHandleShutdownSignalsGracePeriod(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	tracer.Transport = &r
+	tracer.SetFlushInterval(time.Hour)
+
+	shutdown := elasticapm.HandleShutdownSignals(tracer, elasticapm.ShutdownOptions{
+		GracePeriod:  10 * time.Second,
+		FlushTimeout: time.Second,
+	})
+	tx := tracer.StartTransaction("in-flight", "type")
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		shutdown()
+	}()
+
+	// Transactions started after shutdown begins are not recorded.
+	for tracer.Recording() {
+		time.Sleep(time.Millisecond)
+	}
+	tracer.StartTransaction("late", "type").Done(-1)
+	select {
+	case <-shutdownDone:
+		t.Fatal("shutdown completed without waiting for in-flight transaction")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tx.Done(-1)
+	<-shutdownDone
+	payloads := r.Payloads()
+	require.Len(t, payloads, 1)
+	transactions := payloads[0]["transactions"].([]interface{})
+	require.Len(t, transactions, 1)
+	assert.Equal(t, "in-flight", transactions[0].(map[string]interface{})["name"])
+	assertTracerClosed(t, tracer, &r)
+}
+
+func TestHandleShutdownSignalsGracePeriodExceeded(t *testing.T) {
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	tracer.Transport = &r
+	tracer.SetFlushInterval(time.Hour)
+
+	shutdown := elasticapm.HandleShutdownSignals(tracer, elasticapm.ShutdownOptions{
+		GracePeriod:  50 * time.Millisecond,
+		FlushTimeout: time.Second,
+	})
+	tracer.StartTransaction("name", "type").Done(-1)
+	tracer.StartTransaction("never-ended", "type")
+	shutdown()
+	assert.Len(t, r.Payloads(), 1)
+	assertTracerClosed(t, tracer, &r)
+}
+
+func TestHandleShutdownSignalsSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals cannot be sent to the current process on Windows")
+	}
+	var r transporttest.RecorderTransport
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	tracer.Transport = &r
+	tracer.SetFlushInterval(time.Hour)
+
+	// NoRedeliver is required, or the test process would be terminated.
+	shutdown := elasticapm.HandleShutdownSignals(tracer, elasticapm.ShutdownOptions{
+		GracePeriod:  time.Second,
+		FlushTimeout: time.Second,
+		NoRedeliver:  true,
+	})
+	tracer.StartTransaction("name", "type").Done(-1)
+	p, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, p.Signal(syscall.SIGTERM))
+	for len(r.Payloads()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Calling shutdown blocks until the signal-triggered shutdown completes.
+	shutdown()
+	assert.Len(t, r.Payloads(), 1)
+	assertTracerClosed(t, tracer, &r)
+}
+
+func assertTracerClosed(t *testing.T, tracer *elasticapm.Tracer, r *transporttest.RecorderTransport) {
+	// Flush returns immediately once the tracer is closed,
+	// so the new transaction must not be sent.
+	n := len(r.Payloads())
+	tracer.StartTransaction("name", "type").Done(-1)
+	tracer.Flush(nil)
+	assert.Len(t, r.Payloads(), n)
+}
+
+// fakeSignal is an os.Signal that cannot be delivered to a process,
+// so redelivery after shutdown has no effect.
+type fakeSignal struct{}
+
+func (fakeSignal) String() string { return "fake" }
+func (fakeSignal) Signal()        {}
@@ -0,0 +1,131 @@
+package stacktrace
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/elastic/apm-agent-go/model"
+)
+
+// maxCachedCallers is the maximum number of program
+// counters for which resolved frames are cached.
+const maxCachedCallers = 10000
+
+var callerFrames = newFrameCache(maxCachedCallers)
+
+// frameCache is a size-bounded cache of stack frames resolved
+// for program counters. The cache is cleared when it is full;
+// the set of program counters in a program is fixed, so this
+// should only happen for programs with very many call sites.
+type frameCache struct {
+	mu     sync.RWMutex
+	max    int
+	frames map[uintptr][]model.StacktraceFrame
+}
+
+func newFrameCache(max int) *frameCache {
+	return &frameCache{
+		max:    max,
+		frames: make(map[uintptr][]model.StacktraceFrame),
+	}
+}
+
+// get returns the cached frames for pc. The returned slice
+// must not be modified.
+func (c *frameCache) get(pc uintptr) ([]model.StacktraceFrame, bool) {
+	c.mu.RLock()
+	frames, ok := c.frames[pc]
+	c.mu.RUnlock()
+	return frames, ok
+}
+
+// add caches the frames for pc.
+func (c *frameCache) add(pc uintptr, frames []model.StacktraceFrame) {
+	c.mu.Lock()
+	if len(c.frames) >= c.max {
+		c.frames = make(map[uintptr][]model.StacktraceFrame)
+	}
+	c.frames[pc] = frames
+	c.mu.Unlock()
+}
+
+// fileCache is a size-bounded LRU cache of file contents, split
+// into lines. The size of the cache is measured as the total
+// number of bytes in the cached lines.
+type fileCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	lru      list.List
+}
+
+type fileCacheEntry struct {
+	path    string
+	modTime time.Time
+	size    int64
+	lines   []string
+}
+
+func newFileCache(maxBytes int64) *fileCache {
+	return &fileCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached lines for the file with the given path,
+// if there is a cache entry with the given modification time.
+// Entries with a different modification time are invalidated.
+func (c *fileCache) get(path string, modTime time.Time) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*fileCacheEntry)
+	if !entry.modTime.Equal(modTime) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.lines, true
+}
+
+// add adds the lines for the file with the given path and
+// modification time to the cache, evicting the least recently
+// used entries as necessary to remain within c.maxBytes. Files
+// larger than c.maxBytes are not cached.
+func (c *fileCache) add(path string, modTime time.Time, lines []string) {
+	var size int64
+	for _, line := range lines {
+		size += int64(len(line))
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		c.remove(elem)
+	}
+	for c.size+size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+	c.entries[path] = c.lru.PushFront(&fileCacheEntry{
+		path:    path,
+		modTime: modTime,
+		size:    size,
+		lines:   lines,
+	})
+	c.size += size
+}
+
+func (c *fileCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*fileCacheEntry)
+	delete(c.entries, entry.path)
+	c.size -= entry.size
+}
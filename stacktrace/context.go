@@ -33,11 +33,29 @@ func FileSystemContextSetter(fs http.FileSystem) ContextSetter {
 	if fs == nil {
 		panic("fs is nil")
 	}
-	return &fileSystemContextSetter{fs}
+	return &fileSystemContextSetter{FileSystem: fs}
+}
+
+// CachingFileSystemContextSetter returns a ContextSetter that sets
+// context by reading file contents from the provided http.FileSystem,
+// caching the contents of recently used files.
+//
+// The cache is bounded to approximately maxCacheBytes bytes of file
+// content, evicting the least recently used files first. Cached file
+// contents are invalidated when the file's modification time changes.
+func CachingFileSystemContextSetter(fs http.FileSystem, maxCacheBytes int64) ContextSetter {
+	if fs == nil {
+		panic("fs is nil")
+	}
+	return &fileSystemContextSetter{
+		FileSystem: fs,
+		cache:      newFileCache(maxCacheBytes),
+	}
 }
 
 type fileSystemContextSetter struct {
 	http.FileSystem
+	cache *fileCache
 }
 
 func (s *fileSystemContextSetter) SetContext(frame *model.StacktraceFrame, pre, post int) error {
@@ -52,6 +70,9 @@ func (s *fileSystemContextSetter) SetContext(frame *model.StacktraceFrame, pre,
 		return err
 	}
 	defer f.Close()
+	if s.cache != nil {
+		return s.setContextCached(f, frame, pre, post)
+	}
 
 	var lineno int
 	var line string
@@ -81,3 +102,43 @@ func (s *fileSystemContextSetter) SetContext(frame *model.StacktraceFrame, pre,
 	frame.PostContext = postLines
 	return nil
 }
+
+func (s *fileSystemContextSetter) setContextCached(f http.File, frame *model.StacktraceFrame, pre, post int) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	lines, ok := s.cache.get(frame.AbsolutePath, info.ModTime())
+	if !ok {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		s.cache.add(frame.AbsolutePath, info.ModTime(), lines)
+	}
+
+	// The cached lines are shared, so they are copied into the frame.
+	var line string
+	preLines := make([]string, 0, pre)
+	postLines := make([]string, 0, post)
+	if frame.Line <= len(lines) {
+		line = lines[frame.Line-1]
+		start := frame.Line - 1 - pre
+		if start < 0 {
+			start = 0
+		}
+		preLines = append(preLines, lines[start:frame.Line-1]...)
+		end := frame.Line + post
+		if end > len(lines) {
+			end = len(lines)
+		}
+		postLines = append(postLines, lines[frame.Line:end]...)
+	}
+	frame.ContextLine = line
+	frame.PreContext = preLines
+	frame.PostContext = postLines
+	return nil
+}
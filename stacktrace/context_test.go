@@ -3,8 +3,11 @@ package stacktrace_test
 import (
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
@@ -80,3 +83,90 @@ func testSetContext(
 		t.Fatalf("PostContext differs: %s", diff)
 	}
 }
+
+func TestCachingFilesystemContextSetter(t *testing.T) {
+	setter := stacktrace.CachingFileSystemContextSetter(http.Dir("./testdata"), 1024)
+	frame := model.StacktraceFrame{
+		AbsolutePath: "/foo.go",
+		Line:         5,
+	}
+
+	data, err := ioutil.ReadFile("./testdata/foo.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	for i := 0; i < 2; i++ {
+		testSetContext(t, setter, frame, 2, 1,
+			lines[4],
+			lines[2:4],
+			lines[5:],
+		)
+		testSetContext(t, setter, frame, 0, 0, lines[4], []string{}, []string{})
+		testSetContext(t, setter, frame, 500, 0, lines[4], lines[:4], []string{})
+		testSetContext(t, setter, frame, 0, 500, lines[4], []string{}, lines[5:])
+	}
+}
+
+func TestCachingFilesystemContextSetterModTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacktrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "foo.go")
+	writeFile := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	setter := stacktrace.CachingFileSystemContextSetter(http.Dir(dir), 1024)
+	frame := model.StacktraceFrame{AbsolutePath: "/foo.go", Line: 1}
+	writeFile("first", modTime)
+	testSetContext(t, setter, frame, 0, 0, "first", []string{}, []string{})
+
+	// The file contents are cached until the modification time changes.
+	writeFile("second", modTime)
+	testSetContext(t, setter, frame, 0, 0, "first", []string{}, []string{})
+	writeFile("second", modTime.Add(time.Second))
+	testSetContext(t, setter, frame, 0, 0, "second", []string{}, []string{})
+}
+
+func TestCachingFilesystemContextSetterEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacktrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeFile := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The cache can hold only one of the files at a time.
+	setter := stacktrace.CachingFileSystemContextSetter(http.Dir(dir), 12)
+	a := model.StacktraceFrame{AbsolutePath: "/a.go", Line: 1}
+	b := model.StacktraceFrame{AbsolutePath: "/b.go", Line: 1}
+	writeFile("a.go", "aaaaaaaa")
+	writeFile("b.go", "bbbbbbbb")
+	testSetContext(t, setter, a, 0, 0, "aaaaaaaa", []string{}, []string{})
+	testSetContext(t, setter, b, 0, 0, "bbbbbbbb", []string{}, []string{})
+
+	// a.go was evicted when b.go was cached, so
+	// changes to its contents are observed.
+	writeFile("a.go", "AAAAAAAA")
+	writeFile("b.go", "BBBBBBBB")
+	testSetContext(t, setter, a, 0, 0, "AAAAAAAA", []string{}, []string{})
+}
@@ -22,6 +22,20 @@ import (
 // See RuntimeStacktraceFrame for information on what
 // details are included.
 func Stacktrace(skip, n int) []model.StacktraceFrame {
+	return Callers(RuntimeCallers(skip+1, n))
+}
+
+// RuntimeCallers returns a slice of at most n program
+// counter values for the calling goroutine's stack,
+// skipping skip frames starting with RuntimeCallers.
+// If n is negative, then all program counter values
+// will be returned.
+//
+// RuntimeCallers is cheaper than Stacktrace, as the
+// program counters are not resolved to frames. The
+// result may later be passed to Callers to obtain
+// the stack frames.
+func RuntimeCallers(skip, n int) []uintptr {
 	if n == 0 {
 		return nil
 	}
@@ -42,20 +56,48 @@ func Stacktrace(skip, n int) []model.StacktraceFrame {
 			pc = append(pc, 0)
 		}
 	}
-	return Callers(pc)
+	return pc
 }
 
 // Callers returns a slice of StacktraceFrame
-// for the given callers (program counter values).
+// for the given callers (program counter values),
+// as returned by runtime.Callers. A frame is
+// returned for each inlined call, as well as for
+// each physical frame.
 //
 // See RuntimeStacktraceFrame for information on what
 // details are included.
+//
+// The frames resolved for each program counter are
+// cached, as symbolization is relatively expensive,
+// and the same call sites tend to recur.
 func Callers(callers []uintptr) []model.StacktraceFrame {
 	if len(callers) == 0 {
 		return nil
 	}
-	frames := runtime.CallersFrames(callers)
 	out := make([]model.StacktraceFrame, 0, len(callers))
+	for _, pc := range callers {
+		frames, ok := callerFrames.get(pc)
+		if !ok {
+			frames = resolveCaller(pc)
+			callerFrames.add(pc, frames)
+		}
+		out = append(out, frames...)
+	}
+	return out
+}
+
+// resolveCaller returns the stack frames for the program
+// counter pc. There may be multiple frames if pc falls
+// within inlined calls.
+//
+// Program counters returned by runtime.Callers are resolved
+// independently by runtime.CallersFrames, so resolving them
+// one at a time gives the same result as resolving them all
+// at once.
+func resolveCaller(pc uintptr) []model.StacktraceFrame {
+	var out []model.StacktraceFrame
+	frames := runtime.CallersFrames([]uintptr{pc})
 	for {
 		frame, more := frames.Next()
 		out = append(out, RuntimeStacktraceFrame(&frame))
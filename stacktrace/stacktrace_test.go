@@ -1,6 +1,7 @@
 package stacktrace_test
 
 import (
+	"runtime"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -46,6 +47,49 @@ func (*panicker) panic() {
 	panic("oh noes")
 }
 
+func TestCallersCached(t *testing.T) {
+	callers := stacktrace.RuntimeCallers(0, -1)
+	var expect []string
+	frames := runtime.CallersFrames(callers)
+	for {
+		frame, more := frames.Next()
+		expect = append(expect, frame.Function)
+		if !more {
+			break
+		}
+	}
+
+	// The second call uses cached frames,
+	// which must give the same result.
+	for i := 0; i < 2; i++ {
+		var got []string
+		for _, frame := range stacktrace.Callers(callers) {
+			got = append(got, frame.Module+"."+frame.Function)
+		}
+		if diff := cmp.Diff(got, expect); diff != "" {
+			t.Fatalf("%s", diff)
+		}
+	}
+}
+
+func TestCallersInlined(t *testing.T) {
+	callers := runtimeCallersInlined()
+	var got []string
+	for _, frame := range stacktrace.Callers(callers)[:2] {
+		got = append(got, frame.Function)
+	}
+	if diff := cmp.Diff(got, []string{"runtimeCallersInlined", "TestCallersInlined"}); diff != "" {
+		t.Fatalf("%s", diff)
+	}
+}
+
+// runtimeCallersInlined is small enough to be inlined, and is
+// expected to be by the gc compiler. The frames of inlined calls
+// must be reported, as runtime.CallersFrames does.
+func runtimeCallersInlined() []uintptr {
+	return stacktrace.RuntimeCallers(1, -1)
+}
+
 func TestSplitFunctionName(t *testing.T) {
 	testSplitFunctionName(t, "main", "main")
 	testSplitFunctionName(t, "main", "Foo.Bar")
@@ -80,3 +124,19 @@ func assertFunction(t *testing.T, got, expect string) {
 		t.Errorf("got function %q, expected %q", got, expect)
 	}
 }
+
+func BenchmarkStacktrace(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stacktrace.Stacktrace(0, -1)
+	}
+}
+
+func BenchmarkCallers(b *testing.B) {
+	callers := stacktrace.RuntimeCallers(0, -1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stacktrace.Callers(callers)
+	}
+}
@@ -1,5 +1,7 @@
 package elasticapm
 
+import "sync/atomic"
+
 // TracerStats holds statistics for a Tracer.
 type TracerStats struct {
 	Errors              TracerStatsErrors
@@ -7,6 +9,81 @@ type TracerStats struct {
 	ErrorsDropped       uint64
 	TransactionsSent    uint64
 	TransactionsDropped uint64
+
+	// EventsRejected records the number of events rejected
+	// by the server, e.g. due to failing validation. Requests
+	// including rejected events are not retried, and rejected
+	// events are not counted in TransactionsSent or ErrorsSent.
+	EventsRejected uint64
+
+	// CircuitBreakerOpened records the number of times the
+	// circuit breaker has been opened due to repeated send
+	// failures.
+	CircuitBreakerOpened uint64
+
+	// CircuitBreakerOpen reports whether or not the circuit
+	// breaker is currently open, meaning that events are
+	// being dropped rather than sent.
+	CircuitBreakerOpen bool
+
+	// Spans holds span accounting statistics, accumulated
+	// across all transactions.
+	Spans TracerStatsSpans
+
+	// TransactionDrops breaks down, by cause, the transactions
+	// that were dropped or discarded, or sent without detail.
+	TransactionDrops TracerStatsTransactionDrops
+}
+
+// TracerStatsTransactionDrops holds the number of transactions that
+// were not sent in full, by cause, for diagnosing missing data.
+type TracerStatsTransactionDrops struct {
+	// BufferFull records the number of transactions dropped
+	// due to the tracer's buffer or queue being full, e.g.
+	// because the server is slow or unreachable.
+	//
+	// BufferFull is included in TracerStats.TransactionsDropped.
+	BufferFull uint64
+
+	// CircuitOpen records the number of transactions dropped
+	// due to the circuit breaker being open, following
+	// repeated send failures.
+	//
+	// CircuitOpen is included in TracerStats.TransactionsDropped.
+	CircuitOpen uint64
+
+	// NotSampled records the number of transactions which were
+	// not sampled, and so were sent without their context and
+	// spans.
+	NotSampled uint64
+
+	// Discarded records the number of transactions discarded
+	// without being sent, due to being started while the tracer
+	// was not recording.
+	Discarded uint64
+}
+
+// TracerStatsSpans holds span accounting statistics for a Tracer,
+// for tuning the maximum number of spans per transaction.
+type TracerStatsSpans struct {
+	// Started records the number of spans started within
+	// sampled transactions, including those dropped.
+	Started uint64
+
+	// Recorded records the number of spans recorded in
+	// transactions enqueued for sending.
+	Recorded uint64
+
+	// DroppedMaxSpans records the number of spans dropped
+	// due to their transaction reaching the span limit set
+	// by Tracer.SetMaxSpans.
+	DroppedMaxSpans uint64
+
+	// DroppedTransactionEnded records the number of spans
+	// dropped due to being started after their transaction
+	// ended, either by the application or by the tracer for
+	// exceeding the maximum duration.
+	DroppedTransactionEnded uint64
 }
 
 // TracerStatsErrors holds error statistics for a Tracer.
@@ -14,6 +91,7 @@ type TracerStatsErrors struct {
 	SetContext       uint64
 	SendTransactions uint64
 	SendErrors       uint64
+	SendMetrics      uint64
 }
 
 func (s TracerStats) isZero() bool {
@@ -26,8 +104,48 @@ func (s *TracerStats) accumulate(rhs TracerStats) {
 	s.Errors.SetContext += rhs.Errors.SetContext
 	s.Errors.SendTransactions += rhs.Errors.SendTransactions
 	s.Errors.SendErrors += rhs.Errors.SendErrors
+	s.Errors.SendMetrics += rhs.Errors.SendMetrics
 	s.ErrorsSent += rhs.ErrorsSent
 	s.ErrorsDropped += rhs.ErrorsDropped
 	s.TransactionsSent += rhs.TransactionsSent
 	s.TransactionsDropped += rhs.TransactionsDropped
+	s.EventsRejected += rhs.EventsRejected
+	s.CircuitBreakerOpened += rhs.CircuitBreakerOpened
+}
+
+// spanStats holds span accounting statistics, updated atomically
+// in the span lifecycle. The fields correspond to TracerStatsSpans.
+type spanStats struct {
+	started                 uint64
+	recorded                uint64
+	droppedMaxSpans         uint64
+	droppedTransactionEnded uint64
+}
+
+// transactionDropStats holds the number of transactions dropped by
+// cause, updated atomically. The fields correspond to
+// TracerStatsTransactionDrops.
+type transactionDropStats struct {
+	bufferFull  uint64
+	circuitOpen uint64
+	notSampled  uint64
+	discarded   uint64
+}
+
+func (s *transactionDropStats) load() TracerStatsTransactionDrops {
+	return TracerStatsTransactionDrops{
+		BufferFull:  atomic.LoadUint64(&s.bufferFull),
+		CircuitOpen: atomic.LoadUint64(&s.circuitOpen),
+		NotSampled:  atomic.LoadUint64(&s.notSampled),
+		Discarded:   atomic.LoadUint64(&s.discarded),
+	}
+}
+
+func (s *spanStats) load() TracerStatsSpans {
+	return TracerStatsSpans{
+		Started:                 atomic.LoadUint64(&s.started),
+		Recorded:                atomic.LoadUint64(&s.recorded),
+		DroppedMaxSpans:         atomic.LoadUint64(&s.droppedMaxSpans),
+		DroppedTransactionEnded: atomic.LoadUint64(&s.droppedTransactionEnded),
+	}
 }
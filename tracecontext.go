@@ -0,0 +1,181 @@
+package elasticapm
+
+import (
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// TraceParentHeader returns the W3C Trace Context traceparent string for
+// tx, identifying tx as the parent of any operations it is propagated to:
+// "00-<trace-id>-<span-id>-<trace-options>". The string may be propagated
+// over any transport, and parsed with ParseTraceParentHeader to continue
+// the trace with StartTransactionOptions.
+func TraceParentHeader(tx *Transaction) string {
+	return FormatTraceParentHeader(TransactionTraceContext(tx))
+}
+
+// TransactionTraceContext returns the trace context identifying tx as
+// the parent of any operations it is propagated to, as formatted by
+// TraceParentHeader, along with the trace's state and baggage. Unlike
+// tx.TraceContext, the Span field holds the transaction's own ID.
+func TransactionTraceContext(tx *Transaction) TraceContext {
+	c := tx.traceContext
+	c.Span = tx.spanID
+	return c
+}
+
+// FormatTraceParentHeader formats the given trace context as a
+// traceparent string: "00-<trace-id>-<span-id>-<trace-options>".
+func FormatTraceParentHeader(c TraceContext) string {
+	const version = 0
+	var buf [55]byte
+	hex.Encode(buf[0:2], []byte{version})
+	buf[2] = '-'
+	hex.Encode(buf[3:35], c.Trace[:])
+	buf[35] = '-'
+	hex.Encode(buf[36:52], c.Span[:])
+	buf[52] = '-'
+	hex.Encode(buf[53:55], []byte{byte(c.Options)})
+	return string(buf[:])
+}
+
+// ParseTraceParentHeader parses the given traceparent string, which is
+// expected to be in the format "00-<trace-id>-<span-id>-<trace-options>",
+// returning the trace context it holds. Strings with a higher version are
+// parsed as far as the version 00 fields, as long as any additional fields
+// are separated by a "-".
+func ParseTraceParentHeader(h string) (TraceContext, error) {
+	var out TraceContext
+	if len(h) < 3 || h[2] != '-' {
+		return out, errors.New("invalid traceparent header")
+	}
+	var version [1]byte
+	if !strictHexDecode(h[:2], version[:]) {
+		return out, errors.New("invalid traceparent header: version is not hex")
+	}
+	switch {
+	case version[0] == 0xff:
+		return out, errors.New("invalid traceparent header: version 255 is forbidden")
+	case version[0] == 0 && len(h) != 55:
+		return out, errors.Errorf("invalid version 00 traceparent header: expected 55 characters, got %d", len(h))
+	case len(h) < 55 || (len(h) > 55 && h[55] != '-'):
+		return out, errors.New("invalid traceparent header")
+	}
+	if h[35] != '-' || h[52] != '-' {
+		return out, errors.New("invalid traceparent header: malformed field separators")
+	}
+	if !strictHexDecode(h[3:35], out.Trace[:]) {
+		return out, errors.New("invalid traceparent header: trace-id is not hex")
+	}
+	if err := out.Trace.Validate(); err != nil {
+		return out, err
+	}
+	if !strictHexDecode(h[36:52], out.Span[:]) {
+		return out, errors.New("invalid traceparent header: span-id is not hex")
+	}
+	if err := out.Span.Validate(); err != nil {
+		return out, err
+	}
+	var options [1]byte
+	if !strictHexDecode(h[53:55], options[:]) {
+		return out, errors.New("invalid traceparent header: trace-options is not hex")
+	}
+	out.Options = TraceOptions(options[0])
+	return out, nil
+}
+
+// strictHexDecode decodes the lower-case hex string s into out,
+// reporting whether s is valid. Upper-case hex digits are not
+// permitted by the traceparent format.
+func strictHexDecode(s string, out []byte) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	_, err := hex.Decode(out, []byte(s))
+	return err == nil
+}
+
+// TraceContext holds trace context for an incoming or outgoing request.
+type TraceContext struct {
+	// Trace identifies the trace forest.
+	Trace TraceID
+
+	// Span identifies a span: the parent span if this context
+	// corresponds to an incoming request, or the current span
+	// if this is an outgoing request.
+	Span SpanID
+
+	// Options holds the trace options propagated by the parent.
+	Options TraceOptions
+
+	// State holds vendor-specific trace state propagated by the
+	// parent, which should be propagated to downstream services.
+	State TraceState
+
+	// Baggage holds application-defined key/value pairs propagated
+	// by the parent, which should be propagated to downstream services.
+	Baggage Baggage
+}
+
+// TraceID identifies a trace forest.
+type TraceID [16]byte
+
+// Validate validates the trace ID.
+// This will return non-nil for a zero trace ID.
+func (id TraceID) Validate() error {
+	if id == (TraceID{}) {
+		return errors.New("zero trace-id is invalid")
+	}
+	return nil
+}
+
+// String returns id encoded as hex.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// Validate validates the span ID.
+// This will return non-nil for a zero span ID.
+func (id SpanID) Validate() error {
+	if id == (SpanID{}) {
+		return errors.New("zero span-id is invalid")
+	}
+	return nil
+}
+
+// String returns id encoded as hex.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// TraceOptions describes the options for a trace: the trace-flags
+// byte of the W3C Trace Context traceparent header.
+//
+// Only the "sampled" flag is currently defined, but the full byte is
+// parsed, stored, and propagated verbatim, so that flags defined by
+// future versions of the specification are passed on unchanged.
+type TraceOptions uint8
+
+const (
+	traceOptionsSampledFlag TraceOptions = 0x01
+)
+
+// Sampled reports whether or not the trace is sampled.
+func (o TraceOptions) Sampled() bool {
+	return o&traceOptionsSampledFlag == traceOptionsSampledFlag
+}
+
+// WithSampled changes the "sampled" flag, and returns the new TraceOptions.
+// The original value is unchanged.
+func (o TraceOptions) WithSampled(sampled bool) TraceOptions {
+	if sampled {
+		return o | traceOptionsSampledFlag
+	}
+	return o &^ traceOptionsSampledFlag
+}
@@ -0,0 +1,102 @@
+package elasticapm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+func TestFormatTraceParentHeader(t *testing.T) {
+	assert.Equal(t,
+		"00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01",
+		elasticapm.FormatTraceParentHeader(elasticapm.TraceContext{
+			Trace:   elasticapm.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			Span:    elasticapm.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+			Options: elasticapm.TraceOptions(0).WithSampled(true),
+		}),
+	)
+}
+
+func TestParseTraceParentHeader(t *testing.T) {
+	tc, err := elasticapm.ParseTraceParentHeader("00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01")
+	require.NoError(t, err)
+	assert.Equal(t, elasticapm.TraceContext{
+		Trace:   elasticapm.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Span:    elasticapm.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		Options: elasticapm.TraceOptions(0).WithSampled(true),
+	}, tc)
+
+	_, err = elasticapm.ParseTraceParentHeader("00-0102030405060708090a0b0c0d0e0f10-0102030405060708")
+	assert.EqualError(t, err, "invalid version 00 traceparent header: expected 55 characters, got 52")
+
+	// Future versions may add fields.
+	_, err = elasticapm.ParseTraceParentHeader("01-0102030405060708090a0b0c0d0e0f10-0102030405060708-01-extra")
+	assert.NoError(t, err)
+
+	for _, h := range []string{
+		"",
+		"00",
+		"00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01-extra",
+		"ff-0102030405060708090a0b0c0d0e0f10-0102030405060708-01",
+		"00-0102030405060708090A0B0C0D0E0F10-0102030405060708-01",
+		"00-00000000000000000000000000000000-0102030405060708-01",
+		"00-0102030405060708090a0b0c0d0e0f10-0000000000000000-01",
+		"00-0102030405060708090a0b0c0d0e0f10_0102030405060708-01",
+		"00-0102030405060708090a0b0c0d0e0f10-0102030405060708-zz",
+	} {
+		_, err := elasticapm.ParseTraceParentHeader(h)
+		assert.Error(t, err, h)
+	}
+}
+
+func TestTraceParentHeaderPropagation(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.Done(-1)
+	traceparent := elasticapm.TraceParentHeader(tx)
+
+	tc, err := elasticapm.ParseTraceParentHeader(traceparent)
+	require.NoError(t, err)
+	child := tracer.StartTransactionOptions("child", "type", elasticapm.TransactionOptions{
+		TraceContext: tc,
+	})
+	defer child.Done(-1)
+	assert.Equal(t, tx.TraceContext().Trace, child.TraceContext().Trace)
+	assert.True(t, child.Sampled())
+
+	// The child's parent is the original transaction.
+	assert.Equal(t, traceparent, elasticapm.FormatTraceParentHeader(child.TraceContext()))
+	assert.Equal(t, child.TraceContext().Span, elasticapm.TransactionTraceContext(tx).Span)
+}
+
+func TestTraceParentHeaderUnknownFlags(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	// Flags not yet defined by the specification are preserved
+	// through extraction, and propagated verbatim by both sampled
+	// and non-sampled transactions.
+	for _, flags := range []string{"03", "fe"} {
+		tc, err := elasticapm.ParseTraceParentHeader("00-0102030405060708090a0b0c0d0e0f10-0102030405060708-" + flags)
+		require.NoError(t, err)
+		assert.Equal(t, flags == "03", tc.Options.Sampled())
+
+		tx := tracer.StartTransactionOptions("name", "type", elasticapm.TransactionOptions{TraceContext: tc})
+		assert.Equal(t, tc.Options.Sampled(), tx.Sampled())
+		traceparent := elasticapm.TraceParentHeader(tx)
+		assert.Equal(t, flags, traceparent[53:])
+		if span := tx.StartSpan("name", "type", nil); !span.Dropped() {
+			assert.Equal(t, tc.Options, span.TraceContext().Options)
+			span.Done(-1)
+		}
+		tx.Done(-1)
+	}
+	assert.Equal(t, elasticapm.TraceOptions(0xfe), elasticapm.TraceOptions(0xff).WithSampled(false))
+}
@@ -3,7 +3,9 @@ package elasticapm
 import (
 	"context"
 	"log"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -47,7 +49,25 @@ type options struct {
 	flushInterval           time.Duration
 	maxTransactionQueueSize int
 	maxSpans                int
+	maxSpanStacktraces      int
 	sampler                 Sampler
+	recording               bool
+	captureBody             CaptureBodyMode
+	captureBodyLimits       CaptureBodyLimits
+	captureQueryParams      bool
+	apiRequestConcurrency   int
+	apiRequestMinInterval   time.Duration
+	metricsInterval         time.Duration
+	breakdownMetrics        bool
+	metricsExemplars        bool
+	circuitBreaker          circuitBreakerConfig
+	captureEnv              []string
+	topLevelSpans           bool
+	perEventService         bool
+	transactionMaxDuration  time.Duration
+	nestedTransactionSpans  bool
+	captureGoroutines       bool
+	errorRateLimit          int
 }
 
 func (opts *options) init(continueOnError bool) error {
@@ -67,11 +87,100 @@ func (opts *options) init(continueOnError bool) error {
 		maxSpans = defaultMaxSpans
 		errs = append(errs, err)
 	}
+	maxSpanStacktraces, err := initialMaxSpanStacktraces()
+	if err != nil {
+		maxSpanStacktraces = defaultMaxSpanStacktraces
+		errs = append(errs, err)
+	}
 	sampler, err := initialSampler()
 	if err != nil {
 		sampler = nil
 		errs = append(errs, err)
 	}
+	recording, err := initialRecording()
+	if err != nil {
+		recording = defaultRecording
+		errs = append(errs, err)
+	}
+	captureBody, err := initialCaptureBody()
+	if err != nil {
+		captureBody = defaultCaptureBody
+		errs = append(errs, err)
+	}
+	captureBodyLimits, err := initialCaptureBodyLimits()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	captureQueryParams, err := initialCaptureQueryParams()
+	if err != nil {
+		captureQueryParams = defaultCaptureQueryParams
+		errs = append(errs, err)
+	}
+	apiRequestConcurrency, err := initialAPIRequestConcurrency()
+	if err != nil {
+		apiRequestConcurrency = defaultAPIRequestConcurrency
+		errs = append(errs, err)
+	}
+	apiRequestMinInterval, err := initialAPIRequestMinInterval()
+	if err != nil {
+		apiRequestMinInterval = defaultAPIRequestMinInterval
+		errs = append(errs, err)
+	}
+	metricsInterval, err := initialMetricsInterval()
+	if err != nil {
+		metricsInterval = defaultMetricsInterval
+		errs = append(errs, err)
+	}
+	breakdownMetrics, err := initialBreakdownMetrics()
+	if err != nil {
+		breakdownMetrics = defaultBreakdownMetrics
+		errs = append(errs, err)
+	}
+	metricsExemplars, err := initialMetricsExemplars()
+	if err != nil {
+		metricsExemplars = defaultMetricsExemplars
+		errs = append(errs, err)
+	}
+	circuitBreakerThreshold, err := initialCircuitBreakerThreshold()
+	if err != nil {
+		circuitBreakerThreshold = defaultCircuitBreakerThreshold
+		errs = append(errs, err)
+	}
+	circuitBreakerCooldown, err := initialCircuitBreakerCooldown()
+	if err != nil {
+		circuitBreakerCooldown = defaultCircuitBreakerCooldown
+		errs = append(errs, err)
+	}
+	topLevelSpans, err := initialTopLevelSpans()
+	if err != nil {
+		topLevelSpans = defaultTopLevelSpans
+		errs = append(errs, err)
+	}
+	perEventService, err := initialPerEventService()
+	if err != nil {
+		perEventService = defaultPerEventService
+		errs = append(errs, err)
+	}
+	transactionMaxDuration, err := initialTransactionMaxDuration()
+	if err != nil {
+		transactionMaxDuration = defaultTransactionMaxDuration
+		errs = append(errs, err)
+	}
+	nestedTransactionSpans, err := initialNestedTransactionSpans()
+	if err != nil {
+		nestedTransactionSpans = defaultNestedTransactionSpans
+		errs = append(errs, err)
+	}
+	captureGoroutines, err := initialCaptureGoroutines()
+	if err != nil {
+		captureGoroutines = defaultCaptureGoroutines
+		errs = append(errs, err)
+	}
+	errorRateLimit, err := initialErrorRateLimit()
+	if err != nil {
+		errorRateLimit = defaultErrorRateLimit
+		errs = append(errs, err)
+	}
 	if len(errs) != 0 && !continueOnError {
 		return errs[0]
 	}
@@ -81,7 +190,28 @@ func (opts *options) init(continueOnError bool) error {
 	opts.flushInterval = flushInterval
 	opts.maxTransactionQueueSize = maxTransactionQueueSize
 	opts.maxSpans = maxSpans
+	opts.maxSpanStacktraces = maxSpanStacktraces
 	opts.sampler = sampler
+	opts.recording = recording
+	opts.captureBody = captureBody
+	opts.captureBodyLimits = captureBodyLimits
+	opts.captureQueryParams = captureQueryParams
+	opts.apiRequestConcurrency = apiRequestConcurrency
+	opts.apiRequestMinInterval = apiRequestMinInterval
+	opts.metricsInterval = metricsInterval
+	opts.breakdownMetrics = breakdownMetrics
+	opts.metricsExemplars = metricsExemplars
+	opts.circuitBreaker = circuitBreakerConfig{
+		threshold: circuitBreakerThreshold,
+		cooldown:  circuitBreakerCooldown,
+	}
+	opts.captureEnv = initialCaptureEnv()
+	opts.topLevelSpans = topLevelSpans
+	opts.perEventService = perEventService
+	opts.transactionMaxDuration = transactionMaxDuration
+	opts.nestedTransactionSpans = nestedTransactionSpans
+	opts.captureGoroutines = captureGoroutines
+	opts.errorRateLimit = errorRateLimit
 	return nil
 }
 
@@ -109,7 +239,13 @@ type Tracer struct {
 	Service   *model.Service
 
 	process *model.Process
-	system  *model.System
+
+	// initialAgentConfig holds the agent configuration which
+	// cannot change after the tracer is created; see agentConfig.
+	initialAgentConfig map[string]string
+
+	systemMu sync.RWMutex
+	system   *model.System
 
 	closing                    chan struct{}
 	closed                     chan struct{}
@@ -117,6 +253,10 @@ type Tracer struct {
 	setFlushInterval           chan time.Duration
 	setMaxTransactionQueueSize chan int
 	setMaxErrorQueueSize       chan int
+	setAPIRequestConcurrency   chan int
+	setAPIRequestMinInterval   chan time.Duration
+	setMetricsInterval         chan time.Duration
+	setCircuitBreaker          chan circuitBreakerConfig
 	setPreContext              chan int
 	setPostContext             chan int
 	setContextSetter           chan stacktrace.ContextSetter
@@ -125,18 +265,72 @@ type Tracer struct {
 	transactions               chan *Transaction
 	errors                     chan *Error
 
-	statsMu sync.Mutex
-	stats   TracerStats
+	statsMu                 sync.Mutex
+	stats                   TracerStats
+	spanStats               *spanStats
+	transactionDropStats    *transactionDropStats
+	circuitBreakerOpenUntil time.Time
+
+	// activeTransactions holds the number of recording transactions
+	// which have been started but not ended, updated atomically. It
+	// is allocated separately to guarantee 64-bit alignment.
+	activeTransactions *int64
 
 	maxSpansMu sync.RWMutex
 	maxSpans   int
 
+	maxSpanStacktracesMu sync.RWMutex
+	maxSpanStacktraces   int
+
 	samplerMu sync.RWMutex
 	sampler   Sampler
 
-	errorPool       sync.Pool
-	spanPool        sync.Pool
-	transactionPool sync.Pool
+	idGeneratorMu sync.RWMutex
+	idGenerator   IDGenerator
+
+	recordingMu sync.RWMutex
+	recording   bool
+
+	captureBodyMu      sync.RWMutex
+	captureBody        CaptureBodyMode
+	captureBodyLimits  CaptureBodyLimits
+	captureQueryParams bool
+
+	topLevelSpansMu sync.RWMutex
+	topLevelSpans   bool
+
+	perEventServiceMu sync.RWMutex
+	perEventService   bool
+
+	agentMu sync.RWMutex
+	agent   *model.Agent
+
+	transactionMaxDurationMu sync.RWMutex
+	transactionMaxDuration   time.Duration
+
+	captureGoroutinesMu sync.RWMutex
+	captureGoroutines   bool
+
+	// loggerMu guards logger, the Logger set with SetLogger, for
+	// logging outside the tracer loop, which has its own copy.
+	loggerMu sync.RWMutex
+	logger   Logger
+
+	nestedTransactionsMu   sync.Mutex
+	nestedTransactionSpans bool
+	nestedTransactionSites map[uintptr]bool
+
+	useAfterEndMu    sync.Mutex
+	useAfterEndSites map[uintptr]bool
+
+	metricsGatherersMu sync.Mutex
+	metricsGatherers   []MetricsGatherer
+	breakdownMetrics   *breakdownMetrics
+	errorRateLimiter   *errorRateLimiter
+
+	errorPool             sync.Pool
+	spanBufferPool        sync.Pool
+	transactionBufferPool sync.Pool
 }
 
 // NewTracer returns a new Tracer, using the default transport,
@@ -169,12 +363,17 @@ func newTracer(service *model.Service, opts options) *Tracer {
 		Service:                    service,
 		process:                    &currentProcess,
 		system:                     &localSystem,
+		initialAgentConfig:         initialAgentConfig(opts, os.Environ()),
 		closing:                    make(chan struct{}),
 		closed:                     make(chan struct{}),
 		forceFlush:                 make(chan chan<- struct{}),
 		setFlushInterval:           make(chan time.Duration),
 		setMaxTransactionQueueSize: make(chan int),
 		setMaxErrorQueueSize:       make(chan int),
+		setAPIRequestConcurrency:   make(chan int),
+		setAPIRequestMinInterval:   make(chan time.Duration),
+		setMetricsInterval:         make(chan time.Duration),
+		setCircuitBreaker:          make(chan circuitBreakerConfig),
 		setPreContext:              make(chan int),
 		setPostContext:             make(chan int),
 		setContextSetter:           make(chan stacktrace.ContextSetter),
@@ -182,13 +381,41 @@ func newTracer(service *model.Service, opts options) *Tracer {
 		setProcessor:               make(chan Processor),
 		transactions:               make(chan *Transaction, transactionsChannelCap),
 		errors:                     make(chan *Error, errorsChannelCap),
+		spanStats:                  &spanStats{},
+		transactionDropStats:       &transactionDropStats{},
+		activeTransactions:         new(int64),
 		maxSpans:                   opts.maxSpans,
+		maxSpanStacktraces:         opts.maxSpanStacktraces,
 		sampler:                    opts.sampler,
+		idGenerator:                randomIDGenerator{},
+		recording:                  opts.recording,
+		captureBody:                opts.captureBody,
+		captureBodyLimits:          opts.captureBodyLimits,
+		captureQueryParams:         opts.captureQueryParams,
+		topLevelSpans:              opts.topLevelSpans,
+		perEventService:            opts.perEventService,
+		transactionMaxDuration:     opts.transactionMaxDuration,
+		nestedTransactionSpans:     opts.nestedTransactionSpans,
+		captureGoroutines:          opts.captureGoroutines,
+		breakdownMetrics:           newBreakdownMetrics(opts.breakdownMetrics, opts.metricsExemplars),
+		errorRateLimiter:           newErrorRateLimiter(opts.errorRateLimit, defaultErrorRateLimitInterval),
+	}
+	if len(opts.captureEnv) > 0 {
+		process := currentProcess
+		process.Env = captureEnv(os.Environ(), opts.captureEnv)
+		t.process = &process
 	}
+	t.RegisterMetricsGatherer(builtinMetricsGatherer{})
+	t.RegisterMetricsGatherer(t.breakdownMetrics)
+	t.RegisterMetricsGatherer(t.errorRateLimiter)
 	go t.loop()
 	t.setFlushInterval <- opts.flushInterval
 	t.setMaxTransactionQueueSize <- opts.maxTransactionQueueSize
 	t.setMaxErrorQueueSize <- defaultMaxErrorQueueSize
+	t.setAPIRequestConcurrency <- opts.apiRequestConcurrency
+	t.setAPIRequestMinInterval <- opts.apiRequestMinInterval
+	t.setMetricsInterval <- opts.metricsInterval
+	t.setCircuitBreaker <- opts.circuitBreaker
 	t.setPreContext <- defaultPreContext
 	t.setPostContext <- defaultPostContext
 	return t
@@ -253,6 +480,69 @@ func (t *Tracer) SetMaxErrorQueueSize(n int) {
 	}
 }
 
+// SetMetricsInterval sets the metrics interval -- the amount of time
+// between metrics gathering and sending to the APM server. If set to
+// a non-positive value, metrics will not be gathered.
+func (t *Tracer) SetMetricsInterval(d time.Duration) {
+	select {
+	case t.setMetricsInterval <- d:
+	case <-t.closing:
+	case <-t.closed:
+	}
+}
+
+// SetCircuitBreaker configures the tracer's circuit breaker, which
+// stops the tracer from sending to the APM server for the cooldown
+// period once threshold consecutive requests have failed. While the
+// circuit is open, transactions, errors, and metrics are dropped
+// without being encoded. After the cooldown period, sending resumes;
+// if the next request fails, the circuit is opened again.
+//
+// If threshold is non-positive, which is the default, the circuit
+// breaker is disabled.
+func (t *Tracer) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	cfg := circuitBreakerConfig{threshold: threshold, cooldown: cooldown}
+	select {
+	case t.setCircuitBreaker <- cfg:
+	case <-t.closing:
+	case <-t.closed:
+	}
+}
+
+// SetAPIRequestConcurrency sets the maximum number of concurrent
+// requests to the APM server. If set to a non-positive value, the
+// concurrency will be set to 1, meaning that only one request will
+// be made at a time.
+//
+// While the maximum number of requests are in progress, transactions
+// and errors will be buffered in a fixed-size channel. Once that is
+// full, new transactions and errors will be dropped.
+func (t *Tracer) SetAPIRequestConcurrency(n int) {
+	select {
+	case t.setAPIRequestConcurrency <- n:
+	case <-t.closing:
+	case <-t.closed:
+	}
+}
+
+// SetAPIRequestMinInterval sets the minimum interval between requests
+// to the APM server for sending transactions and errors. Events queued
+// within the interval following a request are coalesced into the next
+// request, so that a burst of events does not cause a burst of small
+// requests. If set to a non-positive value, requests are made as soon
+// as events are ready to be sent.
+//
+// Coalescing is bounded by the transaction and error queue sizes: once
+// a queue is full, its events are sent without waiting for the interval
+// to elapse. Explicit calls to Flush also do not wait.
+func (t *Tracer) SetAPIRequestMinInterval(d time.Duration) {
+	select {
+	case t.setAPIRequestMinInterval <- d:
+	case <-t.closing:
+	case <-t.closed:
+	}
+}
+
 // SetContextSetter sets the stacktrace.ContextSetter to be used for
 // setting stacktrace source context. If nil (which is the initial
 // value), no context will be set.
@@ -267,6 +557,9 @@ func (t *Tracer) SetContextSetter(setter stacktrace.ContextSetter) {
 // SetLogger sets the Logger to be used for logging the operation of
 // the tracer.
 func (t *Tracer) SetLogger(logger Logger) {
+	t.loggerMu.Lock()
+	t.logger = logger
+	t.loggerMu.Unlock()
 	select {
 	case t.setLogger <- logger:
 	case <-t.closing:
@@ -274,6 +567,15 @@ func (t *Tracer) SetLogger(logger Logger) {
 	}
 }
 
+// currentLogger returns the Logger set with SetLogger, for logging
+// outside the tracer loop, e.g. from application goroutines.
+func (t *Tracer) currentLogger() Logger {
+	t.loggerMu.RLock()
+	logger := t.logger
+	t.loggerMu.RUnlock()
+	return logger
+}
+
 // SetProcessor sets the processors for the tracer.
 func (t *Tracer) SetProcessor(p ...Processor) {
 	var processor Processor
@@ -298,21 +600,218 @@ func (t *Tracer) SetSampler(s Sampler) {
 // SetMaxSpans sets the maximum number of spans that will be added
 // to a transaction before dropping. If set to a non-positive value,
 // the number of spans is unlimited.
+//
+// Spans started after the limit is reached are returned as dropped
+// spans, whose methods are no-ops, and are counted in the transaction's
+// SpanCount.Dropped.Total.
 func (t *Tracer) SetMaxSpans(n int) {
 	t.maxSpansMu.Lock()
 	t.maxSpans = n
 	t.maxSpansMu.Unlock()
 }
 
+// SetMaxSpanStacktraces sets the maximum number of spans within a
+// transaction that will have their stacktraces reported. If more
+// spans than this have stacktraces set, only the stacktraces of the
+// longest-running spans will be reported. If set to a non-positive
+// value, the number of span stacktraces is unlimited.
+func (t *Tracer) SetMaxSpanStacktraces(n int) {
+	t.maxSpanStacktracesMu.Lock()
+	t.maxSpanStacktraces = n
+	t.maxSpanStacktracesMu.Unlock()
+}
+
+// SetRecording sets whether or not the tracer is recording. While
+// the tracer is not recording, new transactions are inert: they are
+// not sampled, and will not be sent to the APM server; errors sent
+// while not recording are discarded. Transactions started before
+// recording is disabled will continue to be recorded and sent.
+//
+// Recording differs from sampling: the sampling decision is made
+// per trace and propagated to downstream services, whereas recording
+// is a local, process-wide toggle. A transaction will have its spans
+// and context recorded only if the tracer is recording at the time
+// the transaction is started, and the transaction is sampled.
+func (t *Tracer) SetRecording(recording bool) {
+	t.recordingMu.Lock()
+	t.recording = recording
+	t.recordingMu.Unlock()
+}
+
+// Recording reports whether or not the tracer is recording.
+func (t *Tracer) Recording() bool {
+	t.recordingMu.RLock()
+	recording := t.recording
+	t.recordingMu.RUnlock()
+	return recording
+}
+
+// SetTopLevelSpans sets whether or not spans should be sent to the
+// APM server independently of their transactions, rather than nested
+// within them. Each span will reference its transaction by ID.
+//
+// Spans will only be sent independently if the tracer's Transport
+// implements transport.SpansTransport, and the server must support
+// receiving spans independently.
+func (t *Tracer) SetTopLevelSpans(topLevel bool) {
+	t.topLevelSpansMu.Lock()
+	t.topLevelSpans = topLevel
+	t.topLevelSpansMu.Unlock()
+}
+
+func (t *Tracer) sendTopLevelSpans() bool {
+	t.topLevelSpansMu.RLock()
+	topLevel := t.topLevelSpans
+	t.topLevelSpansMu.RUnlock()
+	return topLevel
+}
+
+// SetPerEventService sets whether or not service identity overrides,
+// specified with TransactionOptions.Service, should be sent to the
+// APM server. This requires a server which supports per-event service
+// metadata; if disabled, overrides are discarded, and all events are
+// attributed to the tracer's service.
+func (t *Tracer) SetPerEventService(enabled bool) {
+	t.perEventServiceMu.Lock()
+	t.perEventService = enabled
+	t.perEventServiceMu.Unlock()
+}
+
+func (t *Tracer) sendPerEventService() bool {
+	t.perEventServiceMu.RLock()
+	enabled := t.perEventService
+	t.perEventServiceMu.RUnlock()
+	return enabled
+}
+
+// SetAgent overrides the agent name and version reported to the APM
+// server, which default to "go" and AgentVersion. This is intended for
+// frameworks built on top of this agent, which may report a composite
+// name and version, e.g. "go/myframework" and "1.2", so that data is
+// attributed to the framework while still being recognised as coming
+// from the Go agent. SetAgent returns an error if name or version is
+// empty.
+func (t *Tracer) SetAgent(name, version string) error {
+	if name == "" {
+		return errors.New("agent name must be specified")
+	}
+	if version == "" {
+		return errors.New("agent version must be specified")
+	}
+	t.agentMu.Lock()
+	t.agent = &model.Agent{Name: name, Version: version}
+	t.agentMu.Unlock()
+	return nil
+}
+
+// SetTransactionMaxDuration sets the maximum duration of transactions
+// started after the call. Transactions which have not ended within
+// this duration are forcibly ended, to bound the memory held by
+// transactions that are never ended due to instrumentation bugs.
+//
+// When a transaction exceeds the maximum duration, an error is logged
+// so the underlying bug can be found, and what has been recorded so
+// far is sent with the result "timeout": its name, tags, and spans,
+// with any open spans truncated. Its context is not sent, as the
+// application may still be modifying it. The transaction is marked as
+// ended: any subsequent call to Done is a no-op, and spans started
+// within it are dropped. If d is non-positive, the duration of
+// transactions is unlimited.
+func (t *Tracer) SetTransactionMaxDuration(d time.Duration) {
+	t.transactionMaxDurationMu.Lock()
+	t.transactionMaxDuration = d
+	t.transactionMaxDurationMu.Unlock()
+}
+
+// SetCaptureBody sets the HTTP request body capture mode. Request
+// bodies are captured by instrumentation modules, such as apmhttp,
+// according to the tracer's capture mode.
+func (t *Tracer) SetCaptureBody(mode CaptureBodyMode) {
+	t.captureBodyMu.Lock()
+	t.captureBody = mode
+	t.captureBodyMu.Unlock()
+}
+
+// CaptureBody returns the tracer's HTTP request body capture mode.
+func (t *Tracer) CaptureBody() CaptureBodyMode {
+	t.captureBodyMu.RLock()
+	mode := t.captureBody
+	t.captureBodyMu.RUnlock()
+	return mode
+}
+
+// SetCaptureQueryParams sets whether or not HTTP request query
+// parameters should be captured, parsed and sanitized, by
+// instrumentation modules such as apmhttp.
+func (t *Tracer) SetCaptureQueryParams(capture bool) {
+	t.captureBodyMu.Lock()
+	t.captureQueryParams = capture
+	t.captureBodyMu.Unlock()
+}
+
+// CaptureQueryParams reports whether or not HTTP request
+// query parameters should be captured.
+func (t *Tracer) CaptureQueryParams() bool {
+	t.captureBodyMu.RLock()
+	capture := t.captureQueryParams
+	t.captureBodyMu.RUnlock()
+	return capture
+}
+
+// SetSystemMetadata sets the system metadata reported to the
+// Elastic APM server, replacing the metadata detected when the
+// tracer was created. This takes effect for subsequent sends,
+// so it may be used to update the metadata of long-running
+// services, e.g. after migrating to another host.
+func (t *Tracer) SetSystemMetadata(system model.System) {
+	t.systemMu.Lock()
+	t.system = &system
+	t.systemMu.Unlock()
+}
+
+// systemMetadata returns the system metadata to report
+// to the Elastic APM server.
+func (t *Tracer) systemMetadata() *model.System {
+	t.systemMu.RLock()
+	system := t.system
+	t.systemMu.RUnlock()
+	return system
+}
+
+// SetCaptureBodyLimits sets the limits on HTTP body content captured
+// by instrumentation modules, such as apmhttp.
+func (t *Tracer) SetCaptureBodyLimits(limits CaptureBodyLimits) {
+	t.captureBodyMu.Lock()
+	t.captureBodyLimits = limits
+	t.captureBodyMu.Unlock()
+}
+
+// CaptureBodyLimits returns the tracer's HTTP body capture limits.
+func (t *Tracer) CaptureBodyLimits() CaptureBodyLimits {
+	t.captureBodyMu.RLock()
+	limits := t.captureBodyLimits
+	t.captureBodyMu.RUnlock()
+	return limits
+}
+
 // Stats returns the current TracerStats. This will return the most
 // recent values even after the tracer has been closed.
 func (t *Tracer) Stats() TracerStats {
 	t.statsMu.Lock()
 	stats := t.stats
+	stats.CircuitBreakerOpen = time.Now().Before(t.circuitBreakerOpenUntil)
 	t.statsMu.Unlock()
+	stats.Spans = t.spanStats.load()
+	stats.TransactionDrops = t.transactionDropStats.load()
 	return stats
 }
 
+// Buffered returns the number of transactions and errors currently
+// buffered in the tracer, waiting to be queued for sending.
+func (t *Tracer) Buffered() (transactions, errors int) {
+	return len(t.transactions), len(t.errors)
+}
+
 func (t *Tracer) loop() {
 	defer close(t.closed)
 
@@ -329,21 +828,85 @@ func (t *Tracer) loop() {
 	var flushed chan<- struct{}
 	var maxTransactionQueueSize int
 	var maxErrorQueueSize int
+	var apiRequestConcurrency int
+	var apiRequestMinInterval time.Duration
+	var lastRequest time.Time
 	var flushC <-chan time.Time
+	var minIntervalC <-chan time.Time
 	var transactions []*Transaction
 	var errors []*Error
+	var metrics []*model.Metrics
+	var metricsInterval time.Duration
+	var metricsC <-chan time.Time
+	gatheredMetrics := make(chan []*model.Metrics)
 	var statsUpdates TracerStats
 	sender := sender{
-		tracer: t,
-		stats:  &statsUpdates,
+		tracer:  t,
+		stats:   &statsUpdates,
+		results: make(chan sendResult),
+	}
+
+	// sendTransactions records whether or not the queued transactions
+	// should be sent as soon as there is an API request available.
+	//
+	// inflight records the number of requests currently being
+	// made to the APM server, which is limited to the API request
+	// concurrency. Once the limit is reached, the tracer will stop
+	// receiving transactions and errors until a request completes,
+	// and they will be buffered in (or dropped from) the channels.
+	var sendTransactions bool
+	var inflight int
+	var transactionsFailed, errorsFailed bool
+	var breaker circuitBreaker
+
+	// eventTypesProbed records whether or not the transport has been
+	// queried for the event types accepted by the server, since the
+	// tracer started or last recovered from send failures; the server
+	// may have been upgraded or replaced in the meantime. Gathered
+	// metrics are held back while the query is in progress.
+	var eventTypesProbed, probingEventTypes bool
+	metricsAccepted := true
+	probedEventTypes := make(chan eventTypesResult)
+	probeEventTypes := func() {
+		eventTypesProbed = true
+		if tr, ok := t.Transport.(transport.EventTypesTransport); ok {
+			probingEventTypes = true
+			go t.probeEventTypes(ctx, tr, probedEventTypes)
+		}
+	}
+
+	setCircuitBreakerState := func() {
+		t.statsMu.Lock()
+		t.circuitBreakerOpenUntil = breaker.openUntil
+		t.statsMu.Unlock()
 	}
 
-	errorsC := t.errors
 	forceFlush := t.forceFlush
 	flushTimer := time.NewTimer(0)
 	if !flushTimer.Stop() {
 		<-flushTimer.C
 	}
+	metricsTimer := time.NewTimer(0)
+	if !metricsTimer.Stop() {
+		<-metricsTimer.C
+	}
+	minIntervalTimer := time.NewTimer(0)
+	if !minIntervalTimer.Stop() {
+		<-minIntervalTimer.C
+	}
+	startMetricsTimer := func() {
+		if !metricsTimer.Stop() {
+			select {
+			case <-metricsTimer.C:
+			default:
+			}
+		}
+		metricsC = nil
+		if metricsInterval > 0 {
+			metricsTimer.Reset(metricsInterval)
+			metricsC = metricsTimer.C
+		}
+	}
 	startTimer := func() {
 		if flushC != nil {
 			// Timer already started.
@@ -358,40 +921,155 @@ func (t *Tracer) loop() {
 		flushTimer.Reset(flushInterval)
 		flushC = flushTimer.C
 	}
+	dropCircuitOpen := func(tx *Transaction, stats *TracerStats) {
+		if sender.logger != nil {
+			sender.logger.Debugf("circuit breaker open, dropping transaction %q", tx.Name)
+		}
+		tx.release()
+		stats.TransactionsDropped++
+		atomic.AddUint64(&t.transactionDropStats.circuitOpen, 1)
+	}
 	receivedTransaction := func(tx *Transaction, stats *TracerStats) {
+		if breaker.open(time.Now()) {
+			dropCircuitOpen(tx, stats)
+			return
+		}
 		if maxTransactionQueueSize > 0 && len(transactions) >= maxTransactionQueueSize {
 			// The queue is full, so pop the oldest item.
 			// TODO(axw) use container/ring? implement
 			// ring buffer on top of slice? profile
 			n := uint64(len(transactions) - maxTransactionQueueSize + 1)
+			if sender.logger != nil {
+				sender.logger.Debugf("transaction queue full, dropping %d oldest transaction(s)", n)
+			}
 			for _, tx := range transactions[:n] {
-				tx.reset()
-				t.transactionPool.Put(tx)
+				tx.release()
 			}
 			transactions = transactions[n:]
 			stats.TransactionsDropped += n
+			atomic.AddUint64(&t.transactionDropStats.bufferFull, n)
 		}
 		transactions = append(transactions, tx)
 	}
+	openCircuit := func() {
+		// The circuit breaker has been opened; drop
+		// all queued events, so that any pending
+		// flush can complete.
+		if sender.logger != nil {
+			sender.logger.Errorf(
+				"%d consecutive send failures, dropping events for %s",
+				breaker.failures, breaker.cooldown,
+			)
+		}
+		for _, tx := range transactions {
+			tx.release()
+		}
+		for _, e := range errors {
+			e.reset()
+			t.errorPool.Put(e)
+		}
+		statsUpdates.TransactionsDropped += uint64(len(transactions))
+		atomic.AddUint64(&t.transactionDropStats.circuitOpen, uint64(len(transactions)))
+		statsUpdates.ErrorsDropped += uint64(len(errors))
+		statsUpdates.CircuitBreakerOpened++
+		transactions = nil
+		errors = nil
+		metrics = nil
+		transactionsFailed = false
+		errorsFailed = false
+		sendTransactions = false
+		flushC = nil
+		setCircuitBreakerState()
+	}
 
 	for {
-		var sendTransactions bool
 		statsUpdates = TracerStats{}
 
+		transactionsC := t.transactions
+		errorsC := t.errors
+		if inflight >= apiRequestConcurrency {
+			transactionsC = nil
+			errorsC = nil
+		} else if maxErrorQueueSize > 0 && len(errors) >= maxErrorQueueSize {
+			errorsC = nil
+		}
+
 		select {
 		case <-t.closing:
 			return
 		case flushInterval = <-t.setFlushInterval:
+			sender.config.flushInterval = flushInterval
+			continue
+		case metricsInterval = <-t.setMetricsInterval:
+			sender.config.metricsInterval = metricsInterval
+			t.breakdownMetrics.setGathered(metricsInterval > 0)
+			startMetricsTimer()
+			continue
+		case cfg := <-t.setCircuitBreaker:
+			breaker.threshold = cfg.threshold
+			breaker.cooldown = cfg.cooldown
+			sender.config.circuitBreaker = cfg
 			continue
+		case <-metricsC:
+			go t.gatherMetrics(ctx, sender.logger, gatheredMetrics)
+			startMetricsTimer()
+			continue
+		case gathered := <-gatheredMetrics:
+			if _, ok := t.Transport.(transport.MetricsTransport); !ok {
+				// Metrics are still gathered, so that
+				// gatherers' accumulated state is reset.
+				continue
+			}
+			if breaker.open(time.Now()) {
+				continue
+			}
+			if !eventTypesProbed {
+				probeEventTypes()
+			}
+			if !metricsAccepted && !probingEventTypes {
+				continue
+			}
+			metrics = append(metrics, gathered...)
+		case result := <-probedEventTypes:
+			probingEventTypes = false
+			if result.err != nil {
+				// Assume the server accepts metrics, as
+				// it did before the query was supported.
+				if sender.logger != nil {
+					sender.logger.Debugf("querying supported event types failed: %s", result.err)
+				}
+				metricsAccepted = true
+				break
+			}
+			accepted := result.accepts(transport.EventTypeMetric)
+			if accepted != metricsAccepted && sender.logger != nil {
+				if accepted {
+					sender.logger.Debugf("server accepts metrics, resuming sending metrics")
+				} else {
+					sender.logger.Errorf("server does not accept metrics, metrics will not be sent")
+				}
+			}
+			metricsAccepted = accepted
+			if !metricsAccepted {
+				metrics = nil
+			}
 		case maxTransactionQueueSize = <-t.setMaxTransactionQueueSize:
+			sender.config.maxTransactionQueueSize = maxTransactionQueueSize
 			if maxTransactionQueueSize <= 0 || len(transactions) < maxTransactionQueueSize {
 				continue
 			}
+			sendTransactions = true
 		case maxErrorQueueSize = <-t.setMaxErrorQueueSize:
-			if maxErrorQueueSize <= 0 || len(errors) < maxErrorQueueSize {
-				errorsC = t.errors
-			}
 			continue
+		case apiRequestConcurrency = <-t.setAPIRequestConcurrency:
+			if apiRequestConcurrency < 1 {
+				apiRequestConcurrency = 1
+			}
+			sender.config.apiRequestConcurrency = apiRequestConcurrency
+		case apiRequestMinInterval = <-t.setAPIRequestMinInterval:
+			sender.config.apiRequestMinInterval = apiRequestMinInterval
+		case <-minIntervalC:
+			minIntervalC = nil
 		case sender.preContext = <-t.setPreContext:
 			continue
 		case sender.postContext = <-t.setPostContext:
@@ -402,9 +1080,131 @@ func (t *Tracer) loop() {
 			continue
 		case sender.processor = <-t.setProcessor:
 			continue
+		case result := <-sender.results:
+			if !result.more {
+				inflight--
+			}
+			var eventsRejected int
+			if rejected, ok := result.err.(*transport.RejectedEventsError); ok {
+				// The server processed the request, but rejected
+				// some events; retrying would not help. Rejected
+				// events are counted in EventsRejected, and not
+				// as sent.
+				if sender.logger != nil {
+					for _, event := range rejected.Rejected {
+						sender.logger.Debugf("%s: event rejected: %s", rejected.Op, event.Message)
+					}
+				}
+				eventsRejected = len(rejected.Rejected)
+				statsUpdates.EventsRejected += uint64(eventsRejected)
+				result.err = nil
+			}
+			if result.err == nil {
+				if breaker.failures > 0 {
+					breaker.success()
+					setCircuitBreakerState()
+					// Query the event types again, as the
+					// server may have been upgraded.
+					eventTypesProbed = false
+				}
+				if result.errors != nil {
+					errorsFailed = false
+					statsUpdates.ErrorsSent += eventsSent(len(result.errors), eventsRejected)
+					for _, e := range result.errors {
+						e.reset()
+						t.errorPool.Put(e)
+					}
+					break
+				}
+				if result.metrics != nil {
+					break
+				}
+			} else if result.metrics != nil {
+				// Metrics are not retried; they will
+				// be gathered again at the next interval.
+				if sender.logger != nil {
+					sender.logger.Debugf("sending metrics failed: %s", result.err)
+				}
+				statsUpdates.Errors.SendMetrics++
+				if breaker.failure(time.Now()) {
+					openCircuit()
+				}
+				break
+			} else if result.errors != nil {
+				if sender.logger != nil {
+					sender.logger.Debugf("sending errors failed: %s", result.err)
+				}
+				errorsFailed = true
+				statsUpdates.Errors.SendErrors++
+				errors = append(result.errors, errors...)
+				if breaker.failure(time.Now()) {
+					openCircuit()
+					break
+				}
+				// Sending errors failed, start a new timer to resend.
+				t.statsMu.Lock()
+				t.stats.accumulate(statsUpdates)
+				t.statsMu.Unlock()
+				startTimer()
+				continue
+			}
+
+			// The result is for transactions, their spans, or both.
+			if result.err == nil {
+				statsUpdates.TransactionsSent += eventsSent(len(result.transactions), eventsRejected)
+			} else {
+				if sender.logger != nil {
+					if result.transactions != nil {
+						sender.logger.Debugf("sending transactions failed: %s", result.err)
+					} else {
+						sender.logger.Debugf("sending spans failed: %s", result.err)
+					}
+				}
+				transactionsFailed = true
+				statsUpdates.Errors.SendTransactions++
+				if breaker.failure(time.Now()) {
+					openCircuit()
+				}
+			}
+			retry := transactionsSent(result)
+			if len(retry) == 0 {
+				if result.err == nil {
+					transactionsFailed = false
+				}
+				break
+			}
+			if breaker.open(time.Now()) {
+				for _, tx := range retry {
+					dropCircuitOpen(tx, &statsUpdates)
+				}
+				break
+			}
+			// Requeue the transactions ahead of any received
+			// while sending, dropping the oldest if necessary,
+			// and start a new timer to resend.
+			queued := transactions
+			transactions = retry
+			for _, tx := range queued {
+				receivedTransaction(tx, &statsUpdates)
+			}
+			t.statsMu.Lock()
+			t.stats.accumulate(statsUpdates)
+			t.statsMu.Unlock()
+			startTimer()
+			continue
 		case e := <-errorsC:
+			if breaker.open(time.Now()) {
+				e.reset()
+				t.errorPool.Put(e)
+				statsUpdates.ErrorsDropped++
+				break
+			}
 			errors = append(errors, e)
-		case tx := <-t.transactions:
+		case tx := <-transactionsC:
+			if breaker.open(time.Now()) {
+				dropCircuitOpen(tx, &statsUpdates)
+				break
+			}
 			beforeLen := len(transactions)
 			receivedTransaction(tx, &statsUpdates)
 			if len(transactions) == beforeLen && flushC != nil {
@@ -438,30 +1238,55 @@ func (t *Tracer) loop() {
 			sendTransactions = true
 		}
 
-		if remainder := maxErrorQueueSize - len(errors); remainder > 0 {
-			// Drain any errors in the channel, up to the maximum queue size.
-			for n := len(t.errors); n > 0 && remainder > 0; n-- {
-				errors = append(errors, <-t.errors)
-				remainder--
+		// coalesce records whether or not queued transactions and
+		// errors should be held back, as the minimum interval since
+		// the last request has not elapsed. Explicit flushes are not
+		// held back, and nor are full queues, to bound memory usage.
+		// Errors buffered in the channel are considered queued, as
+		// they would otherwise be drained and sent below.
+		var coalesce bool
+		pendingErrors := len(errors) != 0 || len(t.errors) != 0
+		if apiRequestMinInterval > 0 && flushed == nil && (sendTransactions || pendingErrors) {
+			if wait := apiRequestMinInterval - time.Since(lastRequest); wait > 0 {
+				coalesce = true
+				if minIntervalC == nil {
+					minIntervalTimer.Reset(wait)
+					minIntervalC = minIntervalTimer.C
+				}
 			}
 		}
-		if sender.sendErrors(ctx, errors) {
-			for _, e := range errors {
-				e.reset()
-				t.errorPool.Put(e)
+		transactionsFull := maxTransactionQueueSize > 0 && len(transactions) >= maxTransactionQueueSize
+		errorsFull := maxErrorQueueSize > 0 && len(errors) >= maxErrorQueueSize
+
+		if breaker.open(time.Now()) {
+			// There is nothing queued while the circuit is open.
+			sendTransactions = false
+		} else if inflight < apiRequestConcurrency && (!coalesce || errorsFull) {
+			if remainder := maxErrorQueueSize - len(errors); remainder > 0 {
+				// Drain any errors in the channel, up to the maximum queue size.
+				for n := len(t.errors); n > 0 && remainder > 0; n-- {
+					errors = append(errors, <-t.errors)
+					remainder--
+				}
+			}
+			if len(errors) != 0 {
+				sender.sendErrors(ctx, errors)
+				errors = nil
+				inflight++
+				lastRequest = time.Now()
 			}
-			errors = errors[:0]
-			errorsC = t.errors
-		} else if len(errors) == maxErrorQueueSize {
-			errorsC = nil
 		}
-		if sendTransactions {
-			if sender.sendTransactions(ctx, transactions) {
-				for _, tx := range transactions {
-					tx.reset()
-					t.transactionPool.Put(tx)
-				}
-				transactions = transactions[:0]
+		if len(metrics) != 0 && inflight < apiRequestConcurrency && !probingEventTypes {
+			sender.sendMetrics(ctx, metrics)
+			metrics = nil
+			inflight++
+		}
+		if sendTransactions && inflight < apiRequestConcurrency && (!coalesce || transactionsFull) {
+			sendTransactions = false
+			if len(transactions) != 0 {
+				inflight += sender.sendTransactions(ctx, transactions, apiRequestConcurrency-inflight)
+				transactions = nil
+				lastRequest = time.Now()
 			}
 		}
 
@@ -469,14 +1294,8 @@ func (t *Tracer) loop() {
 			t.statsMu.Lock()
 			t.stats.accumulate(statsUpdates)
 			t.statsMu.Unlock()
-
-			if statsUpdates.Errors.SendTransactions != 0 || statsUpdates.Errors.SendErrors != 0 {
-				// Sending transactions or errors failed, start a new timer to resend.
-				startTimer()
-				continue
-			}
 		}
-		if sendTransactions && flushed != nil {
+		if flushed != nil && !sendTransactions && inflight == 0 && !transactionsFailed && !errorsFailed {
 			forceFlush = t.forceFlush
 			flushed <- struct{}{}
 			flushed = nil
@@ -491,13 +1310,105 @@ type sender struct {
 	contextSetter           stacktrace.ContextSetter
 	preContext, postContext int
 	stats                   *TracerStats
+	results                 chan sendResult
+
+	// config holds the options owned by the tracer loop,
+	// for reporting in the agent configuration.
+	config loopConfig
+
+	// exitSpansLogged records the names of exit spans that have
+	// been logged as having no destination service resource, so
+	// each is logged once rather than on every send.
+	exitSpansLogged map[string]bool
+}
+
+// eventsSent returns the number of events sent in a request
+// of n events, excluding those rejected by the server.
+func eventsSent(n, rejected int) uint64 {
+	if rejected >= n {
+		return 0
+	}
+	return uint64(n - rejected)
+}
+
+// maxExitSpansLogged bounds the size of sender.exitSpansLogged.
+// The set is cleared when the limit is reached, so span names
+// with high cardinality may be logged again.
+const maxExitSpansLogged = 1000
+
+// sendResult holds the result of sending transactions,
+// errors, or metrics to the APM server.
+type sendResult struct {
+	// transactions and spans hold the transactions whose
+	// transaction documents and spans, respectively, were sent.
+	// Both are set when spans are sent with their transactions.
+	transactions []*Transaction
+	spans        []*Transaction
+
+	errors  []*Error
+	metrics []*model.Metrics
+	err     error
+
+	// more records that another result follows for the same
+	// request slot, as the spans and transactions requests
+	// were made one after the other; see sendTransactions.
+	more bool
+}
+
+// transactionsSent records the outcome of the request described by
+// result, releasing transactions once they and their spans have been
+// sent. It returns the transactions with no more requests in flight
+// that are yet to be sent in full.
+func transactionsSent(result sendResult) []*Transaction {
+	var retry []*Transaction
+	update := func(transactions []*Transaction, spans bool) {
+		for _, tx := range transactions {
+			if result.err == nil {
+				if spans {
+					tx.spansSent = true
+				} else {
+					tx.transactionSent = true
+				}
+			}
+			if tx.pendingSends--; tx.pendingSends > 0 {
+				continue
+			}
+			if tx.transactionSent && tx.spansSent {
+				tx.release()
+			} else {
+				retry = append(retry, tx)
+			}
+		}
+	}
+	update(result.transactions, false)
+	update(result.spans, true)
+	return retry
 }
 
-// sendTransactions attempts to send enqueued transactions to the APM server,
-// returning true if the transactions were successfully sent.
-func (s *sender) sendTransactions(ctx context.Context, transactions []*Transaction) bool {
-	if len(transactions) == 0 {
-		return false
+// sendTransactions encodes the transactions into payloads, and sends
+// them to the APM server in new goroutines, returning the number of
+// requests started, which is at most slots. The results will be
+// delivered to s.results.
+func (s *sender) sendTransactions(ctx context.Context, transactions []*Transaction, slots int) int {
+	for _, tx := range transactions {
+		tx.setSpanStacktraces()
+		if s.logger != nil {
+			if tx.tagsTruncated > 0 {
+				s.logger.Debugf(
+					"truncated %d tags exceeding the length limits in transaction %q",
+					tx.tagsTruncated, tx.Name,
+				)
+			}
+			for _, span := range tx.Spans {
+				if span.Exit && !hasDestinationResource(span) && !s.exitSpansLogged[span.Name] {
+					s.logger.Debugf("exit span %q has no destination service resource", span.Name)
+					if s.exitSpansLogged == nil || len(s.exitSpansLogged) >= maxExitSpansLogged {
+						s.exitSpansLogged = make(map[string]bool)
+					}
+					s.exitSpansLogged[span.Name] = true
+				}
+			}
+		}
 	}
 	if s.contextSetter != nil {
 		var err error
@@ -514,35 +1425,98 @@ func (s *sender) sendTransactions(ctx context.Context, transactions []*Transacti
 		}
 	}
 	payload := model.TransactionsPayload{
-		Service:      s.tracer.Service,
+		Service:      s.tracer.metadataService(s.config),
 		Process:      s.tracer.process,
-		System:       s.tracer.system,
+		System:       s.tracer.systemMetadata(),
 		Transactions: make([]*model.Transaction, len(transactions)),
 	}
+	perEventService := s.tracer.sendPerEventService()
 	for i, tx := range transactions {
 		tx.setID()
+		if !perEventService {
+			tx.Service = nil
+		}
 		if s.processor != nil {
 			s.processor.ProcessTransaction(&tx.Transaction)
 		}
 		payload.Transactions[i] = &tx.Transaction
 	}
-	if err := s.tracer.Transport.SendTransactions(ctx, &payload); err != nil {
-		if s.logger != nil {
-			s.logger.Debugf("sending transactions failed: %s", err)
+	spansTransport, ok := s.tracer.Transport.(transport.SpansTransport)
+	tr := s.tracer.Transport
+	if !ok || !s.tracer.sendTopLevelSpans() {
+		for _, tx := range transactions {
+			tx.pendingSends += 2
 		}
-		s.stats.Errors.SendTransactions++
-		return false
+		go s.send(sendResult{transactions: transactions, spans: transactions}, func() error {
+			return tr.SendTransactions(ctx, &payload)
+		})
+		return 1
 	}
-	s.stats.TransactionsSent += uint64(len(transactions))
-	return true
-}
 
-// sendErrors attempts to send enqueued errors to the APM server,
-// returning true if the errors were successfully sent.
-func (s *sender) sendErrors(ctx context.Context, errors []*Error) bool {
-	if len(errors) == 0 {
-		return false
+	// Send the spans independently, referencing their transactions
+	// by ID. The transactions are copied so they are sent without
+	// spans, while keeping the spans for resending on failure. The
+	// spans and transactions are sent in separate requests, so if
+	// one fails then only it is resent.
+	spansPayload := model.SpansPayload{
+		Service: payload.Service,
+		Process: payload.Process,
+		System:  payload.System,
 	}
+	var sendTransactions, sendSpans []*Transaction
+	payload.Transactions = payload.Transactions[:0]
+	for _, tx := range transactions {
+		if !tx.transactionSent {
+			txCopy := tx.Transaction
+			txCopy.Spans = nil
+			payload.Transactions = append(payload.Transactions, &txCopy)
+			sendTransactions = append(sendTransactions, tx)
+			tx.pendingSends++
+		}
+		if !tx.spansSent {
+			if len(tx.Spans) == 0 {
+				tx.spansSent = true
+				continue
+			}
+			for _, span := range tx.Spans {
+				span.TransactionID = tx.ID
+				spansPayload.Spans = append(spansPayload.Spans, span)
+			}
+			sendSpans = append(sendSpans, tx)
+			tx.pendingSends++
+		}
+	}
+	sendSpansRequest := func() error {
+		return spansTransport.SendSpans(ctx, &spansPayload)
+	}
+	sendTransactionsRequest := func() error {
+		return tr.SendTransactions(ctx, &payload)
+	}
+	if len(sendSpans) != 0 && len(sendTransactions) != 0 && slots < 2 {
+		// There is only room for one more request, so make
+		// the requests one after the other, in the same slot.
+		go func() {
+			s.send(sendResult{spans: sendSpans, more: true}, sendSpansRequest)
+			s.send(sendResult{transactions: sendTransactions}, sendTransactionsRequest)
+		}()
+		return 1
+	}
+	var requests int
+	if len(sendSpans) != 0 {
+		go s.send(sendResult{spans: sendSpans}, sendSpansRequest)
+		requests++
+	}
+	if len(sendTransactions) != 0 {
+		go s.send(sendResult{transactions: sendTransactions}, sendTransactionsRequest)
+		requests++
+	}
+	return requests
+}
+
+// sendErrors encodes the errors into a payload, and sends it to the
+// APM server in a new goroutine. The result will be delivered to
+// s.results.
+func (s *sender) sendErrors(ctx context.Context, errors []*Error) {
 	if s.contextSetter != nil {
 		var err error
 		for _, e := range errors {
@@ -558,15 +1532,20 @@ func (s *sender) sendErrors(ctx context.Context, errors []*Error) bool {
 		}
 	}
 	payload := model.ErrorsPayload{
-		Service: s.tracer.Service,
+		Service: s.tracer.metadataService(s.config),
 		Process: s.tracer.process,
-		System:  s.tracer.system,
+		System:  s.tracer.systemMetadata(),
 		Errors:  make([]*model.Error, len(errors)),
 	}
+	perEventService := s.tracer.sendPerEventService()
 	for i, e := range errors {
 		if e.Transaction != nil {
-			e.Transaction.setID()
-			e.TransactionID = e.Transaction.ID
+			e.TransactionID = e.Transaction.setID()
+			e.TraceID = e.Transaction.traceContext.Trace.String()
+			e.ParentID = e.Transaction.spanID.String()
+		}
+		if !perEventService {
+			e.Service = nil
 		}
 		if s.processor != nil {
 			s.processor.ProcessError(&e.Error)
@@ -574,13 +1553,36 @@ func (s *sender) sendErrors(ctx context.Context, errors []*Error) bool {
 		e.setCulprit()
 		payload.Errors[i] = &e.Error
 	}
-	if err := s.tracer.Transport.SendErrors(ctx, &payload); err != nil {
-		if s.logger != nil {
-			s.logger.Debugf("sending errors failed: %s", err)
+	tr := s.tracer.Transport
+	go s.send(sendResult{errors: errors}, func() error {
+		return tr.SendErrors(ctx, &payload)
+	})
+}
+
+// sendMetrics sends the metrics to the APM server in a new goroutine.
+// The result will be delivered to s.results.
+func (s *sender) sendMetrics(ctx context.Context, metrics []*model.Metrics) {
+	payload := model.MetricsPayload{
+		Service: s.tracer.metadataService(s.config),
+		Process: s.tracer.process,
+		System:  s.tracer.systemMetadata(),
+		Metrics: metrics,
+	}
+	tr, ok := s.tracer.Transport.(transport.MetricsTransport)
+	go s.send(sendResult{metrics: metrics}, func() error {
+		if !ok {
+			// The transport was replaced after the
+			// metrics were gathered; discard them.
+			return nil
 		}
-		s.stats.Errors.SendErrors++
-		return false
+		return tr.SendMetrics(ctx, &payload)
+	})
+}
+
+func (s *sender) send(result sendResult, send func() error) {
+	result.err = send()
+	select {
+	case s.results <- result:
+	case <-s.tracer.closed:
 	}
-	s.stats.ErrorsSent += uint64(len(errors))
-	return true
 }
@@ -0,0 +1,265 @@
+package elasticapm
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxTraceStateEntries is the maximum number of list-members
+	// in a W3C Trace Context tracestate header.
+	maxTraceStateEntries = 32
+
+	// maxTraceStateLength is the maximum length of a W3C Trace
+	// Context tracestate header that vendors must propagate.
+	maxTraceStateLength = 512
+
+	// maxTraceStatePruneEntryLength is the length above which
+	// tracestate entries are pruned first, per the W3C Trace
+	// Context recommendations.
+	maxTraceStatePruneEntryLength = 128
+
+	// elasticTraceStateKey is the tracestate key reserved for
+	// Elastic APM. The entry with this key is never pruned.
+	elasticTraceStateKey = "es"
+
+	// elasticSampleRateField is the field of the Elastic APM
+	// tracestate entry which holds the trace's sample rate.
+	elasticSampleRateField = "s"
+)
+
+// TraceState holds vendor-specific trace state, as described by
+// the W3C Trace Context tracestate header. The entries are ordered
+// from most to least recently updated.
+type TraceState []TraceStateEntry
+
+// TraceStateEntry holds a single tracestate list-member.
+type TraceStateEntry struct {
+	// Key holds the vendor key.
+	Key string
+
+	// Value holds the vendor-specific opaque value.
+	Value string
+}
+
+func (e TraceStateEntry) len() int {
+	return len(e.Key) + 1 + len(e.Value)
+}
+
+// ParseTraceState parses s as a W3C Trace Context tracestate
+// header value.
+//
+// Malformed and duplicate entries are skipped, and if the header
+// exceeds the limits of 32 entries or 512 characters, then the
+// entries beyond the limits are discarded; an oversized tracestate
+// is truncated rather than rejected. The Elastic APM ("es") entry is
+// never discarded, wherever it appears in the header.
+func ParseTraceState(s string) TraceState {
+	var state TraceState
+	for _, member := range strings.Split(s, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		equal := strings.IndexRune(member, '=')
+		if equal <= 0 {
+			continue
+		}
+		entry := TraceStateEntry{Key: member[:equal], Value: member[equal+1:]}
+		if !validTraceStateKey(entry.Key) || !validTraceStateValue(entry.Value) {
+			continue
+		}
+		if state.index(entry.Key) >= 0 {
+			continue
+		}
+		state = append(state, entry)
+	}
+
+	// Truncate the entries beyond the limits, reserving
+	// room for the Elastic APM entry so it is kept.
+	entries, length := 0, -1 // no comma before the first entry
+	if i := state.index(elasticTraceStateKey); i >= 0 {
+		entries, length = 1, state[i].len()
+	}
+	truncated := state[:0]
+	var full bool
+	for _, entry := range state {
+		if entry.Key != elasticTraceStateKey {
+			full = full || entries == maxTraceStateEntries || length+1+entry.len() > maxTraceStateLength
+			if full {
+				continue
+			}
+			entries++
+			length += 1 + entry.len()
+		}
+		truncated = append(truncated, entry)
+	}
+	return truncated
+}
+
+// String returns s encoded as a W3C Trace Context tracestate
+// header value.
+//
+// If s exceeds the limits of 32 entries or 512 characters, entries
+// are pruned to bring it within the limits: entries longer than 128
+// characters are pruned first, followed by the least recently updated
+// entries. The Elastic APM ("es") entry is never pruned, and is always
+// encoded first.
+func (s TraceState) String() string {
+	entries := make(TraceState, 0, len(s))
+	if i := s.index(elasticTraceStateKey); i >= 0 {
+		entries = append(entries, s[i])
+	}
+	for _, entry := range s {
+		if entry.Key != elasticTraceStateKey {
+			entries = append(entries, entry)
+		}
+	}
+	for !entries.withinLimits() {
+		i := entries.pruneIndex()
+		if i < 0 {
+			break
+		}
+		entries = append(entries[:i], entries[i+1:]...)
+	}
+
+	var buf bytes.Buffer
+	for i, entry := range entries {
+		if i > 0 {
+			buf.WriteRune(',')
+		}
+		buf.WriteString(entry.Key)
+		buf.WriteRune('=')
+		buf.WriteString(entry.Value)
+	}
+	return buf.String()
+}
+
+// elasticSampleRate returns the sample rate recorded in the Elastic
+// APM entry of s, e.g. "es=s:0.5", and whether a valid rate was found.
+func (s TraceState) elasticSampleRate() (float64, bool) {
+	i := s.index(elasticTraceStateKey)
+	if i < 0 {
+		return 0, false
+	}
+	for _, field := range strings.Split(s[i].Value, ";") {
+		if !strings.HasPrefix(field, elasticSampleRateField+":") {
+			continue
+		}
+		rate, err := strconv.ParseFloat(field[len(elasticSampleRateField)+1:], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return 0, false
+		}
+		return rate, true
+	}
+	return 0, false
+}
+
+// withElasticSampleRate returns a copy of s with the sample rate in
+// its Elastic APM entry set to rate, rounded to 4 decimal places.
+// Other fields of the entry are preserved, and the entry is moved
+// to the front, as the most recently updated.
+func (s TraceState) withElasticSampleRate(rate float64) TraceState {
+	value := strconv.FormatFloat(rate, 'f', 4, 64)
+	value = strings.TrimRight(strings.TrimRight(value, "0"), ".")
+	fields := []string{elasticSampleRateField + ":" + value}
+	i := s.index(elasticTraceStateKey)
+	if i >= 0 {
+		for _, field := range strings.Split(s[i].Value, ";") {
+			if field != "" && !strings.HasPrefix(field, elasticSampleRateField+":") {
+				fields = append(fields, field)
+			}
+		}
+	}
+	out := make(TraceState, 1, len(s)+1)
+	out[0] = TraceStateEntry{Key: elasticTraceStateKey, Value: strings.Join(fields, ";")}
+	for j, entry := range s {
+		if j != i {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// index returns the index of the entry with the given key,
+// or -1 if there is no such entry.
+func (s TraceState) index(key string) int {
+	for i, entry := range s {
+		if entry.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s TraceState) withinLimits() bool {
+	if len(s) > maxTraceStateEntries {
+		return false
+	}
+	length := len(s) - 1 // commas
+	for _, entry := range s {
+		length += entry.len()
+	}
+	return length <= maxTraceStateLength
+}
+
+// pruneIndex returns the index of the next entry to prune,
+// or -1 if only the Elastic APM entry remains.
+func (s TraceState) pruneIndex() int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].Key != elasticTraceStateKey && s[i].len() > maxTraceStatePruneEntryLength {
+			return i
+		}
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].Key != elasticTraceStateKey {
+			return i
+		}
+	}
+	return -1
+}
+
+// validTraceStateKey reports whether or not key is a valid
+// tracestate key: up to 256 characters of lowercase letters,
+// digits, '_', '-', '*', '/', and at most one '@' separating
+// a tenant ID from a system ID.
+func validTraceStateKey(key string) bool {
+	if len(key) == 0 || len(key) > 256 {
+		return false
+	}
+	if strings.Count(key, "@") > 1 {
+		return false
+	}
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '*', r == '/':
+			if i == 0 {
+				return false
+			}
+		case r == '@':
+			if i == 0 || i == len(key)-1 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validTraceStateValue reports whether or not value is a valid
+// tracestate value: up to 256 printable ASCII characters, other
+// than ',' and '=', and not ending with a space.
+func validTraceStateValue(value string) bool {
+	if len(value) == 0 || len(value) > 256 || value[len(value)-1] == ' ' {
+		return false
+	}
+	for _, r := range value {
+		if r < 0x20 || r > 0x7e || r == ',' || r == '=' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package elasticapm_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-agent-go"
+)
+
+func TestParseTraceState(t *testing.T) {
+	state := elasticapm.ParseTraceState(" es=s:1 ,, foo=bar,invalid,Upper=case,foo=dup, tenant@sys=x ")
+	assert.Equal(t, elasticapm.TraceState{
+		{Key: "es", Value: "s:1"},
+		{Key: "foo", Value: "bar"},
+		{Key: "tenant@sys", Value: "x"},
+	}, state)
+	assert.Equal(t, "es=s:1,foo=bar,tenant@sys=x", state.String())
+}
+
+func TestParseTraceStateMaxEntries(t *testing.T) {
+	members := make([]string, 33)
+	for i := range members {
+		members[i] = fmt.Sprintf("k%d=v", i)
+	}
+	state := elasticapm.ParseTraceState(strings.Join(members[:32], ","))
+	assert.Len(t, state, 32)
+
+	// Entries beyond the limit are truncated, not rejected.
+	state = elasticapm.ParseTraceState(strings.Join(members, ","))
+	assert.Len(t, state, 32)
+	assert.Equal(t, "k31", state[31].Key)
+}
+
+func TestParseTraceStateMaxLength(t *testing.T) {
+	// 2 entries of 255 characters, plus a comma: 511 characters.
+	a := "a=" + strings.Repeat("x", 253)
+	b := "b=" + strings.Repeat("x", 253)
+	state := elasticapm.ParseTraceState(a + "," + b)
+	assert.Len(t, state, 2)
+	assert.Len(t, state.String(), 511)
+
+	// Adding another entry would exceed 512 characters,
+	// so the tracestate is truncated.
+	state = elasticapm.ParseTraceState(a + "," + b + ",c=x")
+	assert.Equal(t, []string{"a", "b"}, traceStateKeys(state))
+
+	// Exactly 512 characters is accepted.
+	b = "b=" + strings.Repeat("x", 254)
+	state = elasticapm.ParseTraceState(a + "," + b)
+	assert.Len(t, state, 2)
+	assert.Len(t, state.String(), 512)
+}
+
+func TestParseTraceStateKeepsElasticEntry(t *testing.T) {
+	members := make([]string, 40)
+	for i := range members {
+		members[i] = fmt.Sprintf("k%d=v", i)
+	}
+	members = append(members, "es=s:0.5")
+
+	// The "es" entry is kept in place of the last entry
+	// within the limit, even though it comes last.
+	state := elasticapm.ParseTraceState(strings.Join(members, ","))
+	assert.Len(t, state, 32)
+	assert.Equal(t, "k30", state[30].Key)
+	assert.Equal(t, elasticapm.TraceStateEntry{Key: "es", Value: "s:0.5"}, state[31])
+
+	// Likewise when the header exceeds the length limit.
+	a := "a=" + strings.Repeat("x", 253)
+	b := "b=" + strings.Repeat("x", 253)
+	state = elasticapm.ParseTraceState(a + "," + b + ",es=s:1")
+	assert.Equal(t, []string{"a", "es"}, traceStateKeys(state))
+}
+
+func TestTraceStateStringPruneEntries(t *testing.T) {
+	var state elasticapm.TraceState
+	for i := 0; i < 40; i++ {
+		state = append(state, elasticapm.TraceStateEntry{Key: fmt.Sprintf("k%d", i), Value: "v"})
+	}
+	state = append(state, elasticapm.TraceStateEntry{Key: "es", Value: "s:1"})
+
+	// The "es" entry is moved to the front, and the oldest
+	// entries are pruned to bring it within 32 entries.
+	pruned := elasticapm.ParseTraceState(state.String())
+	assert.Len(t, pruned, 32)
+	assert.Equal(t, "es", pruned[0].Key)
+	assert.Equal(t, "k0", pruned[1].Key)
+	assert.Equal(t, "k30", pruned[31].Key)
+}
+
+func TestTraceStateStringPruneLength(t *testing.T) {
+	state := elasticapm.TraceState{
+		{Key: "a", Value: strings.Repeat("x", 100)},
+		{Key: "big", Value: strings.Repeat("x", 200)},
+		{Key: "b", Value: strings.Repeat("x", 100)},
+		{Key: "c", Value: strings.Repeat("x", 100)},
+		{Key: "es", Value: strings.Repeat("x", 200)},
+	}
+	// Total length is 102+204+102+102+203+4 = 717. Entries longer
+	// than 128 characters are pruned first, other than the "es" entry.
+	// That leaves 102*3+203+3 = 512: exactly at the limit.
+	s := state.String()
+	assert.Len(t, s, 512)
+	assert.Equal(t, []string{"es", "a", "b", "c"}, traceStateKeys(elasticapm.ParseTraceState(s)))
+
+	// Adding one more character requires pruning the oldest entry.
+	state[0].Value += "x"
+	s = state.String()
+	assert.Equal(t, []string{"es", "a", "b"}, traceStateKeys(elasticapm.ParseTraceState(s)))
+}
+
+func TestSpanTraceContextState(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	assert.NoError(t, err)
+	defer tracer.Close()
+
+	traceContext := elasticapm.TraceContext{
+		Trace:   elasticapm.TraceID{1},
+		Span:    elasticapm.SpanID{1},
+		Options: elasticapm.TraceOptions(0).WithSampled(true),
+		State:   elasticapm.ParseTraceState("foo=bar"),
+	}
+	tx := tracer.StartTransactionOptions("name", "type", elasticapm.TransactionOptions{
+		TraceContext: traceContext,
+	})
+	defer tx.Done(-1)
+	span := tx.StartSpan("name", "type", nil)
+	defer span.Done(-1)
+	assert.Equal(t, traceContext.State, span.TraceContext().State)
+}
+
+func traceStateKeys(state elasticapm.TraceState) []string {
+	keys := make([]string, len(state))
+	for i, entry := range state {
+		keys[i] = entry.Key
+	}
+	return keys
+}
+
+func TestTransactionTraceStateSampleRate(t *testing.T) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	assert.NoError(t, err)
+	defer tracer.Close()
+	tracer.SetSampler(elasticapm.NewRatioSampler(0.25, rand.NewSource(0)))
+
+	tx := tracer.StartTransactionOptions("name", "type", elasticapm.TransactionOptions{
+		TraceContext: elasticapm.TraceContext{
+			Trace:   elasticapm.TraceID{1},
+			Span:    elasticapm.SpanID{1},
+			Options: elasticapm.TraceOptions(0).WithSampled(true),
+			State:   elasticapm.ParseTraceState("foo=bar,es=s:0.5"),
+		},
+	})
+	defer tx.Done(-1)
+
+	// The sample rate of a continued trace is propagated as-is.
+	span := tx.StartSpan("name", "type", nil)
+	defer span.Done(-1)
+	assert.Equal(t, "es=s:0.5,foo=bar", span.TraceContext().State.String())
+}
@@ -1,8 +1,14 @@
 package elasticapm
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
 
 	"github.com/elastic/apm-agent-go/model"
 	"github.com/elastic/apm-agent-go/stacktrace"
@@ -10,20 +16,74 @@ import (
 
 // StartTransaction returns a new Transaction with the specified
 // name and type, and with the start time set to the current time.
+// This is equivalent to calling StartTransactionOptions with a
+// zero TransactionOptions.
+//
+// Names longer than 1024 characters are truncated, as the Elastic
+// APM server would otherwise reject the transaction. The same limit
+// applies to span names, and is enforced again when the transaction
+// ends, in case the names have been set directly.
 func (t *Tracer) StartTransaction(name, transactionType string) *Transaction {
-	tx := t.newTransaction(name, transactionType)
-	tx.Timestamp = time.Now()
+	return t.StartTransactionOptions(name, transactionType, TransactionOptions{})
+}
+
+// StartTransactionOptions returns a new Transaction with the
+// specified name, type, and options.
+func (t *Tracer) StartTransactionOptions(name, transactionType string, opts TransactionOptions) *Transaction {
+	tx := t.newTransaction(name, transactionType, opts.TraceContext)
+	if opts.Service != nil {
+		service := *opts.Service
+		tx.Service = &service
+	}
+	tx.Timestamp = opts.Start
+	if tx.Timestamp.IsZero() {
+		tx.Timestamp = time.Now()
+	}
+	if tx.recording {
+		t.transactionMaxDurationMu.RLock()
+		tx.maxDuration = t.transactionMaxDuration
+		t.transactionMaxDurationMu.RUnlock()
+		if tx.maxDuration > 0 {
+			tx.watchdog = time.AfterFunc(tx.maxDuration, tx.forceEnd)
+		}
+	}
 	return tx
 }
 
+// TransactionOptions holds options for Tracer.StartTransactionOptions.
+type TransactionOptions struct {
+	// TraceContext holds the trace context of the transaction's
+	// parent, if any. If TraceContext.Trace is zero, then a new
+	// trace will be started; otherwise the transaction will
+	// continue the trace, and inherit its sampling decision.
+	// TraceContext.Baggage is propagated in either case.
+	TraceContext TraceContext
+
+	// Start is the start time of the transaction. If this has the
+	// zero value, time.Now() will be used instead.
+	Start time.Time
+
+	// Service, if non-nil, overrides the tracer's service identity
+	// for the transaction, and for errors associated with it. This
+	// is useful for processes handling requests on behalf of several
+	// logical services or environments.
+	//
+	// Overrides are only sent if the tracer is configured to send
+	// per-event service metadata, which requires server support;
+	// see Tracer.SetPerEventService. Otherwise the tracer's service
+	// identity is used.
+	Service *model.EventService
+}
+
 // newTransaction returns a new Transaction with the specified
-// name and type, and sampling applied.
-func (t *Tracer) newTransaction(name, transactionType string) *Transaction {
-	tx, _ := t.transactionPool.Get().(*Transaction)
-	if tx == nil {
-		tx = &Transaction{tracer: t}
+// name, type, and parent trace context, and sampling applied.
+func (t *Tracer) newTransaction(name, transactionType string, traceContext TraceContext) *Transaction {
+	tx := &Transaction{tracer: t}
+	if buffers, _ := t.transactionBufferPool.Get().(*transactionBuffers); buffers != nil {
+		tx.tags = buffers.tags
+		tx.spans = buffers.spans
 	}
-	tx.Name = name
+	tx.Name = truncateRunes(name, maxNameLength)
 	tx.Type = transactionType
 
 	// Take a snapshot of the max spans config to ensure
@@ -33,17 +93,69 @@ func (t *Tracer) newTransaction(name, transactionType string) *Transaction {
 	tx.maxSpans = t.maxSpans
 	t.maxSpansMu.RUnlock()
 
-	t.samplerMu.RLock()
-	sampler := t.sampler
-	t.samplerMu.RUnlock()
+	t.maxSpanStacktracesMu.RLock()
+	tx.maxSpanStacktraces = t.maxSpanStacktraces
+	t.maxSpanStacktracesMu.RUnlock()
+
+	tx.recording = t.Recording()
+	if tx.recording {
+		atomic.AddInt64(t.activeTransactions, 1)
+	}
 	tx.sampled = true
-	if sampler != nil && !sampler.Sample(tx) {
+	if !tx.recording {
+		// The tracer is not recording, so the transaction is
+		// inert; propagate the parent's trace context as-is.
+		tx.traceContext = traceContext
 		tx.sampled = false
-		tx.Transaction.Sampled = &tx.sampled
+	} else if traceContext.Trace.Validate() == nil {
+		tx.spanID = t.generator().NewSpanID()
+		// Continuing an existing trace: the sampling
+		// decision is made by the root transaction.
+		tx.traceContext = traceContext
+		tx.sampled = traceContext.Options.Sampled()
+		if rate, ok := traceContext.State.elasticSampleRate(); ok {
+			tx.sampleRate = rate
+			tx.Transaction.SampleRate = &tx.sampleRate
+		}
+	} else {
+		generator := t.generator()
+		tx.traceContext.Trace = generator.NewTraceID()
+		tx.spanID = generator.NewSpanID()
+		// Baggage may be propagated without a trace context.
+		tx.traceContext.Baggage = traceContext.Baggage
+		t.samplerMu.RLock()
+		sampler := t.sampler
+		t.samplerMu.RUnlock()
+		if sampler != nil && !sampler.Sample(tx) {
+			tx.sampled = false
+		}
+		if sampler == nil {
+			tx.setSampleRate(1)
+		} else if sampler, ok := sampler.(RatedSampler); ok {
+			tx.setSampleRate(sampler.SampleRate(tx))
+		}
+		if sampler, ok := sampler.(errorSampler); ok {
+			tx.sampledUnlessErrors = sampler.deferredSample(tx)
+			tx.deferSampling = true
+		}
+		tx.traceContext.Options = tx.traceContext.Options.WithSampled(tx.sampled)
 	}
+	// Always record the sampling decision explicitly, as some
+	// server versions require "sampled": false to be specified.
+	tx.Transaction.Sampled = &tx.sampled
 	return tx
 }
 
+// setSampleRate records the sample rate of a transaction starting
+// a new trace, and adds it to the trace state to be propagated to
+// downstream services. The rate is rounded as in the trace state, so
+// that all transactions in the trace record the same rate.
+func (tx *Transaction) setSampleRate(rate float64) {
+	tx.traceContext.State = tx.traceContext.State.withElasticSampleRate(rate)
+	tx.sampleRate, _ = tx.traceContext.State.elasticSampleRate()
+	tx.Transaction.SampleRate = &tx.sampleRate
+}
+
 // Transaction describes an event occurring in the monitored service.
 //
 // The ID, Spans, and SpanCount fields should not be modified
@@ -56,33 +168,113 @@ func (t *Tracer) newTransaction(name, transactionType string) *Transaction {
 type Transaction struct {
 	model.Transaction
 
-	tracer   *Tracer
-	sampled  bool
-	maxSpans int
+	tracer             *Tracer
+	traceContext       TraceContext
+	sampleRate         float64
+	spanID             SpanID
+	recording          bool
+	sampled            bool
+	maxSpans           int
+	maxSpanStacktraces int
+
+	// deferSampling records whether the sampling decision is
+	// deferred until the transaction ends, in which case the
+	// transaction is sampled if sampledUnlessErrors is true,
+	// or if errors were reported during the transaction.
+	deferSampling       bool
+	sampledUnlessErrors bool
+
+	// watchdog, if non-nil, force-ends the transaction if it has
+	// not ended within maxDuration. Whichever of Done and the
+	// watchdog stops or fires the timer first ends the transaction.
+	watchdog    *time.Timer
+	maxDuration time.Duration
 
-	mu           sync.Mutex
-	tags         []tag
-	spans        []*Span
-	spansDropped int
+	// pendingSends, transactionSent, and spansSent are owned by the
+	// tracer loop. When spans are sent independently of their
+	// transaction, they record the requests in flight for each and
+	// which have been sent, so only the unsent part is resent after
+	// a failure.
+	pendingSends    int
+	transactionSent bool
+	spansSent       bool
+
+	mu            sync.Mutex
+	ended         bool
+	forceEnded    bool
+	hasErrors     bool
+	renamed       bool
+	tags          []tag
+	tagsTruncated int
+	spans         []*Span
+	spansDropped  int
 }
 
 type tag struct {
 	key, value string
 }
 
-func (tx *Transaction) setID() {
-	if tx.Transaction.ID != "" {
-		return
+// newTag returns a tag with the given key and value, truncated
+// to the maximum lengths accepted by the server, and reports
+// whether or not either was truncated.
+func newTag(key, value string) (tag, bool) {
+	var truncated bool
+	if len(key) > maxTagKeyLength {
+		key = truncateBytes(key, maxTagKeyLength)
+		truncated = true
+	}
+	if utf8.RuneCountInString(value) > maxTagValueLength {
+		value = truncateRunes(value, maxTagValueLength)
+		truncated = true
 	}
-	transactionID, err := NewUUID()
-	if err != nil {
-		// We ignore the error from NewUUID, which will
-		// only occur if the entropy source fails. In
-		// that case, there's nothing we can do. We don't
-		// want to panic inside the user's application.
+	return tag{key, value}, truncated
+}
+
+// setID sets the transaction's ID, if it has not already been set,
+// and returns it. The ID is generated lazily, as it is only needed
+// when the transaction or its errors are sent, or when it is used
+// for log correlation.
+func (tx *Transaction) setID() string {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.Transaction.ID == "" {
+		tx.Transaction.ID = tx.tracer.newUUID()
+	}
+	return tx.Transaction.ID
+}
+
+// setSpanStacktraces sets the stacktraces of the transaction's spans
+// from the program counters recorded by Span.SetStacktrace. If there
+// are more such spans than tx.maxSpanStacktraces, then only the
+// longest-running spans will have their stacktraces set.
+//
+// The stacktraces are only resolved once the transaction is sent,
+// and not at all if it is not sampled: spans are recorded for every
+// transaction while the sampling decision is deferred, as with
+// NewErrorSampler, but are discarded if the transaction is not kept.
+func (tx *Transaction) setSpanStacktraces() {
+	if !tx.sampled {
 		return
 	}
-	tx.Transaction.ID = transactionID
+	var spans []*Span
+	for _, s := range tx.spans {
+		if len(s.stacktracePCs) != 0 {
+			spans = append(spans, s)
+		}
+	}
+	if tx.maxSpanStacktraces > 0 && len(spans) > tx.maxSpanStacktraces {
+		sort.Slice(spans, func(i, j int) bool {
+			return spans[i].Duration > spans[j].Duration
+		})
+		for _, s := range spans[tx.maxSpanStacktraces:] {
+			s.stacktracePCs = s.stacktracePCs[:0]
+		}
+		spans = spans[:tx.maxSpanStacktraces]
+	}
+	for _, s := range spans {
+		s.Stacktrace = stacktrace.Callers(s.stacktracePCs)
+		s.stacktracePCs = s.stacktracePCs[:0]
+	}
 }
 
 func (tx *Transaction) setContext(setter stacktrace.ContextSetter, pre, post int) error {
@@ -94,70 +286,304 @@ func (tx *Transaction) setContext(setter stacktrace.ContextSetter, pre, post int
 	return nil
 }
 
-// reset resets the Transaction back to its zero state, so it can be reused
-// in the transaction pool.
-func (tx *Transaction) reset() {
-	for _, s := range tx.spans {
-		s.reset()
-		tx.tracer.spanPool.Put(s)
+// release releases the transaction's resources once it has been sent
+// or dropped, returning its buffers, and those of its spans, to the
+// tracer's pools.
+//
+// The transaction itself is never reused, as the application may still
+// hold a reference to it or its spans. It remains marked as ended, so
+// any later calls to its methods, or those of its spans, are no-ops.
+func (tx *Transaction) release() {
+	tx.mu.Lock()
+	tx.ended = true
+	tags, spans := tx.tags, tx.spans
+	tx.tags, tx.spans = nil, nil
+	tx.mu.Unlock()
+	tx.Spans = nil
+	for i, s := range spans {
+		s.release()
+		spans[i] = nil
 	}
-	tags := tx.tags[:0]
-	spans := tx.spans[:0]
-	modelSpans := tx.Spans[:0]
-	tracer := tx.tracer
-	*tx = Transaction{}
-	tx.tags = tags
-	tx.tracer = tracer
-	tx.spans = spans
-	tx.Spans = modelSpans
+	tx.tracer.transactionBufferPool.Put(&transactionBuffers{
+		tags:  tags[:0],
+		spans: spans[:0],
+	})
+}
+
+// transactionBuffers holds the buffers of a released transaction,
+// for reuse by new transactions.
+type transactionBuffers struct {
+	tags  []tag
+	spans []*Span
+}
+
+// TraceContext returns the transaction's parent trace context,
+// or a new trace context if the transaction was not started with
+// one. The trace context's Span field identifies the transaction's
+// parent span, and will be zero if the transaction is a trace root.
+func (tx *Transaction) TraceContext() TraceContext {
+	return tx.traceContext
 }
 
 // Sampled reports whether or not the transaction is sampled.
+//
+// If the sampling decision is deferred, as with NewErrorSampler,
+// the transaction is reported as sampled until it ends.
 func (tx *Transaction) Sampled() bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
 	return tx.sampled
 }
 
 // SetTag sets a tag on the transaction, returning true if
 // the tag is added to the transaction, false otherwise.
-// The tag will not be added to a non-sampled transaction,
-// or if the tag key is invalid (contains '.', '*', or '"').
+// The tag will not be added to a non-sampled transaction.
+// The key is sanitized as described by model.SanitizeTagKey.
+//
+// Tag keys longer than 1024 bytes, and values longer than
+// 1024 characters, will be truncated, as the server would
+// otherwise reject the transaction.
 func (tx *Transaction) SetTag(key, value string) bool {
-	if !tx.Sampled() || !validTagKey(key) {
+	if !tx.Sampled() || tx.usedAfterEnd("Transaction.SetTag") {
 		return false
 	}
+	tag, truncated := newTag(model.SanitizeTagKey(key), value)
 	tx.mu.Lock()
-	tx.tags = append(tx.tags, tag{key, value})
+	tx.tags = append(tx.tags, tag)
+	if truncated {
+		tx.tagsTruncated++
+	}
 	tx.mu.Unlock()
 	return true
 }
 
+// Rename sets the transaction's name, recording the previous name in
+// the "original_name" tag if the transaction is sampled. If the
+// transaction is renamed multiple times, the tag will hold the name
+// the transaction had before the first call to Rename.
+//
+// Rename should be used in place of setting tx.Name directly when
+// the name is changed to alter the grouping of transactions, but
+// the original name is still useful for debugging.
+func (tx *Transaction) Rename(name string) {
+	if tx.usedAfterEnd("Transaction.Rename") {
+		return
+	}
+	name = truncateRunes(name, maxNameLength)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if name == tx.Name {
+		return
+	}
+	if !tx.renamed && tx.sampled {
+		tag, truncated := newTag("original_name", tx.Name)
+		tx.tags = append(tx.tags, tag)
+		if truncated {
+			tx.tagsTruncated++
+		}
+	}
+	tx.renamed = true
+	tx.Name = name
+}
+
 // Done sets the transaction's duration to the specified value, and
 // enqueues it for sending to the Elastic APM server. The Transaction
 // must not be used after this.
 //
+// Calling Done again, or calling methods of the transaction or its
+// spans after Done, has no effect and logs a debug message once per
+// call site, even after the transaction has been sent.
+//
 // If the duration specified is negative, then Done will set the
 // duration to "time.Since(tx.Timestamp)" instead.
+//
+// If the transaction is not sampled, then its Context and SpanCount
+// will be cleared before it is enqueued.
+//
+// If the transaction was started while the tracer was not recording,
+// then Done will discard the transaction. If the transaction has been
+// force-ended for exceeding the tracer's maximum transaction duration,
+// then Done is a no-op.
 func (tx *Transaction) Done(d time.Duration) {
+	if tx.usedAfterEnd("Transaction.Done") {
+		return
+	}
+	if !tx.recording {
+		atomic.AddUint64(&tx.tracer.transactionDropStats.discarded, 1)
+		tx.release()
+		return
+	}
+	if tx.watchdog != nil && !tx.watchdog.Stop() {
+		// The watchdog has fired, but may not have marked
+		// the transaction as force-ended yet.
+		tx.forceEnd()
+		return
+	}
+	tx.done(d)
+}
+
+// forceEnd is called by the watchdog timer once the transaction has
+// exceeded its maximum duration. The application may still be using
+// the transaction, so forceEnd marks it as ended, making further
+// operations on it no-ops, and enqueues a copy of what has been
+// recorded so far with the result "timeout"; see timeoutCopy.
+func (tx *Transaction) forceEnd() {
+	tx.mu.Lock()
+	if tx.ended {
+		tx.mu.Unlock()
+		return
+	}
+	tx.ended = true
+	tx.forceEnded = true
+	end := time.Now()
+	if tx.Transaction.ID == "" {
+		// Errors reported against the transaction after it has
+		// been force-ended must still refer to the copy sent.
+		tx.Transaction.ID = tx.tracer.newUUID()
+	}
+	timeout := tx.timeoutCopy()
+	tx.mu.Unlock()
+	atomic.AddInt64(tx.tracer.activeTransactions, -1)
+
+	if logger := tx.tracer.currentLogger(); logger != nil {
+		logger.Errorf(
+			"transaction %q force-ended after exceeding the maximum duration of %s",
+			timeout.Name, tx.maxDuration,
+		)
+	}
+	d := end.Sub(tx.Timestamp)
+	if d < 0 {
+		d = 0
+	}
+	timeout.done(d)
+}
+
+// timeoutCopy returns a copy of the transaction for forceEnd to send
+// in its place, taking its tags and spans. Spans which have ended are
+// copied as-is, while open spans are copied with only their name, type,
+// start, and labels, and are truncated when the copy ends. The context
+// is not copied, as the application may be modifying it.
+//
+// timeoutCopy must be called with tx.mu held.
+func (tx *Transaction) timeoutCopy() *Transaction {
+	timeout := &Transaction{
+		tracer:              tx.tracer,
+		traceContext:        tx.traceContext,
+		sampleRate:          tx.sampleRate,
+		spanID:              tx.spanID,
+		recording:           tx.recording,
+		sampled:             tx.sampled,
+		maxSpanStacktraces:  tx.maxSpanStacktraces,
+		deferSampling:       tx.deferSampling,
+		sampledUnlessErrors: tx.sampledUnlessErrors,
+		maxDuration:         tx.maxDuration,
+		forceEnded:          true,
+		hasErrors:           tx.hasErrors,
+		tags:                tx.tags,
+		tagsTruncated:       tx.tagsTruncated,
+		spansDropped:        tx.spansDropped,
+	}
+	timeout.Transaction = model.Transaction{
+		ID:        tx.Transaction.ID,
+		Name:      tx.Name,
+		Type:      tx.Type,
+		Timestamp: tx.Timestamp,
+		Result:    "timeout",
+		Sampled:   &timeout.sampled,
+		Service:   tx.Service,
+	}
+	if tx.Transaction.SampleRate != nil {
+		timeout.Transaction.SampleRate = &timeout.sampleRate
+	}
+	if len(tx.spans) > 0 {
+		timeout.spans = make([]*Span, len(tx.spans))
+	}
+	for i, s := range tx.spans {
+		span := &Span{tx: timeout, id: s.id, parentID: s.parentID}
+		s.mu.Lock()
+		if s.done {
+			span.Span = s.Span
+			span.Span.Stacktrace = append([]model.StacktraceFrame(nil), s.Span.Stacktrace...)
+			span.Span.Links = append([]model.SpanLink(nil), s.Span.Links...)
+			span.stacktracePCs = s.stacktracePCs
+			span.done = true
+		} else {
+			span.Name = s.Name
+			span.Type = s.Type
+			span.Start = s.Start
+			span.ID = s.ID
+			span.Parent = s.Parent
+		}
+		span.tags = s.tags
+		s.tags = nil
+		s.mu.Unlock()
+		timeout.spans[i] = span
+	}
+	tx.tags = nil
+	tx.spans = nil
+	return timeout
+}
+
+func (tx *Transaction) done(d time.Duration) {
 	if d < 0 {
 		d = time.Since(tx.Timestamp)
 	}
+	tx.mu.Lock()
+	tx.ended = true
+	forceEnded := tx.forceEnded
+	tx.mu.Unlock()
+	if !forceEnded {
+		// forceEnd has already decremented the count
+		// for the transaction this is a copy of.
+		atomic.AddInt64(tx.tracer.activeTransactions, -1)
+	}
 	tx.Duration = d
+	if tx.deferSampling && !tx.sampledUnlessErrors {
+		tx.mu.Lock()
+		tx.sampled = tx.hasErrors
+		tx.mu.Unlock()
+	}
+	// The name may have been set directly, so ensure
+	// it does not exceed the server's length limit.
+	tx.Name = truncateRunes(tx.Name, maxNameLength)
+	tx.tracer.breakdownMetrics.recordTransaction(tx)
+	tx.TraceID = tx.traceContext.Trace.String()
+	tx.SpanID = tx.spanID.String()
+	if tx.traceContext.Span.Validate() == nil {
+		tx.ParentID = tx.traceContext.Span.String()
+	}
+	if !tx.sampled {
+		// Non-sampled transactions omit context and span details,
+		// even if they have been set by the application.
+		tx.Context = nil
+		tx.SpanCount = nil
+		atomic.AddUint64(&tx.tracer.transactionDropStats.notSampled, 1)
+		tx.enqueue()
+		return
+	}
 
 	tx.mu.Lock()
 	spans := tx.spans[:len(tx.spans)]
 	tags := tx.tags[:len(tx.tags)]
+	spansDropped := tx.spansDropped
 	tx.mu.Unlock()
 	if len(spans) != 0 {
 		tx.Spans = make([]*model.Span, len(spans))
 		for i, s := range spans {
 			s.truncate(d)
+			s.setContextTags()
+			truncateSpanFields(&s.Span)
+			s.TraceID = tx.TraceID
+			s.SpanID = s.id.String()
+			s.ParentID = s.parentID.String()
 			tx.Spans[i] = &s.Span
 		}
+		atomic.AddUint64(&tx.tracer.spanStats.recorded, uint64(len(spans)))
 	}
-	if tx.spansDropped > 0 {
+	if spansDropped > 0 {
 		tx.SpanCount = &model.SpanCount{
 			Dropped: &model.SpanCountDropped{
-				Total: tx.spansDropped,
+				Total: spansDropped,
 			},
 		}
 	}
@@ -184,101 +610,419 @@ func (tx *Transaction) enqueue() {
 		tx.tracer.statsMu.Lock()
 		tx.tracer.stats.TransactionsDropped++
 		tx.tracer.statsMu.Unlock()
-		tx.reset()
-		tx.tracer.transactionPool.Put(tx)
+		atomic.AddUint64(&tx.tracer.transactionDropStats.bufferFull, 1)
+		if logger := tx.tracer.currentLogger(); logger != nil {
+			logger.Debugf("transaction buffer full, dropping transaction %q", tx.Name)
+		}
+		tx.release()
 	}
 }
 
 // StartSpan starts and returns a new Span within the transaction,
 // with the specified name, type, and optional parent span, and
 // with the start time set to the current time relative to the
-// transaction's timestamp. The span's ID will be set.
+// transaction's timestamp. If the transaction's timestamp is in
+// the future, the span's start will be zero. The span's ID will
+// be set.
 //
 // If the transaction is not being sampled, then StartSpan will
-// return nil.
+// return nil without allocating. A nil Span is inert: its methods
+// may be called, and are no-ops.
 //
 // If the transaction is sampled, then the span's ID will be set,
 // and its stacktrace will be set if the tracer is configured
 // accordingly.
+//
+// If the transaction's span limit has been reached, or the transaction
+// has been force-ended by the tracer, then the span will be dropped.
+// Dropped spans do not hold any pooled resources, and will not record
+// stacktraces.
 func (tx *Transaction) StartSpan(name, transactionType string, parent *Span) *Span {
+	return tx.StartSpanOptions(name, transactionType, SpanOptions{Parent: parent})
+}
+
+// SpanOptions holds options for Transaction.StartSpanOptions and
+// StartSpanOptions.
+type SpanOptions struct {
+	// Parent, if non-nil, holds the span's parent span. The parent
+	// must belong to the same transaction as the span, and must not
+	// have been dropped; otherwise it is ignored, and the span will
+	// be a child of the transaction.
+	Parent *Span
+
+	// TransactionParent, if true, makes the span a child of the
+	// transaction, ignoring Parent and, for StartSpanOptions, any
+	// span in the context. This is useful for work started within
+	// a span that is logically independent of it, e.g. background
+	// work kicked off mid-request.
+	TransactionParent bool
+}
+
+// StartSpanOptions starts and returns a new Span within the transaction,
+// with the specified name, type, and options. See StartSpan for details.
+func (tx *Transaction) StartSpanOptions(name, transactionType string, opts SpanOptions) *Span {
 	if !tx.Sampled() {
 		return nil
 	}
+	parent := opts.Parent
+	if opts.TransactionParent || parent != nil && (parent.tx != tx || parent.dropped) {
+		parent = nil
+	}
 
 	start := time.Since(tx.Timestamp)
-	span, _ := tx.tracer.spanPool.Get().(*Span)
-	if span == nil {
-		span = &Span{}
+	if start < 0 {
+		start = 0
 	}
-	span.tx = tx
-	span.Name = name
-	span.Type = transactionType
-	span.Start = start
 
+	// Generate the span ID before locking the transaction,
+	// as the tracer's IDGenerator may be slow.
+	id := tx.tracer.generator().NewSpanID()
+	atomic.AddUint64(&tx.tracer.spanStats.started, 1)
 	tx.mu.Lock()
-	if tx.maxSpans > 0 && len(tx.spans) >= tx.maxSpans {
-		span.dropped = true
-		tx.spansDropped++
-	} else {
-		if parent != nil {
-			span.Parent = parent.ID
+	if ended := tx.ended; ended || tx.maxSpans > 0 && len(tx.spans) >= tx.maxSpans {
+		if ended {
+			atomic.AddUint64(&tx.tracer.spanStats.droppedTransactionEnded, 1)
+		} else {
+			tx.spansDropped++
+			atomic.AddUint64(&tx.tracer.spanStats.droppedMaxSpans, 1)
+		}
+		forceEnded := tx.forceEnded
+		tx.mu.Unlock()
+		if ended && !forceEnded {
+			tx.tracer.logUseAfterEnd("Transaction.StartSpan")
 		}
-		spanID := int64(len(tx.spans))
-		span.ID = &spanID
-		tx.spans = append(tx.spans, span)
+		// Dropped spans are never added to the transaction,
+		// and so would never be released; allocate a new span
+		// without taking any buffers from the span buffer pool.
+		return &Span{
+			Span: model.Span{
+				Name:  name,
+				Type:  transactionType,
+				Start: start,
+			},
+			tx:      tx,
+			dropped: true,
+		}
+	}
+	span := &Span{tx: tx}
+	if buffers, _ := tx.tracer.spanBufferPool.Get().(*spanBuffers); buffers != nil {
+		span.Span.Stacktrace = buffers.stacktrace
+		span.Span.Links = buffers.links
+		span.tags = buffers.tags
+	}
+	span.Name = truncateRunes(name, maxNameLength)
+	span.Type = transactionType
+	span.Start = start
+	if parent != nil {
+		span.Parent = parent.ID
+		span.parentID = parent.id
+	} else {
+		span.parentID = tx.spanID
 	}
+	span.id = id
+	spanID := int64(len(tx.spans))
+	span.ID = &spanID
+	tx.spans = append(tx.spans, span)
 	tx.mu.Unlock()
 	return span
 }
 
+// RecordSpan records a completed span within the transaction, with
+// the given parent span (if non-nil). This can be used for recording
+// timings of operations measured outside of the Go application, such
+// as in a non-Go subprocess.
+//
+// The span's Name and Type must be specified, and its Start and
+// Duration fields will be used as the span's timing; Start is relative
+// to the transaction's start time. The timings will be clamped so that
+// the span lies within the transaction's elapsed time up to now. The
+// span's Context, Exit, and Stacktrace fields are also recorded; all
+// other fields are ignored.
+//
+// If the transaction is not being sampled, or the transaction's span
+// limit has been reached, then RecordSpan does nothing.
+func (tx *Transaction) RecordSpan(in model.Span, parent *Span) error {
+	if in.Name == "" {
+		return errors.New("span name must be specified")
+	}
+	if in.Type == "" {
+		return errors.New("span type must be specified")
+	}
+	if !tx.Sampled() {
+		return nil
+	}
+	start, duration := clampSpanTiming(in.Start, in.Duration, time.Since(tx.Timestamp))
+	span := tx.StartSpan(in.Name, in.Type, parent)
+	if span.Dropped() {
+		return nil
+	}
+	span.Start = start
+	span.Context = in.Context
+	span.Exit = in.Exit
+	span.Stacktrace = append(span.Stacktrace[:0], in.Stacktrace...)
+	span.Done(duration)
+	return nil
+}
+
+// clampSpanTiming returns start and duration, adjusted such that
+// the span lies within [0, elapsed] relative to the transaction's
+// start time.
+func clampSpanTiming(start, duration, elapsed time.Duration) (time.Duration, time.Duration) {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if start < 0 {
+		start = 0
+	} else if start > elapsed {
+		start = elapsed
+	}
+	if duration < 0 {
+		duration = 0
+	} else if duration > elapsed-start {
+		duration = elapsed - start
+	}
+	return start, duration
+}
+
 // Span describes an operation within a transaction.
 type Span struct {
 	model.Span
-	tx      *Transaction
-	dropped bool
+	tx            *Transaction
+	id            SpanID
+	parentID      SpanID
+	dropped       bool
+	stacktracePCs []uintptr
 
 	mu        sync.Mutex
 	done      bool
 	truncated bool
+	tags      []tag
+}
+
+// release returns the span's buffers to the tracer's pool, once its
+// transaction has been released.
+func (s *Span) release() {
+	s.mu.Lock()
+	tags := s.tags
+	s.tags = nil
+	s.mu.Unlock()
+	buffers := &spanBuffers{
+		stacktrace: s.Span.Stacktrace[:0],
+		links:      s.Span.Links[:0],
+		tags:       tags[:0],
+	}
+	s.Span.Stacktrace = nil
+	s.Span.Links = nil
+	s.tx.tracer.spanBufferPool.Put(buffers)
+}
+
+// spanBuffers holds the buffers of a released span,
+// for reuse by new spans.
+type spanBuffers struct {
+	stacktrace []model.StacktraceFrame
+	links      []model.SpanLink
+	tags       []tag
+}
+
+// SetLabel sets a label on the span, returning true if the label is
+// added to the span, false otherwise. Labels are recorded in the span
+// context's tags, and may be used for filtering spans, e.g. by cache
+// hit or miss. The label will not be added to a dropped span. As for
+// Transaction.SetTag, the key is sanitized by model.SanitizeTagKey.
+//
+// As with Transaction.SetTag, label keys longer than 1024 bytes, and
+// values longer than 1024 characters, will be truncated; truncated
+// labels are counted with the transaction's truncated tags.
+func (s *Span) SetLabel(key, value string) bool {
+	if s.Dropped() || s.tx.usedAfterEnd("Span.SetLabel") {
+		return false
+	}
+	tag, truncated := newTag(model.SanitizeTagKey(key), value)
+	s.mu.Lock()
+	s.tags = append(s.tags, tag)
+	s.mu.Unlock()
+	if truncated {
+		s.tx.mu.Lock()
+		s.tx.tagsTruncated++
+		s.tx.mu.Unlock()
+	}
+	return true
 }
 
-func (s *Span) reset() {
-	stacktrace := s.Span.Stacktrace[:0]
-	*s = Span{}
-	s.Span.Stacktrace = stacktrace
+// setContextTags adds the span's labels to a copy of its context's
+// tags, so that any context shared between spans is not modified.
+func (s *Span) setContextTags() {
+	s.mu.Lock()
+	tags := s.tags
+	s.mu.Unlock()
+	if len(tags) == 0 {
+		return
+	}
+	var spanContext model.SpanContext
+	if s.Context != nil {
+		spanContext = *s.Context
+	}
+	contextTags := make(map[string]string, len(spanContext.Tags)+len(tags))
+	for k, v := range spanContext.Tags {
+		contextTags[k] = v
+	}
+	for _, tag := range tags {
+		contextTags[tag.key] = tag.value
+	}
+	spanContext.Tags = contextTags
+	s.Context = &spanContext
 }
 
 // SetStacktrace sets the stacktrace for the span,
 // skipping the first skip number of frames,
 // excluding the SetStacktrace function.
 //
+// The stack frames are recorded cheaply, and resolved when the
+// transaction is sent, if it is sampled. See Tracer.SetMaxSpanStacktraces for
+// limiting the number of spans within a transaction whose
+// stacktraces are reported.
+//
 // If the span is dropped, this method is a no-op.
 func (s *Span) SetStacktrace(skip int) {
-	if s.Dropped() {
+	if s.Dropped() || s.tx.usedAfterEnd("Span.SetStacktrace") {
 		return
 	}
-	// TODO(axw) consider using an LRU cache for
-	// the stacktrace frames. We can key on the
-	// top of the stack.
-	s.Stacktrace = stacktrace.Stacktrace(skip+1, -1)
+	// Only the program counters are recorded here; they are
+	// resolved to frames, via the stacktrace package's frame
+	// cache, when the transaction is sent.
+	s.stacktracePCs = stacktrace.RuntimeCallers(skip+1, -1)
+}
+
+// TraceContext returns the span's trace context, identifying the
+// span within its trace. This may be propagated to other services,
+// e.g. in outgoing requests, to continue the trace. Dropped spans
+// have a zero span ID, and a nil span has a zero trace context.
+func (s *Span) TraceContext() TraceContext {
+	if s == nil {
+		return TraceContext{}
+	}
+	return TraceContext{
+		Trace:   s.tx.traceContext.Trace,
+		Span:    s.id,
+		Options: s.tx.traceContext.Options,
+		State:   s.tx.traceContext.State,
+		Baggage: s.tx.traceContext.Baggage,
+	}
+}
+
+// SetDestinationAddress sets the network address and port of the
+// span's destination, and marks the span as an exit span. The host
+// must be non-empty, and the port must be in the range 1-65535.
+//
+// If the span is dropped, this method is a no-op.
+func (s *Span) SetDestinationAddress(host string, port int) error {
+	if host == "" {
+		return errors.New("destination host must be specified")
+	}
+	if port < 1 || port > 65535 {
+		return errors.Errorf("destination port %d out of range", port)
+	}
+	if s.Dropped() || s.tx.usedAfterEnd("Span.SetDestinationAddress") {
+		return nil
+	}
+	destination := s.destination()
+	destination.Address = host
+	destination.Port = port
+	s.Exit = true
+	return nil
+}
+
+// SetDestinationService sets the service and resource of the span's
+// destination, and marks the span as an exit span. Spans with the
+// same destination resource, e.g. "elasticsearch:9200", are treated
+// as operating on the same downstream dependency in the service map.
+// The resource must be non-empty.
+//
+// If the span is dropped, this method is a no-op.
+func (s *Span) SetDestinationService(name, resource, serviceType string) error {
+	if resource == "" {
+		return errors.New("destination resource must be specified")
+	}
+	if s.Dropped() || s.tx.usedAfterEnd("Span.SetDestinationService") {
+		return nil
+	}
+	s.destination().Service = &model.DestinationServiceSpanContext{
+		Type:     serviceType,
+		Name:     name,
+		Resource: resource,
+	}
+	s.Exit = true
+	return nil
+}
+
+// destination returns the span's destination context for
+// modification, copying the span context and destination
+// first, as instrumentation may share them between spans.
+func (s *Span) destination() *model.DestinationSpanContext {
+	var spanContext model.SpanContext
+	if s.Context != nil {
+		spanContext = *s.Context
+	}
+	var destination model.DestinationSpanContext
+	if spanContext.Destination != nil {
+		destination = *spanContext.Destination
+	}
+	spanContext.Destination = &destination
+	s.Context = &spanContext
+	return &destination
+}
+
+// AddLink adds a link from the span to the span identified by c,
+// which may belong to another trace, recording a causal relationship
+// other than that with the span's parent. This is useful for spans
+// aggregating the work of multiple operations, such as a batch write
+// triggered by several requests, each of which may be linked to.
+//
+// Links with an invalid trace or span ID are ignored. If the span is
+// dropped, this method is a no-op.
+func (s *Span) AddLink(c TraceContext) {
+	if s.Dropped() || c.Trace.Validate() != nil || c.Span.Validate() != nil {
+		return
+	}
+	if s.tx.usedAfterEnd("Span.AddLink") {
+		return
+	}
+	s.Links = append(s.Links, model.SpanLink{
+		TraceID: c.Trace.String(),
+		SpanID:  c.Span.String(),
+	})
+}
+
+// hasDestinationResource reports whether or not the span
+// has a destination service resource specified.
+func hasDestinationResource(span *model.Span) bool {
+	return span.Context != nil &&
+		span.Context.Destination != nil &&
+		span.Context.Destination.Service != nil &&
+		span.Context.Destination.Service.Resource != ""
 }
 
 // Dropped indicates whether or not the span is dropped, meaning it
 // will not be included in the transaction. Spans are dropped when
-// the configurable limit is reached.
+// the configurable limit is reached. A nil span is considered to be
+// dropped.
 func (s *Span) Dropped() bool {
-	return s.dropped
+	return s == nil || s.dropped
 }
 
 // Done sets the span's duration to the specified value. The Span
 // must not be used after this.
 //
+// Calling Done again, or calling methods of the transaction or its
+// spans after Done, has no effect and logs a debug message once per
+// call site, even after the transaction has been sent.
+//
 // If the duration specified is negative, then Done will set the
 // duration to "time.Since(tx.Timestamp.Add(s.Start))" instead.
 //
 // If the span is dropped, this method is a no-op.
 func (s *Span) Done(d time.Duration) {
-	if s.Dropped() {
+	if s.Dropped() || s.tx.usedAfterEnd("Span.Done") {
 		return
 	}
 	if d < 0 {
@@ -293,6 +1037,46 @@ func (s *Span) Done(d time.Duration) {
 	s.mu.Unlock()
 }
 
+// DoneContext is like Done, but additionally records the span's
+// outcome as a failure if ctx has been cancelled or its deadline
+// exceeded, with the "context_error" tag set to "canceled" or
+// "deadline_exceeded" respectively. This surfaces timeouts which
+// would otherwise appear only as slow spans.
+//
+// If ctx is nil, DoneContext is equivalent to Done.
+func (s *Span) DoneContext(ctx context.Context, d time.Duration) {
+	if s.Dropped() || s.tx.usedAfterEnd("Span.DoneContext") {
+		return
+	}
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			s.setContextError(err)
+		}
+	}
+	s.Done(d)
+}
+
+// contextErrorTagKey is the span tag recording the reason for a
+// span's context ending before the span, as set by DoneContext.
+const contextErrorTagKey = "context_error"
+
+func (s *Span) setContextError(err error) {
+	reason := "canceled"
+	if err == context.DeadlineExceeded {
+		reason = "deadline_exceeded"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.truncated {
+		return
+	}
+	s.Outcome = model.OutcomeFailure
+	// The tag is added to the span's labels rather than its context,
+	// which may be shared with other spans; see setContextTags.
+	tag, _ := newTag(contextErrorTagKey, reason)
+	s.tags = append(s.tags, tag)
+}
+
 func (s *Span) truncate(d time.Duration) {
 	s.mu.Lock()
 	if !s.done {
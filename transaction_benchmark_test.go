@@ -0,0 +1,74 @@
+package elasticapm_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/elastic/apm-agent-go"
+	"github.com/elastic/apm-agent-go/transport/transporttest"
+)
+
+func BenchmarkSpanStacktrace(b *testing.B) {
+	b.Run("under_limit", func(b *testing.B) {
+		benchmarkSpanStacktrace(b, 0)
+	})
+	b.Run("over_limit", func(b *testing.B) {
+		// With a span limit of 1, all spans
+		// after the first one are dropped.
+		benchmarkSpanStacktrace(b, 1)
+	})
+}
+
+func benchmarkSpanStacktrace(b *testing.B, maxSpans int) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+	tracer.SetMaxSpans(maxSpans)
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.Done(-1)
+	tx.StartSpan("name", "type", nil).Done(-1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		span := tx.StartSpan("name", "type", nil)
+		span.SetStacktrace(1)
+		span.Done(-1)
+	}
+}
+
+func BenchmarkTransaction(b *testing.B) {
+	b.Run("sampled", func(b *testing.B) {
+		benchmarkTransaction(b, elasticapm.NewRatioSampler(1, rand.NewSource(0)))
+	})
+	b.Run("non_sampled", func(b *testing.B) {
+		benchmarkTransaction(b, elasticapm.NewRatioSampler(0, rand.NewSource(0)))
+	})
+}
+
+func benchmarkTransaction(b *testing.B, sampler elasticapm.Sampler) {
+	tracer, err := elasticapm.NewTracer("tracer.testing", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+	tracer.SetSampler(sampler)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx := tracer.StartTransaction("name", "type")
+		ctx := elasticapm.ContextWithTransaction(context.Background(), tx)
+		for j := 0; j < 10; j++ {
+			span, _ := elasticapm.StartSpan(ctx, "name", "type")
+			span.Done(-1)
+		}
+		tx.Done(-1)
+	}
+}
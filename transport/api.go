@@ -15,3 +15,38 @@ type Transport interface {
 	// SendErrors sends the errors payload to the server.
 	SendErrors(context.Context, *model.ErrorsPayload) error
 }
+
+// MetricsTransport is an optional interface that may be implemented
+// by a Transport, for sending metrics payloads. Metrics are not
+// gathered for sending if the Transport does not implement it.
+type MetricsTransport interface {
+	// SendMetrics sends the metrics payload to the server.
+	SendMetrics(context.Context, *model.MetricsPayload) error
+}
+
+// SpansTransport is an optional interface that may be implemented
+// by a Transport, for sending spans independently of transactions.
+// Servers must support receiving spans independently for this to
+// be used.
+type SpansTransport interface {
+	// SendSpans sends the spans payload to the server.
+	SendSpans(context.Context, *model.SpansPayload) error
+}
+
+// Event types which may be reported by EventTypesTransport.
+const (
+	EventTypeTransaction = "transaction"
+	EventTypeError       = "error"
+	EventTypeMetric      = "metric"
+)
+
+// EventTypesTransport is an optional interface that may be implemented
+// by a Transport, for querying the types of events accepted by the
+// server. The tracer uses this to avoid sending events which the
+// server would reject, such as metrics to servers predating them.
+type EventTypesTransport interface {
+	// SupportedEventTypes returns the types of events accepted
+	// by the server, e.g. EventTypeTransaction. An error should
+	// be returned if the server's capabilities are unknown.
+	SupportedEventTypes(context.Context) ([]string, error)
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"sync/atomic"
 
+	"github.com/pkg/errors"
+
 	"github.com/elastic/apm-agent-go/internal/pretty"
 	"github.com/elastic/apm-agent-go/model"
 )
@@ -29,3 +31,43 @@ func (dt *debugTransport) SendErrors(ctx context.Context, p *model.ErrorsPayload
 	log.Printf("elasticapm SendErrors %d <- %v", id, err)
 	return err
 }
+
+func (dt *debugTransport) SendSpans(ctx context.Context, p *model.SpansPayload) error {
+	id := atomic.AddUint64(&dt.id, 1)
+	log.Printf("elasticapm SendSpans %d -> %# v", id, pretty.Formatter(p))
+	var err error
+	if st, ok := dt.transport.(SpansTransport); ok {
+		err = st.SendSpans(ctx, p)
+	} else {
+		err = errors.New("transport does not support sending spans")
+	}
+	log.Printf("elasticapm SendSpans %d <- %v", id, err)
+	return err
+}
+
+func (dt *debugTransport) SendMetrics(ctx context.Context, p *model.MetricsPayload) error {
+	id := atomic.AddUint64(&dt.id, 1)
+	log.Printf("elasticapm SendMetrics %d -> %# v", id, pretty.Formatter(p))
+	var err error
+	if mt, ok := dt.transport.(MetricsTransport); ok {
+		err = mt.SendMetrics(ctx, p)
+	} else {
+		err = errors.New("transport does not support sending metrics")
+	}
+	log.Printf("elasticapm SendMetrics %d <- %v", id, err)
+	return err
+}
+
+func (dt *debugTransport) SupportedEventTypes(ctx context.Context) ([]string, error) {
+	id := atomic.AddUint64(&dt.id, 1)
+	log.Printf("elasticapm SupportedEventTypes %d ->", id)
+	var eventTypes []string
+	var err error
+	if et, ok := dt.transport.(EventTypesTransport); ok {
+		eventTypes, err = et.SupportedEventTypes(ctx)
+	} else {
+		err = errors.New("transport does not support querying event types")
+	}
+	log.Printf("elasticapm SupportedEventTypes %d <- %v, %v", id, eventTypes, err)
+	return eventTypes, err
+}
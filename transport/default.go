@@ -3,13 +3,27 @@ package transport
 import (
 	"os"
 
+	"github.com/pkg/errors"
+
 	"github.com/elastic/apm-agent-go/internal/apmdebug"
 )
 
+const (
+	envTransport = "ELASTIC_APM_TRANSPORT"
+)
+
 var (
 	// Default is the default Transport, using the
 	// ELASTIC_APM_* environment variables.
 	//
+	// If ELASTIC_APM_TRANSPORT is set to "stderr", then
+	// Default will write payloads to stderr as JSON, as
+	// described by NewWriterTransport.
+	//
+	// If ELASTIC_APM_TRANSPORT is set to "otlp", then Default
+	// will export payloads via OTLP/HTTP to the receiver at
+	// ELASTIC_APM_OTLP_ENDPOINT, as described by NewOTLPTransport.
+	//
 	// If ELASTIC_APM_SERVER_URL is not defined, then
 	// Defaultwill be set to Discard. If it is defined,
 	// but invalid, then Default will be set to a transport
@@ -39,6 +53,20 @@ func InitDefault() (Transport, error) {
 }
 
 func getDefault() (Transport, error) {
+	switch value := os.Getenv(envTransport); value {
+	case "":
+	case "stderr":
+		return NewWriterTransport(os.Stderr), nil
+	case "otlp":
+		t, err := NewOTLPTransport("")
+		if err != nil {
+			return discardTransport{err}, err
+		}
+		return t, nil
+	default:
+		err := errors.Errorf("invalid %s value %q", envTransport, value)
+		return discardTransport{err}, err
+	}
 	url := os.Getenv(envServerURL)
 	if url == "" {
 		return Discard, nil
@@ -17,3 +17,11 @@ func (t discardTransport) SendTransactions(context.Context, *model.TransactionsP
 func (t discardTransport) SendErrors(context.Context, *model.ErrorsPayload) error {
 	return t.err
 }
+
+func (t discardTransport) SendMetrics(context.Context, *model.MetricsPayload) error {
+	return t.err
+}
+
+func (t discardTransport) SendSpans(context.Context, *model.SpansPayload) error {
+	return t.err
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -18,8 +19,14 @@ import (
 )
 
 const (
-	transactionsPath = "/v1/transactions"
-	errorsPath       = "/v1/errors"
+	// DefaultIntakePath is the default path, relative to the
+	// server URL, under which the intake API endpoints reside.
+	DefaultIntakePath = "/v1"
+
+	transactionsPath = "transactions"
+	errorsPath       = "errors"
+	metricsPath      = "metrics"
+	spansPath        = "spans"
 
 	envSecretToken      = "ELASTIC_APM_SECRET_TOKEN"
 	envServerURL        = "ELASTIC_APM_SERVER_URL"
@@ -39,6 +46,8 @@ type HTTPTransport struct {
 	baseURL         *url.URL
 	transactionsURL *url.URL
 	errorsURL       *url.URL
+	metricsURL      *url.URL
+	spansURL        *url.URL
 	headers         http.Header
 }
 
@@ -50,7 +59,9 @@ type HTTPTransport struct {
 // value of the ELASTIC_APM_SERVER_URL environment variable, if defined; if
 // the environment variable is also undefined, then an error will be returned.
 // The URL must be the base server URL, excluding any transactions or errors
-// path. e.g. "http://elastic-apm.example:8200".
+// path. e.g. "http://elastic-apm.example:8200". Requests will be sent to
+// the intake API endpoints under DefaultIntakePath, relative to the base
+// server URL; this may be changed using SetIntakePath.
 //
 // If the secret token specified is the empty string, then NewHTTPTransport
 // will use the value of the ELASTIC_APM_SECRET_TOKEN environment variable, if
@@ -102,19 +113,32 @@ func NewHTTPTransport(serverURL, secretToken string) (*HTTPTransport, error) {
 		headers.Set("Authorization", "Bearer "+secretToken)
 	}
 
-	return &HTTPTransport{
-		Client:          client,
-		baseURL:         req.URL,
-		transactionsURL: urlWithPath(req.URL, transactionsPath),
-		errorsURL:       urlWithPath(req.URL, errorsPath),
-		headers:         headers,
-	}, nil
+	t := &HTTPTransport{
+		Client:  client,
+		baseURL: req.URL,
+		headers: headers,
+	}
+	t.SetIntakePath(DefaultIntakePath)
+	return t, nil
+}
+
+// SetIntakePath sets the path, relative to the base server URL,
+// under which the intake API endpoints reside. The path is joined
+// with the base server URL, so leading and trailing slashes are
+// optional; e.g. "/v1", "v1", and "v1/" are all equivalent.
+//
+// SetIntakePath must not be called concurrently with sending.
+func (t *HTTPTransport) SetIntakePath(intakePath string) {
+	t.transactionsURL = urlWithPath(t.baseURL, intakePath, transactionsPath)
+	t.errorsURL = urlWithPath(t.baseURL, intakePath, errorsPath)
+	t.metricsURL = urlWithPath(t.baseURL, intakePath, metricsPath)
+	t.spansURL = urlWithPath(t.baseURL, intakePath, spansPath)
 }
 
 // SendTransactions sends the transactions payload over HTTP.
 func (t *HTTPTransport) SendTransactions(ctx context.Context, p *model.TransactionsPayload) error {
 	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(p); err != nil {
+	if err := model.EncodeTransactionsPayload(&buf, p); err != nil {
 		return errors.Wrap(err, "encoding transactions payload failed")
 	}
 	req := t.newTransactionsRequest().WithContext(ctx)
@@ -135,6 +159,91 @@ func (t *HTTPTransport) SendErrors(ctx context.Context, p *model.ErrorsPayload)
 	return t.send(req, "SendErrors")
 }
 
+// SendMetrics sends the metrics payload over HTTP.
+func (t *HTTPTransport) SendMetrics(ctx context.Context, p *model.MetricsPayload) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(p); err != nil {
+		return errors.Wrap(err, "encoding metrics payload failed")
+	}
+	req := t.newMetricsRequest().WithContext(ctx)
+	req.ContentLength = int64(buf.Len())
+	req.Body = ioutil.NopCloser(&buf)
+	return t.send(req, "SendMetrics")
+}
+
+// SendSpans sends the spans payload over HTTP. This requires a server
+// which supports receiving spans independently of transactions.
+func (t *HTTPTransport) SendSpans(ctx context.Context, p *model.SpansPayload) error {
+	var buf bytes.Buffer
+	if err := model.EncodeSpansPayload(&buf, p); err != nil {
+		return errors.Wrap(err, "encoding spans payload failed")
+	}
+	req := t.newRequest(t.spansURL).WithContext(ctx)
+	req.ContentLength = int64(buf.Len())
+	req.Body = ioutil.NopCloser(&buf)
+	return t.send(req, "SendSpans")
+}
+
+// SupportedEventTypes queries the server information endpoint, at
+// the base server URL, for the types of events the server accepts.
+// The server's capabilities are derived from its version: servers
+// prior to 6.3 do not report their version, nor accept metrics.
+func (t *HTTPTransport) SupportedEventTypes(ctx context.Context) ([]string, error) {
+	req := t.newRequest(t.baseURL).WithContext(ctx)
+	req.Method = "GET"
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "sending request for SupportedEventTypes failed")
+	}
+	defer resp.Body.Close()
+	bodyContents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response for SupportedEventTypes failed")
+	}
+	eventTypes := []string{EventTypeTransaction, EventTypeError}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return eventTypes, nil
+	default:
+		return nil, &HTTPError{
+			Op:       "SupportedEventTypes",
+			Response: resp,
+			Message:  strings.TrimSpace(string(bodyContents)),
+		}
+	}
+	var info struct {
+		Version string `json:"version"`
+	}
+	if json.Unmarshal(bodyContents, &info) != nil {
+		return eventTypes, nil
+	}
+	if major, minor, ok := parseServerVersion(info.Version); ok {
+		if major > 6 || major == 6 && minor >= 3 {
+			eventTypes = append(eventTypes, EventTypeMetric)
+		}
+	}
+	return eventTypes, nil
+}
+
+// parseServerVersion parses the major and minor components of
+// the server version v, e.g. "6.3.0" or "7.0.0-alpha1".
+func parseServerVersion(v string) (major, minor int, ok bool) {
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
 func (t *HTTPTransport) send(req *http.Request, op string) error {
 	resp, err := t.Client.Do(req)
 	if err != nil {
@@ -154,6 +263,21 @@ func (t *HTTPTransport) send(req *http.Request, op string) error {
 	if err == nil {
 		resp.Body = ioutil.NopCloser(bytes.NewReader(bodyContents))
 	}
+	if resp.StatusCode == http.StatusBadRequest {
+		// The server reports events that failed validation
+		// individually; the others will have been accepted.
+		var response struct {
+			Accepted int             `json:"accepted"`
+			Errors   []RejectedEvent `json:"errors"`
+		}
+		if json.Unmarshal(bodyContents, &response) == nil && len(response.Errors) > 0 {
+			return &RejectedEventsError{
+				Op:       op,
+				Accepted: response.Accepted,
+				Rejected: response.Errors,
+			}
+		}
+	}
 	return &HTTPError{
 		Op:       op,
 		Response: resp,
@@ -169,6 +293,10 @@ func (t *HTTPTransport) newErrorsRequest() *http.Request {
 	return t.newRequest(t.errorsURL)
 }
 
+func (t *HTTPTransport) newMetricsRequest() *http.Request {
+	return t.newRequest(t.metricsURL)
+}
+
 func (t *HTTPTransport) newRequest(url *url.URL) *http.Request {
 	req := &http.Request{
 		Method:     "POST",
@@ -182,13 +310,25 @@ func (t *HTTPTransport) newRequest(url *url.URL) *http.Request {
 	return req
 }
 
-func urlWithPath(url *url.URL, p string) *url.URL {
-	urlCopy := *url
-	urlCopy.Path += p
-	if urlCopy.RawPath != "" {
-		urlCopy.RawPath += p
+// urlWithPath returns a copy of base with the given path elements
+// joined to its path, such that there is exactly one slash between
+// each element regardless of leading or trailing slashes.
+func urlWithPath(base *url.URL, elem ...string) *url.URL {
+	baseCopy := *base
+	if !strings.HasSuffix(baseCopy.Path, "/") {
+		baseCopy.Path += "/"
+		if baseCopy.RawPath != "" {
+			baseCopy.RawPath += "/"
+		}
 	}
-	return &urlCopy
+	var parts []string
+	for _, elem := range elem {
+		if elem = strings.Trim(elem, "/"); elem != "" {
+			parts = append(parts, elem)
+		}
+	}
+	ref := &url.URL{Path: strings.Join(parts, "/")}
+	return baseCopy.ResolveReference(ref)
 }
 
 // HTTPError is an error returned by HTTPTransport methods when requests fail.
@@ -205,3 +345,35 @@ func (e *HTTPError) Error() string {
 	}
 	return msg
 }
+
+// RejectedEventsError is returned by HTTPTransport methods when the
+// server rejects some of the events in a request, e.g. due to failing
+// validation. Any other events in the request will have been accepted,
+// so the request should not be retried.
+type RejectedEventsError struct {
+	Op string
+
+	// Accepted is the number of events accepted by the server.
+	Accepted int
+
+	// Rejected holds the events rejected by the server,
+	// along with the reasons for their rejection.
+	Rejected []RejectedEvent
+}
+
+func (e *RejectedEventsError) Error() string {
+	return fmt.Sprintf(
+		"%s: %d events rejected: %s",
+		e.Op, len(e.Rejected), e.Rejected[0].Message,
+	)
+}
+
+// RejectedEvent describes an event rejected by the server.
+type RejectedEvent struct {
+	// Message describes the reason for rejecting the event.
+	Message string `json:"message"`
+
+	// Document holds the rejected event's encoded document,
+	// if reported by the server.
+	Document string `json:"document,omitempty"`
+}
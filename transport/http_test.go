@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -11,8 +12,10 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/apm-agent-go/model"
 	"github.com/elastic/apm-agent-go/transport"
@@ -147,6 +150,34 @@ func TestHTTPError(t *testing.T) {
 	assert.EqualError(t, err, "SendTransactions failed with 500 Internal Server Error: error-message")
 }
 
+func TestHTTPRejectedEvents(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"accepted":2,"errors":[{"message":"invalid span","document":"{}"}]}`))
+	})
+	tr, server := newHTTPTransport(t, h)
+	defer server.Close()
+
+	err := tr.SendTransactions(context.Background(), &model.TransactionsPayload{})
+	require.IsType(t, &transport.RejectedEventsError{}, err)
+	assert.Equal(t, &transport.RejectedEventsError{
+		Op:       "SendTransactions",
+		Accepted: 2,
+		Rejected: []transport.RejectedEvent{{Message: "invalid span", Document: "{}"}},
+	}, err)
+	assert.EqualError(t, err, "SendTransactions: 1 events rejected: invalid span")
+
+	// A 400 response which does not describe rejected
+	// events is reported as an HTTPError.
+	h = func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}
+	tr, server = newHTTPTransport(t, h)
+	defer server.Close()
+	err = tr.SendTransactions(context.Background(), &model.TransactionsPayload{})
+	assert.IsType(t, &transport.HTTPError{}, err)
+}
+
 func TestConcurrentSendTransactions(t *testing.T) {
 	payload := &model.TransactionsPayload{
 		Service: &model.Service{},
@@ -165,6 +196,151 @@ func TestConcurrentSendErrors(t *testing.T) {
 	})
 }
 
+func TestConcurrentSendMetrics(t *testing.T) {
+	payload := &model.MetricsPayload{
+		Service: &model.Service{},
+	}
+	testConcurrentSend(t, func(tr transport.Transport) {
+		tr.(transport.MetricsTransport).SendMetrics(context.Background(), payload)
+	})
+}
+
+func TestHTTPTransportSendMetrics(t *testing.T) {
+	var h recordingHandler
+	transport, server := newHTTPTransport(t, &h)
+	defer server.Close()
+
+	err := transport.SendMetrics(context.Background(), &model.MetricsPayload{})
+	assert.NoError(t, err)
+	assert.Len(t, h.requests, 1)
+	assert.Equal(t, "/v1/metrics", h.requests[0].URL.Path)
+}
+
+func TestHTTPTransportSendSpans(t *testing.T) {
+	var decoded map[string]interface{}
+	var path string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		if err := json.NewDecoder(req.Body).Decode(&decoded); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	transport, server := newHTTPTransport(t, handler)
+	defer server.Close()
+
+	err := transport.SendSpans(context.Background(), &model.SpansPayload{
+		Service: &model.Service{Name: "service"},
+		Spans: []*model.Span{{
+			Name:          "name",
+			Type:          "type",
+			Start:         time.Millisecond,
+			Duration:      2 * time.Millisecond,
+			TraceID:       "0102030405060708090a0b0c0d0e0f10",
+			SpanID:        "0102030405060708",
+			ParentID:      "0807060504030201",
+			TransactionID: "transaction-id",
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/v1/spans", path)
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"name":           "name",
+		"type":           "type",
+		"start":          float64(1),
+		"duration":       float64(2),
+		"trace_id":       "0102030405060708090a0b0c0d0e0f10",
+		"span_id":        "0102030405060708",
+		"parent_id":      "0807060504030201",
+		"transaction_id": "transaction-id",
+	}}, decoded["spans"])
+}
+
+func TestHTTPTransportSupportedEventTypes(t *testing.T) {
+	type test struct {
+		status   int
+		body     string
+		expected []string
+	}
+	for _, test := range []test{{
+		status:   http.StatusOK,
+		body:     `{"build_date":"2018-05-01T00:00:00Z","version":"6.3.0"}`,
+		expected: []string{"transaction", "error", "metric"},
+	}, {
+		status:   http.StatusOK,
+		body:     `{"version":"7.0.0-alpha1"}`,
+		expected: []string{"transaction", "error", "metric"},
+	}, {
+		status:   http.StatusOK,
+		body:     `{"version":"6.2.4"}`,
+		expected: []string{"transaction", "error"},
+	}, {
+		status:   http.StatusOK,
+		body:     "",
+		expected: []string{"transaction", "error"},
+	}, {
+		status:   http.StatusNotFound,
+		body:     "404 page not found",
+		expected: []string{"transaction", "error"},
+	}} {
+		var method, path string
+		h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			method, path = req.Method, req.URL.Path
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		})
+		tr, server := newHTTPTransport(t, h)
+		eventTypes, err := tr.SupportedEventTypes(context.Background())
+		server.Close()
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, eventTypes)
+		assert.Equal(t, "GET", method)
+		assert.Equal(t, "/", path)
+	}
+}
+
+func TestHTTPTransportSupportedEventTypesError(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+	tr, server := newHTTPTransport(t, h)
+	defer server.Close()
+
+	_, err := tr.SupportedEventTypes(context.Background())
+	assert.EqualError(t, err, "SupportedEventTypes failed with 401 Unauthorized: unauthorized")
+}
+
+func TestHTTPTransportIntakePath(t *testing.T) {
+	var h recordingHandler
+	server := httptest.NewServer(&h)
+	defer server.Close()
+
+	for _, test := range []struct {
+		serverPath string
+		intakePath string
+		expected   string
+	}{
+		{"", "", "/v1/transactions"},
+		{"/", "", "/v1/transactions"},
+		{"/apm", "", "/apm/v1/transactions"},
+		{"/apm/", "", "/apm/v1/transactions"},
+		{"/apm/", "/intake/v2/", "/apm/intake/v2/transactions"},
+		{"/apm", "intake/v2", "/apm/intake/v2/transactions"},
+		{"", "/", "/transactions"},
+		{"/a%2Fb", "v1", "/a%2Fb/v1/transactions"},
+	} {
+		transport, err := transport.NewHTTPTransport(server.URL+test.serverPath, "")
+		require.NoError(t, err)
+		if test.intakePath != "" {
+			transport.SetIntakePath(test.intakePath)
+		}
+		h.requests = nil
+		err = transport.SendTransactions(context.Background(), &model.TransactionsPayload{})
+		assert.NoError(t, err)
+		require.Len(t, h.requests, 1)
+		assert.Equal(t, test.expected, h.requests[0].URL.EscapedPath(), "%+v", test)
+	}
+}
+
 func testConcurrentSend(t *testing.T, write func(transport.Transport)) {
 	transport, server := newHTTPTransport(t, nopHandler{})
 	defer server.Close()
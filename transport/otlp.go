@@ -0,0 +1,604 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/apm-agent-go/model"
+)
+
+const (
+	// DefaultOTLPEndpoint is the default base URL of the OTLP/HTTP
+	// receiver used by OTLPTransport, e.g. an OpenTelemetry collector.
+	DefaultOTLPEndpoint = "http://localhost:4318"
+
+	otlpTracesPath  = "v1/traces"
+	otlpMetricsPath = "v1/metrics"
+	otlpLogsPath    = "v1/logs"
+
+	otlpScopeName = "github.com/elastic/apm-agent-go"
+
+	envOTLPEndpoint = "ELASTIC_APM_OTLP_ENDPOINT"
+)
+
+// OTLP span kinds, status codes, and severity numbers.
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+
+	otlpStatusCodeOK    = 1
+	otlpStatusCodeError = 2
+
+	otlpSeverityError = 17
+
+	otlpAggregationTemporalityDelta      = 1
+	otlpAggregationTemporalityCumulative = 2
+)
+
+// OTLPTransport is an implementation of Transport, exporting payloads
+// to an OpenTelemetry Protocol (OTLP) receiver, such as an OpenTelemetry
+// collector, using OTLP/HTTP with JSON encoding.
+//
+// Transactions and their spans are exported as OTLP spans, errors as
+// OTLP log records with exception attributes, correlated with the trace
+// and span of their transaction, and metrics as OTLP gauges, or as
+// monotonic sums for counters. Spans sent independently of transactions
+// are not supported, and errors are not recorded as span events.
+type OTLPTransport struct {
+	Client     *http.Client
+	tracesURL  *url.URL
+	metricsURL *url.URL
+	logsURL    *url.URL
+	headers    http.Header
+}
+
+// NewOTLPTransport returns a new OTLPTransport, which can be used for
+// exporting transactions, errors, and metrics to the OTLP/HTTP receiver
+// at the specified base URL, e.g. "http://otel-collector.example:4318".
+// Requests will be sent to the standard OTLP/HTTP paths, such as
+// "/v1/traces", relative to the base URL.
+//
+// If the URL specified is the empty string, then NewOTLPTransport will
+// use the value of the ELASTIC_APM_OTLP_ENDPOINT environment variable,
+// if defined, and otherwise DefaultOTLPEndpoint.
+//
+// The Client field will be initialized with a new http.Client, and may
+// be modified or replaced, e.g. in order to specify TLS root CAs.
+func NewOTLPTransport(endpoint string) (*OTLPTransport, error) {
+	if endpoint == "" {
+		endpoint = os.Getenv(envOTLPEndpoint)
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+	}
+	req, err := http.NewRequest("POST", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/json")
+	return &OTLPTransport{
+		Client:     &http.Client{},
+		tracesURL:  urlWithPath(req.URL, otlpTracesPath),
+		metricsURL: urlWithPath(req.URL, otlpMetricsPath),
+		logsURL:    urlWithPath(req.URL, otlpLogsPath),
+		headers:    headers,
+	}, nil
+}
+
+// SendTransactions exports the transactions, and their spans,
+// as OTLP spans.
+func (t *OTLPTransport) SendTransactions(ctx context.Context, p *model.TransactionsPayload) error {
+	var resources otlpResources
+	var request otlpTracesRequest
+	for _, tx := range p.Transactions {
+		i, ok := resources.index(tx.Service)
+		if !ok {
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource:   otlpNewResource(p.Service, tx.Service, p.Process, p.System),
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpNewScope(p.Service)}},
+			})
+		}
+		scopeSpans := &request.ResourceSpans[i].ScopeSpans[0]
+		scopeSpans.Spans = append(scopeSpans.Spans, otlpTransactionSpan(tx))
+		for _, span := range tx.Spans {
+			scopeSpans.Spans = append(scopeSpans.Spans, otlpSpanSpan(tx, span))
+		}
+	}
+	return t.send(ctx, t.tracesURL, &request, "SendTransactions")
+}
+
+// SendErrors exports the errors as OTLP log records.
+func (t *OTLPTransport) SendErrors(ctx context.Context, p *model.ErrorsPayload) error {
+	var resources otlpResources
+	var request otlpLogsRequest
+	for _, e := range p.Errors {
+		i, ok := resources.index(e.Service)
+		if !ok {
+			request.ResourceLogs = append(request.ResourceLogs, otlpResourceLogs{
+				Resource:  otlpNewResource(p.Service, e.Service, p.Process, p.System),
+				ScopeLogs: []otlpScopeLogs{{Scope: otlpNewScope(p.Service)}},
+			})
+		}
+		scopeLogs := &request.ResourceLogs[i].ScopeLogs[0]
+		scopeLogs.LogRecords = append(scopeLogs.LogRecords, otlpErrorLogRecord(e))
+	}
+	return t.send(ctx, t.logsURL, &request, "SendErrors")
+}
+
+// SendMetrics exports the metrics as OTLP gauges, or as monotonic
+// sums for metrics of type model.MetricTypeCounter (cumulative) and
+// model.MetricTypeDeltaCounter (delta).
+func (t *OTLPTransport) SendMetrics(ctx context.Context, p *model.MetricsPayload) error {
+	scopeMetrics := otlpScopeMetrics{Scope: otlpNewScope(p.Service)}
+	for _, m := range p.Metrics {
+		names := make([]string, 0, len(m.Samples))
+		for name := range m.Samples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		attributes := otlpStringAttributes(m.Labels)
+		for _, name := range names {
+			sample := m.Samples[name]
+			dataPoints := []otlpNumberDataPoint{{
+				TimeUnixNano: otlpTime(m.Timestamp),
+				AsDouble:     sample.Value,
+				Attributes:   attributes,
+			}}
+			metric := otlpMetric{Name: name}
+			switch sample.Type {
+			case model.MetricTypeCounter:
+				metric.Sum = &otlpSum{
+					DataPoints:             dataPoints,
+					AggregationTemporality: otlpAggregationTemporalityCumulative,
+					IsMonotonic:            true,
+				}
+			case model.MetricTypeDeltaCounter:
+				metric.Sum = &otlpSum{
+					DataPoints:             dataPoints,
+					AggregationTemporality: otlpAggregationTemporalityDelta,
+					IsMonotonic:            true,
+				}
+			default:
+				metric.Gauge = &otlpGauge{DataPoints: dataPoints}
+			}
+			scopeMetrics.Metrics = append(scopeMetrics.Metrics, metric)
+		}
+	}
+	request := otlpMetricsRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource:     otlpNewResource(p.Service, nil, p.Process, p.System),
+			ScopeMetrics: []otlpScopeMetrics{scopeMetrics},
+		}},
+	}
+	return t.send(ctx, t.metricsURL, &request, "SendMetrics")
+}
+
+func (t *OTLPTransport) send(ctx context.Context, url *url.URL, request interface{}, op string) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(request); err != nil {
+		return errors.Wrapf(err, "encoding OTLP request for %s failed", op)
+	}
+	req := &http.Request{
+		Method:     "POST",
+		URL:        url,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     t.headers,
+		Host:       url.Host,
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(buf.Len())
+	req.Body = ioutil.NopCloser(&buf)
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "sending request for %s failed", op)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	bodyContents, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(bodyContents))
+	}
+	return &HTTPError{
+		Op:       op,
+		Response: resp,
+		Message:  strings.TrimSpace(string(bodyContents)),
+	}
+}
+
+// otlpResources maps per-event service overrides to the index of
+// the corresponding resource in an OTLP request.
+type otlpResources map[model.EventService]int
+
+// index returns the index of the resource for the given service
+// override, and reports whether or not it already exists. If it
+// does not exist, it is assigned the next index.
+func (r *otlpResources) index(service *model.EventService) (int, bool) {
+	var key model.EventService
+	if service != nil {
+		key = *service
+	}
+	if *r == nil {
+		*r = make(otlpResources)
+	}
+	i, ok := (*r)[key]
+	if !ok {
+		i = len(*r)
+		(*r)[key] = i
+	}
+	return i, ok
+}
+
+func otlpTransactionSpan(tx *model.Transaction) otlpSpan {
+	span := otlpSpan{
+		TraceID:           tx.TraceID,
+		SpanID:            tx.SpanID,
+		ParentSpanID:      tx.ParentID,
+		Name:              tx.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: otlpTime(tx.Timestamp),
+		EndTimeUnixNano:   otlpTime(tx.Timestamp.Add(tx.Duration)),
+		Attributes: []otlpKeyValue{
+			otlpString("transaction.type", tx.Type),
+		},
+	}
+	if tx.Type == "request" {
+		span.Kind = otlpSpanKindServer
+	}
+	if tx.Result != "" {
+		span.Attributes = append(span.Attributes, otlpString("transaction.result", tx.Result))
+	}
+	if tx.Context != nil {
+		if req := tx.Context.Request; req != nil {
+			span.Attributes = append(span.Attributes, otlpString("http.request.method", req.Method))
+			if req.URL.Full != "" {
+				span.Attributes = append(span.Attributes, otlpString("url.full", req.URL.Full))
+			}
+		}
+		if resp := tx.Context.Response; resp != nil && resp.StatusCode != 0 {
+			span.Attributes = append(span.Attributes, otlpInt("http.response.status_code", int64(resp.StatusCode)))
+			if resp.StatusCode >= 500 {
+				span.Status = &otlpStatus{Code: otlpStatusCodeError}
+			}
+		}
+		span.Attributes = append(span.Attributes, otlpStringAttributes(tx.Context.Tags)...)
+	}
+	return span
+}
+
+func otlpSpanSpan(tx *model.Transaction, s *model.Span) otlpSpan {
+	start := tx.Timestamp.Add(s.Start)
+	span := otlpSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentID,
+		Name:              s.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: otlpTime(start),
+		EndTimeUnixNano:   otlpTime(start.Add(s.Duration)),
+		Attributes: []otlpKeyValue{
+			otlpString("span.type", s.Type),
+		},
+	}
+	if s.Exit {
+		span.Kind = otlpSpanKindClient
+	}
+	switch s.Outcome {
+	case model.OutcomeSuccess:
+		span.Status = &otlpStatus{Code: otlpStatusCodeOK}
+	case model.OutcomeFailure:
+		span.Status = &otlpStatus{Code: otlpStatusCodeError}
+	}
+	if s.Context != nil {
+		if db := s.Context.Database; db != nil && db.Statement != "" {
+			span.Attributes = append(span.Attributes, otlpString("db.statement", db.Statement))
+		}
+		if httpContext := s.Context.HTTP; httpContext != nil {
+			if httpContext.URL != nil && httpContext.URL.Full != "" {
+				span.Attributes = append(span.Attributes, otlpString("url.full", httpContext.URL.Full))
+			}
+			if httpContext.StatusCode != 0 {
+				span.Attributes = append(span.Attributes, otlpInt("http.response.status_code", int64(httpContext.StatusCode)))
+			}
+		}
+		if dest := s.Context.Destination; dest != nil {
+			if dest.Address != "" {
+				span.Attributes = append(span.Attributes, otlpString("server.address", dest.Address))
+			}
+			if dest.Port != 0 {
+				span.Attributes = append(span.Attributes, otlpInt("server.port", int64(dest.Port)))
+			}
+		}
+		span.Attributes = append(span.Attributes, otlpStringAttributes(s.Context.Tags)...)
+	}
+	for _, link := range s.Links {
+		span.Links = append(span.Links, otlpLink{TraceID: link.TraceID, SpanID: link.SpanID})
+	}
+	return span
+}
+
+func otlpErrorLogRecord(e *model.Error) otlpLogRecord {
+	record := otlpLogRecord{
+		TimeUnixNano:   otlpTime(e.Timestamp),
+		SeverityNumber: otlpSeverityError,
+		SeverityText:   "ERROR",
+		TraceID:        e.TraceID,
+		SpanID:         e.ParentID,
+	}
+	if e.ID != "" {
+		record.Attributes = append(record.Attributes, otlpString("error.id", e.ID))
+	}
+	if e.TransactionID != "" {
+		record.Attributes = append(record.Attributes, otlpString("transaction.id", e.TransactionID))
+	}
+	if e.Culprit != "" {
+		record.Attributes = append(record.Attributes, otlpString("error.culprit", e.Culprit))
+	}
+	var message string
+	if e.Exception != nil {
+		message = e.Exception.Message
+		exceptionType := e.Exception.Type
+		if e.Exception.Module != "" {
+			exceptionType = e.Exception.Module + "." + exceptionType
+		}
+		record.Attributes = append(record.Attributes,
+			otlpString("exception.type", exceptionType),
+			otlpString("exception.message", e.Exception.Message),
+		)
+		if len(e.Exception.Stacktrace) > 0 {
+			record.Attributes = append(record.Attributes, otlpString(
+				"exception.stacktrace", otlpStacktrace(e.Exception.Stacktrace),
+			))
+		}
+	}
+	if e.Log != nil {
+		message = e.Log.Message
+		if e.Log.LoggerName != "" {
+			record.Attributes = append(record.Attributes, otlpString("log.logger", e.Log.LoggerName))
+		}
+	}
+	record.Body = otlpAnyValue{StringValue: &message}
+	return record
+}
+
+// otlpStacktrace formats frames in the style of a Go panic.
+func otlpStacktrace(frames []model.StacktraceFrame) string {
+	var buf bytes.Buffer
+	for i, frame := range frames {
+		if i > 0 {
+			buf.WriteRune('\n')
+		}
+		function := frame.Function
+		if frame.Module != "" {
+			function = frame.Module + "." + function
+		}
+		file := frame.AbsolutePath
+		if file == "" {
+			file = frame.File
+		}
+		fmt.Fprintf(&buf, "%s\n\t%s:%d", function, file, frame.Line)
+	}
+	return buf.String()
+}
+
+func otlpNewResource(
+	service *model.Service,
+	override *model.EventService,
+	process *model.Process,
+	system *model.System,
+) otlpResource {
+	var resource otlpResource
+	add := func(key, value string) {
+		if value != "" {
+			resource.Attributes = append(resource.Attributes, otlpString(key, value))
+		}
+	}
+	var name, version, environment string
+	if service != nil {
+		name, version, environment = service.Name, service.Version, service.Environment
+	}
+	if override != nil {
+		if override.Name != "" {
+			name = override.Name
+		}
+		if override.Version != "" {
+			version = override.Version
+		}
+		if override.Environment != "" {
+			environment = override.Environment
+		}
+	}
+	add("service.name", name)
+	add("service.version", version)
+	add("deployment.environment", environment)
+	if service != nil {
+		add("telemetry.sdk.name", "elastic-apm-go")
+		add("telemetry.sdk.language", service.Agent.Name)
+		add("telemetry.sdk.version", service.Agent.Version)
+	}
+	if system != nil {
+		add("host.name", system.Hostname)
+		add("host.arch", system.Architecture)
+		add("os.type", system.Platform)
+	}
+	if process != nil {
+		resource.Attributes = append(resource.Attributes, otlpInt("process.pid", int64(process.Pid)))
+	}
+	return resource
+}
+
+func otlpNewScope(service *model.Service) otlpScope {
+	scope := otlpScope{Name: otlpScopeName}
+	if service != nil {
+		scope.Version = service.Agent.Version
+	}
+	return scope
+}
+
+// otlpStringAttributes returns the key/value pairs in m
+// as attributes, sorted by key.
+func otlpStringAttributes(m map[string]string) []otlpKeyValue {
+	if len(m) == 0 {
+		return nil
+	}
+	attributes := make([]otlpKeyValue, 0, len(m))
+	for k, v := range m {
+		attributes = append(attributes, otlpString(k, v))
+	}
+	sort.Slice(attributes, func(i, j int) bool {
+		return attributes[i].Key < attributes[j].Key
+	})
+	return attributes
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) otlpKeyValue {
+	// 64-bit integers are encoded as strings in OTLP/JSON.
+	s := strconv.FormatInt(value, 10)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+// otlpTime returns t as a decimal string of nanoseconds
+// since the Unix epoch, as 64-bit integers are encoded as
+// strings in OTLP/JSON.
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// The following types describe the OTLP/JSON encoding of the OTLP
+// export requests, as defined by the OpenTelemetry protocol buffers.
+
+type otlpTracesRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber"`
+	SeverityText   string         `json:"severityText"`
+	Body           otlpAnyValue   `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes,omitempty"`
+	TraceID        string         `json:"traceId,omitempty"`
+	SpanID         string         `json:"spanId,omitempty"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpNumberDataPoint struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	AsDouble     float64        `json:"asDouble"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
@@ -0,0 +1,274 @@
+package transport_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport"
+)
+
+func TestOTLPTransportTransactions(t *testing.T) {
+	var h otlpHandler
+	server := httptest.NewServer(&h)
+	defer server.Close()
+
+	tr, err := transport.NewOTLPTransport(server.URL)
+	require.NoError(t, err)
+
+	timestamp := time.Unix(1, 0).UTC()
+	err = tr.SendTransactions(context.Background(), &model.TransactionsPayload{
+		Service: &model.Service{
+			Name:  "service",
+			Agent: model.Agent{Name: "go", Version: "1.0"},
+		},
+		Transactions: []*model.Transaction{{
+			TraceID:   "0102030405060708090a0b0c0d0e0f10",
+			SpanID:    "0102030405060708",
+			Name:      "GET /",
+			Type:      "request",
+			Timestamp: timestamp,
+			Duration:  time.Second,
+			Context: &model.Context{
+				Response: &model.Response{StatusCode: 503},
+			},
+			Spans: []*model.Span{{
+				TraceID:  "0102030405060708090a0b0c0d0e0f10",
+				SpanID:   "1112131415161718",
+				ParentID: "0102030405060708",
+				Name:     "SELECT FROM foo",
+				Type:     "db.sql.query",
+				Start:    time.Millisecond,
+				Duration: time.Millisecond,
+				Exit:     true,
+				Outcome:  model.OutcomeFailure,
+			}},
+		}},
+	})
+	require.NoError(t, err)
+	require.Len(t, h.requests, 1)
+	assert.Equal(t, "/v1/traces", h.requests[0].path)
+	assert.Equal(t, map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []interface{}{
+					otlpStringAttribute("service.name", "service"),
+					otlpStringAttribute("telemetry.sdk.name", "elastic-apm-go"),
+					otlpStringAttribute("telemetry.sdk.language", "go"),
+					otlpStringAttribute("telemetry.sdk.version", "1.0"),
+				},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{
+					"name":    "github.com/elastic/apm-agent-go",
+					"version": "1.0",
+				},
+				"spans": []interface{}{
+					map[string]interface{}{
+						"traceId":           "0102030405060708090a0b0c0d0e0f10",
+						"spanId":            "0102030405060708",
+						"name":              "GET /",
+						"kind":              2.0,
+						"startTimeUnixNano": "1000000000",
+						"endTimeUnixNano":   "2000000000",
+						"attributes": []interface{}{
+							otlpStringAttribute("transaction.type", "request"),
+							map[string]interface{}{
+								"key":   "http.response.status_code",
+								"value": map[string]interface{}{"intValue": "503"},
+							},
+						},
+						"status": map[string]interface{}{"code": 2.0},
+					},
+					map[string]interface{}{
+						"traceId":           "0102030405060708090a0b0c0d0e0f10",
+						"spanId":            "1112131415161718",
+						"parentSpanId":      "0102030405060708",
+						"name":              "SELECT FROM foo",
+						"kind":              3.0,
+						"startTimeUnixNano": "1001000000",
+						"endTimeUnixNano":   "1002000000",
+						"attributes": []interface{}{
+							otlpStringAttribute("span.type", "db.sql.query"),
+						},
+						"status": map[string]interface{}{"code": 2.0},
+					},
+				},
+			}},
+		}},
+	}, h.requests[0].body)
+}
+
+func TestOTLPTransportErrors(t *testing.T) {
+	var h otlpHandler
+	server := httptest.NewServer(&h)
+	defer server.Close()
+
+	tr, err := transport.NewOTLPTransport(server.URL)
+	require.NoError(t, err)
+	err = tr.SendErrors(context.Background(), &model.ErrorsPayload{
+		Errors: []*model.Error{{
+			Timestamp: time.Unix(1, 0),
+			TraceID:   "0102030405060708090a0b0c0d0e0f10",
+			ParentID:  "0102030405060708",
+			Exception: &model.Exception{
+				Message: "boom",
+				Module:  "errors",
+				Type:    "errorString",
+			},
+		}, {
+			Timestamp: time.Unix(2, 0),
+			Service:   &model.EventService{Name: "other"},
+			Log:       &model.Log{Message: "log message"},
+		}},
+	})
+	require.NoError(t, err)
+	require.Len(t, h.requests, 1)
+	assert.Equal(t, "/v1/logs", h.requests[0].path)
+
+	resourceLogs := h.requests[0].body["resourceLogs"].([]interface{})
+	require.Len(t, resourceLogs, 2)
+	records := func(i int) []interface{} {
+		scopeLogs := resourceLogs[i].(map[string]interface{})["scopeLogs"].([]interface{})
+		return scopeLogs[0].(map[string]interface{})["logRecords"].([]interface{})
+	}
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"timeUnixNano":   "1000000000",
+		"severityNumber": 17.0,
+		"severityText":   "ERROR",
+		"body":           map[string]interface{}{"stringValue": "boom"},
+		"traceId":        "0102030405060708090a0b0c0d0e0f10",
+		"spanId":         "0102030405060708",
+		"attributes": []interface{}{
+			otlpStringAttribute("exception.type", "errors.errorString"),
+			otlpStringAttribute("exception.message", "boom"),
+		},
+	}}, records(0))
+	assert.Equal(t, map[string]interface{}{
+		"attributes": []interface{}{otlpStringAttribute("service.name", "other")},
+	}, resourceLogs[1].(map[string]interface{})["resource"])
+	assert.Len(t, records(1), 1)
+}
+
+func TestOTLPTransportMetrics(t *testing.T) {
+	var h otlpHandler
+	server := httptest.NewServer(&h)
+	defer server.Close()
+
+	tr, err := transport.NewOTLPTransport(server.URL)
+	require.NoError(t, err)
+	err = tr.SendMetrics(context.Background(), &model.MetricsPayload{
+		Metrics: []*model.Metrics{{
+			Timestamp: time.Unix(1, 0),
+			Labels:    map[string]string{"k": "v"},
+			Samples: map[string]model.Metric{
+				"b": {Value: 2},
+				"a": {Value: 1},
+				"c": {Value: 3, Type: model.MetricTypeCounter},
+				"d": {Value: 4, Type: model.MetricTypeDeltaCounter},
+			},
+		}},
+	})
+	require.NoError(t, err)
+	require.Len(t, h.requests, 1)
+	assert.Equal(t, "/v1/metrics", h.requests[0].path)
+
+	resourceMetrics := h.requests[0].body["resourceMetrics"].([]interface{})
+	require.Len(t, resourceMetrics, 1)
+	scopeMetrics := resourceMetrics[0].(map[string]interface{})["scopeMetrics"].([]interface{})
+	dataPoints := func(value float64) []interface{} {
+		return []interface{}{map[string]interface{}{
+			"timeUnixNano": "1000000000",
+			"asDouble":     value,
+			"attributes":   []interface{}{otlpStringAttribute("k", "v")},
+		}}
+	}
+	gauge := func(name string, value float64) map[string]interface{} {
+		return map[string]interface{}{
+			"name":  name,
+			"gauge": map[string]interface{}{"dataPoints": dataPoints(value)},
+		}
+	}
+	sum := func(name string, value float64, temporality float64) map[string]interface{} {
+		return map[string]interface{}{
+			"name": name,
+			"sum": map[string]interface{}{
+				"dataPoints":             dataPoints(value),
+				"aggregationTemporality": temporality,
+				"isMonotonic":            true,
+			},
+		}
+	}
+	assert.Equal(t, []interface{}{
+		gauge("a", 1),
+		gauge("b", 2),
+		sum("c", 3, 2), // cumulative
+		sum("d", 4, 1), // delta
+	}, scopeMetrics[0].(map[string]interface{})["metrics"])
+}
+
+func TestOTLPTransportError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	tr, err := transport.NewOTLPTransport(server.URL)
+	require.NoError(t, err)
+	err = tr.SendMetrics(context.Background(), &model.MetricsPayload{})
+	assert.EqualError(t, err, "SendMetrics failed with 400 Bad Request: invalid request")
+}
+
+func TestOTLPTransportEnvEndpoint(t *testing.T) {
+	var h otlpHandler
+	server := httptest.NewServer(&h)
+	defer server.Close()
+
+	defer transport.InitDefault()
+	defer patchEnv("ELASTIC_APM_TRANSPORT", "otlp")()
+	defer patchEnv("ELASTIC_APM_OTLP_ENDPOINT", server.URL+"/otlp")()
+
+	tr, err := transport.InitDefault()
+	require.NoError(t, err)
+	assert.IsType(t, &transport.OTLPTransport{}, tr)
+	err = tr.SendTransactions(context.Background(), &model.TransactionsPayload{})
+	require.NoError(t, err)
+	require.Len(t, h.requests, 1)
+	assert.Equal(t, "/otlp/v1/traces", h.requests[0].path)
+}
+
+type otlpRequest struct {
+	path string
+	body map[string]interface{}
+}
+
+type otlpHandler struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+}
+
+func (h *otlpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, otlpRequest{path: req.URL.Path, body: body})
+}
+
+func otlpStringAttribute(key, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"key":   key,
+		"value": map[string]interface{}{"stringValue": value},
+	}
+}
@@ -11,6 +11,7 @@ import (
 type CallbackTransport struct {
 	Transactions func(context.Context, *model.TransactionsPayload) error
 	Errors       func(context.Context, *model.ErrorsPayload) error
+	Metrics      func(context.Context, *model.MetricsPayload) error
 }
 
 // SendTransactions returns t.Transactions(ctx, p).
@@ -22,3 +23,11 @@ func (t CallbackTransport) SendTransactions(ctx context.Context, p *model.Transa
 func (t CallbackTransport) SendErrors(ctx context.Context, p *model.ErrorsPayload) error {
 	return t.Errors(ctx, p)
 }
+
+// SendMetrics returns t.Metrics(ctx, p), or nil if t.Metrics is nil.
+func (t CallbackTransport) SendMetrics(ctx context.Context, p *model.MetricsPayload) error {
+	if t.Metrics == nil {
+		return nil
+	}
+	return t.Metrics(ctx, p)
+}
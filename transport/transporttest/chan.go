@@ -14,6 +14,7 @@ import (
 type ChannelTransport struct {
 	Transactions chan<- SendTransactionsRequest
 	Errors       chan<- SendErrorsRequest
+	Metrics      chan<- SendMetricsRequest
 }
 
 // SendTransactionsRequest is the type of values sent over the
@@ -32,6 +33,14 @@ type SendErrorsRequest struct {
 	Result  chan<- error
 }
 
+// SendMetricsRequest is the type of values sent over the
+// ChannelTransport.Metrics channel when its SendMetrics
+// method is called.
+type SendMetricsRequest struct {
+	Payload *model.MetricsPayload
+	Result  chan<- error
+}
+
 // SendTransactions sends a SendTransactionsRequest value over the
 // c.Transactions channel with the given payload, and waits for a
 // response on the error channel included in the request, or for
@@ -68,3 +77,25 @@ func (c *ChannelTransport) SendErrors(ctx context.Context, payload *model.Errors
 		}
 	}
 }
+
+// SendMetrics sends a SendMetricsRequest value over the c.Metrics channel
+// with the given payload, and waits for a response on the error channel
+// included in the request, or for the context to be canceled. If c.Metrics
+// is nil, the payload is discarded and SendMetrics returns nil.
+func (c *ChannelTransport) SendMetrics(ctx context.Context, payload *model.MetricsPayload) error {
+	if c.Metrics == nil {
+		return nil
+	}
+	result := make(chan error, 1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case c.Metrics <- SendMetricsRequest{payload, result}:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-result:
+			return err
+		}
+	}
+}
@@ -28,7 +28,20 @@ func (r *RecorderTransport) SendErrors(ctx context.Context, payload *model.Error
 	return r.record(payload)
 }
 
-// Payloads returns the payloads recorded by SendTransactions and SendErrors.
+// SendMetrics records the metrics payload such that it can later be obtained
+// via Payloads.
+func (r *RecorderTransport) SendMetrics(ctx context.Context, payload *model.MetricsPayload) error {
+	return r.record(payload)
+}
+
+// SendSpans records the spans payload such that it can later be obtained
+// via Payloads.
+func (r *RecorderTransport) SendSpans(ctx context.Context, payload *model.SpansPayload) error {
+	return r.record(payload)
+}
+
+// Payloads returns the payloads recorded by SendTransactions, SendErrors,
+// SendMetrics, and SendSpans.
 func (r *RecorderTransport) Payloads() []map[string]interface{} {
 	r.mu.Lock()
 	payloads := r.payloads[:]
@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/elastic/apm-agent-go/model"
+)
+
+// NewWriterTransport returns a Transport which writes payloads to w as
+// indented JSON, rather than sending them to the Elastic APM server.
+// This is intended for debugging instrumentation during development.
+//
+// Timestamps and durations are encoded exactly as they would be sent
+// to the server, i.e. durations are rendered as milliseconds.
+func NewWriterTransport(w io.Writer) Transport {
+	return &writerTransport{w: w}
+}
+
+type writerTransport struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *writerTransport) SendTransactions(ctx context.Context, p *model.TransactionsPayload) error {
+	return t.write(p)
+}
+
+func (t *writerTransport) SendErrors(ctx context.Context, p *model.ErrorsPayload) error {
+	return t.write(p)
+}
+
+func (t *writerTransport) SendMetrics(ctx context.Context, p *model.MetricsPayload) error {
+	return t.write(p)
+}
+
+func (t *writerTransport) SendSpans(ctx context.Context, p *model.SpansPayload) error {
+	return t.write(p)
+}
+
+func (t *writerTransport) write(payload interface{}) error {
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package transport_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-agent-go/model"
+	"github.com/elastic/apm-agent-go/transport"
+)
+
+func TestWriterTransport(t *testing.T) {
+	var buf bytes.Buffer
+	tr := transport.NewWriterTransport(&buf)
+	err := tr.SendTransactions(context.Background(), &model.TransactionsPayload{
+		Service: &model.Service{Name: "service"},
+		Transactions: []*model.Transaction{{
+			Name:      "name",
+			Type:      "type",
+			Timestamp: time.Unix(123, 0),
+			Duration:  1500 * time.Microsecond,
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `
+  "transactions": [
+    {
+      "id": "",
+      "name": "name",
+      "type": "type",
+      "timestamp": "1970-01-01T00:02:03Z",
+      "duration": 1.5
+    }
+  ]
+}
+`)
+
+	buf.Reset()
+	err = tr.SendErrors(context.Background(), &model.ErrorsPayload{})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `"errors": null`)
+}
+
+func TestInitDefaultStderr(t *testing.T) {
+	defer patchEnv("ELASTIC_APM_TRANSPORT", "stderr")()
+
+	tr, err := transport.InitDefault()
+	assert.NoError(t, err)
+	assert.Exactly(t, tr, transport.Default)
+	assert.NotEqual(t, transport.Discard, tr)
+}
+
+func TestInitDefaultTransportInvalid(t *testing.T) {
+	defer patchEnv("ELASTIC_APM_TRANSPORT", "carrier-pigeon")()
+
+	_, err := transport.InitDefault()
+	assert.EqualError(t, err, `invalid ELASTIC_APM_TRANSPORT value "carrier-pigeon"`)
+}
@@ -0,0 +1,62 @@
+package elasticapm
+
+import (
+	"runtime"
+	"strings"
+)
+
+// usedAfterEnd reports whether tx has ended, in which case the
+// operation op, called on tx or one of its spans, must be a no-op:
+// the transaction may be being encoded by the tracer, or released.
+// If tx was ended by the application, rather than force-ended by
+// the tracer, a debug message is logged; see logUseAfterEnd.
+//
+// Transactions and spans are never reused, so use after end is also
+// detected after the transaction has been sent and released.
+func (tx *Transaction) usedAfterEnd(op string) bool {
+	tx.mu.Lock()
+	ended, forceEnded := tx.ended, tx.forceEnded
+	tx.mu.Unlock()
+	if ended && !forceEnded {
+		tx.tracer.logUseAfterEnd(op)
+	}
+	return ended
+}
+
+// logUseAfterEnd logs a debug message with the tracer's logger,
+// reporting that op was called after its transaction ended, at most
+// once per call site. The call site is that of the first caller
+// outside of this package.
+func (t *Tracer) logUseAfterEnd(op string) {
+	var pcs [16]uintptr
+	n := runtime.Callers(1, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	self, more := frames.Next()
+	pkgPrefix := strings.TrimSuffix(self.Function, "(*Tracer).logUseAfterEnd")
+	var caller runtime.Frame
+	for more {
+		caller, more = frames.Next()
+		if !strings.HasPrefix(caller.Function, pkgPrefix) {
+			break
+		}
+	}
+	if caller.PC == 0 {
+		return
+	}
+
+	t.useAfterEndMu.Lock()
+	logged := t.useAfterEndSites[caller.PC]
+	if !logged {
+		if t.useAfterEndSites == nil {
+			t.useAfterEndSites = make(map[uintptr]bool)
+		}
+		t.useAfterEndSites[caller.PC] = true
+	}
+	t.useAfterEndMu.Unlock()
+	if logger := t.currentLogger(); !logged && logger != nil {
+		logger.Debugf(
+			"%s called after the transaction ended, by %s (%s:%d); ignoring",
+			op, caller.Function, caller.File, caller.Line,
+		)
+	}
+}
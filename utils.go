@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/elastic/apm-agent-go/model"
 )
@@ -14,7 +15,7 @@ var (
 	envFramework   *model.Framework
 	envService     model.Service
 	goAgent        = model.Agent{Name: "go", Version: AgentVersion}
-	goLanguage     = model.Language{Name: "go", Version: runtime.Version()}
+	goLanguage     = model.Language{Name: "go", Version: strings.TrimPrefix(runtime.Version(), "go")}
 	goRuntime      = model.Runtime{Name: runtime.Compiler, Version: runtime.Version()}
 	localSystem    model.System
 )
@@ -63,12 +64,37 @@ func getEnvironmentFramework() *model.Framework {
 func getEnvironmentService() model.Service {
 	name := os.Getenv(envServiceName)
 	if name == "" {
-		name = filepath.Base(os.Args[0])
+		name = executableServiceName(os.Args[0])
 	}
 	svc := newService(name, "")
 	return *svc
 }
 
+// executableServiceName returns the default service name for the
+// executable at the given path: its base name, without any extension
+// (e.g. ".exe" on Windows), and with any characters not permitted in
+// service names replaced by "_".
+func executableServiceName(path string) string {
+	// Windows paths may be given with either separator.
+	if i := strings.LastIndexAny(path, `/\`); i >= 0 {
+		path = path[i+1:]
+	}
+	name := strings.TrimSuffix(path, filepath.Ext(path))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == ' ', r == '_', r == '-':
+		default:
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
 func newService(name, version string) *model.Service {
 	if version == "" {
 		version = os.Getenv(envServiceVersion)
@@ -98,6 +124,65 @@ func getLocalSystem() model.System {
 	return system
 }
 
-func validTagKey(k string) bool {
-	return !strings.ContainsAny(k, `.*"`)
+// The following limits correspond to those imposed by the
+// Elastic APM server. Values exceeding the limits cause the
+// server to reject the entire payload, so values are truncated
+// by the agent instead.
+const (
+	// maxTagKeyLength is the maximum length of a tag key, in bytes.
+	maxTagKeyLength = 1024
+
+	// maxTagValueLength is the maximum length of a tag value,
+	// in characters.
+	maxTagValueLength = 1024
+
+	// maxNameLength is the maximum length of a transaction
+	// or span name, in characters.
+	maxNameLength = 1024
+
+	// maxDatabaseInstanceLength is the maximum length of a
+	// span's database instance name, in characters.
+	maxDatabaseInstanceLength = 1024
+
+	// maxDatabaseStatementLength is the maximum length of a
+	// span's database statement, in characters.
+	maxDatabaseStatementLength = 10000
+)
+
+// truncateBytes returns s truncated to at most n bytes,
+// without splitting a multi-byte character.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// truncateRunes returns s truncated to at most n characters.
+func truncateRunes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	var i int
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
+
+// truncateSpanFields truncates the span's name and database
+// context fields, if they exceed the server's length limits.
+func truncateSpanFields(span *model.Span) {
+	span.Name = truncateRunes(span.Name, maxNameLength)
+	if span.Context != nil && span.Context.Database != nil {
+		db := span.Context.Database
+		db.Instance = truncateRunes(db.Instance, maxDatabaseInstanceLength)
+		db.Statement = truncateRunes(db.Statement, maxDatabaseStatementLength)
+	}
 }